@@ -0,0 +1,39 @@
+package erc4337
+
+import (
+	"bxs/logger"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// the UserOperationEvent of the EntryPoint, the same in v0.6 and v0.7
+const (
+	EntryPointAbiJson           = `[{"anonymous":false,"inputs":[{"indexed":true,"internalType":"bytes32","name":"userOpHash","type":"bytes32"},{"indexed":true,"internalType":"address","name":"sender","type":"address"},{"indexed":true,"internalType":"address","name":"paymaster","type":"address"},{"indexed":false,"internalType":"uint256","name":"nonce","type":"uint256"},{"indexed":false,"internalType":"bool","name":"success","type":"bool"},{"indexed":false,"internalType":"uint256","name":"actualGasCost","type":"uint256"},{"indexed":false,"internalType":"uint256","name":"actualGasUsed","type":"uint256"}],"name":"UserOperationEvent","type":"event"}]`
+	UserOperationEventTopic0Hex = "0x49628fd1471006c1482da88028e9ce4dbb080b815c9b0344d39e5a8e6ec1419f"
+	EntryPointV06AddressHex     = "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"
+	EntryPointV07AddressHex     = "0x0000000071727De22E5E9d8BAf0edaC6f37da032"
+)
+
+var (
+	EntryPointAbi            *abi.ABI
+	UserOperationEventTopic0 = common.HexToHash(UserOperationEventTopic0Hex)
+	UserOperationEvent       *abi.Event
+	EntryPointV06Address     = common.HexToAddress(EntryPointV06AddressHex)
+	EntryPointV07Address     = common.HexToAddress(EntryPointV07AddressHex)
+)
+
+func init() {
+	entryPointAbi, err := abi.JSON(strings.NewReader(EntryPointAbiJson))
+	if err != nil {
+		logger.G.Fatal("Failed to parse entry point ABI", zap.Error(err))
+	}
+	EntryPointAbi = &entryPointAbi
+
+	userOperationEvent, err := entryPointAbi.EventByID(UserOperationEventTopic0)
+	if err != nil {
+		logger.G.Fatal("Failed to find UserOperationEvent", zap.Error(err))
+	}
+	UserOperationEvent = userOperationEvent
+}
@@ -0,0 +1,47 @@
+package multicall3
+
+import (
+	"bxs/logger"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"math/big"
+	"strings"
+)
+
+// aggregate3 and aggregate3Value of Multicall3, deployed at the same address on most chains
+const (
+	Multicall3AbiJson = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"},{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"uint256","name":"value","type":"uint256"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3Value[]","name":"calls","type":"tuple[]"}],"name":"aggregate3Value","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+	AddressHex        = "0xcA11bde05977b3631167028862bE2a173976CA11"
+)
+
+var (
+	Multicall3Abi *abi.ABI
+	Address       = common.HexToAddress(AddressHex)
+)
+
+type Call3 struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type Call3Value struct {
+	Target       common.Address
+	AllowFailure bool
+	Value        *big.Int
+	CallData     []byte
+}
+
+type Result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+func init() {
+	multicall3Abi, err := abi.JSON(strings.NewReader(Multicall3AbiJson))
+	if err != nil {
+		logger.G.Fatal("Failed to parse multicall3 ABI", zap.Error(err))
+	}
+	Multicall3Abi = &multicall3Abi
+}
@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"bxs/abi/bep20"
+	"bxs/abi/ds_token"
+	"bxs/abi/erc4337"
+	"bxs/abi/multicall3"
+	pancakev2 "bxs/abi/pancake/v2"
+	uniswapv2 "bxs/abi/uniswap/v2"
+	uniswapv3 "bxs/abi/uniswap/v3"
+	"bxs/abi/xlaunch"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+the kinds of the built-in ABIs, a JSON file of the registry directory named after one replaces it,
+for a contract deployed with other argument names or indexed inputs. The calls and the event parsers only use
+these kinds: a file of another kind is loaded, but a new contract still needs its calls or its parser written in Go.
+*/
+const (
+	KindBep20            = "bep20"
+	KindDsToken          = "ds_token"
+	KindPancakeV2Pair    = "pancake_v2_pair"
+	KindPancakeV2Factory = "pancake_v2_factory"
+	KindPancakeV2Router  = "pancake_v2_router"
+	KindUniswapV2Pair    = "uniswap_v2_pair"
+	KindUniswapV2Factory = "uniswap_v2_factory"
+	KindUniswapV3Pool    = "uniswap_v3_pool"
+	KindUniswapV3Factory = "uniswap_v3_factory"
+	KindXLaunchPool      = "xlaunch_pool"
+	KindXLaunchFactory   = "xlaunch_factory"
+	KindEntryPoint       = "erc4337_entry_point"
+	KindMulticall3       = "multicall3"
+)
+
+var (
+	ErrKindNotFound   = errors.New("abi kind not found")
+	ErrMethodNotFound = errors.New("abi method not found")
+	ErrEventNotFound  = errors.New("abi event not found")
+)
+
+type kindEvent struct {
+	kind  string
+	event *abi.Event
+}
+
+/*
+Registry indexes the ABIs by contract kind: their methods by (kind, method) and their events by topic0,
+the parsers resolve their events in it when they are built, so a replaced ABI is used by them with no code change,
+so two kinds can define a method of the same name with different outputs.
+A topic0 can be defined by several kinds, the same signature with different indexed inputs (the ERC-20 and ERC-721 Transfer),
+the lookups of an event take the kind, or the first kind registered with it when empty.
+*/
+type Registry struct {
+	mu     sync.RWMutex
+	abis   map[string]*abi.ABI
+	events map[common.Hash][]kindEvent
+}
+
+func New() *Registry {
+	return &Registry{
+		abis:   make(map[string]*abi.ABI),
+		events: make(map[common.Hash][]kindEvent),
+	}
+}
+
+// Add registers the ABI of a kind, replacing the one registered before
+func (r *Registry) Add(kind string, contractAbi *abi.ABI) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.abis[kind]; ok {
+		for topic, events := range r.events {
+			r.events[topic] = removeKind(events, kind)
+		}
+	}
+
+	r.abis[kind] = contractAbi
+	for _, event := range contractAbi.Events {
+		r.events[event.ID] = append(r.events[event.ID], kindEvent{kind: kind, event: &event})
+	}
+}
+
+func removeKind(events []kindEvent, kind string) []kindEvent {
+	kept := events[:0]
+	for _, e := range events {
+		if e.kind != kind {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// AddJSON registers an ABI JSON: a plain ABI array, or a compiler artifact with the array in its abi field
+func (r *Registry) AddJSON(kind string, data []byte) error {
+	var artifact struct {
+		Abi json.RawMessage `json:"abi"`
+	}
+	if err := json.Unmarshal(data, &artifact); err == nil && len(artifact.Abi) > 0 {
+		data = artifact.Abi
+	}
+
+	contractAbi, err := abi.JSON(strings.NewReader(string(data)))
+	if err != nil {
+		return fmt.Errorf("abi kind %s: %w", kind, err)
+	}
+	r.Add(kind, &contractAbi)
+	return nil
+}
+
+// LoadDir registers the *.json files of a directory, the kind of a file is its name without the extension, see BuiltIn
+func (r *Registry) LoadDir(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := make([]string, 0, len(paths))
+	for _, path := range paths {
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		kind := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if err = r.AddJSON(kind, data); err != nil {
+			return nil, err
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+func (r *Registry) ABI(kind string) (*abi.ABI, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	contractAbi, ok := r.abis[kind]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKindNotFound, kind)
+	}
+	return contractAbi, nil
+}
+
+func (r *Registry) Method(kind, name string) (*abi.Method, error) {
+	contractAbi, err := r.ABI(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	method, ok := contractAbi.Methods[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s.%s", ErrMethodNotFound, kind, name)
+	}
+	return &method, nil
+}
+
+// Event returns the event of a kind by topic0, of any kind when kind is empty
+func (r *Registry) Event(kind string, topic0 common.Hash) (*abi.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, e := range r.events[topic0] {
+		if kind == "" || e.kind == kind {
+			return e.event, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrEventNotFound, kind, topic0)
+}
+
+func (r *Registry) Pack(kind, method string, args ...interface{}) ([]byte, error) {
+	contractAbi, err := r.ABI(kind)
+	if err != nil {
+		return nil, err
+	}
+	return contractAbi.Pack(method, args...)
+}
+
+func (r *Registry) Unpack(kind, method string, data []byte) ([]interface{}, error) {
+	contractAbi, err := r.ABI(kind)
+	if err != nil {
+		return nil, err
+	}
+	return contractAbi.Unpack(method, data)
+}
+
+// G holds the built-in ABIs, and the ones loaded from the registry directory on start
+var G = New()
+
+var builtIn = make(map[string]bool)
+
+// BuiltIn tells a kind is used by the calls or the parsers, a loaded ABI of another kind is used by nothing
+func BuiltIn(kind string) bool {
+	return builtIn[kind]
+}
+
+func init() {
+	for kind, contractAbi := range map[string]*abi.ABI{
+		KindBep20:            bep20.Abi,
+		KindDsToken:          ds_token.Abi,
+		KindPancakeV2Pair:    pancakev2.PairAbi,
+		KindPancakeV2Factory: pancakev2.FactoryAbi,
+		KindPancakeV2Router:  pancakev2.RouterAbi,
+		KindUniswapV2Pair:    uniswapv2.PairAbi,
+		KindUniswapV2Factory: uniswapv2.FactoryAbi,
+		KindUniswapV3Pool:    uniswapv3.PoolAbi,
+		KindUniswapV3Factory: uniswapv3.FactoryAbi,
+		KindXLaunchPool:      xlaunch.PairAbi,
+		KindXLaunchFactory:   xlaunch.FactoryAbi,
+		KindEntryPoint:       erc4337.EntryPointAbi,
+		KindMulticall3:       multicall3.Multicall3Abi,
+	} {
+		G.Add(kind, contractAbi)
+		builtIn[kind] = true
+	}
+}
@@ -0,0 +1,88 @@
+package registry
+
+import (
+	"bxs/abi/bep20"
+	pancakev2 "bxs/abi/pancake/v2"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	// the Transfer of an ERC-721, the topic0 of the ERC-20 one with the tokenId indexed
+	erc721Json = `[{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":true,"name":"tokenId","type":"uint256"}],"name":"Transfer","type":"event"},{"inputs":[{"name":"tokenId","type":"uint256"}],"name":"ownerOf","outputs":[{"name":"","type":"address"}],"stateMutability":"view","type":"function"}]`
+	// a compiler artifact, the ABI is in its abi field
+	vaultArtifactJson = `{"contractName":"Vault","abi":[{"inputs":[],"name":"totalAssets","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"}]}`
+)
+
+func TestRegistry_BuiltIn(t *testing.T) {
+	method, err := G.Method(KindBep20, "decimals")
+	require.NoError(t, err)
+	require.Equal(t, bep20.Abi.Methods["decimals"].ID, method.ID)
+
+	event, err := G.Event("", pancakev2.SwapTopic0)
+	require.NoError(t, err)
+	require.Equal(t, "Swap", event.Name)
+
+	_, err = G.Method(KindBep20, "getReserves")
+	require.ErrorIs(t, err, ErrMethodNotFound)
+	_, err = G.ABI("unknown")
+	require.ErrorIs(t, err, ErrKindNotFound)
+
+	require.True(t, BuiltIn(KindPancakeV2Pair))
+	require.False(t, BuiltIn("erc721"))
+}
+
+func TestRegistry_LoadDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "erc721.json"), []byte(erc721Json), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "vault.json"), []byte(vaultArtifactJson), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("not an abi"), 0644))
+
+	r := New()
+	r.Add(KindBep20, bep20.Abi)
+	kinds, err := r.LoadDir(dir)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"erc721", "vault"}, kinds)
+
+	data, err := r.Pack("vault", "totalAssets")
+	require.NoError(t, err)
+	require.Equal(t, crypto.Keccak256([]byte("totalAssets()"))[:4], data)
+
+	out, err := r.ABI("vault")
+	require.NoError(t, err)
+	packed, err := out.Methods["totalAssets"].Outputs.Pack(big.NewInt(7))
+	require.NoError(t, err)
+	values, err := r.Unpack("vault", "totalAssets", packed)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(7), values[0])
+
+	// both kinds define the Transfer topic0, with different indexed inputs
+	transferTopic0 := bep20.Abi.Events["Transfer"].ID
+	erc20Transfer, err := r.Event(KindBep20, transferTopic0)
+	require.NoError(t, err)
+	erc721Transfer, err := r.Event("erc721", transferTopic0)
+	require.NoError(t, err)
+	require.False(t, erc20Transfer.Inputs[2].Indexed)
+	require.True(t, erc721Transfer.Inputs[2].Indexed)
+
+	// a file replaces the kind it is named after
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bep20.json"), []byte(vaultArtifactJson), 0644))
+	_, err = r.LoadDir(dir)
+	require.NoError(t, err)
+	_, err = r.Event(KindBep20, transferTopic0)
+	require.ErrorIs(t, err, ErrEventNotFound)
+	_, err = r.Method(KindBep20, "totalAssets")
+	require.NoError(t, err)
+}
+
+func TestRegistry_LoadDirInvalid(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"abi":`), 0644))
+
+	_, err := New().LoadDir(dir)
+	require.Error(t, err)
+}
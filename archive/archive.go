@@ -0,0 +1,266 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	bucketSize = 10000
+	fileSuffix = ".rlp.gz"
+)
+
+var ErrNotFound = errors.New("block not in archive")
+
+/*
+Archive keeps the raw blocks and receipts fetched from the node on local disk, so history can be parsed again without RPC.
+The answers of the archive node the parser needs for them are kept next to them, see CallStore.
+
+Each block is a gzip file of the RLP encoded block and consensus receipts, named by height and grouped in directories of 10000 blocks:
+<dir>/<height/10000>/<height>.rlp.gz. The sha256 of the RLP payload is kept in the gzip header comment.
+
+Get checks the sha256, the height, the tx root and the receipt root against the block header,
+then derives the receipt fields (tx hash, indexes, gas used, log positions) the node returned but RLP does not keep.
+*/
+type Archive interface {
+	Put(block *ethtypes.Block, receipts []*ethtypes.Receipt) error
+	Get(height uint64) (*ethtypes.Block, []*ethtypes.Receipt, error)
+	Has(height uint64) bool
+	// Range returns the lowest and highest archived heights, ok is false when the archive is empty
+	Range() (from, to uint64, ok bool)
+	// Verify reads every archived block in [from, to] and returns the heights failing the checks
+	Verify(from, to uint64) ([]uint64, error)
+	// Prune removes the archived blocks in [from, to] and returns how many were removed
+	Prune(from, to uint64) (int, error)
+}
+
+type entry struct {
+	Block    rlp.RawValue
+	Receipts []*ethtypes.Receipt
+}
+
+type archive struct {
+	dir         string
+	chainConfig *params.ChainConfig // derives the receipt fields
+	lock        sync.Mutex          // guards the bucket directories between Put and Prune
+}
+
+func NewArchive(dir string, chainConfig *params.ChainConfig) (Archive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &archive{dir: dir, chainConfig: chainConfig}, nil
+}
+
+func (a *archive) bucketDir(height uint64) string {
+	return filepath.Join(a.dir, strconv.FormatUint(height/bucketSize, 10))
+}
+
+func (a *archive) path(height uint64) string {
+	return filepath.Join(a.bucketDir(height), strconv.FormatUint(height, 10)+fileSuffix)
+}
+
+func (a *archive) Put(block *ethtypes.Block, receipts []*ethtypes.Receipt) error {
+	blockRlp, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		return err
+	}
+
+	payload, err := rlp.EncodeToBytes(&entry{Block: blockRlp, Receipts: receipts})
+	if err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(payload)
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Comment = hex.EncodeToString(checksum[:])
+	if _, err = zw.Write(payload); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+
+	height := block.NumberU64()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err = os.MkdirAll(a.bucketDir(height), 0755); err != nil {
+		return err
+	}
+
+	// written to a tmp file and renamed, so a crash never leaves a truncated block behind
+	tmp := a.path(height) + ".tmp"
+	if err = os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path(height))
+}
+
+func (a *archive) read(height uint64) (*entry, error) {
+	f, err := os.Open(a.path(height))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := sha256.Sum256(payload)
+	if zr.Comment != hex.EncodeToString(checksum[:]) {
+		return nil, fmt.Errorf("block %d checksum mismatch", height)
+	}
+
+	e := &entry{}
+	if err = rlp.DecodeBytes(payload, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (a *archive) Get(height uint64) (*ethtypes.Block, []*ethtypes.Receipt, error) {
+	e, err := a.read(height)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block := &ethtypes.Block{}
+	if err = rlp.DecodeBytes(e.Block, block); err != nil {
+		return nil, nil, err
+	}
+
+	if block.NumberU64() != height {
+		return nil, nil, fmt.Errorf("block %d archived as %d", block.NumberU64(), height)
+	}
+
+	receipts := ethtypes.Receipts(e.Receipts)
+	if txRoot := ethtypes.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); txRoot != block.TxHash() {
+		return nil, nil, fmt.Errorf("block %d tx root mismatch", height)
+	}
+	if receiptRoot := ethtypes.DeriveSha(receipts, trie.NewStackTrie(nil)); receiptRoot != block.ReceiptHash() {
+		return nil, nil, fmt.Errorf("block %d receipt root mismatch", height)
+	}
+
+	// the blob gas price is left empty, the parser does not use it
+	err = receipts.DeriveFields(a.chainConfig, block.Hash(), height, block.Time(), block.BaseFee(), nil, block.Transactions())
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, receipts, nil
+}
+
+func (a *archive) Has(height uint64) bool {
+	_, err := os.Stat(a.path(height))
+	return err == nil
+}
+
+func (a *archive) Range() (uint64, uint64, bool) {
+	heights, err := a.heights(0, ^uint64(0))
+	if err != nil || len(heights) == 0 {
+		return 0, 0, false
+	}
+	return heights[0], heights[len(heights)-1], true
+}
+
+// heights lists the archived heights in [from, to] in ascending order
+func (a *archive) heights(from, to uint64) ([]uint64, error) {
+	buckets, err := os.ReadDir(a.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var heights []uint64
+	for _, bucket := range buckets {
+		bucketId, parseErr := strconv.ParseUint(bucket.Name(), 10, 64)
+		if !bucket.IsDir() || parseErr != nil || bucketId < from/bucketSize || bucketId > to/bucketSize {
+			continue
+		}
+
+		files, readErr := os.ReadDir(filepath.Join(a.dir, bucket.Name()))
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		for _, file := range files {
+			name, ok := strings.CutSuffix(file.Name(), fileSuffix)
+			if !ok {
+				continue
+			}
+			height, heightErr := strconv.ParseUint(name, 10, 64)
+			if heightErr != nil || height < from || height > to {
+				continue
+			}
+			heights = append(heights, height)
+		}
+	}
+
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights, nil
+}
+
+func (a *archive) Verify(from, to uint64) ([]uint64, error) {
+	heights, err := a.heights(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var bad []uint64
+	for _, height := range heights {
+		if _, _, err = a.Get(height); err != nil {
+			bad = append(bad, height)
+		}
+	}
+	return bad, nil
+}
+
+func (a *archive) Prune(from, to uint64) (int, error) {
+	heights, err := a.heights(from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	cnt := 0
+	bucketDirs := make(map[string]struct{})
+	for _, height := range heights {
+		if err = os.Remove(a.path(height)); err != nil {
+			return cnt, err
+		}
+		bucketDirs[a.bucketDir(height)] = struct{}{}
+		cnt++
+	}
+
+	for dir := range bucketDirs {
+		// fails on the buckets still holding blocks out of the range
+		os.Remove(dir)
+	}
+	return cnt, nil
+}
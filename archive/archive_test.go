@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"bxs/chain_params"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBlock(t *testing.T, height uint64) (*ethtypes.Block, []*ethtypes.Receipt) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	signer := ethtypes.LatestSignerForChainID(chain_params.G.ChainConfig.ChainID)
+	to := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+
+	legacyTx, err := ethtypes.SignNewTx(key, signer, &ethtypes.LegacyTx{Nonce: 0, To: &to, Gas: 21000, GasPrice: big.NewInt(1)})
+	require.NoError(t, err)
+	dynamicTx, err := ethtypes.SignNewTx(key, signer, &ethtypes.DynamicFeeTx{
+		ChainID: chain_params.G.ChainConfig.ChainID, Nonce: 1, To: &to, Gas: 50000, GasFeeCap: big.NewInt(2), GasTipCap: big.NewInt(1),
+	})
+	require.NoError(t, err)
+
+	receipts := []*ethtypes.Receipt{
+		{Type: ethtypes.LegacyTxType, Status: ethtypes.ReceiptStatusSuccessful, CumulativeGasUsed: 21000},
+		{Type: ethtypes.DynamicFeeTxType, Status: ethtypes.ReceiptStatusSuccessful, CumulativeGasUsed: 60000, Logs: []*ethtypes.Log{
+			{Address: to, Topics: []common.Hash{common.HexToHash("0x01")}, Data: []byte{1}},
+			{Address: to, Topics: []common.Hash{common.HexToHash("0x02")}},
+		}},
+	}
+
+	header := &ethtypes.Header{
+		Number:     new(big.Int).SetUint64(height),
+		Time:       1700000000,
+		Difficulty: big.NewInt(2),
+		BaseFee:    big.NewInt(0),
+	}
+	block := ethtypes.NewBlock(header, &ethtypes.Body{Transactions: ethtypes.Transactions{legacyTx, dynamicTx}}, receipts, trie.NewStackTrie(nil))
+	return block, receipts
+}
+
+func TestArchive_PutGet(t *testing.T) {
+	a, err := NewArchive(t.TempDir(), chain_params.G.ChainConfig)
+	require.NoError(t, err)
+
+	block, _ := newTestBlock(t, 12345)
+	_, _, err = a.Get(12345)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, _, ok := a.Range()
+	require.False(t, ok)
+
+	require.NoError(t, a.Put(newTestBlock(t, 12345)))
+	require.NoError(t, a.Put(block, nil))
+	require.True(t, a.Has(12345))
+
+	// the receipts must match the block receipt root
+	_, _, err = a.Get(12345)
+	require.ErrorContains(t, err, "receipt root mismatch")
+
+	block, receipts := newTestBlock(t, 12346)
+	require.NoError(t, a.Put(block, receipts))
+	getBlock, getReceipts, err := a.Get(12346)
+	require.NoError(t, err)
+	require.Equal(t, block.Hash(), getBlock.Hash())
+	require.Len(t, getReceipts, 2)
+
+	// derived fields are restored
+	receipt := getReceipts[1]
+	require.Equal(t, block.Transactions()[1].Hash(), receipt.TxHash)
+	require.Equal(t, uint(1), receipt.TransactionIndex)
+	require.Equal(t, uint64(39000), receipt.GasUsed)
+	require.Equal(t, uint64(12346), receipt.BlockNumber.Uint64())
+	require.Equal(t, uint(1), receipt.Logs[1].Index)
+	require.Equal(t, receipt.TxHash, receipt.Logs[1].TxHash)
+	require.Equal(t, []byte{1}, receipt.Logs[0].Data)
+
+	from, to, ok := a.Range()
+	require.True(t, ok)
+	require.Equal(t, uint64(12345), from)
+	require.Equal(t, uint64(12346), to)
+}
+
+func TestArchive_VerifyPrune(t *testing.T) {
+	a, err := NewArchive(t.TempDir(), chain_params.G.ChainConfig)
+	require.NoError(t, err)
+
+	for _, height := range []uint64{9999, 10000, 10001, 20000} {
+		require.NoError(t, a.Put(newTestBlock(t, height)))
+	}
+
+	bad, err := a.Verify(0, 30000)
+	require.NoError(t, err)
+	require.Empty(t, bad)
+
+	// flip a byte of the compressed payload
+	path := a.(*archive).path(10001)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-12] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	bad, err = a.Verify(0, 30000)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{10001}, bad)
+
+	cnt, err := a.Prune(9000, 10001)
+	require.NoError(t, err)
+	require.Equal(t, 3, cnt)
+	require.False(t, a.Has(9999))
+	require.False(t, a.Has(10000))
+	require.True(t, a.Has(20000))
+
+	from, to, ok := a.Range()
+	require.True(t, ok)
+	require.Equal(t, uint64(20000), from)
+	require.Equal(t, uint64(20000), to)
+}
+
+func TestCallStore(t *testing.T) {
+	dir := t.TempDir()
+	calls, err := NewCallStore(dir)
+	require.NoError(t, err)
+
+	_, err = calls.Get("price 1")
+	require.ErrorIs(t, err, ErrCallNotFound)
+	require.NoError(t, calls.Put("price 1", []byte("600")))
+	require.NoError(t, calls.Put("eth_call 0x01 0x06fdde03", nil))
+	// a key keeps its first answer
+	require.NoError(t, calls.Put("price 1", []byte("700")))
+	require.NoError(t, calls.Close())
+
+	// a truncated last line is skipped
+	f, err := os.OpenFile(filepath.Join(dir, callsFile), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"key":"price 2","val`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	calls, err = NewCallStore(dir)
+	require.NoError(t, err)
+	defer calls.Close()
+	value, err := calls.Get("price 1")
+	require.NoError(t, err)
+	require.Equal(t, "600", string(value))
+	value, err = calls.Get("eth_call 0x01 0x06fdde03")
+	require.NoError(t, err)
+	require.Empty(t, value)
+	_, err = calls.Get("price 2")
+	require.ErrorIs(t, err, ErrCallNotFound)
+}
@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const callsFile = "calls.jsonl"
+
+// ErrCallNotFound is the error of a call answered from the archive that was not archived
+var ErrCallNotFound = errors.New("call not in archive")
+
+/*
+CallStore keeps, next to the archived blocks, the answers the parser got from the archive node while parsing them:
+the native price of each block, the eth_call of the tokens and pairs, the revert reasons of the failed swaps.
+In replay mode they are answered from it, so the archived blocks are parsed again without any RPC.
+
+The answers are appended to <dir>/calls.jsonl, a key keeps its first answer, like the calls on the head state
+answered again the same when replayed. Prune keeps them, they are a small part of the archive.
+The calls answered from the cache when the blocks were archived are not in it, they are replayed on the same database.
+*/
+type CallStore interface {
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	Close() error
+}
+
+type call struct {
+	Key   string        `json:"key"`
+	Value hexutil.Bytes `json:"value"`
+}
+
+type callStore struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+	file   *os.File
+	w      *bufio.Writer
+}
+
+// NewCallStore loads the archived calls of dir and appends the new ones to them
+func NewCallStore(dir string) (CallStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, callsFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &callStore{values: make(map[string][]byte), file: file, w: bufio.NewWriter(file)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		c := &call{}
+		// a crash can leave the last line truncated, it is answered again by the node
+		if json.Unmarshal(scanner.Bytes(), c) != nil {
+			continue
+		}
+		if _, ok := s.values[c.Key]; !ok {
+			s.values[c.Key] = c.Value
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *callStore) Get(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.values[key]
+	if !ok {
+		return nil, ErrCallNotFound
+	}
+	return value, nil
+}
+
+func (s *callStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		return nil
+	}
+
+	line, err := json.Marshal(&call{Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	if _, err = s.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// flushed line by line, a crash truncates the line being written at most, it is skipped when loaded
+	if err = s.w.Flush(); err != nil {
+		return err
+	}
+	s.values[key] = value
+	return nil
+}
+
+func (s *callStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -1,11 +1,13 @@
 package block_getter
 
 import (
+	"bxs/archive"
 	"bxs/cache"
 	"bxs/config"
 	"bxs/logger"
 	"bxs/metrics"
 	"bxs/sequencer"
+	"bxs/tracing"
 	"bxs/types"
 	"context"
 	"github.com/avast/retry-go/v4"
@@ -15,6 +17,8 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/panjf2000/ants/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"math/big"
 	"sync"
@@ -25,53 +29,83 @@ type BlockGetter interface {
 	Start()
 	GetStartBlockNumber(startBlockNumber uint64) uint64
 	StartDispatch(startBlockNumber uint64)
+	// Stop stops dispatching new blocks, the dispatched ones are still fetched and Next returns nil after them
 	Stop()
+	// Dispatched returns the highest block dispatched to the workers, 0 before the first one
+	Dispatched() uint64
+	// Head returns the highest head of the chain seen, the highest archived block in replay mode
+	Head() uint64
 	GetBlockAsync(blockNumber uint64)
 	Next() *types.BlockContext
 }
 
 type blockGetter struct {
+	chain           string // the chain label of the metrics
 	subHeader       bool
-	ctx             context.Context
+	mode            string
+	logRange        uint64
+	filterQueries   []ethereum.FilterQuery
+	ctx             context.Context // the in flight requests are only cancelled with it
+	stopCtx         context.Context // done on Stop, ends the dispatch and the new head loops
+	stop            context.CancelFunc
 	wsEthClient     *ethclient.Client
 	ethClientPool   EthClientPool
-	inputQueue      chan uint64
+	inputQueue      chan blockRange
 	outputBuffer    chan *types.BlockContext
 	workPool        *ants.Pool
 	cache           cache.BlockCache
-	stopped         SafeVar[bool]
 	blockHeaderChan chan *ethtypes.Header
 	blockSequencer  sequencer.Sequencer
 	headerHeight    SafeVar[uint64]
+	dispatched      SafeVar[uint64]
 	retryParams     *config.RetryParams
+	archive         archive.Archive
 }
 
 func NewBlockGetter(
+	ctx context.Context,
+	chain string,
 	subHeader bool,
 	wsEthClient *ethclient.Client,
+	ethClientPool EthClientPool, // nil in replay mode
 	cache cache.BlockCache,
 	blockSequencer sequencer.Sequencer,
 	retryParams *config.RetryParams,
+	filterQueries []ethereum.FilterQuery,
+	blockArchive archive.Archive,
 ) BlockGetter {
 	workPool, err := ants.NewPool(config.G.BlockGetter.PoolSize)
 	if err != nil {
 		logger.G.Fatal("ants pool(BlockGetter) init err", zap.Error(err))
 	}
 
-	ethClientPool_ := NewEthClientPool(config.G.Chain.WsEndpoint, config.G.BlockGetter.PoolSize)
+	if blockArchive != nil && config.G.BlockGetter.Mode == ModeLogs {
+		logger.G.Warn("blocks are not archived in logs mode, full blocks are not fetched")
+	}
+	if config.G.FailedSwaps.Enabled && config.G.BlockGetter.Mode == ModeLogs {
+		logger.G.Warn("failed swaps are not decoded in logs mode, the reverted txs have no logs and are not fetched")
+	}
 
+	stopCtx, stop := context.WithCancel(ctx)
 	return &blockGetter{
+		chain:           chain,
 		subHeader:       subHeader,
-		ctx:             context.Background(),
+		mode:            config.G.BlockGetter.Mode,
+		logRange:        max(config.G.BlockGetter.LogRange, 1),
+		filterQueries:   filterQueries,
+		ctx:             ctx,
+		stopCtx:         stopCtx,
+		stop:            stop,
 		wsEthClient:     wsEthClient,
-		ethClientPool:   ethClientPool_,
-		inputQueue:      make(chan uint64, config.G.BlockGetter.QueueSize),
+		ethClientPool:   ethClientPool,
+		inputQueue:      make(chan blockRange, config.G.BlockGetter.QueueSize),
 		outputBuffer:    make(chan *types.BlockContext, 10),
 		workPool:        workPool,
 		cache:           cache,
 		blockHeaderChan: make(chan *ethtypes.Header, 100),
 		blockSequencer:  blockSequencer,
 		retryParams:     retryParams,
+		archive:         blockArchive,
 	}
 }
 
@@ -79,7 +113,7 @@ func (bg *blockGetter) Commit(x sequencer.Sequenceable) {
 	bg.outputBuffer <- x.(*types.BlockContext)
 }
 
-func (bg *blockGetter) getBlock(blockNumber uint64) (*types.BlockContext, error) {
+func (bg *blockGetter) getBlock(ctx context.Context, blockNumber uint64) (*types.BlockContext, error) {
 	var (
 		block          *ethtypes.Block
 		blockReceipts  []*ethtypes.Receipt
@@ -91,8 +125,10 @@ func (bg *blockGetter) getBlock(blockNumber uint64) (*types.BlockContext, error)
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
+		spanCtx, span := tracing.Start(ctx, "eth_getBlockByNumber")
 		now := time.Now()
-		block, getBlockErr = bg.ethClientPool.Get().BlockByNumber(bg.ctx, big.NewInt(int64(blockNumber)))
+		block, getBlockErr = bg.ethClientPool.Get().BlockByNumber(spanCtx, big.NewInt(int64(blockNumber)))
+		tracing.End(span, getBlockErr)
 		if getBlockErr == nil {
 			duration := time.Since(now)
 			metrics.GetBlockDurationMs.Observe(float64(duration.Milliseconds()))
@@ -101,8 +137,10 @@ func (bg *blockGetter) getBlock(blockNumber uint64) (*types.BlockContext, error)
 
 	go func() {
 		defer wg.Done()
+		spanCtx, span := tracing.Start(ctx, "eth_getBlockReceipts")
 		now := time.Now()
-		blockReceipts, getReceiptsErr = bg.ethClientPool.Get().BlockReceipts(bg.ctx, rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(blockNumber)))
+		blockReceipts, getReceiptsErr = bg.ethClientPool.Get().BlockReceipts(spanCtx, rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(blockNumber)))
+		tracing.End(span, getReceiptsErr)
 		if getReceiptsErr == nil {
 			duration := time.Since(now)
 			metrics.GetBlockReceiptsDurationMs.Observe(float64(duration.Milliseconds()))
@@ -119,26 +157,58 @@ func (bg *blockGetter) getBlock(blockNumber uint64) (*types.BlockContext, error)
 	}
 	metrics.BlockDelay.Observe(time.Now().Sub(time.Unix((int64)(block.Time()), 0)).Seconds())
 
+	if bg.archive != nil {
+		if err := bg.archive.Put(block, blockReceipts); err != nil {
+			logger.G.Warn("archive block err", zap.Uint64("blockNumber", blockNumber), zap.Error(err))
+		}
+	}
+
+	return bg.newBlockContext(block, blockReceipts), nil
+}
+
+func (bg *blockGetter) newBlockContext(block *ethtypes.Block, blockReceipts []*ethtypes.Receipt) *types.BlockContext {
 	transactions := block.Transactions()
 	transactionsLen := uint(len(transactions))
 	return &types.BlockContext{
 		HeightTime:      types.GetBlockHeightTime(block.Header()),
+		HeadHeight:      bg.getHeaderHeight(),
 		TransactionsLen: transactionsLen,
 		Transactions:    transactions,
 		Receipts:        blockReceipts,
 		Senders:         make([]common.Address, transactionsLen),
 		TxResults:       make([]*types.TxResult, transactionsLen),
-	}, nil
+	}
 }
 
-func (bg *blockGetter) getBlockWithRetry(blockNumber uint64) (*types.BlockContext, error) {
-	return retry.DoWithData(func() (*types.BlockContext, error) {
-		return bg.getBlock(blockNumber)
-	}, bg.retryParams.Attempts, bg.retryParams.Delay)
+func (bg *blockGetter) getArchivedBlock(ctx context.Context, blockNumber uint64) (*types.BlockContext, error) {
+	_, span := tracing.Start(ctx, "get_archived_block")
+	block, blockReceipts, err := bg.archive.Get(blockNumber)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return bg.newBlockContext(block, blockReceipts), nil
+}
+
+// getBlockWithRetry retries until it gets the block or bg.ctx is done, the sequencer waits for every block
+func (bg *blockGetter) getBlockWithRetry(ctx context.Context, blockNumber uint64) (*types.BlockContext, error) {
+	ctx, span := tracing.Start(ctx, "get_block")
+	for {
+		bc, err := retry.DoWithData(func() (*types.BlockContext, error) {
+			return bg.getBlock(ctx, blockNumber)
+		}, bg.retryParams.Attempts, bg.retryParams.Delay, retry.Context(bg.ctx), retry.OnRetry(func(n uint, err error) {
+			span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", int(n)+1), attribute.String("error", err.Error())))
+		}))
+		if err == nil || bg.ctx.Err() != nil {
+			tracing.End(span, err)
+			return bc, err
+		}
+		logger.G.Error("get block attempts exhausted, retry", zap.Uint64("blockNumber", blockNumber), zap.Error(err))
+	}
 }
 
 func (bg *blockGetter) GetBlockAsync(blockNumber uint64) {
-	bg.inputQueue <- blockNumber
+	bg.inputQueue <- blockRange{from: blockNumber, to: blockNumber}
 }
 
 func (bg *blockGetter) Next() *types.BlockContext {
@@ -151,36 +221,95 @@ func (bg *blockGetter) Start() {
 	tagFor:
 		for {
 			select {
-			case blockNumber, ok := <-bg.inputQueue:
+			case r, ok := <-bg.inputQueue:
 				if !ok {
 					logger.G.Info("block inputQueue is closed")
 					break tagFor
 				}
+				metrics.QueueDepth.WithLabelValues(bg.chain, "block_getter_input").Set(float64(len(bg.inputQueue)))
 
 				wg.Add(1)
+				if bg.mode == ModeLogs {
+					bg.workPool.Submit(func() {
+						defer wg.Done()
+						bg.getAndCommitLogRange(r)
+					})
+					continue
+				}
+
+				blockNumber := r.from
+				if bg.mode == ModeReplay {
+					bg.workPool.Submit(func() {
+						defer wg.Done()
+						ctx, span := tracing.Start(bg.ctx, "block", tracing.BlockNumber(blockNumber))
+						bw, err := bg.getArchivedBlock(ctx, blockNumber)
+						if err != nil {
+							tracing.End(span, err)
+							// the sequencer would wait for the block forever
+							logger.G.Fatal("get archived block err", zap.Uint64("blockNumber", blockNumber), zap.Error(err))
+						}
+						bw.TraceCtx = ctx
+						bg.commit(bw)
+					})
+					continue
+				}
+
 				bg.workPool.Submit(func() {
 					defer wg.Done()
 
 					logger.G.Debug("get block start", zap.Uint64("block_number", blockNumber))
-					bw, err := bg.getBlockWithRetry(blockNumber)
+					ctx, span := tracing.Start(bg.ctx, "block", tracing.BlockNumber(blockNumber))
+					bw, err := bg.getBlockWithRetry(ctx, blockNumber)
 					if err != nil {
+						tracing.End(span, err)
 						logger.G.Error("get block err", zap.Uint64("blockNumber", blockNumber), zap.Error(err))
 						return
 					}
+					bw.TraceCtx = ctx
 
 					logger.G.Debug("get block success", zap.Uint64("blockNumber", blockNumber))
-					metrics.BlockQueueSize.Set(float64(len(bg.outputBuffer)))
-					bg.blockSequencer.CommitWithSequence(bw, bg)
+					metrics.QueueDepth.WithLabelValues(bg.chain, "block_getter_output").Set(float64(len(bg.outputBuffer)))
+					bg.commit(bw)
 				})
 			}
 		}
 
 		wg.Wait()
 		logger.G.Info("all block getter task finish")
+		if bg.ethClientPool != nil {
+			bg.ethClientPool.Close()
+		}
 		close(bg.outputBuffer)
 	}()
 }
 
+func (bg *blockGetter) commit(bc *types.BlockContext) {
+	if err := bg.blockSequencer.CommitWithSequence(bc, bg); err != nil {
+		logger.G.Warn("commit block err", zap.Uint64("block", bc.HeightTime.Height), zap.Error(err))
+	}
+}
+
+func (bg *blockGetter) getAndCommitLogRange(r blockRange) {
+	logger.G.Debug("get log range start", zap.Uint64("from", r.from), zap.Uint64("to", r.to))
+	// the range is a trace of its own, linked from the traces of its blocks
+	rangeCtx, rangeSpan := tracing.Start(bg.ctx, "get_log_range", attribute.Int64("block.from", int64(r.from)), attribute.Int64("block.to", int64(r.to)))
+	bcs, err := bg.getLogRangeWithRetry(rangeCtx, r)
+	tracing.End(rangeSpan, err)
+	if err != nil {
+		logger.G.Error("get log range err", zap.Uint64("from", r.from), zap.Uint64("to", r.to), zap.Error(err))
+		return
+	}
+
+	logger.G.Debug("get log range success", zap.Uint64("from", r.from), zap.Uint64("to", r.to))
+	for _, bc := range bcs {
+		bc.TraceCtx, _ = tracing.Tracer().Start(bg.ctx, "block",
+			trace.WithAttributes(tracing.BlockNumber(bc.HeightTime.Height)),
+			trace.WithLinks(trace.LinkFromContext(rangeCtx)))
+		metrics.QueueDepth.WithLabelValues(bg.chain, "block_getter_output").Set(float64(len(bg.outputBuffer)))
+		bg.commit(bc)
+	}
+}
+
 func (bg *blockGetter) GetStartBlockNumber(startBlockNumber uint64) uint64 {
 	if startBlockNumber != 0 {
 		return startBlockNumber
@@ -191,6 +320,14 @@ func (bg *blockGetter) GetStartBlockNumber(startBlockNumber uint64) uint64 {
 		return finishedBlock + 1
 	}
 
+	if bg.mode == ModeReplay {
+		from, _, ok := bg.archive.Range()
+		if !ok {
+			logger.G.Fatal("replay from an empty archive")
+		}
+		return from
+	}
+
 	newestBlockNumber, err := bg.wsEthClient.BlockNumber(bg.ctx)
 	if err != nil {
 		logger.G.Fatal("ethClient.BlockNumber() err", zap.Error(err))
@@ -232,7 +369,11 @@ func (bg *blockGetter) reconnectWithBackoff() (ethereum.Subscription, <-chan err
 			zap.Error(err),
 			zap.Duration("nextRetry", retryDelay),
 		)
-		time.Sleep(retryDelay)
+		select {
+		case <-bg.stopCtx.Done():
+			return nil, nil
+		case <-time.After(retryDelay):
+		}
 
 		retryDelay *= 2
 		if retryDelay > maxRetryDelay {
@@ -244,17 +385,23 @@ func (bg *blockGetter) reconnectWithBackoff() (ethereum.Subscription, <-chan err
 func (bg *blockGetter) startQueryNewHead() {
 	go func() {
 		for {
-			bn, err := bg.wsEthClient.BlockNumber(bg.ctx)
+			interval := time.Millisecond * 300 // TODO Rate limiting - rapid requests may trigger RCP provider blocking
+			bn, err := bg.wsEthClient.BlockNumber(bg.stopCtx)
 			if err != nil {
 				logger.G.Error("ethClient.BlockNumber() err", zap.Error(err))
-				time.Sleep(time.Second)
-				continue
+				interval = time.Second
+			} else {
+				logger.G.Debug("New block", zap.Uint64("height", bn))
+				bg.setHeaderHeight(bn)
+				metrics.NewestHeight.WithLabelValues(bg.chain).Set(float64(bn))
 			}
 
-			logger.G.Debug("New block", zap.Uint64("height", bn))
-			bg.setHeaderHeight(bn)
-			metrics.NewestHeight.Set(float64(bn))
-			time.Sleep(time.Millisecond * 300) // TODO Rate limiting - rapid requests may trigger RCP provider blocking
+			select {
+			case <-bg.stopCtx.Done():
+				logger.G.Info("query new head stopped")
+				return
+			case <-time.After(interval):
+			}
 		}
 	}()
 }
@@ -281,7 +428,9 @@ func (bg *blockGetter) startSubscribeNewHead() {
 			case <-noBlockTimeout.C:
 			default:
 			}
-			sub.Unsubscribe()
+			if sub != nil {
+				sub.Unsubscribe()
+			}
 
 			sub, errChan = bg.reconnectWithBackoff()
 			noBlockTimeout.Reset(10 * time.Second)
@@ -289,6 +438,12 @@ func (bg *blockGetter) startSubscribeNewHead() {
 
 		for {
 			select {
+			case <-bg.stopCtx.Done():
+				if sub != nil {
+					sub.Unsubscribe()
+				}
+				logger.G.Info("subscribe new head stopped")
+				return
 			case err = <-errChan:
 				logger.G.Error("WebSocket error", zap.Error(err))
 				resetConnection()
@@ -296,7 +451,7 @@ func (bg *blockGetter) startSubscribeNewHead() {
 				height := blockHeader.Number.Uint64()
 				logger.G.Info("New block", zap.Uint64("height", height))
 				bg.setHeaderHeight(height)
-				metrics.NewestHeight.Set(float64(height))
+				metrics.NewestHeight.WithLabelValues(bg.chain).Set(float64(height))
 
 				noBlockTimeout.Stop()
 				select {
@@ -313,16 +468,34 @@ func (bg *blockGetter) startSubscribeNewHead() {
 }
 
 func (bg *blockGetter) dispatchRange(from, to uint64) (stopped bool, nextBlock uint64) {
+	if bg.mode == ModeLogs {
+		for i := from; i <= to; i += bg.logRange {
+			if bg.isStopped() {
+				return true, i
+			}
+			r := blockRange{from: i, to: min(i+bg.logRange-1, to)}
+			bg.inputQueue <- r
+			bg.dispatched.Set(r.to)
+		}
+		return false, 0
+	}
+
 	for i := from; i <= to; i++ {
 		if bg.isStopped() {
 			return true, i
 		}
 		bg.GetBlockAsync(i)
+		bg.dispatched.Set(i)
 	}
 	return false, 0
 }
 
 func (bg *blockGetter) StartDispatch(startBlockNumber uint64) {
+	if bg.mode == ModeReplay {
+		bg.startDispatchReplay(startBlockNumber)
+		return
+	}
+
 	if bg.subHeader {
 		bg.startSubscribeNewHead()
 	} else {
@@ -334,6 +507,11 @@ func (bg *blockGetter) StartDispatch(startBlockNumber uint64) {
 		for {
 			headerHeight := bg.getHeaderHeight()
 			if headerHeight < cur {
+				if bg.isStopped() {
+					logger.G.Info("dispatch interrupted", zap.Uint64("nextBlockHeight", cur))
+					bg.doStop()
+					return
+				}
 				time.Sleep(100 * time.Millisecond)
 				continue
 			}
@@ -351,13 +529,40 @@ func (bg *blockGetter) StartDispatch(startBlockNumber uint64) {
 }
 
 func (bg *blockGetter) Stop() {
-	bg.stopped.Set(true)
+	bg.stop()
 }
 
 func (bg *blockGetter) isStopped() bool {
-	return bg.stopped.Get()
+	return bg.stopCtx.Err() != nil
+}
+
+func (bg *blockGetter) Dispatched() uint64 {
+	return bg.dispatched.Get()
+}
+
+func (bg *blockGetter) Head() uint64 {
+	return bg.getHeaderHeight()
 }
 
 func (bg *blockGetter) doStop() {
 	close(bg.inputQueue)
 }
+
+// startDispatchReplay dispatches the archived blocks from startBlockNumber to the highest archived one, then stops
+func (bg *blockGetter) startDispatchReplay(startBlockNumber uint64) {
+	_, to, ok := bg.archive.Range()
+	if !ok {
+		logger.G.Fatal("replay from an empty archive")
+	}
+	bg.setHeaderHeight(to)
+
+	go func() {
+		stopped, nextBlockHeight := bg.dispatchRange(startBlockNumber, to)
+		if stopped {
+			logger.G.Info("dispatch interrupted", zap.Uint64("nextBlockHeight", nextBlockHeight))
+		} else {
+			logger.G.Info("replay dispatch finish", zap.Uint64("from", startBlockNumber), zap.Uint64("to", to))
+		}
+		bg.doStop()
+	}()
+}
@@ -182,3 +182,22 @@ func (p *ethClientPool) Close() {
 		client.close()
 	}
 }
+
+// staticEthClientPool always returns the same client, for a single node or an in process one
+type staticEthClientPool struct {
+	client *ethclient.Client
+}
+
+func NewStaticEthClientPool(client *ethclient.Client) EthClientPool {
+	return &staticEthClientPool{client: client}
+}
+
+func (p *staticEthClientPool) Get() *ethclient.Client {
+	return p.client
+}
+
+func (p *staticEthClientPool) GetWithIndex() (*ethclient.Client, int) {
+	return p.client, 0
+}
+
+func (p *staticEthClientPool) Close() {}
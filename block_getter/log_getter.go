@@ -0,0 +1,239 @@
+package block_getter
+
+import (
+	"bxs/logger"
+	"bxs/metrics"
+	"bxs/types"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.uber.org/zap"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	ModeBlock  = "block"
+	ModeLogs   = "logs"
+	ModeReplay = "replay"
+)
+
+type blockRange struct {
+	from uint64
+	to   uint64
+}
+
+/*
+getLogRange builds the block contexts of a block range from eth_getLogs instead of full blocks and receipts.
+Only the headers of the range and the transactions having a matched log are fetched, both in batch requests.
+
+The contexts have the same shape as in block mode, but sparse:
+  - Transactions holds only the matched transactions, at their index in the block, other entries are nil
+  - Receipts holds one synthesized receipt per matched transaction, with only the matched logs,
+    logs are only emitted by successful transactions so the receipts have status 1,
+    the traders paid to a contract are resolved from them, see the traderResolver of the parser
+*/
+func (bg *blockGetter) getLogRange(ctx context.Context, r blockRange) ([]*types.BlockContext, error) {
+	client := bg.ethClientPool.Get()
+
+	now := time.Now()
+	logs := make([]ethtypes.Log, 0, 256)
+	for _, query := range bg.filterQueries {
+		query.FromBlock = new(big.Int).SetUint64(r.from)
+		query.ToBlock = new(big.Int).SetUint64(r.to)
+		queryLogs, err := client.FilterLogs(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, queryLogs...)
+	}
+	metrics.GetLogsDurationMs.Observe(float64(time.Since(now).Milliseconds()))
+
+	headers, err := bg.getHeaders(ctx, client, r)
+	if err != nil {
+		return nil, err
+	}
+
+	height2TxIndex2Logs := make(map[uint64]map[uint][]*ethtypes.Log)
+	txHashes := make(map[common.Hash]struct{})
+	for i := range logs {
+		log := &logs[i]
+		if log.Removed {
+			continue
+		}
+
+		if log.BlockNumber < r.from || log.BlockNumber > r.to {
+			return nil, fmt.Errorf("log of block %d out of range [%d, %d]", log.BlockNumber, r.from, r.to)
+		}
+
+		// the range was reorganized between eth_getLogs and the headers request
+		if header := headers[log.BlockNumber-r.from]; log.BlockHash != header.hash {
+			return nil, fmt.Errorf("block %d hash mismatch, log %s header %s", log.BlockNumber, log.BlockHash, header.hash)
+		}
+
+		txIndex2Logs, ok := height2TxIndex2Logs[log.BlockNumber]
+		if !ok {
+			txIndex2Logs = make(map[uint][]*ethtypes.Log)
+			height2TxIndex2Logs[log.BlockNumber] = txIndex2Logs
+		}
+		txIndex2Logs[log.TxIndex] = append(txIndex2Logs[log.TxIndex], log)
+		txHashes[log.TxHash] = struct{}{}
+	}
+
+	txs, err := bg.getTransactions(ctx, client, txHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	bcs := make([]*types.BlockContext, 0, len(headers))
+	for _, header := range headers {
+		bcs = append(bcs, bg.newSparseBlockContext(header.Header, height2TxIndex2Logs[header.Number.Uint64()], txs))
+	}
+
+	last := headers[len(headers)-1]
+	metrics.BlockDelay.Observe(time.Now().Sub(time.Unix((int64)(last.Time), 0)).Seconds())
+	return bcs, nil
+}
+
+func (bg *blockGetter) newSparseBlockContext(
+	header *ethtypes.Header,
+	txIndex2Logs map[uint][]*ethtypes.Log,
+	txs map[common.Hash]*ethtypes.Transaction,
+) *types.BlockContext {
+	txIndexes := make([]uint, 0, len(txIndex2Logs))
+	for txIndex := range txIndex2Logs {
+		txIndexes = append(txIndexes, txIndex)
+	}
+	sort.Slice(txIndexes, func(i, j int) bool { return txIndexes[i] < txIndexes[j] })
+
+	var transactionsLen uint
+	if len(txIndexes) > 0 {
+		transactionsLen = txIndexes[len(txIndexes)-1] + 1
+	}
+
+	transactions := make([]*ethtypes.Transaction, transactionsLen)
+	receipts := make([]*ethtypes.Receipt, 0, len(txIndexes))
+	for _, txIndex := range txIndexes {
+		txLogs := txIndex2Logs[txIndex]
+		sort.Slice(txLogs, func(i, j int) bool { return txLogs[i].Index < txLogs[j].Index })
+
+		transactions[txIndex] = txs[txLogs[0].TxHash]
+		receipts = append(receipts, &ethtypes.Receipt{
+			Status:           ethtypes.ReceiptStatusSuccessful,
+			Logs:             txLogs,
+			TxHash:           txLogs[0].TxHash,
+			BlockHash:        txLogs[0].BlockHash,
+			BlockNumber:      header.Number,
+			TransactionIndex: txIndex,
+		})
+	}
+
+	return &types.BlockContext{
+		HeightTime:      types.GetBlockHeightTime(header),
+		HeadHeight:      bg.getHeaderHeight(),
+		TransactionsLen: transactionsLen,
+		Transactions:    transactions,
+		Receipts:        receipts,
+		Senders:         make([]common.Address, transactionsLen),
+		TxResults:       make([]*types.TxResult, transactionsLen),
+	}
+}
+
+// hashedHeader keeps the block hash reported by the node, it is what the logs refer to
+type hashedHeader struct {
+	*ethtypes.Header
+	hash common.Hash
+}
+
+func (bg *blockGetter) getHeaders(ctx context.Context, client *ethclient.Client, r blockRange) ([]*hashedHeader, error) {
+	raws := make([]json.RawMessage, r.to-r.from+1)
+	batch := make([]rpc.BatchElem, len(raws))
+	for i := range batch {
+		batch[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []any{hexutil.EncodeUint64(r.from + uint64(i)), false},
+			Result: &raws[i],
+		}
+	}
+
+	if err := client.Client().BatchCallContext(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	headers := make([]*hashedHeader, len(raws))
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+		if len(raws[i]) == 0 || string(raws[i]) == "null" {
+			return nil, fmt.Errorf("block %d not found", r.from+uint64(i))
+		}
+
+		header := &hashedHeader{Header: &ethtypes.Header{}}
+		if err := json.Unmarshal(raws[i], header.Header); err != nil {
+			return nil, err
+		}
+
+		var blockHash struct {
+			Hash common.Hash `json:"hash"`
+		}
+		if err := json.Unmarshal(raws[i], &blockHash); err != nil {
+			return nil, err
+		}
+		header.hash = blockHash.Hash
+		headers[i] = header
+	}
+	return headers, nil
+}
+
+func (bg *blockGetter) getTransactions(ctx context.Context, client *ethclient.Client, txHashes map[common.Hash]struct{}) (map[common.Hash]*ethtypes.Transaction, error) {
+	txs := make(map[common.Hash]*ethtypes.Transaction, len(txHashes))
+	if len(txHashes) == 0 {
+		return txs, nil
+	}
+
+	results := make([]*ethtypes.Transaction, len(txHashes))
+	batch := make([]rpc.BatchElem, 0, len(txHashes))
+	for txHash := range txHashes {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getTransactionByHash",
+			Args:   []any{txHash},
+			Result: &results[len(batch)],
+		})
+	}
+
+	if err := client.Client().BatchCallContext(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+		if results[i] == nil {
+			return nil, fmt.Errorf("tx %s not found", elem.Args[0])
+		}
+		txs[results[i].Hash()] = results[i]
+	}
+	return txs, nil
+}
+
+// getLogRangeWithRetry retries until it gets the range or bg.ctx is done, the sequencer waits for every block of it
+func (bg *blockGetter) getLogRangeWithRetry(ctx context.Context, r blockRange) ([]*types.BlockContext, error) {
+	for {
+		bcs, err := retry.DoWithData(func() ([]*types.BlockContext, error) {
+			return bg.getLogRange(ctx, r)
+		}, bg.retryParams.Attempts, bg.retryParams.Delay, retry.Context(bg.ctx))
+		if err == nil || bg.ctx.Err() != nil {
+			return bcs, err
+		}
+		logger.G.Error("get log range attempts exhausted, retry", zap.Uint64("from", r.from), zap.Uint64("to", r.to), zap.Error(err))
+	}
+}
@@ -0,0 +1,142 @@
+package block_getter
+
+import (
+	"bxs/config"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/avast/retry-go/v4"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeEth struct {
+	headers      map[uint64]*ethtypes.Header
+	logs         []*ethtypes.Log
+	txs          map[common.Hash]*ethtypes.Transaction
+	getLogsFails atomic.Int32 // eth_getLogs calls failing before the next one succeeds
+}
+
+func (f *fakeEth) GetLogs(query map[string]any) ([]*ethtypes.Log, error) {
+	if f.getLogsFails.Add(-1) >= 0 {
+		return nil, errors.New("node unavailable")
+	}
+	return f.logs, nil
+}
+
+func (f *fakeEth) GetBlockByNumber(number hexutil.Uint64, fullTx bool) (json.RawMessage, error) {
+	header, ok := f.headers[uint64(number)]
+	if !ok {
+		return json.RawMessage("null"), nil
+	}
+	return header.MarshalJSON()
+}
+
+func (f *fakeEth) GetTransactionByHash(hash common.Hash) (*ethtypes.Transaction, error) {
+	return f.txs[hash], nil
+}
+
+func TestBlockGetter_GetLogRange(t *testing.T) {
+	f := &fakeEth{
+		headers: make(map[uint64]*ethtypes.Header),
+		txs:     make(map[common.Hash]*ethtypes.Transaction),
+	}
+	for height := uint64(10); height <= 12; height++ {
+		f.headers[height] = &ethtypes.Header{
+			Number:     new(big.Int).SetUint64(height),
+			Time:       1700000000 + height,
+			Difficulty: big.NewInt(2),
+		}
+	}
+
+	tx := ethtypes.NewTx(&ethtypes.LegacyTx{Nonce: 1, Gas: 21000, GasPrice: big.NewInt(1), Value: big.NewInt(0)})
+	f.txs[tx.Hash()] = tx
+
+	blockHash := f.headers[11].Hash()
+	topic := common.HexToHash("0x01")
+	f.logs = []*ethtypes.Log{
+		{Topics: []common.Hash{topic}, BlockNumber: 11, BlockHash: blockHash, TxHash: tx.Hash(), TxIndex: 3, Index: 5},
+		{Topics: []common.Hash{topic}, BlockNumber: 11, BlockHash: blockHash, TxHash: tx.Hash(), TxIndex: 3, Index: 4},
+		{Topics: []common.Hash{topic}, BlockNumber: 12, BlockHash: f.headers[12].Hash(), TxHash: tx.Hash(), Removed: true},
+	}
+
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("eth", f))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := ethclient.Dial(httpServer.URL)
+	require.NoError(t, err)
+
+	bg := &blockGetter{
+		ctx:           context.Background(),
+		ethClientPool: NewStaticEthClientPool(client),
+		filterQueries: []ethereum.FilterQuery{{Topics: [][]common.Hash{{topic}}}},
+	}
+
+	bcs, err := bg.getLogRange(context.Background(), blockRange{from: 10, to: 12})
+	require.NoError(t, err)
+	require.Len(t, bcs, 3)
+
+	require.Equal(t, uint64(10), bcs[0].HeightTime.Height)
+	require.Equal(t, uint(0), bcs[0].TransactionsLen)
+	require.Empty(t, bcs[0].Receipts)
+
+	bc := bcs[1]
+	require.Equal(t, uint64(11), bc.HeightTime.Height)
+	require.Equal(t, uint64(1700000011), bc.HeightTime.Timestamp)
+	require.Equal(t, uint(4), bc.TransactionsLen)
+	require.Nil(t, bc.Transactions[0])
+	require.Equal(t, tx.Hash(), bc.Transactions[3].Hash())
+	require.Len(t, bc.Receipts, 1)
+	require.Equal(t, uint(3), bc.Receipts[0].TransactionIndex)
+	require.Equal(t, ethtypes.ReceiptStatusSuccessful, bc.Receipts[0].Status)
+	require.Len(t, bc.Receipts[0].Logs, 2)
+	require.Equal(t, uint(4), bc.Receipts[0].Logs[0].Index)
+
+	// removed logs are ignored
+	require.Empty(t, bcs[2].Receipts)
+}
+
+func TestBlockGetter_GetLogRangeWithRetry(t *testing.T) {
+	f := &fakeEth{headers: make(map[uint64]*ethtypes.Header)}
+	f.headers[10] = &ethtypes.Header{Number: big.NewInt(10), Difficulty: big.NewInt(2)}
+	f.getLogsFails.Store(5)
+
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("eth", f))
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := ethclient.Dial(httpServer.URL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bg := &blockGetter{
+		ctx:           ctx,
+		ethClientPool: NewStaticEthClientPool(client),
+		filterQueries: []ethereum.FilterQuery{{}},
+		retryParams:   &config.RetryParams{Attempts: retry.Attempts(2), Delay: retry.Delay(time.Millisecond)},
+	}
+
+	// the range is not dropped once the attempts are exhausted, the sequencer would wait for it forever
+	bcs, err := bg.getLogRangeWithRetry(ctx, blockRange{from: 10, to: 10})
+	require.NoError(t, err)
+	require.Len(t, bcs, 1)
+
+	f.getLogsFails.Store(1 << 20)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = bg.getLogRangeWithRetry(ctx, blockRange{from: 10, to: 10})
+	require.ErrorIs(t, err, context.Canceled)
+}
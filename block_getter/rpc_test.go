@@ -1,26 +1,47 @@
 package block_getter
 
 import (
+	"bxs/chain/fake"
+	"bxs/chain/fixture"
+	"bxs/chain_params"
 	"context"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/stretchr/testify/require"
 	"math/big"
+	"net/http"
 	"testing"
 )
 
+const testBlockNumber = 62032287
+
+// scriptBlock serves the block of the tests, a transfer, a call with logs and a failed call
+func scriptBlock() http.Handler {
+	fc := fake.NewChain(chain_params.G)
+	token := common.HexToAddress("0x55d398326f99059fF775485246999027B3197955")
+	transfer := &ethtypes.Log{Address: token, Topics: []common.Hash{common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")}}
+	fc.AddBlock(testBlockNumber, 1758000000,
+		&fake.Tx{To: common.HexToAddress("0xb0b"), Value: big.NewInt(1e18)},
+		&fake.Tx{To: token, Logs: []*ethtypes.Log{transfer, transfer}},
+		&fake.Tx{To: token, Failed: true},
+	)
+	return fc.Handler()
+}
+
 func Test_GetBlock(t *testing.T) {
-	ethCli, err := ethclient.Dial("https://bsc-dataseed.bnbchain.org")
-	require.NoError(t, err)
-	block, getBlockErr := ethCli.BlockByNumber(context.Background(), big.NewInt(62032287))
+	ethCli := fixture.DialTest(t, "get_block", scriptBlock)
+	block, getBlockErr := ethCli.BlockByNumber(context.Background(), big.NewInt(testBlockNumber))
 	require.NoError(t, getBlockErr)
-	t.Log(block)
+	require.Equal(t, uint64(testBlockNumber), block.NumberU64())
+	require.Len(t, block.Transactions(), 3)
 }
 
 func Test_GetBlockReceipt(t *testing.T) {
-	ethCli, err := ethclient.Dial("https://bsc-dataseed.bnbchain.org")
-	require.NoError(t, err)
-	blockReceipts, getErr := ethCli.BlockReceipts(context.Background(), rpc.BlockNumberOrHashWithNumber(62032287))
+	ethCli := fixture.DialTest(t, "get_block_receipts", scriptBlock)
+	blockReceipts, getErr := ethCli.BlockReceipts(context.Background(), rpc.BlockNumberOrHashWithNumber(testBlockNumber))
 	require.NoError(t, getErr)
-	t.Log(blockReceipts)
+	require.Len(t, blockReceipts, 3)
+	require.Len(t, blockReceipts[1].Logs, 2)
+	require.Equal(t, ethtypes.ReceiptStatusFailed, blockReceipts[2].Status)
 }
@@ -0,0 +1,342 @@
+package cache
+
+import (
+	"bxs/config"
+	"bxs/logger"
+	"bxs/types"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var boltBucket = []byte("cache")
+
+// boltPriceSweepEvery is the number of prices set between two sweeps of the expired ones
+const boltPriceSweepEvery = 1000
+
+/*
+boltCache is the embedded counterpart of twoTierCache: the same memory tier and keys,
+with a local bbolt file in place of redis, so a single node runs without redis.
+Prices expire after the price ttl as in redis, they are stored with their expiry
+and the expired ones are swept on open and every boltPriceSweepEvery prices set.
+*/
+type boltCache struct {
+	chainId   int
+	memory    *memoryTier
+	db        *bbolt.DB
+	priceTtl  time.Duration
+	priceSets atomic.Uint64
+}
+
+func NewBoltCache(chain string, chainId int, conf *config.CacheConf) (Cache, error) {
+	path := conf.BoltPath
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists(boltBucket)
+		return e
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	c := &boltCache{
+		chainId:  chainId,
+		memory:   newMemoryTier(chain, conf),
+		db:       db,
+		priceTtl: time.Duration(conf.Price.TtlSec) * time.Second,
+	}
+	if err = c.sweepPrices(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *boltCache) Close() error {
+	return c.db.Close()
+}
+
+func (c *boltCache) put(k string, v []byte) error {
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(k), v)
+	})
+}
+
+func (c *boltCache) get(k string) ([]byte, bool) {
+	var v []byte
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		// the value is only valid inside the transaction
+		if b := tx.Bucket(boltBucket).Get([]byte(k)); b != nil {
+			v = append([]byte{}, b...)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.G.Error("bolt get err", zap.String("key", k), zap.Error(err))
+		return nil, false
+	}
+	return v, v != nil
+}
+
+func (c *boltCache) del(k string) error {
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(k))
+	})
+}
+
+// boltPrice is the stored price, "price expireAt" with expireAt in unix nano, or the bare price when it never expires
+func boltPrice(price decimal.Decimal, expireAt int64) []byte {
+	if expireAt == 0 {
+		return []byte(price.String())
+	}
+	return []byte(price.String() + " " + strconv.FormatInt(expireAt, 10))
+}
+
+func parseBoltPrice(v []byte) (decimal.Decimal, int64, error) {
+	s, expireAt, found := strings.Cut(string(v), " ")
+	price, err := decimal.NewFromString(s)
+	if err != nil || !found {
+		return price, 0, err
+	}
+
+	at, err := strconv.ParseInt(expireAt, 10, 64)
+	return price, at, err
+}
+
+// sweepPrices deletes the expired prices of the chain
+func (c *boltCache) sweepPrices() error {
+	now := time.Now().UnixNano()
+	prefix := []byte(fmt.Sprintf("%d:P:", c.chainId))
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			if _, expireAt, err := parseBoltPrice(v); err != nil || (expireAt != 0 && now >= expireAt) {
+				// the key is only valid inside the transaction and deleting under the cursor skips keys
+				expired = append(expired, append([]byte{}, k...))
+			}
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *boltCache) SetPrice(blockNumber *big.Int, price decimal.Decimal) {
+	k := PriceCacheKey(c.chainId, blockNumber)
+	c.memory.prices.Set(k, price)
+
+	var expireAt int64
+	if c.priceTtl > 0 {
+		expireAt = time.Now().Add(c.priceTtl).UnixNano()
+	}
+	err := c.put(k, boltPrice(price, expireAt))
+	if err != nil {
+		logger.G.Error("save price failed", zap.Error(err))
+	}
+
+	if c.priceSets.Add(1)%boltPriceSweepEvery == 0 {
+		if err = c.sweepPrices(); err != nil {
+			logger.G.Error("sweep prices err", zap.Error(err))
+		}
+	}
+}
+
+func (c *boltCache) GetPrice(blockNumber *big.Int) (decimal.Decimal, bool) {
+	k := PriceCacheKey(c.chainId, blockNumber)
+	price, ok := c.memory.prices.Get(k)
+	if ok {
+		return price, true
+	}
+
+	v, ok := c.get(k)
+	if !ok {
+		return decimal.Zero, false
+	}
+
+	decimalPrice, expireAt, err := parseBoltPrice(v)
+	if err != nil || (expireAt != 0 && time.Now().UnixNano() >= expireAt) {
+		return decimal.Decimal{}, false
+	}
+	c.memory.prices.Set(k, decimalPrice)
+	return decimalPrice, true
+}
+
+func (c *boltCache) SetToken(token *types.Token) {
+	token.UpdateTs = time.Now()
+	k := TokenCacheKey(c.chainId, token.Address)
+	c.memory.tokens.Set(k, token)
+
+	bytes, err := json.Marshal(token)
+	if err != nil {
+		logger.G.Sugar().Fatalf("json marshal token [%s] err [%s]", token.Address.String(), err.Error())
+	}
+
+	err = c.put(k, bytes)
+	if err != nil {
+		logger.G.Sugar().Fatalf("bolt set token [%s] err [%s]", string(bytes), err.Error())
+	}
+}
+
+func (c *boltCache) GetToken(address common.Address) (*types.Token, bool) {
+	k := TokenCacheKey(c.chainId, address)
+	token, ok := c.memory.tokens.Get(k)
+	if ok {
+		return token, true
+	}
+
+	bytes, ok := c.get(k)
+	if !ok {
+		return nil, false
+	}
+
+	token = &types.Token{}
+	err := json.Unmarshal(bytes, token)
+	if err != nil {
+		logger.G.Sugar().Errorf("json unmarshal token [%s] err [%s]", string(bytes), err.Error())
+		return nil, false
+	}
+
+	c.memory.tokens.Set(k, token)
+	return token, true
+}
+
+func (c *boltCache) DelToken(address common.Address) {
+	k := TokenCacheKey(c.chainId, address)
+	c.memory.tokens.Delete(k)
+	err := c.del(k)
+	if err != nil {
+		logger.G.Sugar().Errorf("bolt del token [%s] err [%s]", k, err.Error())
+	}
+}
+
+func (c *boltCache) SetPair(pair *types.Pair) {
+	pair.UpdateTs = time.Now()
+	k := PairCacheKey(c.chainId, pair.Address)
+	c.memory.pairs.Set(k, pair)
+
+	bytes, err := json.Marshal(pair)
+	if err != nil {
+		logger.G.Sugar().Fatalf("json marshal pair [%s] err [%s]", pair.Address.String(), err.Error())
+	}
+
+	err = c.put(k, bytes)
+	if err != nil {
+		logger.G.Sugar().Fatalf("bolt set pair [%s] err [%s]", string(bytes), err.Error())
+	}
+}
+
+func (c *boltCache) GetPair(address common.Address) (*types.Pair, bool) {
+	k := PairCacheKey(c.chainId, address)
+	pair, ok := c.memory.pairs.Get(k)
+	if ok {
+		return pair, true
+	}
+
+	bytes, ok := c.get(k)
+	if !ok {
+		return nil, false
+	}
+
+	pair = &types.Pair{}
+	err := json.Unmarshal(bytes, pair)
+	if err != nil {
+		logger.G.Sugar().Warnf("json unmarshal bytes [%s] err [%v]", string(bytes), err.Error())
+		return nil, false
+	}
+
+	c.memory.pairs.Set(k, pair)
+	return pair, true
+}
+
+func (c *boltCache) PairExist(address common.Address) bool {
+	_, ok := c.GetPair(address)
+	return ok
+}
+
+func (c *boltCache) DelPair(address common.Address) {
+	k := PairCacheKey(c.chainId, address)
+	c.memory.pairs.Delete(k)
+	err := c.del(k)
+	if err != nil {
+		logger.G.Sugar().Errorf("bolt del [%s] err [%s]", k, err.Error())
+	}
+}
+
+func (c *boltCache) SetFinishedBlock(blockNumber uint64) {
+	err := c.put(FbKey(c.chainId), []byte(strconv.FormatUint(blockNumber, 10)))
+	if err != nil {
+		logger.G.Error("bolt set finished block err", zap.Error(err))
+	}
+}
+
+func (c *boltCache) GetFinishedBlock() uint64 {
+	v, ok := c.get(FbKey(c.chainId))
+	if !ok {
+		return 0
+	}
+
+	blockNumber, err := strconv.ParseUint(string(v), 10, 64)
+	if err != nil {
+		logger.G.Error("bolt parse finished block err", zap.Error(err))
+		return 0
+	}
+	return blockNumber
+}
+
+func (c *boltCache) SetMigrateToken(address common.Address) {
+	k := MigrateTokenCacheKey(c.chainId, address)
+	c.memory.migrateTokens.Set(k, true)
+	err := c.put(k, []byte("1"))
+	if err != nil {
+		logger.G.Sugar().Errorf("bolt set migrate token [%s] err [%s]", k, err.Error())
+	}
+}
+
+func (c *boltCache) MigrateTokenExist(address common.Address) bool {
+	k := MigrateTokenCacheKey(c.chainId, address)
+	_, ok := c.memory.migrateTokens.Get(k)
+	if ok {
+		return true
+	}
+
+	if _, ok = c.get(k); !ok {
+		return false
+	}
+
+	c.memory.migrateTokens.Set(k, true)
+	return true
+}
+
+func (c *boltCache) DelMigrateToken(address common.Address) {
+	k := MigrateTokenCacheKey(c.chainId, address)
+	c.memory.migrateTokens.Delete(k)
+	err := c.del(k)
+	if err != nil {
+		logger.G.Sugar().Fatalf("bolt del migrate token [%s] err[%s]", k, err.Error())
+	}
+}
@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltCache(t *testing.T) {
+	conf := *config.G.Cache
+	conf.BoltPath = filepath.Join(t.TempDir(), "cache.db")
+	c, err := NewBoltCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+
+	price, _ := decimal.NewFromString("33.33")
+	c.SetPrice(big.NewInt(1), price)
+
+	address := common.HexToAddress("0xe76004cffcab665c4692f663b8fb2a2f66adda9b")
+	c.SetToken(&types.Token{
+		Address:     address,
+		Creator:     address,
+		Name:        "test",
+		Symbol:      "test",
+		Decimals:    18,
+		TotalSupply: decimal.NewFromInt(1),
+		BlockNumber: 1,
+		BlockTime:   time.Unix(1000, 1),
+		Program:     "test",
+	})
+	c.SetPair(&types.Pair{Address: address, Block: 2})
+	c.SetMigrateToken(address)
+	c.SetFinishedBlock(100)
+	require.NoError(t, c.Close())
+
+	// reopen, so the values come from the bolt file instead of the memory tier
+	c, err = NewBoltCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+	defer c.Close()
+
+	getPrice, ok := c.GetPrice(big.NewInt(1))
+	require.True(t, ok)
+	require.True(t, price.Equal(getPrice))
+
+	token, ok := c.GetToken(address)
+	require.True(t, ok)
+	require.Equal(t, "test", token.Name)
+
+	pair, ok := c.GetPair(address)
+	require.True(t, ok)
+	require.Equal(t, uint64(2), pair.Block)
+
+	require.True(t, c.MigrateTokenExist(address))
+	require.Equal(t, uint64(100), c.GetFinishedBlock())
+
+	c.DelToken(address)
+	c.DelPair(address)
+	c.DelMigrateToken(address)
+	_, ok = c.GetToken(address)
+	require.False(t, ok)
+	require.False(t, c.PairExist(address))
+	require.False(t, c.MigrateTokenExist(address))
+}
+
+func TestBoltCache_PriceTtl(t *testing.T) {
+	conf := *config.G.Cache
+	conf.BoltPath = filepath.Join(t.TempDir(), "cache.db")
+	conf.Price = &config.CacheKindConf{Size: 10, TtlSec: 1}
+	c, err := NewBoltCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+
+	price, _ := decimal.NewFromString("33.33")
+	c.SetPrice(big.NewInt(1), price)
+	require.NoError(t, c.Close())
+
+	c, err = NewBoltCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+	_, ok := c.GetPrice(big.NewInt(1))
+	require.True(t, ok)
+	require.NoError(t, c.Close())
+
+	// expired, and swept when the file is opened again
+	time.Sleep(time.Second)
+	c, err = NewBoltCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+	defer c.Close()
+	_, ok = c.GetPrice(big.NewInt(1))
+	require.False(t, ok)
+	_, ok = c.(*boltCache).get(PriceCacheKey(chain_params.G.ChainID, big.NewInt(1)))
+	require.False(t, ok)
+}
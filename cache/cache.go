@@ -1,7 +1,7 @@
 package cache
 
 import (
-	"bxs/chain_params"
+	"bxs/config"
 	"bxs/logger"
 	"bxs/types"
 	"context"
@@ -10,7 +10,6 @@ import (
 	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/go-redis/redis/v8"
-	"github.com/patrickmn/go-cache"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"math/big"
@@ -52,56 +51,66 @@ type Cache interface {
 	PairCache
 	BlockCache
 	MigrateTokenCache
+	Close() error
 }
 
 type twoTierCache struct {
-	ctx    context.Context
-	memory *cache.Cache
-	redis  *redis.Client
+	ctx      context.Context
+	chainId  int
+	memory   *memoryTier
+	redis    *redis.Client
+	priceTtl time.Duration
 }
 
-func NewTwoTierCache(redis *redis.Client) Cache {
+func NewTwoTierCache(chain string, chainId int, redis *redis.Client, conf *config.CacheConf) Cache {
 	return &twoTierCache{
-		ctx:    context.Background(),
-		memory: cache.New(time.Hour*24, time.Hour),
-		redis:  redis,
+		ctx:      context.Background(),
+		chainId:  chainId,
+		memory:   newMemoryTier(chain, conf),
+		redis:    redis,
+		priceTtl: time.Duration(conf.Price.TtlSec) * time.Second,
 	}
 }
 
-func PriceCacheKey(blockNumber *big.Int) string {
-	return fmt.Sprintf("%d:P:%s", chain_params.G.ChainID, blockNumber.String())
+func (c *twoTierCache) Close() error {
+	return c.redis.Close()
 }
 
-func TokenCacheKey(address common.Address) string {
-	return fmt.Sprintf("%d:t:%s", chain_params.G.ChainID, address.Hex())
+func PriceCacheKey(chainId int, blockNumber *big.Int) string {
+	return fmt.Sprintf("%d:P:%s", chainId, blockNumber.String())
 }
 
-func PairCacheKey(address common.Address) string {
-	return fmt.Sprintf("%d:p:%s", chain_params.G.ChainID, address.Hex())
+func TokenCacheKey(chainId int, address common.Address) string {
+	return fmt.Sprintf("%d:t:%s", chainId, address.Hex())
 }
 
-func MigrateTokenCacheKey(address common.Address) string {
-	return fmt.Sprintf("%d:m:%s", chain_params.G.ChainID, address.Hex())
+func PairCacheKey(chainId int, address common.Address) string {
+	return fmt.Sprintf("%d:p:%s", chainId, address.Hex())
 }
 
-func FbKey() string {
-	return fmt.Sprintf("%d:fb", chain_params.G.ChainID)
+func MigrateTokenCacheKey(chainId int, address common.Address) string {
+	return fmt.Sprintf("%d:m:%s", chainId, address.Hex())
+}
+
+func FbKey(chainId int) string {
+	return fmt.Sprintf("%d:fb", chainId)
 }
 
 func (c *twoTierCache) SetPrice(blockNumber *big.Int, price decimal.Decimal) {
-	k := PriceCacheKey(blockNumber)
-	c.memory.Set(k, price, cache.DefaultExpiration)
-	err := c.redis.Set(c.ctx, k, price.String(), 0).Err()
+	k := PriceCacheKey(c.chainId, blockNumber)
+	c.memory.prices.Set(k, price)
+	// prices of old blocks are rarely read again, expire them in redis too so the keyspace stays bounded
+	err := c.redis.Set(c.ctx, k, price.String(), c.priceTtl).Err()
 	if err != nil {
 		logger.G.Error("save price failed", zap.Error(err))
 	}
 }
 
 func (c *twoTierCache) GetPrice(blockNumber *big.Int) (decimal.Decimal, bool) {
-	k := PriceCacheKey(blockNumber)
-	price, ok := c.memory.Get(k)
+	k := PriceCacheKey(c.chainId, blockNumber)
+	price, ok := c.memory.prices.Get(k)
 	if ok {
-		return price.(decimal.Decimal), true
+		return price, true
 	}
 
 	v, err := c.redis.Get(c.ctx, k).Result()
@@ -116,14 +125,14 @@ func (c *twoTierCache) GetPrice(blockNumber *big.Int) (decimal.Decimal, bool) {
 	if err != nil {
 		return decimal.Decimal{}, false
 	}
-	c.memory.Set(k, decimalPrice, 0)
+	c.memory.prices.Set(k, decimalPrice)
 	return decimalPrice, true
 }
 
 func (c *twoTierCache) SetToken(token *types.Token) {
 	token.UpdateTs = time.Now()
-	k := TokenCacheKey(token.Address)
-	c.memory.Set(k, token, cache.DefaultExpiration)
+	k := TokenCacheKey(c.chainId, token.Address)
+	c.memory.tokens.Set(k, token)
 
 	bytes, err := json.Marshal(token)
 	if err != nil {
@@ -137,10 +146,10 @@ func (c *twoTierCache) SetToken(token *types.Token) {
 }
 
 func (c *twoTierCache) GetToken(address common.Address) (*types.Token, bool) {
-	k := TokenCacheKey(address)
-	obj, ok := c.memory.Get(k)
+	k := TokenCacheKey(c.chainId, address)
+	token, ok := c.memory.tokens.Get(k)
 	if ok {
-		return obj.(*types.Token), true
+		return token, true
 	}
 
 	bytes, err := c.redis.Get(c.ctx, k).Bytes()
@@ -151,20 +160,20 @@ func (c *twoTierCache) GetToken(address common.Address) (*types.Token, bool) {
 		return nil, false
 	}
 
-	token := &types.Token{}
+	token = &types.Token{}
 	err = json.Unmarshal(bytes, token)
 	if err != nil {
 		logger.G.Sugar().Errorf("json unmarshal token [%s] err [%s]", string(bytes), err.Error())
 		return nil, false
 	}
 
-	c.memory.Set(k, token, cache.DefaultExpiration)
+	c.memory.tokens.Set(k, token)
 	return token, true
 }
 
 func (c *twoTierCache) DelToken(address common.Address) {
-	k := TokenCacheKey(address)
-	c.memory.Delete(k)
+	k := TokenCacheKey(c.chainId, address)
+	c.memory.tokens.Delete(k)
 	err := c.redis.Del(c.ctx, k).Err()
 	if err != nil {
 		logger.G.Sugar().Errorf("redis del token [%s] err [%s]", k, err.Error())
@@ -173,8 +182,8 @@ func (c *twoTierCache) DelToken(address common.Address) {
 
 func (c *twoTierCache) SetPair(pair *types.Pair) {
 	pair.UpdateTs = time.Now()
-	k := PairCacheKey(pair.Address)
-	c.memory.Set(k, pair, cache.DefaultExpiration)
+	k := PairCacheKey(c.chainId, pair.Address)
+	c.memory.pairs.Set(k, pair)
 
 	bytes, err := json.Marshal(pair)
 	if err != nil {
@@ -188,10 +197,10 @@ func (c *twoTierCache) SetPair(pair *types.Pair) {
 }
 
 func (c *twoTierCache) GetPair(address common.Address) (*types.Pair, bool) {
-	k := PairCacheKey(address)
-	obj, ok := c.memory.Get(k)
+	k := PairCacheKey(c.chainId, address)
+	pair, ok := c.memory.pairs.Get(k)
 	if ok {
-		return obj.(*types.Pair), true
+		return pair, true
 	}
 
 	bytes, err := c.redis.Get(c.ctx, k).Bytes()
@@ -202,14 +211,14 @@ func (c *twoTierCache) GetPair(address common.Address) (*types.Pair, bool) {
 		return nil, false
 	}
 
-	pair := &types.Pair{}
+	pair = &types.Pair{}
 	err = json.Unmarshal(bytes, pair)
 	if err != nil {
 		logger.G.Sugar().Warnf("json unmarshal bytes [%s] err [%v]", string(bytes), err.Error())
 		return nil, false
 	}
 
-	c.memory.Set(k, pair, cache.DefaultExpiration)
+	c.memory.pairs.Set(k, pair)
 	return pair, true
 }
 
@@ -219,8 +228,8 @@ func (c *twoTierCache) PairExist(address common.Address) bool {
 }
 
 func (c *twoTierCache) DelPair(address common.Address) {
-	k := PairCacheKey(address)
-	c.memory.Delete(k)
+	k := PairCacheKey(c.chainId, address)
+	c.memory.pairs.Delete(k)
 	err := c.redis.Del(c.ctx, k).Err()
 	if err != nil {
 		logger.G.Sugar().Errorf("redis del [%s] err [%s]", k, err.Error())
@@ -228,11 +237,11 @@ func (c *twoTierCache) DelPair(address common.Address) {
 }
 
 func (c *twoTierCache) SetFinishedBlock(blockNumber uint64) {
-	c.redis.Set(c.ctx, FbKey(), blockNumber, 0)
+	c.redis.Set(c.ctx, FbKey(c.chainId), blockNumber, 0)
 }
 
 func (c *twoTierCache) GetFinishedBlock() uint64 {
-	v, err := c.redis.Get(c.ctx, FbKey()).Uint64()
+	v, err := c.redis.Get(c.ctx, FbKey(c.chainId)).Uint64()
 	if err != nil {
 		if !errors.Is(err, redis.Nil) {
 			logger.G.Error("redis get err", zap.Error(err))
@@ -243,8 +252,8 @@ func (c *twoTierCache) GetFinishedBlock() uint64 {
 }
 
 func (c *twoTierCache) SetMigrateToken(address common.Address) {
-	k := MigrateTokenCacheKey(address)
-	c.memory.Set(k, true, cache.DefaultExpiration)
+	k := MigrateTokenCacheKey(c.chainId, address)
+	c.memory.migrateTokens.Set(k, true)
 	err := c.redis.Set(c.ctx, k, true, 0).Err()
 	if err != nil {
 		logger.G.Sugar().Errorf("redis set migrate token [%s] err [%s]", k, err.Error())
@@ -252,8 +261,8 @@ func (c *twoTierCache) SetMigrateToken(address common.Address) {
 }
 
 func (c *twoTierCache) MigrateTokenExist(address common.Address) bool {
-	k := MigrateTokenCacheKey(address)
-	_, ok := c.memory.Get(k)
+	k := MigrateTokenCacheKey(c.chainId, address)
+	_, ok := c.memory.migrateTokens.Get(k)
 	if ok {
 		return true
 	}
@@ -266,13 +275,13 @@ func (c *twoTierCache) MigrateTokenExist(address common.Address) bool {
 		return false
 	}
 
-	c.memory.Set(k, true, 0)
+	c.memory.migrateTokens.Set(k, true)
 	return true
 }
 
 func (c *twoTierCache) DelMigrateToken(address common.Address) {
-	k := MigrateTokenCacheKey(address)
-	c.memory.Delete(k)
+	k := MigrateTokenCacheKey(c.chainId, address)
+	c.memory.migrateTokens.Delete(k)
 	err := c.redis.Del(c.ctx, k).Err()
 	if err != nil {
 		logger.G.Sugar().Fatalf("redis del migrate token [%s] err[%s]", k, err.Error())
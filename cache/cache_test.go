@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"bxs/chain_params"
+	"bxs/config"
 	"bxs/types"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/go-redis/redis/v8"
@@ -15,7 +17,7 @@ func TestCacheSetPrice(t *testing.T) {
 	redisClient := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
-	cache := NewTwoTierCache(redisClient)
+	cache := NewTwoTierCache(chain_params.G.Name, chain_params.G.ChainID, redisClient, config.G.Cache)
 
 	blockNumber := big.NewInt(1)
 	price, _ := decimal.NewFromString("33.33")
@@ -26,7 +28,7 @@ func TestCacheGetPrice(t *testing.T) {
 	redisClient := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
-	cache := NewTwoTierCache(redisClient)
+	cache := NewTwoTierCache(chain_params.G.Name, chain_params.G.ChainID, redisClient, config.G.Cache)
 
 	blockNumber := big.NewInt(1)
 	price, _ := decimal.NewFromString("33.33")
@@ -39,7 +41,7 @@ func TestCacheSetToken(t *testing.T) {
 	redisClient := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
-	cache := NewTwoTierCache(redisClient)
+	cache := NewTwoTierCache(chain_params.G.Name, chain_params.G.ChainID, redisClient, config.G.Cache)
 
 	address := common.HexToAddress("0xe76004cffcab665c4692f663b8fb2a2f66adda9b")
 	token := &types.Token{
@@ -61,7 +63,7 @@ func TestCacheGetToken(t *testing.T) {
 	redisClient := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
-	cache := NewTwoTierCache(redisClient)
+	cache := NewTwoTierCache(chain_params.G.Name, chain_params.G.ChainID, redisClient, config.G.Cache)
 
 	address := common.HexToAddress("0xe76004cffcab665c4692f663b8fb2a2f66adda9b")
 	expectToken := &types.Token{
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"bxs/config"
+	"bxs/metrics"
+	"bxs/types"
+	"container/list"
+	"github.com/shopspring/decimal"
+	"sync"
+	"time"
+)
+
+const (
+	KindPrice        = "price"
+	KindToken        = "token"
+	KindPair         = "pair"
+	KindMigrateToken = "migrate_token"
+)
+
+const (
+	evictBySize    = "size"
+	evictByExpired = "expired"
+)
+
+type lruEntry[V any] struct {
+	Key      string `json:"key"`
+	Value    V      `json:"value"`
+	ExpireAt int64  `json:"expire_at"` // unix nano, 0 never expire
+}
+
+func (e *lruEntry[V]) expired(now int64) bool {
+	return e.ExpireAt != 0 && now >= e.ExpireAt
+}
+
+/*
+lru is a size bounded, least recently used map with one TTL for all its entries.
+Each cache kind gets its own lru, so prices can expire and be evicted
+without pushing out pairs and tokens, which are expensive to load again.
+Size 0 means unbounded, ttl 0 means entries never expire.
+*/
+type lru[V any] struct {
+	mu    sync.Mutex
+	chain string
+	kind  string
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newLRU[V any](chain, kind string, conf *config.CacheKindConf) *lru[V] {
+	return &lru[V]{
+		chain: chain,
+		kind:  kind,
+		size:  conf.Size,
+		ttl:   time.Duration(conf.TtlSec) * time.Second,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (l *lru[V]) Get(k string) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var zero V
+	el, ok := l.items[k]
+	if !ok {
+		metrics.CacheMisses.WithLabelValues(l.chain, l.kind).Inc()
+		return zero, false
+	}
+
+	entry := el.Value.(*lruEntry[V])
+	if entry.expired(time.Now().UnixNano()) {
+		l.remove(el)
+		metrics.CacheEvictions.WithLabelValues(l.chain, l.kind, evictByExpired).Inc()
+		metrics.CacheMisses.WithLabelValues(l.chain, l.kind).Inc()
+		return zero, false
+	}
+
+	l.ll.MoveToFront(el)
+	metrics.CacheHits.WithLabelValues(l.chain, l.kind).Inc()
+	return entry.Value, true
+}
+
+func (l *lru[V]) Set(k string, v V) {
+	var expireAt int64
+	if l.ttl > 0 {
+		expireAt = time.Now().Add(l.ttl).UnixNano()
+	}
+	l.set(&lruEntry[V]{Key: k, Value: v, ExpireAt: expireAt})
+}
+
+func (l *lru[V]) set(entry *lruEntry[V]) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[entry.Key]; ok {
+		el.Value = entry
+		l.ll.MoveToFront(el)
+		return
+	}
+
+	l.items[entry.Key] = l.ll.PushFront(entry)
+	if l.size > 0 && l.ll.Len() > l.size {
+		l.remove(l.ll.Back())
+		metrics.CacheEvictions.WithLabelValues(l.chain, l.kind, evictBySize).Inc()
+	}
+	metrics.CacheSize.WithLabelValues(l.chain, l.kind).Set(float64(l.ll.Len()))
+}
+
+func (l *lru[V]) Delete(k string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[k]; ok {
+		l.remove(el)
+	}
+}
+
+func (l *lru[V]) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+func (l *lru[V]) remove(el *list.Element) {
+	l.ll.Remove(el)
+	delete(l.items, el.Value.(*lruEntry[V]).Key)
+	metrics.CacheSize.WithLabelValues(l.chain, l.kind).Set(float64(l.ll.Len()))
+}
+
+// entries returns the live entries from the least to the most recently used, the order restore expects
+func (l *lru[V]) entries() []*lruEntry[V] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	entries := make([]*lruEntry[V], 0, l.ll.Len())
+	for el := l.ll.Back(); el != nil; el = el.Prev() {
+		entry := el.Value.(*lruEntry[V])
+		if !entry.expired(now) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (l *lru[V]) restore(entries []*lruEntry[V]) {
+	now := time.Now().UnixNano()
+	for _, entry := range entries {
+		if !entry.expired(now) {
+			l.set(entry)
+		}
+	}
+}
+
+// memoryTier is the in process tier shared by all cache backends, one lru per cache kind
+type memoryTier struct {
+	prices        *lru[decimal.Decimal]
+	tokens        *lru[*types.Token]
+	pairs         *lru[*types.Pair]
+	migrateTokens *lru[bool]
+}
+
+func newMemoryTier(chain string, conf *config.CacheConf) *memoryTier {
+	return &memoryTier{
+		prices:        newLRU[decimal.Decimal](chain, KindPrice, conf.Price),
+		tokens:        newLRU[*types.Token](chain, KindToken, conf.Token),
+		pairs:         newLRU[*types.Pair](chain, KindPair, conf.Pair),
+		migrateTokens: newLRU[bool](chain, KindMigrateToken, conf.MigrateToken),
+	}
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLRU_EvictBySize(t *testing.T) {
+	l := newLRU[int]("test", "test", &config.CacheKindConf{Size: 2})
+	l.Set("a", 1)
+	l.Set("b", 2)
+
+	// touch a, so b is the least recently used one
+	_, ok := l.Get("a")
+	require.True(t, ok)
+
+	l.Set("c", 3)
+	require.Equal(t, 2, l.Len())
+	_, ok = l.Get("b")
+	require.False(t, ok)
+
+	v, ok := l.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+}
+
+func TestLRU_Expire(t *testing.T) {
+	l := newLRU[int]("test", "test", &config.CacheKindConf{})
+	l.ttl = time.Millisecond * 10
+	l.Set("a", 1)
+
+	_, ok := l.Get("a")
+	require.True(t, ok)
+
+	time.Sleep(time.Millisecond * 20)
+	_, ok = l.Get("a")
+	require.False(t, ok)
+	require.Equal(t, 0, l.Len())
+}
+
+func TestLRU_Restore(t *testing.T) {
+	l := newLRU[int]("test", "test", &config.CacheKindConf{Size: 3})
+	l.Set("a", 1)
+	l.Set("b", 2)
+	l.Set("c", 3)
+	l.Get("a")
+
+	restored := newLRU[int]("test", "test", &config.CacheKindConf{Size: 3})
+	restored.restore(l.entries())
+
+	// the recency order survives the restore: b is evicted first
+	restored.Set("d", 4)
+	_, ok := restored.Get("b")
+	require.False(t, ok)
+	_, ok = restored.Get("a")
+	require.True(t, ok)
+}
+
+func TestMemoryCache_Snapshot(t *testing.T) {
+	conf := *config.G.Cache
+	conf.SnapshotPath = filepath.Join(t.TempDir(), "cache.json")
+	c, err := NewMemoryCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+
+	address := common.HexToAddress("0xe76004cffcab665c4692f663b8fb2a2f66adda9b")
+	price, _ := decimal.NewFromString("33.33")
+	c.SetPrice(big.NewInt(1), price)
+	c.SetToken(&types.Token{Address: address, Name: "test", Decimals: 18})
+	c.SetPair(&types.Pair{Address: address, Block: 2})
+	c.SetMigrateToken(address)
+	c.SetFinishedBlock(100)
+	require.NoError(t, c.Close())
+
+	c, err = NewMemoryCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+
+	getPrice, ok := c.GetPrice(big.NewInt(1))
+	require.True(t, ok)
+	require.True(t, price.Equal(getPrice))
+
+	token, ok := c.GetToken(address)
+	require.True(t, ok)
+	require.Equal(t, "test", token.Name)
+
+	require.True(t, c.PairExist(address))
+	require.True(t, c.MigrateTokenExist(address))
+	require.Equal(t, uint64(100), c.GetFinishedBlock())
+}
+
+func TestMemoryCache_PairsUnbounded(t *testing.T) {
+	conf := *config.G.Cache
+	conf.Pair = &config.CacheKindConf{Size: 1, TtlSec: 1}
+	c, err := NewMemoryCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+
+	first := common.HexToAddress("0x01")
+	c.SetPair(&types.Pair{Address: first})
+	c.SetPair(&types.Pair{Address: common.HexToAddress("0x02")})
+	require.True(t, c.PairExist(first))
+}
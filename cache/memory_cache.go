@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"bxs/config"
+	"bxs/logger"
+	"bxs/types"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+type memorySnapshot struct {
+	FinishedBlock uint64                       `json:"finished_block"`
+	Prices        []*lruEntry[decimal.Decimal] `json:"prices"`
+	Tokens        []*lruEntry[*types.Token]    `json:"tokens"`
+	Pairs         []*lruEntry[*types.Pair]     `json:"pairs"`
+	MigrateTokens []*lruEntry[bool]            `json:"migrate_tokens"`
+}
+
+/*
+memoryCache keeps everything in the memory tier, without redis.
+Nothing is behind the tier to load an evicted pair or token again, so only the prices are bounded,
+the size and ttl of the other kinds are ignored: an evicted pair would drop all its later swaps.
+With a snapshot path the cache is loaded from it on start and written to it on Close,
+so a restart does not begin with an empty cache and lose the finished block.
+*/
+type memoryCache struct {
+	chainId       int
+	memory        *memoryTier
+	finishedBlock atomic.Uint64
+	snapshotPath  string
+}
+
+func NewMemoryCache(chain string, chainId int, conf *config.CacheConf) (Cache, error) {
+	unbounded := *conf
+	for kind, kindConf := range map[string]**config.CacheKindConf{
+		KindToken:        &unbounded.Token,
+		KindPair:         &unbounded.Pair,
+		KindMigrateToken: &unbounded.MigrateToken,
+	} {
+		if (*kindConf).Size > 0 || (*kindConf).TtlSec > 0 {
+			logger.G.Warn("memory cache backend keeps every entry of the kind, size and ttl ignored", zap.String("kind", kind))
+			*kindConf = &config.CacheKindConf{}
+		}
+	}
+
+	c := &memoryCache{
+		chainId:      chainId,
+		memory:       newMemoryTier(chain, &unbounded),
+		snapshotPath: conf.SnapshotPath,
+	}
+
+	if c.snapshotPath == "" {
+		return c, nil
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *memoryCache) load() error {
+	bytes, err := os.ReadFile(c.snapshotPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	snapshot := &memorySnapshot{}
+	if err = json.Unmarshal(bytes, snapshot); err != nil {
+		return err
+	}
+
+	c.finishedBlock.Store(snapshot.FinishedBlock)
+	c.memory.prices.restore(snapshot.Prices)
+	c.memory.tokens.restore(snapshot.Tokens)
+	c.memory.pairs.restore(snapshot.Pairs)
+	c.memory.migrateTokens.restore(snapshot.MigrateTokens)
+
+	logger.G.Info("cache snapshot loaded",
+		zap.String("path", c.snapshotPath),
+		zap.Uint64("finished block", snapshot.FinishedBlock),
+		zap.Int("tokens", len(snapshot.Tokens)),
+		zap.Int("pairs", len(snapshot.Pairs)))
+	return nil
+}
+
+func (c *memoryCache) Close() error {
+	if c.snapshotPath == "" {
+		return nil
+	}
+
+	bytes, err := json.Marshal(&memorySnapshot{
+		FinishedBlock: c.finishedBlock.Load(),
+		Prices:        c.memory.prices.entries(),
+		Tokens:        c.memory.tokens.entries(),
+		Pairs:         c.memory.pairs.entries(),
+		MigrateTokens: c.memory.migrateTokens.entries(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(c.snapshotPath), 0755); err != nil {
+		return err
+	}
+
+	// write then rename, a crash while saving must not destroy the previous snapshot
+	tmp := c.snapshotPath + ".tmp"
+	if err = os.WriteFile(tmp, bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.snapshotPath)
+}
+
+func (c *memoryCache) SetPrice(blockNumber *big.Int, price decimal.Decimal) {
+	c.memory.prices.Set(PriceCacheKey(c.chainId, blockNumber), price)
+}
+
+func (c *memoryCache) GetPrice(blockNumber *big.Int) (decimal.Decimal, bool) {
+	return c.memory.prices.Get(PriceCacheKey(c.chainId, blockNumber))
+}
+
+func (c *memoryCache) SetToken(token *types.Token) {
+	token.UpdateTs = time.Now()
+	c.memory.tokens.Set(TokenCacheKey(c.chainId, token.Address), token)
+}
+
+func (c *memoryCache) GetToken(address common.Address) (*types.Token, bool) {
+	return c.memory.tokens.Get(TokenCacheKey(c.chainId, address))
+}
+
+func (c *memoryCache) DelToken(address common.Address) {
+	c.memory.tokens.Delete(TokenCacheKey(c.chainId, address))
+}
+
+func (c *memoryCache) SetPair(pair *types.Pair) {
+	pair.UpdateTs = time.Now()
+	c.memory.pairs.Set(PairCacheKey(c.chainId, pair.Address), pair)
+}
+
+func (c *memoryCache) GetPair(address common.Address) (*types.Pair, bool) {
+	return c.memory.pairs.Get(PairCacheKey(c.chainId, address))
+}
+
+func (c *memoryCache) PairExist(address common.Address) bool {
+	_, ok := c.GetPair(address)
+	return ok
+}
+
+func (c *memoryCache) DelPair(address common.Address) {
+	c.memory.pairs.Delete(PairCacheKey(c.chainId, address))
+}
+
+func (c *memoryCache) SetFinishedBlock(blockNumber uint64) {
+	c.finishedBlock.Store(blockNumber)
+}
+
+func (c *memoryCache) GetFinishedBlock() uint64 {
+	return c.finishedBlock.Load()
+}
+
+func (c *memoryCache) SetMigrateToken(address common.Address) {
+	c.memory.migrateTokens.Set(MigrateTokenCacheKey(c.chainId, address), true)
+}
+
+func (c *memoryCache) MigrateTokenExist(address common.Address) bool {
+	_, ok := c.memory.migrateTokens.Get(MigrateTokenCacheKey(c.chainId, address))
+	return ok
+}
+
+func (c *memoryCache) DelMigrateToken(address common.Address) {
+	c.memory.migrateTokens.Delete(MigrateTokenCacheKey(c.chainId, address))
+}
@@ -28,6 +28,10 @@ func NewMockCache() Cache {
 	}
 }
 
+func (c *MockCache) Close() error {
+	return nil
+}
+
 func (c *MockCache) DelToken(address common.Address) {
 	c.memory.Delete(address.String())
 }
@@ -0,0 +1,225 @@
+package fake
+
+import (
+	"bxs/abi/multicall3"
+	"bxs/abi/registry"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"slices"
+)
+
+var ErrExecutionReverted = errors.New("execution reverted")
+
+// ethAPI serves the eth namespace of a Chain, the rpc server registers each of its methods as eth_<method>
+type ethAPI struct {
+	c *Chain
+}
+
+type callArgs struct {
+	To    *common.Address `json:"to"`
+	Input hexutil.Bytes   `json:"input"`
+	Data  hexutil.Bytes   `json:"data"`
+}
+
+type filterArgs struct {
+	FromBlock *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock   *rpc.BlockNumber `json:"toBlock"`
+	Addresses []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+}
+
+func (api *ethAPI) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(api.c.chain.ChainConfig.ChainID)
+}
+
+func (api *ethAPI) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(api.c.Head())
+}
+
+func (api *ethAPI) GetBlockByNumber(number rpc.BlockNumber, fullTx bool) (map[string]any, error) {
+	block, _ := api.c.block(number)
+	if block == nil {
+		return nil, nil
+	}
+
+	fields, err := jsonFields(block.Header())
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]any, 0, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs = append(txs, tx.Hash())
+			continue
+		}
+		rpcTx, txErr := api.rpcTransaction(block, i)
+		if txErr != nil {
+			return nil, txErr
+		}
+		txs = append(txs, rpcTx)
+	}
+	fields["transactions"] = txs
+	fields["uncles"] = []common.Hash{}
+	return fields, nil
+}
+
+func (api *ethAPI) GetBlockReceipts(blockNrOrHash rpc.BlockNumberOrHash) ([]*ethtypes.Receipt, error) {
+	number, ok := blockNrOrHash.Number()
+	if !ok {
+		return nil, errors.New("receipts by block hash are not supported")
+	}
+	_, receipts := api.c.block(number)
+	return receipts, nil
+}
+
+func (api *ethAPI) GetTransactionByHash(hash common.Hash) (map[string]any, error) {
+	block, _ := api.c.txBlock(hash)
+	if block == nil {
+		return nil, nil
+	}
+	return api.rpcTransaction(block, txIndex(block, hash))
+}
+
+func (api *ethAPI) GetTransactionReceipt(hash common.Hash) (*ethtypes.Receipt, error) {
+	block, receipts := api.c.txBlock(hash)
+	if block == nil {
+		return nil, nil
+	}
+	return receipts[txIndex(block, hash)], nil
+}
+
+func (api *ethAPI) GetLogs(args filterArgs) ([]*ethtypes.Log, error) {
+	head := api.c.Head()
+	from, to := head, head
+	if args.FromBlock != nil && *args.FromBlock >= 0 {
+		from = uint64(*args.FromBlock)
+	}
+	if args.ToBlock != nil && *args.ToBlock >= 0 {
+		to = uint64(*args.ToBlock)
+	}
+
+	logs := make([]*ethtypes.Log, 0)
+	for height := from; height <= to; height++ {
+		_, receipts := api.c.block(rpc.BlockNumber(height))
+		for _, receipt := range receipts {
+			for _, log := range receipt.Logs {
+				if matchLog(log, args.Addresses, args.Topics) {
+					logs = append(logs, log)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
+
+func (api *ethAPI) Call(args callArgs, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	if args.To == nil {
+		return nil, errors.New("contract creation is not supported")
+	}
+
+	data := args.Input
+	if len(data) == 0 {
+		data = args.Data
+	}
+
+	if *args.To == api.c.chain.Multicall3Address {
+		return api.aggregate3(data)
+	}
+
+	result, ok := api.c.call(*args.To, data)
+	if !ok {
+		return nil, ErrExecutionReverted
+	}
+	return result, nil
+}
+
+func (api *ethAPI) GetCode(address common.Address, blockNrOrHash rpc.BlockNumberOrHash) hexutil.Bytes {
+	return api.c.code(address)
+}
+
+// aggregate3 answers each call of a Multicall3 aggregate3, the calls without a result fail
+func (api *ethAPI) aggregate3(data []byte) (hexutil.Bytes, error) {
+	method, err := registry.G.Method(registry.KindMulticall3, "aggregate3")
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ErrExecutionReverted
+	}
+
+	inputs, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, err
+	}
+	calls := *abi.ConvertType(inputs[0], new([]multicall3.Call3)).(*[]multicall3.Call3)
+
+	results := make([]multicall3.Result, len(calls))
+	for i, call := range calls {
+		result, ok := api.c.call(call.Target, call.CallData)
+		if !ok && !call.AllowFailure {
+			return nil, ErrExecutionReverted
+		}
+		results[i] = multicall3.Result{Success: ok, ReturnData: result}
+	}
+	return method.Outputs.Pack(results)
+}
+
+// rpcTransaction is the transaction JSON with its block position and sender, as served by a node
+func (api *ethAPI) rpcTransaction(block *ethtypes.Block, index int) (map[string]any, error) {
+	tx := block.Transactions()[index]
+	fields, err := jsonFields(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := ethtypes.Sender(ethtypes.MakeSigner(api.c.chain.ChainConfig, block.Number(), block.Time()), tx)
+	if err != nil {
+		return nil, err
+	}
+	fields["blockHash"] = block.Hash()
+	fields["blockNumber"] = (*hexutil.Big)(block.Number())
+	fields["transactionIndex"] = hexutil.Uint64(index)
+	fields["from"] = from
+	return fields, nil
+}
+
+func txIndex(block *ethtypes.Block, hash common.Hash) int {
+	return slices.IndexFunc(block.Transactions(), func(tx *ethtypes.Transaction) bool {
+		return tx.Hash() == hash
+	})
+}
+
+func jsonFields(v json.Marshaler) (map[string]any, error) {
+	data, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]any
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// matchLog is the eth_getLogs filter: any of the addresses, and at each topic position any of its topics
+func matchLog(log *ethtypes.Log, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 && !slices.Contains(addresses, log.Address) {
+		return false
+	}
+	if len(topics) > len(log.Topics) {
+		return false
+	}
+	for i, position := range topics {
+		if len(position) > 0 && !slices.Contains(position, log.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,236 @@
+package fake
+
+import (
+	"bxs/chain_params"
+	"crypto/ecdsa"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"math/big"
+	"net/http"
+	"sync"
+)
+
+// senderKeyHex signs the scripted transactions, a fixed key keeps their hashes stable between runs
+const senderKeyHex = "b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291"
+
+// Tx is a scripted transaction, its logs only need the address, topics and data
+type Tx struct {
+	To     common.Address
+	Value  *big.Int
+	Data   []byte
+	Logs   []*ethtypes.Log
+	Failed bool
+}
+
+type callKey struct {
+	to   common.Address
+	data string
+}
+
+/*
+Chain is a scripted in-memory chain served through the JSON-RPC calls of the block getter and the contract caller:
+  - blocks: eth_blockNumber, eth_getBlockByNumber, eth_getBlockReceipts, eth_getTransactionByHash,
+    eth_getTransactionReceipt and eth_getLogs over the blocks added with AddBlock, the head is the last one
+  - state: eth_call answers the results set with SetCall whatever the block, Multicall3 aggregate3 calls are
+    answered call by call, eth_getCode answers the codes set with SetCode
+
+The head is polled, newHeads subscriptions are not supported.
+*/
+type Chain struct {
+	chain    *chain_params.ChainParams
+	key      *ecdsa.PrivateKey
+	mu       sync.RWMutex
+	blocks   map[uint64]*ethtypes.Block
+	receipts map[uint64][]*ethtypes.Receipt
+	txs      map[common.Hash]*ethtypes.Block
+	head     uint64
+	nonce    uint64
+	calls    map[callKey][]byte
+	codes    map[common.Address][]byte
+	server   *rpc.Server
+}
+
+func NewChain(chain *chain_params.ChainParams) *Chain {
+	key, err := crypto.HexToECDSA(senderKeyHex)
+	if err != nil {
+		panic(err)
+	}
+
+	c := &Chain{
+		chain:    chain,
+		key:      key,
+		blocks:   make(map[uint64]*ethtypes.Block),
+		receipts: make(map[uint64][]*ethtypes.Receipt),
+		txs:      make(map[common.Hash]*ethtypes.Block),
+		calls:    make(map[callKey][]byte),
+		codes:    make(map[common.Address][]byte),
+		server:   rpc.NewServer(),
+	}
+	if err = c.server.RegisterName("eth", &ethAPI{c: c}); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Client returns a client of the chain, connected in process
+func (c *Chain) Client() *ethclient.Client {
+	return ethclient.NewClient(rpc.DialInProc(c.server))
+}
+
+// Handler serves the chain over HTTP
+func (c *Chain) Handler() http.Handler {
+	return c.server
+}
+
+// Sender is the sender of the scripted transactions
+func (c *Chain) Sender() common.Address {
+	return crypto.PubkeyToAddress(c.key.PublicKey)
+}
+
+func (c *Chain) Head() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.head
+}
+
+func (c *Chain) SetCall(to common.Address, data, result []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[callKey{to: to, data: string(data)}] = result
+}
+
+func (c *Chain) SetCode(address common.Address, code []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.codes[address] = code
+}
+
+// AddBlock signs the transactions and appends their block after the head, the logs get their block and tx positions
+func (c *Chain) AddBlock(number, time uint64, txs ...*Tx) *ethtypes.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if number <= c.head {
+		panic(fmt.Sprintf("block %d is not above the head %d", number, c.head))
+	}
+
+	header := &ethtypes.Header{
+		Number:     new(big.Int).SetUint64(number),
+		Time:       time,
+		Difficulty: big.NewInt(2),
+		GasLimit:   140_000_000,
+		Coinbase:   common.HexToAddress("0xfe"),
+	}
+	if parent, ok := c.blocks[c.head]; ok {
+		header.ParentHash = parent.Hash()
+	}
+
+	signer := ethtypes.MakeSigner(c.chain.ChainConfig, header.Number, time)
+	signedTxs := make([]*ethtypes.Transaction, len(txs))
+	receipts := make([]*ethtypes.Receipt, len(txs))
+	var gasUsed, logIndex uint64
+	for i, tx := range txs {
+		value := tx.Value
+		if value == nil {
+			value = new(big.Int)
+		}
+		signed, err := ethtypes.SignNewTx(c.key, signer, &ethtypes.LegacyTx{
+			Nonce:    c.nonce,
+			GasPrice: big.NewInt(1e9),
+			Gas:      300_000,
+			To:       &tx.To,
+			Value:    value,
+			Data:     tx.Data,
+		})
+		if err != nil {
+			panic(err)
+		}
+		c.nonce++
+		signedTxs[i] = signed
+
+		receipt := &ethtypes.Receipt{
+			Type:              signed.Type(),
+			Status:            ethtypes.ReceiptStatusSuccessful,
+			TxHash:            signed.Hash(),
+			GasUsed:           100_000,
+			EffectiveGasPrice: signed.GasPrice(),
+			BlockNumber:       header.Number,
+			TransactionIndex:  uint(i),
+			Logs:              []*ethtypes.Log{},
+		}
+		gasUsed += receipt.GasUsed
+		receipt.CumulativeGasUsed = gasUsed
+		if tx.Failed {
+			receipt.Status = ethtypes.ReceiptStatusFailed
+		} else {
+			for _, log := range tx.Logs {
+				l := *log
+				l.BlockNumber = number
+				l.TxHash = signed.Hash()
+				l.TxIndex = uint(i)
+				l.Index = uint(logIndex)
+				logIndex++
+				receipt.Logs = append(receipt.Logs, &l)
+			}
+		}
+		receipt.Bloom = ethtypes.CreateBloom(ethtypes.Receipts{receipt})
+		receipts[i] = receipt
+	}
+	header.GasUsed = gasUsed
+
+	block := ethtypes.NewBlock(header, &ethtypes.Body{Transactions: signedTxs}, receipts, trie.NewStackTrie(nil))
+	for _, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		for _, log := range receipt.Logs {
+			log.BlockHash = block.Hash()
+		}
+	}
+
+	c.blocks[number] = block
+	c.receipts[number] = receipts
+	for _, tx := range signedTxs {
+		c.txs[tx.Hash()] = block
+	}
+	c.head = number
+	return block
+}
+
+func (c *Chain) block(number rpc.BlockNumber) (*ethtypes.Block, []*ethtypes.Receipt) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	height := uint64(number)
+	if number < 0 { // latest, pending, safe and finalized are all the head
+		height = c.head
+	}
+	return c.blocks[height], c.receipts[height]
+}
+
+func (c *Chain) txBlock(hash common.Hash) (*ethtypes.Block, []*ethtypes.Receipt) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	block, ok := c.txs[hash]
+	if !ok {
+		return nil, nil
+	}
+	return block, c.receipts[block.NumberU64()]
+}
+
+func (c *Chain) call(to common.Address, data []byte) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result, ok := c.calls[callKey{to: to, data: string(data)}]
+	return result, ok
+}
+
+func (c *Chain) code(address common.Address) []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.codes[address]
+}
@@ -0,0 +1,321 @@
+package fixture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var record bool
+
+func init() {
+	// only test binaries take -record, it stays out of the flags of the indexer
+	if testing.Testing() {
+		flag.BoolVar(&record, "record", false, "record the JSON-RPC fixtures from the chains scripted by the tests instead of replaying them")
+	}
+}
+
+// Recording reports if the tests were run with -record
+func Recording() bool {
+	return record
+}
+
+// ErrNotRecorded is the error of a replayed call missing from the fixture
+var ErrNotRecorded = errors.New("not recorded, run the test with -record")
+
+type callError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Call is a recorded JSON-RPC call, the params are compacted so a call is found again whatever its request id
+type Call struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *callError      `json:"error,omitempty"`
+}
+
+type message struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *callError      `json:"error,omitempty"`
+}
+
+/*
+Transport records the JSON-RPC calls made over HTTP to a fixture file, and replays them offline.
+  - record: the requests are sent to the node, the answers are kept and written by Save
+  - replay: the requests are answered from the fixture, a call missing from it fails with ErrNotRecorded
+
+Single and batch requests are both supported. The calls are matched on their method and params only,
+so a call answered differently at different times, like eth_blockNumber, is replayed with its first answer.
+The tests of a protocol parser replay the logs, receipts and eth_call answers of the transactions
+they script on the fake chain, recorded this way, each parser comes with the fixture of its test.
+*/
+type Transport struct {
+	path     string
+	upstream http.RoundTripper // nil when replaying
+	mu       sync.Mutex
+	calls    []*Call
+	index    map[string]*Call
+}
+
+// NewRecorder records the calls sent to the node through the default transport
+func NewRecorder(path string) *Transport {
+	return &Transport{
+		path:     path,
+		upstream: http.DefaultTransport,
+		calls:    []*Call{},
+		index:    make(map[string]*Call),
+	}
+}
+
+// Open replays the calls of a fixture file, the error wraps os.ErrNotExist when it was not recorded yet
+func Open(path string) (*Transport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []*Call
+	if err = json.Unmarshal(data, &calls); err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+
+	t := &Transport{path: path, index: make(map[string]*Call, len(calls))}
+	for _, call := range calls {
+		t.add(call)
+	}
+	return t, nil
+}
+
+// Dial connects a client to url through the transport, replayed clients never reach url
+func Dial(url string, t *Transport) (*ethclient.Client, error) {
+	client, err := rpc.DialOptions(context.Background(), url, rpc.WithHTTPClient(&http.Client{Transport: t}))
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(client), nil
+}
+
+func (t *Transport) Recording() bool {
+	return t.upstream != nil
+}
+
+// Save writes the recorded calls, in the order they were first made
+func (t *Transport) Save() error {
+	t.mu.Lock()
+	data, err := json.MarshalIndent(t.calls, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, append(data, '\n'), 0644)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	requests, batch, err := parseMessages(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Recording() {
+		return t.record(req, body, requests, batch)
+	}
+	return t.replay(req, requests, batch)
+}
+
+func (t *Transport) record(req *http.Request, body []byte, requests []*message, batch bool) (*http.Response, error) {
+	upstreamReq := req.Clone(req.Context())
+	upstreamReq.Body = io.NopCloser(bytes.NewReader(body))
+	upstreamReq.ContentLength = int64(len(body))
+	resp, err := t.upstream.RoundTrip(upstreamReq)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	responses, _, err := parseMessages(respBody)
+	if err != nil {
+		return nil, err
+	}
+	id2Response := make(map[string]*message, len(responses))
+	for _, response := range responses {
+		id2Response[string(response.ID)] = response
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, request := range requests {
+		response, ok := id2Response[string(request.ID)]
+		if !ok {
+			continue
+		}
+		t.add(&Call{Method: request.Method, Params: request.Params, Result: response.Result, Error: response.Error})
+	}
+	return resp, nil
+}
+
+func (t *Transport) replay(req *http.Request, requests []*message, batch bool) (*http.Response, error) {
+	responses := make([]*message, len(requests))
+	t.mu.Lock()
+	for i, request := range requests {
+		response := &message{Version: "2.0", ID: request.ID}
+		call, ok := t.index[callKey(request.Method, request.Params)]
+		if ok {
+			response.Result, response.Error = call.Result, call.Error
+		} else {
+			response.Error = &callError{Code: -32000, Message: fmt.Sprintf("%s %s %s", request.Method, request.Params, ErrNotRecorded)}
+		}
+		responses[i] = response
+	}
+	t.mu.Unlock()
+
+	var (
+		body []byte
+		err  error
+	)
+	if batch {
+		body, err = json.Marshal(responses)
+	} else {
+		body, err = json.Marshal(responses[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// add keeps the first answer of a call, t.mu is held or t is not shared yet
+func (t *Transport) add(call *Call) {
+	call.Params = compact(call.Params)
+	key := callKey(call.Method, call.Params)
+	if _, ok := t.index[key]; ok {
+		return
+	}
+	t.index[key] = call
+	t.calls = append(t.calls, call)
+}
+
+func callKey(method string, params json.RawMessage) string {
+	return method + " " + string(compact(params))
+}
+
+func compact(data json.RawMessage) json.RawMessage {
+	if len(data) == 0 {
+		return json.RawMessage("[]")
+	}
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, data); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+// parseMessages parses a single message or a batch of them, batch is true for the latter
+func parseMessages(data []byte) ([]*message, bool, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var msgs []*message
+		if err := json.Unmarshal(data, &msgs); err != nil {
+			return nil, true, err
+		}
+		return msgs, true, nil
+	}
+
+	msg := &message{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, false, err
+	}
+	return []*message{msg}, false, nil
+}
+
+// replayURL is dialed by the replayed tests, the transport answers in its place
+const replayURL = "http://fixture.invalid"
+
+/*
+DialTest connects a test to its fixture testdata/<name>.json, in the test package.
+The fixture is replayed offline, a missing one fails the test. With -record it is recorded again,
+once the test passes, from the chain served by serve, the chain scripted for the test.
+*/
+func DialTest(t testing.TB, name string, serve func() http.Handler) *ethclient.Client {
+	path := filepath.Join("testdata", name+".json")
+	url := replayURL
+	var (
+		transport *Transport
+		err       error
+	)
+	if Recording() {
+		server := httptest.NewServer(serve())
+		t.Cleanup(server.Close)
+		url = server.URL
+
+		transport = NewRecorder(path)
+		t.Cleanup(func() {
+			// a failed recording would replace the fixture with a partial one
+			if t.Failed() {
+				return
+			}
+			if saveErr := transport.Save(); saveErr != nil {
+				t.Errorf("save fixture %s: %v", path, saveErr)
+			}
+		})
+	} else if transport, err = Open(path); errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("fixture %s is not recorded, run the test with -record to record it", path)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := Dial(url, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
@@ -0,0 +1,104 @@
+package fixture
+
+import (
+	"bxs/chain/fake"
+	"bxs/chain_params"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTransport_RecordReplay(t *testing.T) {
+	fc := fake.NewChain(chain_params.G)
+	token := common.HexToAddress("0x70")
+	fc.SetCall(token, []byte{0x06, 0xfd, 0xde, 0x03}, common.LeftPadBytes([]byte{1}, 32))
+	log := &ethtypes.Log{Address: token, Topics: []common.Hash{common.HexToHash("0x01")}}
+	block := fc.AddBlock(100, 1700000000, &fake.Tx{To: token, Logs: []*ethtypes.Log{log}})
+	txHash := block.Transactions()[0].Hash()
+
+	server := httptest.NewServer(fc.Handler())
+	path := filepath.Join(t.TempDir(), "testdata", "chain.json")
+
+	// calls the same requests on a recording or a replaying client
+	calls := func(t *testing.T, tr *Transport, url string) (*ethtypes.Receipt, []byte, json.RawMessage) {
+		client, err := Dial(url, tr)
+		require.NoError(t, err)
+		defer client.Close()
+
+		receipt, err := client.TransactionReceipt(context.Background(), txHash)
+		require.NoError(t, err)
+		result, err := client.CallContract(context.Background(), ethereum.CallMsg{To: &token, Data: []byte{0x06, 0xfd, 0xde, 0x03}}, nil)
+		require.NoError(t, err)
+
+		var header json.RawMessage
+		batch := []rpc.BatchElem{{Method: "eth_getBlockByNumber", Args: []any{"0x64", false}, Result: &header}}
+		require.NoError(t, client.Client().BatchCallContext(context.Background(), batch))
+		require.NoError(t, batch[0].Error)
+		return receipt, result, header
+	}
+
+	recorder := NewRecorder(path)
+	receipt, result, header := calls(t, recorder, server.URL)
+	require.NoError(t, recorder.Save())
+	server.Close()
+
+	replayer, err := Open(path)
+	require.NoError(t, err)
+	replayed, replayedResult, replayedHeader := calls(t, replayer, server.URL)
+	require.Equal(t, receipt.TxHash, replayed.TxHash)
+	require.Equal(t, receipt.Logs[0].Topics, replayed.Logs[0].Topics)
+	require.Equal(t, result, replayedResult)
+	require.JSONEq(t, string(header), string(replayedHeader))
+
+	// a call missing from the fixture fails, the node is not reached
+	client, err := Dial(server.URL, replayer)
+	require.NoError(t, err)
+	_, err = client.BlockNumber(context.Background())
+	require.ErrorContains(t, err, ErrNotRecorded.Error())
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing.json"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// fatalTB records the failure of DialTest, which ends the goroutine of the test
+type fatalTB struct {
+	testing.TB
+	failure string
+}
+
+func (tb *fatalTB) Fatalf(format string, args ...any) {
+	tb.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// a test without its fixture fails, it does not call a node
+func TestDialTest_NotRecorded(t *testing.T) {
+	if Recording() {
+		t.Skip("records nothing")
+	}
+	served := false
+	tb := &fatalTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		DialTest(tb, "not_recorded", func() http.Handler {
+			served = true
+			return fake.NewChain(chain_params.G).Handler()
+		})
+	}()
+	<-done
+
+	require.Contains(t, tb.failure, "testdata/not_recorded.json is not recorded")
+	require.False(t, served)
+}
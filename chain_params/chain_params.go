@@ -1,19 +1,43 @@
 package chain_params
 
 import (
+	"bxs/abi/erc4337"
+	"bxs/abi/multicall3"
 	"bxs/chain"
 	"bxs/chain/v1_5_17"
+	"bxs/config"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/params"
+	"slices"
 )
 
+/*
+ChainParams is the definition of an indexed chain: the signer fork schedule, the native and wrapped native tokens,
+the factories whose pairs are indexed and the v2 pair pricing the native token in USD.
+
+The pairs are only indexed when one token is the native or the wrapped native token,
+the parsers order that token as token1, so the pair price and amounts are quoted in it.
+*/
 type ChainParams struct {
-	ChainID                      int
-	ChainConfig                  *params.ChainConfig
-	WBNBAddress                  common.Address
-	PancakeV2FactoryAddress      common.Address
-	PancakeV2BusdWbnbPairAddress common.Address
-	XLaunchFactoryAddress        common.Address
+	Name                    string
+	ChainID                 int
+	ChainConfig             *params.ChainConfig
+	NativeSymbol            string
+	WrappedNativeAddress    common.Address
+	WrappedNativeSymbol     string
+	WrappedNativeDecimals   int8
+	PancakeV2FactoryAddress common.Address
+	PancakeV2RouterAddress  common.Address // quotes and simulates the trades of the PancakeV2 pairs
+	Multicall3Address       common.Address
+	PricePairAddress        common.Address // v2 pair of the wrapped native and a USD stable coin
+	PricePairNativeIsToken0 bool
+	PricePairStableDecimals int8
+	XLaunchFactoryAddress   common.Address
+	Routers                 []common.Address // swap routers, a swap paid to one is credited to the tx sender
+	EntryPoints             []common.Address // ERC-4337 entry points, a swap of a user operation is credited to its account
+	Rpc                     *config.ChainConf
+	StartBlockNumber        uint64
 }
 
 const (
@@ -23,6 +47,11 @@ const (
 	PancakeV2FactoryAddressTestnetHex = "0xB7926C0430Afb07AA7DEfDE6DA862aE0Bde767bc"
 	PancakeV2BusdWbnbPairHex          = "0x58F876857a02D6762E0101bb5C46A8c1ED44Dc16"
 	PancakeV2BusdWbnbPairTestnetHex   = "0x85EcDcdd01EbE0BfD0Aba74B81Ca6d7F4A53582b"
+	PancakeV2RouterHex                = "0x10ED43C718714eb63d5aA57B78B54704E256024E"
+	PancakeV2RouterTestnetHex         = "0xD99D1c33F9fC3444f8101754aBC46c52416550D1"
+
+	PresetBSC    = "bsc"
+	PresetChapel = "chapel"
 )
 
 var (
@@ -32,31 +61,203 @@ var (
 	PancakeV2FactoryAddressTestnet  = common.HexToAddress(PancakeV2FactoryAddressTestnetHex)
 	PancakeV2BusdWbnbAddress        = common.HexToAddress(PancakeV2BusdWbnbPairHex)
 	PancakeV2BusdWbnbAddressTestnet = common.HexToAddress(PancakeV2BusdWbnbPairTestnetHex)
+	PancakeV2Router                 = common.HexToAddress(PancakeV2RouterHex)
+	PancakeV2RouterTestnet          = common.HexToAddress(PancakeV2RouterTestnetHex)
+	EntryPoints                     = []common.Address{erc4337.EntryPointV06Address, erc4337.EntryPointV07Address}
 
 	mainnetParams = &ChainParams{
-		ChainID:                      chain.BSCMainnetID,
-		ChainConfig:                  v1_5_17.BSCChainConfig,
-		PancakeV2FactoryAddress:      PancakeV2FactoryAddress,
-		PancakeV2BusdWbnbPairAddress: PancakeV2BusdWbnbAddress,
-		WBNBAddress:                  WBNBAddress,
+		Name:                    PresetBSC,
+		ChainID:                 chain.BSCMainnetID,
+		ChainConfig:             v1_5_17.BSCChainConfig,
+		NativeSymbol:            "BNB",
+		WrappedNativeAddress:    WBNBAddress,
+		WrappedNativeSymbol:     "WBNB",
+		WrappedNativeDecimals:   18,
+		PancakeV2FactoryAddress: PancakeV2FactoryAddress,
+		PancakeV2RouterAddress:  PancakeV2Router,
+		Multicall3Address:       multicall3.Address,
+		PricePairAddress:        PancakeV2BusdWbnbAddress,
+		PricePairNativeIsToken0: true,
+		PricePairStableDecimals: 18,
+		Routers:                 []common.Address{PancakeV2Router},
+		EntryPoints:             EntryPoints,
 	}
 
 	testnetParams = &ChainParams{
-		ChainID:                      chain.BSCTestnetID,
-		ChainConfig:                  v1_5_17.ChapelChainConfig,
-		PancakeV2FactoryAddress:      PancakeV2FactoryAddressTestnet,
-		PancakeV2BusdWbnbPairAddress: PancakeV2BusdWbnbAddressTestnet,
-		WBNBAddress:                  WBNBAddressTestnet,
+		Name:                    PresetChapel,
+		ChainID:                 chain.BSCTestnetID,
+		ChainConfig:             v1_5_17.ChapelChainConfig,
+		NativeSymbol:            "BNB",
+		WrappedNativeAddress:    WBNBAddressTestnet,
+		WrappedNativeSymbol:     "WBNB",
+		WrappedNativeDecimals:   18,
+		PancakeV2FactoryAddress: PancakeV2FactoryAddressTestnet,
+		PancakeV2RouterAddress:  PancakeV2RouterTestnet,
+		Multicall3Address:       multicall3.Address,
+		PricePairAddress:        PancakeV2BusdWbnbAddressTestnet,
+		PricePairNativeIsToken0: true,
+		PricePairStableDecimals: 18,
+		Routers:                 []common.Address{PancakeV2RouterTestnet},
+		EntryPoints:             EntryPoints,
 	}
 
+	presets = map[string]*ChainParams{
+		PresetBSC:    mainnetParams,
+		PresetChapel: testnetParams,
+	}
+
+	// G is the first configured chain, used where a single chain is assumed (tools and tests)
 	G *ChainParams = mainnetParams
 )
 
+func (c *ChainParams) IsWrappedNative(address common.Address) bool {
+	return address == c.WrappedNativeAddress
+}
+
+func (c *ChainParams) IsRouter(address common.Address) bool {
+	return slices.Contains(c.Routers, address)
+}
+
+func (c *ChainParams) IsEntryPoint(address common.Address) bool {
+	return slices.Contains(c.EntryPoints, address)
+}
+
+// OrderAddress orders the tokens of a pair so the wrapped native token is token1, reversed is true when they were swapped
+func (c *ChainParams) OrderAddress(a0, a1 common.Address) (common.Address, common.Address, bool) {
+	if c.IsWrappedNative(a1) {
+		return a0, a1, false
+	}
+	return a1, a0, true
+}
+
 func LoadNetwork(testnet bool, xLaunchFactoryAddress common.Address) {
 	if testnet {
-		G = testnetParams
+		G = copyPreset(PresetChapel)
 	} else {
-		G = mainnetParams
+		G = copyPreset(PresetBSC)
 	}
 	G.XLaunchFactoryAddress = xLaunchFactoryAddress
 }
+
+func copyPreset(name string) *ChainParams {
+	c := *presets[name]
+	c.Routers = slices.Clone(c.Routers)
+	return &c
+}
+
+// FromConf builds a chain from its preset, overridden by the fields set in conf
+func FromConf(conf *config.ChainDefConf) (*ChainParams, error) {
+	c := &ChainParams{WrappedNativeDecimals: 18, PricePairStableDecimals: 18, EntryPoints: EntryPoints, Multicall3Address: multicall3.Address}
+	if conf.Preset != "" {
+		if _, ok := presets[conf.Preset]; !ok {
+			return nil, fmt.Errorf("chain %s: unknown preset %s", conf.Name, conf.Preset)
+		}
+		c = copyPreset(conf.Preset)
+	}
+
+	if conf.Name != "" {
+		c.Name = conf.Name
+	}
+	if conf.ChainId != 0 {
+		c.ChainID = conf.ChainId
+	}
+	if conf.ChainConfig != nil {
+		c.ChainConfig = conf.ChainConfig
+	}
+	if conf.NativeSymbol != "" {
+		c.NativeSymbol = conf.NativeSymbol
+	}
+	if wrapped := conf.WrappedNative; wrapped != nil {
+		if wrapped.Address != (common.Address{}) {
+			c.WrappedNativeAddress = wrapped.Address
+		}
+		if wrapped.Symbol != "" {
+			c.WrappedNativeSymbol = wrapped.Symbol
+		}
+		if wrapped.Decimals != 0 {
+			c.WrappedNativeDecimals = wrapped.Decimals
+		}
+	}
+	if conf.PancakeV2Factory != (common.Address{}) {
+		c.PancakeV2FactoryAddress = conf.PancakeV2Factory
+	}
+	if conf.PancakeV2Router != (common.Address{}) {
+		c.PancakeV2RouterAddress = conf.PancakeV2Router
+		if !c.IsRouter(conf.PancakeV2Router) {
+			c.Routers = append(c.Routers, conf.PancakeV2Router)
+		}
+	}
+	if conf.Multicall3 != (common.Address{}) {
+		c.Multicall3Address = conf.Multicall3
+	}
+	if pricePair := conf.PricePair; pricePair != nil {
+		c.PricePairAddress = pricePair.Address
+		c.PricePairNativeIsToken0 = pricePair.NativeIsToken0
+		if pricePair.StableDecimals != 0 {
+			c.PricePairStableDecimals = pricePair.StableDecimals
+		}
+	}
+	c.XLaunchFactoryAddress = conf.XLaunchFactory
+	c.Routers = append(c.Routers, conf.Routers...)
+	if len(conf.EntryPoints) > 0 {
+		c.EntryPoints = conf.EntryPoints
+	}
+	c.Rpc = conf.Rpc
+	c.StartBlockNumber = conf.StartBlockNumber
+
+	switch {
+	case c.Name == "":
+		return nil, fmt.Errorf("chain %d: name is empty", c.ChainID)
+	case c.ChainID == 0:
+		return nil, fmt.Errorf("chain %s: chain_id is empty", c.Name)
+	case c.ChainConfig == nil || c.ChainConfig.ChainID == nil:
+		return nil, fmt.Errorf("chain %s: chain_config is empty", c.Name)
+	case c.ChainConfig.ChainID.Int64() != int64(c.ChainID):
+		return nil, fmt.Errorf("chain %s: chain_config chain id %s is not %d", c.Name, c.ChainConfig.ChainID, c.ChainID)
+	case c.WrappedNativeAddress == (common.Address{}):
+		return nil, fmt.Errorf("chain %s: wrapped_native address is empty", c.Name)
+	case c.Rpc == nil:
+		return nil, fmt.Errorf("chain %s: rpc is empty", c.Name)
+	}
+	return c, nil
+}
+
+/*
+Load returns the chains to index. With no chains configured it is the legacy single chain:
+bsc or chapel by testnet, with the top level chain endpoints, start block and xlaunch factory.
+G is set to the first chain.
+*/
+func Load(conf *config.Config) ([]*ChainParams, error) {
+	if len(conf.Chains) == 0 {
+		LoadNetwork(conf.TestNet, conf.XLaunchFactoryAddress)
+		G.Rpc = conf.Chain
+		G.StartBlockNumber = conf.BlockGetter.StartBlockNumber
+		return []*ChainParams{G}, nil
+	}
+
+	chains := make([]*ChainParams, 0, len(conf.Chains))
+	names := make(map[string]struct{})
+	ids := make(map[int]struct{})
+	for _, chainConf := range conf.Chains {
+		c, err := FromConf(chainConf)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.StartBlockNumber == 0 {
+			c.StartBlockNumber = conf.BlockGetter.StartBlockNumber
+		}
+		if _, ok := names[c.Name]; ok {
+			return nil, fmt.Errorf("chain %s defined twice", c.Name)
+		}
+		if _, ok := ids[c.ChainID]; ok {
+			return nil, fmt.Errorf("chain id %d defined twice", c.ChainID)
+		}
+		names[c.Name] = struct{}{}
+		ids[c.ChainID] = struct{}{}
+		chains = append(chains, c)
+	}
+
+	G = chains[0]
+	return chains, nil
+}
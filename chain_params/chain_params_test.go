@@ -0,0 +1,89 @@
+package chain_params
+
+import (
+	"bxs/config"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+func TestLoad_Legacy(t *testing.T) {
+	conf := config.G
+	conf.TestNet = true
+	conf.XLaunchFactoryAddress = common.HexToAddress("0x01")
+
+	chains, err := Load(&conf)
+	require.NoError(t, err)
+	require.Len(t, chains, 1)
+	require.Same(t, G, chains[0])
+	require.Equal(t, PresetChapel, G.Name)
+	require.Equal(t, WBNBAddressTestnet, G.WrappedNativeAddress)
+	require.Equal(t, conf.XLaunchFactoryAddress, G.XLaunchFactoryAddress)
+	require.Equal(t, conf.Chain, G.Rpc)
+	require.Equal(t, conf.BlockGetter.StartBlockNumber, G.StartBlockNumber)
+
+	// the preset is copied, not modified
+	require.Equal(t, common.Address{}, testnetParams.XLaunchFactoryAddress)
+}
+
+func TestLoad_Chains(t *testing.T) {
+	weth := common.HexToAddress("0x4200000000000000000000000000000000000006")
+	conf := config.G
+	conf.Chains = []*config.ChainDefConf{
+		{Name: "bsc", Preset: PresetBSC, Rpc: &config.ChainConf{}, StartBlockNumber: 100},
+		{
+			Name:          "base",
+			ChainId:       8453,
+			ChainConfig:   &params.ChainConfig{ChainID: big.NewInt(8453)},
+			Rpc:           &config.ChainConf{},
+			NativeSymbol:  "ETH",
+			WrappedNative: &config.WrappedNativeConf{Address: weth, Symbol: "WETH"},
+			PricePair:     &config.PricePairConf{Address: common.HexToAddress("0x02"), StableDecimals: 6},
+		},
+	}
+
+	chains, err := Load(&conf)
+	require.NoError(t, err)
+	require.Len(t, chains, 2)
+	require.Same(t, G, chains[0])
+
+	bsc := chains[0]
+	require.Equal(t, 56, bsc.ChainID)
+	require.Equal(t, WBNBAddress, bsc.WrappedNativeAddress)
+	require.Equal(t, uint64(100), bsc.StartBlockNumber)
+
+	base := chains[1]
+	require.Equal(t, 8453, base.ChainID)
+	require.True(t, base.IsWrappedNative(weth))
+	require.Equal(t, "WETH", base.WrappedNativeSymbol)
+	require.Equal(t, int8(18), base.WrappedNativeDecimals)
+	require.False(t, base.PricePairNativeIsToken0)
+	require.Equal(t, int8(6), base.PricePairStableDecimals)
+	require.Equal(t, conf.BlockGetter.StartBlockNumber, base.StartBlockNumber)
+
+	token := common.HexToAddress("0x03")
+	token0, token1, reversed := base.OrderAddress(weth, token)
+	require.Equal(t, token, token0)
+	require.Equal(t, weth, token1)
+	require.True(t, reversed)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	conf := config.G
+	conf.Chains = []*config.ChainDefConf{{Name: "x", Preset: "unknown"}}
+	_, err := Load(&conf)
+	require.ErrorContains(t, err, "unknown preset")
+
+	conf.Chains = []*config.ChainDefConf{{Name: "x", Preset: PresetBSC, ChainId: 1, Rpc: &config.ChainConf{}}}
+	_, err = Load(&conf)
+	require.ErrorContains(t, err, "chain_config chain id")
+
+	conf.Chains = []*config.ChainDefConf{
+		{Name: "a", Preset: PresetBSC, Rpc: &config.ChainConf{}},
+		{Name: "b", Preset: PresetBSC, Rpc: &config.ChainConf{}},
+	}
+	_, err = Load(&conf)
+	require.ErrorContains(t, err, "chain id 56 defined twice")
+}
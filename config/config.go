@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/avast/retry-go/v4"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
 	"os"
 	"time"
 )
@@ -22,23 +23,134 @@ type ChainConf struct {
 	WsEndpoint      string `json:"ws_endpoint"`
 }
 
+type WrappedNativeConf struct {
+	Address  common.Address `json:"address"`
+	Symbol   string         `json:"symbol"`
+	Decimals int8           `json:"decimals"`
+}
+
+type PricePairConf struct {
+	Address        common.Address `json:"address"`          // v2 pair of the wrapped native and a USD stable coin
+	NativeIsToken0 bool           `json:"native_is_token0"` // the wrapped native is the token0 of the pair
+	StableDecimals int8           `json:"stable_decimals"`
+}
+
+/*
+ChainDefConf defines an indexed chain, the empty fields take the value of the preset (bsc | chapel) if any.
+Each chain runs its own pipeline, the cache keys and the token and pair rows are scoped by chain_id.
+*/
+type ChainDefConf struct {
+	Name             string              `json:"name"`
+	Preset           string              `json:"preset"`
+	ChainId          int                 `json:"chain_id"`
+	ChainConfig      *params.ChainConfig `json:"chain_config"` // fork schedule selecting the tx signer
+	Rpc              *ChainConf          `json:"rpc"`
+	StartBlockNumber uint64              `json:"start_block_number"` // block_getter.start_block_number when 0
+	NativeSymbol     string              `json:"native_symbol"`
+	WrappedNative    *WrappedNativeConf  `json:"wrapped_native"`
+	PancakeV2Factory common.Address      `json:"pancake_v2_factory"`
+	PancakeV2Router  common.Address      `json:"pancake_v2_router"`
+	Multicall3       common.Address      `json:"multicall3"` // the canonical deployment when empty
+	PricePair        *PricePairConf      `json:"price_pair"`
+	XLaunchFactory   common.Address      `json:"xlaunch_factory"`
+	Routers          []common.Address    `json:"routers"`      // swap routers besides the preset ones
+	EntryPoints      []common.Address    `json:"entry_points"` // ERC-4337 entry points, v0.6 and v0.7 when empty
+}
+
 type RedisConf struct {
 	Addr     string `json:"addr"`
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
+type CacheKindConf struct {
+	Size   int `json:"size"`    // max entries in memory, 0 unbounded, ignored by the memory backend but for prices
+	TtlSec int `json:"ttl_sec"` // 0 never expire, ignored by the memory backend but for prices
+}
+
+type CacheConf struct {
+	Backend      string         `json:"backend"`       // redis | bolt | memory
+	BoltPath     string         `json:"bolt_path"`     // bbolt file, used when backend is bolt
+	SnapshotPath string         `json:"snapshot_path"` // memory backend snapshot, loaded on start and saved on shutdown, empty to disable
+	Price        *CacheKindConf `json:"price"`
+	Token        *CacheKindConf `json:"token"`
+	Pair         *CacheKindConf `json:"pair"`
+	MigrateToken *CacheKindConf `json:"migrate_token"`
+}
+
+type CacheWarmupConf struct {
+	Enabled   bool `json:"enabled"`    // rebuild the cache from the token_pair database before parsing starts
+	BatchSize int  `json:"batch_size"` // rows loaded per query
+}
+
 type BlockGetterConf struct {
 	PoolSize         int       `json:"pool_size"`
 	QueueSize        int       `json:"queue_size"`
 	StartBlockNumber uint64    `json:"start_block_number"`
 	Retry            RetryConf `json:"retry"`
 	SubHeader        bool      `json:"sub_header"`
+	Mode             string    `json:"mode"`      // block: full blocks and receipts | logs: eth_getLogs over block ranges | replay: blocks and calls from the archive, no RPC
+	LogRange         uint64    `json:"log_range"` // blocks per eth_getLogs request in logs mode
+}
+
+type ArchiveConf struct {
+	Enabled bool   `json:"enabled"` // write the blocks and receipts fetched in block mode, and the calls made to parse them, to the archive
+	Dir     string `json:"dir"`     // archive directory, also read in replay mode
 }
 
 type BlockHandlerConf struct {
-	PoolSize  int `json:"pool_size"`
-	QueueSize int `json:"queue_size"`
+	PoolSize        int `json:"pool_size"`          // blocks parsed at once
+	ParseTxPoolSize int `json:"parse_tx_pool_size"` // goroutines sharing the txs of a block, 0 or 1 to parse them one by one
+	QueueSize       int `json:"queue_size"`
+}
+
+type LaunchDetectorConf struct {
+	Enabled       bool   `json:"enabled"`        // flag the buys of new xLaunch tokens by launch insiders and snipers
+	SniperBlocks  uint64 `json:"sniper_blocks"`  // a buy up to that many blocks after the launch block is early
+	WatchBlocks   uint64 `json:"watch_blocks"`   // the buys of a token are checked up to that many blocks after its launch
+	FundingBlocks uint64 `json:"funding_blocks"` // native transfers are remembered that many blocks to find the wallets a creator funded
+}
+
+type FailedSwapsConf struct {
+	Enabled      bool `json:"enabled"`       // decode the reverted txs sent to xLaunch pools and routers, in block mode only
+	RevertReason bool `json:"revert_reason"` // re-simulate them with eth_call on the parent block to get the revert reason
+}
+
+type TokenSecurityConf struct {
+	Enabled            bool    `json:"enabled"`              // simulate a buy, a transfer and a sell of the tokens listed on PancakeV2
+	ProbeAmount        float64 `json:"probe_amount"`         // native amount of the simulated buy
+	RefreshIntervalSec int     `json:"refresh_interval_sec"` // a listed token is simulated again after that many seconds
+	WatchHours         int     `json:"watch_hours"`          // and no longer refreshed that many hours after its listing
+	PoolSize           int     `json:"pool_size"`            // simulations run at once
+}
+
+type TokenMetadataConf struct {
+	Enabled          bool   `json:"enabled"`            // resolve the CID of the created tokens and normalise their social links
+	Gateway          string `json:"gateway"`            // IPFS HTTP gateway, the CID is appended to it, the only host fetched
+	TimeoutMs        int    `json:"timeout_ms"`         // of a gateway request
+	MaxMetadataBytes int64  `json:"max_metadata_bytes"` // larger metadata JSON is invalid
+	MaxImageBytes    int64  `json:"max_image_bytes"`    // larger images are invalid
+	MaxAttempts      int    `json:"max_attempts"`       // a token the gateway failed that many times is given up
+	BackoffSec       int    `json:"backoff_sec"`        // delay before the first retry, doubled on each attempt
+	PoolSize         int    `json:"pool_size"`          // tokens resolved at once
+	QueueSize        int    `json:"queue_size"`         // tokens waiting, the ones over it are not enriched
+}
+
+type StateRefreshConf struct {
+	PairReserves      bool `json:"pair_reserves"`       // store the reserves of the last pool update of each block on its pair
+	TokenSupply       bool `json:"token_supply"`        // read again the totalSupply of the tokens traded, and log its changes
+	SupplyIntervalSec int  `json:"supply_interval_sec"` // the tokens traded in that long are read at its end
+	SupplyPoolSize    int  `json:"supply_pool_size"`    // totalSupply calls at once
+}
+
+type MevAnalyzerConf struct {
+	Enabled bool `json:"enabled"` // find the sandwiches and arbitrages in the trades of each block
+}
+
+type SequencerConf struct {
+	BufferSize    int    `json:"buffer_size"`     // values buffered ahead of a missing sequence, 0 unbounded
+	GapTimeoutSec int    `json:"gap_timeout_sec"` // a missing sequence older than that is reported, and skipped with the skip policy
+	GapPolicy     string `json:"gap_policy"`      // wait: keep waiting for the missing sequence | skip: skip it and record the gap
 }
 
 type RetryConf struct {
@@ -75,8 +187,49 @@ type KafkaConf struct {
 	RetryIntervalByMs int      `json:"retry_interval_by_ms"`
 }
 
+type ClickHouseConf struct {
+	Enabled         bool   `json:"enabled"`
+	Endpoint        string `json:"endpoint"`
+	Database        string `json:"database"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	BatchSize       int    `json:"batch_size"` // a flush is started as soon as that many rows are buffered
+	FlushIntervalMs int    `json:"flush_interval_ms"`
+	TimeoutMs       int    `json:"timeout_ms"`
+	MaxRetry        int    `json:"max_retry"`
+	MaxBufferRows   int    `json:"max_buffer_rows"` // the rows over it are dropped while ClickHouse is down, 0 is unbounded
+}
+
+type MulticallConf struct {
+	Enabled  bool `json:"enabled"`   // coalesce the calls on the head state into Multicall3 aggregate3 calls
+	WindowMs int  `json:"window_ms"` // a batch is sent that long after its first call
+	MaxBatch int  `json:"max_batch"` // or once it has that many calls
+}
+
+type AbiRegistryConf struct {
+	Dir string `json:"dir"` // ABI JSON files loaded on start, <kind>.json replaces the ABI of a built-in kind, a new contract still needs its parser in Go
+}
+
+type TracingConf struct {
+	Enabled     bool    `json:"enabled"`      // trace each block from its fetch to its commit with OpenTelemetry
+	Exporter    string  `json:"exporter"`     // otlp: OTLP over HTTP to endpoint | stdout: pretty printed spans
+	Endpoint    string  `json:"endpoint"`     // host:port of the OTLP collector
+	Insecure    bool    `json:"insecure"`     // plain HTTP to the collector
+	SampleRatio float64 `json:"sample_ratio"` // of the blocks traced, 1 traces them all
+	ServiceName string  `json:"service_name"`
+}
+
+type HealthConf struct {
+	MaxLagBlocks        uint64 `json:"max_lag_blocks"`         // readyz fails when the committed block is more than that behind the head
+	MaxCommitAgeSec     int    `json:"max_commit_age_sec"`     // healthz fails when no block was committed for that long
+	MaxPriceAgeSec      int    `json:"max_price_age_sec"`      // healthz fails when the native token price was not updated for that long
+	KafkaErrorWindowSec int    `json:"kafka_error_window_sec"` // healthz fails when the kafka producer failed that recently
+	TimeoutMs           int    `json:"timeout_ms"`             // of each check
+}
+
 type ContractCallerConf struct {
-	Retry *RetryConf `json:"retry"`
+	Retry     *RetryConf     `json:"retry"`
+	Multicall *MulticallConf `json:"multicall"`
 }
 
 type DBDatasourceConf struct {
@@ -92,24 +245,51 @@ func (c *DBDatasourceConf) GetPostgresDsn() string {
 		c.Host, c.Username, c.Password, c.DBName, c.Port)
 }
 
+type BulkLoadConf struct {
+	Enabled         bool   `json:"enabled"`
+	LagThreshold    uint64 `json:"lag_threshold"`     // switch to COPY when head - current height >= LagThreshold
+	BatchRows       int    `json:"batch_rows"`        // the txs are buffered across blocks and copied once that many are buffered
+	FlushIntervalMs int    `json:"flush_interval_ms"` // or once the first one waited that long, the finished block only advances then
+}
+
 type DBConf struct {
 	Enabled      bool              `json:"enabled"`
+	Driver       string            `json:"driver"`      // postgres | sqlite
+	SqlitePath   string            `json:"sqlite_path"` // database file, used when driver is sqlite
+	Migrate      bool              `json:"migrate"`     // apply the postgres migrations of the added columns and tables on start
 	DBDatasource *DBDatasourceConf `json:"db_datasource"`
+	BulkLoad     *BulkLoadConf     `json:"bulk_load"`
 }
 
 type Config struct {
 	Log                   *LogConf            `json:"log"`
 	Chain                 *ChainConf          `json:"chain"`
+	Chains                []*ChainDefConf     `json:"chains"` // chains indexed at once, when empty the single chain of chain, testnet and xlaunch_factory_address
 	Redis                 *RedisConf          `json:"redis"`
+	Cache                 *CacheConf          `json:"cache"`
+	CacheWarmup           *CacheWarmupConf    `json:"cache_warmup"`
 	BlockGetter           *BlockGetterConf    `json:"block_getter"`
+	Archive               *ArchiveConf        `json:"archive"`
 	BlockHandler          *BlockHandlerConf   `json:"block_handler"`
+	LaunchDetector        *LaunchDetectorConf `json:"launch_detector"`
+	MevAnalyzer           *MevAnalyzerConf    `json:"mev_analyzer"`
+	FailedSwaps           *FailedSwapsConf    `json:"failed_swaps"`
+	TokenSecurity         *TokenSecurityConf  `json:"token_security"`
+	TokenMetadata         *TokenMetadataConf  `json:"token_metadata"`
+	StateRefresh          *StateRefreshConf   `json:"state_refresh"`
 	EnableSequencer       bool                `json:"enable_sequencer"`
+	Sequencer             *SequencerConf      `json:"sequencer"`
 	PriceService          *PriceServiceConf   `json:"price_service"`
 	Kafka                 *KafkaConf          `json:"kafka"`
+	ClickHouse            *ClickHouseConf     `json:"clickhouse"`
 	ContractCaller        *ContractCallerConf `json:"contract_caller"`
+	AbiRegistry           *AbiRegistryConf    `json:"abi_registry"`
+	Tracing               *TracingConf        `json:"tracing"`
 	TxDatabase            *DBConf             `json:"tx_database"`
 	TokenPairDatabase     *DBConf             `json:"token_pair_database"`
-	MetricsPort           int                 `json:"metrics_port"` // Port for Prometheus metrics
+	MetricsPort           int                 `json:"metrics_port"` // Port for Prometheus metrics, /healthz and /readyz
+	Health                *HealthConf         `json:"health"`
+	ShutdownTimeoutSec    int                 `json:"shutdown_timeout_sec"` // on SIGTERM wait that long for the in flight blocks to commit
 	TestNet               bool                `json:"testnet"`
 	XLaunchFactoryAddress common.Address      `json:"xlaunch_factory_address"`
 }
@@ -122,15 +302,40 @@ var (
 			AsyncFlushIntervalBySecond: 1,
 		},
 		Chain: &ChainConf{
-			Endpoint:        "https://base-rpc.publicnode.com",
-			EndpointArchive: "https://base-rpc.publicnode.com",
-			WsEndpoint:      "wss://base-rpc.publicnode.com",
+			Endpoint:        "https://bsc-rpc.publicnode.com",
+			EndpointArchive: "https://bsc-rpc.publicnode.com",
+			WsEndpoint:      "wss://bsc-rpc.publicnode.com",
 		},
 		Redis: &RedisConf{
 			Addr:     "localhost:6379",
 			Username: "",
 			Password: "",
 		},
+		Cache: &CacheConf{
+			Backend:      "redis",
+			BoltPath:     "data/cache.db",
+			SnapshotPath: "",
+			Price: &CacheKindConf{
+				Size:   10000,
+				TtlSec: 3600,
+			},
+			Token: &CacheKindConf{
+				Size:   1000000,
+				TtlSec: 0,
+			},
+			Pair: &CacheKindConf{
+				Size:   1000000,
+				TtlSec: 0,
+			},
+			MigrateToken: &CacheKindConf{
+				Size:   100000,
+				TtlSec: 0,
+			},
+		},
+		CacheWarmup: &CacheWarmupConf{
+			Enabled:   false,
+			BatchSize: 5000,
+		},
 		BlockGetter: &BlockGetterConf{
 			PoolSize:         1,
 			QueueSize:        1,
@@ -141,12 +346,61 @@ var (
 				TimeoutMs: 5000,
 			},
 			SubHeader: false,
+			Mode:      "block",
+			LogRange:  100,
+		},
+		Archive: &ArchiveConf{
+			Enabled: false,
+			Dir:     "data/archive",
 		},
 		BlockHandler: &BlockHandlerConf{
-			PoolSize:  1,
-			QueueSize: 1,
+			PoolSize:        1,
+			ParseTxPoolSize: 0,
+			QueueSize:       1,
+		},
+		LaunchDetector: &LaunchDetectorConf{
+			Enabled:       false,
+			SniperBlocks:  3,
+			WatchBlocks:   1200,
+			FundingBlocks: 600,
+		},
+		MevAnalyzer: &MevAnalyzerConf{
+			Enabled: false,
+		},
+		FailedSwaps: &FailedSwapsConf{
+			Enabled:      false,
+			RevertReason: true,
+		},
+		TokenSecurity: &TokenSecurityConf{
+			Enabled:            false,
+			ProbeAmount:        0.1,
+			RefreshIntervalSec: 600,
+			WatchHours:         24,
+			PoolSize:           4,
+		},
+		TokenMetadata: &TokenMetadataConf{
+			Enabled:          false,
+			Gateway:          "https://ipfs.io/ipfs/",
+			TimeoutMs:        10000,
+			MaxMetadataBytes: 64 << 10,
+			MaxImageBytes:    2 << 20,
+			MaxAttempts:      6,
+			BackoffSec:       30,
+			PoolSize:         4,
+			QueueSize:        10000,
+		},
+		StateRefresh: &StateRefreshConf{
+			PairReserves:      true,
+			TokenSupply:       false,
+			SupplyIntervalSec: 300,
+			SupplyPoolSize:    16,
 		},
 		EnableSequencer: true,
+		Sequencer: &SequencerConf{
+			BufferSize:    1000,
+			GapTimeoutSec: 60,
+			GapPolicy:     "wait",
+		},
 		PriceService: &PriceServiceConf{
 			PoolSize:  1,
 			FromChain: true,
@@ -159,15 +413,44 @@ var (
 			MaxRetry:          10,
 			RetryIntervalByMs: 100,
 		},
+		ClickHouse: &ClickHouseConf{
+			Enabled:         false,
+			Endpoint:        "http://localhost:8123",
+			Database:        "bxs",
+			BatchSize:       10000,
+			FlushIntervalMs: 1000,
+			TimeoutMs:       10000,
+			MaxRetry:        3,
+			MaxBufferRows:   1000000,
+		},
 		ContractCaller: &ContractCallerConf{
 			Retry: &RetryConf{
 				Attempts:  10,
 				DelayMs:   100,
 				TimeoutMs: 3000,
 			},
+			Multicall: &MulticallConf{
+				Enabled:  false,
+				WindowMs: 5,
+				MaxBatch: 100,
+			},
+		},
+		AbiRegistry: &AbiRegistryConf{
+			Dir: "",
+		},
+		Tracing: &TracingConf{
+			Enabled:     false,
+			Exporter:    "otlp",
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1,
+			ServiceName: "bxs",
 		},
 		TxDatabase: &DBConf{
-			Enabled: false,
+			Enabled:    false,
+			Driver:     "postgres",
+			SqlitePath: "data/bxs.db",
+			Migrate:    false,
 			DBDatasource: &DBDatasourceConf{
 				Host:     "localhost",
 				Port:     5432,
@@ -175,9 +458,18 @@ var (
 				Password: "postgres",
 				DBName:   "test",
 			},
+			BulkLoad: &BulkLoadConf{
+				Enabled:         false,
+				LagThreshold:    1000,
+				BatchRows:       50000,
+				FlushIntervalMs: 5000,
+			},
 		},
 		TokenPairDatabase: &DBConf{
-			Enabled: false,
+			Enabled:    false,
+			Driver:     "postgres",
+			SqlitePath: "data/bxs.db",
+			Migrate:    false,
 			DBDatasource: &DBDatasourceConf{
 				Host:     "localhost",
 				Port:     5432,
@@ -187,7 +479,15 @@ var (
 			},
 		},
 		MetricsPort: 9100,
-		TestNet:     false,
+		Health: &HealthConf{
+			MaxLagBlocks:        200,
+			MaxCommitAgeSec:     60,
+			MaxPriceAgeSec:      300,
+			KafkaErrorWindowSec: 60,
+			TimeoutMs:           2000,
+		},
+		ShutdownTimeoutSec: 30,
+		TestNet:            false,
 	}
 
 	G = defaultConfig
@@ -0,0 +1,209 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOk   = "ok"
+	StatusFail = "fail"
+)
+
+// Check returns an error when the checked component is unhealthy, detail is reported either way, nil when there is none
+type Check func(ctx context.Context) (detail any, err error)
+
+type Result struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	Detail     any    `json:"detail,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string    `json:"status"`
+	Checks []*Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	live  bool
+	check Check
+}
+
+/*
+Checker runs the checks of the indexer for the orchestrator:
+  - /healthz runs the liveness checks, the ones a restart can fix: a stalled commit, a stale price, a failing producer
+  - /readyz runs all of them, with the reachability of the dependencies, the lag behind the head and the skipped gaps
+
+Both answer a JSON Report, with 200 when every check passes and 503 otherwise.
+The checks are added once the pipelines start, until then the indexer is alive but not ready.
+*/
+type Checker struct {
+	timeout time.Duration
+	mu      sync.RWMutex
+	checks  []*namedCheck
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add adds a check, live ones are also run by /healthz
+func (c *Checker) Add(name string, live bool, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, &namedCheck{name: name, live: live, check: check})
+}
+
+// Run runs the checks at once, each within the timeout, the results are in the order the checks were added
+func (c *Checker) Run(ctx context.Context, liveOnly bool) *Report {
+	c.mu.RLock()
+	checks := make([]*namedCheck, 0, len(c.checks))
+	for _, check := range c.checks {
+		if !liveOnly || check.live {
+			checks = append(checks, check)
+		}
+	}
+	c.mu.RUnlock()
+
+	report := &Report{Status: StatusOk, Checks: make([]*Result, len(checks))}
+	if !liveOnly && len(checks) == 0 {
+		report.Status = StatusFail
+		return report
+	}
+
+	wg := &sync.WaitGroup{}
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOk {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check *namedCheck) *Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	now := time.Now()
+	detail, err := check.check(ctx)
+	result := &Result{
+		Name:       check.name,
+		Status:     StatusOk,
+		Detail:     detail,
+		DurationMs: time.Since(now).Milliseconds(),
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Handler serves the report of the liveness checks when liveOnly, of all the checks otherwise
+func (c *Checker) Handler(liveOnly bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context(), liveOnly)
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusOk {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Init serves /healthz and /readyz next to /metrics
+func Init(c *Checker) {
+	http.Handle("/healthz", c.Handler(true))
+	http.Handle("/readyz", c.Handler(false))
+}
+
+// Ping checks a dependency is reachable
+func Ping(ping func(ctx context.Context) error) Check {
+	return func(ctx context.Context) (any, error) {
+		return nil, ping(ctx)
+	}
+}
+
+// NoGap checks gaps returns no gap, the gaps are the detail
+func NoGap[T any](gaps func() []T) Check {
+	return func(ctx context.Context) (any, error) {
+		g := gaps()
+		if len(g) > 0 {
+			return g, fmt.Errorf("%d gaps skipped", len(g))
+		}
+		return nil, nil
+	}
+}
+
+type lagDetail struct {
+	Head      uint64 `json:"head"`
+	Committed uint64 `json:"committed"`
+	Lag       uint64 `json:"lag"`
+	MaxLag    uint64 `json:"max_lag"`
+}
+
+// MaxLag checks the committed block is at most maxLag blocks behind the head
+func MaxLag(head, committed func() uint64, maxLag uint64) Check {
+	return func(ctx context.Context) (any, error) {
+		detail := &lagDetail{Head: head(), Committed: committed(), MaxLag: maxLag}
+		if detail.Head > detail.Committed {
+			detail.Lag = detail.Head - detail.Committed
+		}
+		if detail.Lag > maxLag {
+			return detail, fmt.Errorf("%d blocks behind the head", detail.Lag)
+		}
+		return detail, nil
+	}
+}
+
+type ageDetail struct {
+	At     time.Time `json:"at"`
+	AgeSec int64     `json:"age_sec"`
+	MaxSec int64     `json:"max_sec"`
+}
+
+// MaxAge checks the time returned by at is at most maxAge ago
+func MaxAge(at func() time.Time, maxAge time.Duration) Check {
+	return func(ctx context.Context) (any, error) {
+		t := at()
+		age := time.Since(t)
+		detail := &ageDetail{At: t, AgeSec: int64(age.Seconds()), MaxSec: int64(maxAge.Seconds())}
+		if age > maxAge {
+			return detail, fmt.Errorf("%s old", age.Truncate(time.Second))
+		}
+		return detail, nil
+	}
+}
+
+type errorDetail struct {
+	At        time.Time `json:"at"`
+	Error     string    `json:"error"`
+	WindowSec int64     `json:"window_sec"`
+}
+
+// NoRecentError checks lastError did not return an error within the window
+func NoRecentError(lastError func() (time.Time, error), window time.Duration) Check {
+	return func(ctx context.Context) (any, error) {
+		at, err := lastError()
+		if err == nil || time.Since(at) > window {
+			return nil, nil
+		}
+		return &errorDetail{At: at, Error: err.Error(), WindowSec: int64(window.Seconds())}, fmt.Errorf("error %s ago", time.Since(at).Truncate(time.Second))
+	}
+}
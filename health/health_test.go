@@ -0,0 +1,131 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, handler http.Handler) (int, *Report) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	report := &Report{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), report))
+	return recorder.Code, report
+}
+
+func TestChecker_Handler(t *testing.T) {
+	checker := NewChecker(time.Second)
+
+	// not started: alive but not ready
+	code, report := get(t, checker.Handler(true))
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, StatusOk, report.Status)
+	code, report = get(t, checker.Handler(false))
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusFail, report.Status)
+
+	var redisErr error
+	checker.Add("rpc", false, Ping(func(ctx context.Context) error { return nil }))
+	checker.Add("redis", false, Ping(func(ctx context.Context) error { return redisErr }))
+	checker.Add("last_commit", true, MaxAge(time.Now, time.Minute))
+
+	code, report = get(t, checker.Handler(false))
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, report.Checks, 3)
+	require.Equal(t, "rpc", report.Checks[0].Name)
+	require.Equal(t, "last_commit", report.Checks[2].Name)
+
+	redisErr = errors.New("connection refused")
+	code, report = get(t, checker.Handler(false))
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, StatusFail, report.Status)
+	require.Equal(t, StatusOk, report.Checks[0].Status)
+	require.Equal(t, StatusFail, report.Checks[1].Status)
+	require.Equal(t, "connection refused", report.Checks[1].Error)
+
+	// the dependencies are only checked for readiness
+	code, report = get(t, checker.Handler(true))
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, report.Checks, 1)
+	require.Equal(t, "last_commit", report.Checks[0].Name)
+}
+
+func TestChecker_Timeout(t *testing.T) {
+	checker := NewChecker(10 * time.Millisecond)
+	checker.Add("rpc", false, Ping(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+
+	report := checker.Run(context.Background(), false)
+	require.Equal(t, StatusFail, report.Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), report.Checks[0].Error)
+}
+
+func TestMaxLag(t *testing.T) {
+	head := uint64(1000)
+	check := MaxLag(func() uint64 { return head }, func() uint64 { return 900 }, 100)
+
+	detail, err := check(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, &lagDetail{Head: 1000, Committed: 900, Lag: 100, MaxLag: 100}, detail)
+
+	head = 1001
+	_, err = check(context.Background())
+	require.EqualError(t, err, "101 blocks behind the head")
+
+	// the head polled before the commit
+	head = 899
+	_, err = check(context.Background())
+	require.NoError(t, err)
+}
+
+func TestMaxAge(t *testing.T) {
+	at := time.Now().Add(-2 * time.Minute)
+	_, err := MaxAge(func() time.Time { return at }, time.Minute)(context.Background())
+	require.EqualError(t, err, "2m0s old")
+
+	_, err = MaxAge(func() time.Time { return at }, 3*time.Minute)(context.Background())
+	require.NoError(t, err)
+}
+
+func TestNoRecentError(t *testing.T) {
+	var (
+		at      time.Time
+		lastErr error
+	)
+	check := NoRecentError(func() (time.Time, error) { return at, lastErr }, time.Minute)
+
+	_, err := check(context.Background())
+	require.NoError(t, err)
+
+	at, lastErr = time.Now().Add(-2*time.Minute), errors.New("broker down")
+	_, err = check(context.Background())
+	require.NoError(t, err)
+
+	at = time.Now()
+	detail, err := check(context.Background())
+	require.Error(t, err)
+	require.Equal(t, "broker down", detail.(*errorDetail).Error)
+}
+
+func TestNoGap(t *testing.T) {
+	var gaps []string
+	check := NoGap(func() []string { return gaps })
+
+	_, err := check(context.Background())
+	require.NoError(t, err)
+
+	gaps = []string{"100-102"}
+	detail, err := check(context.Background())
+	require.EqualError(t, err, "1 gaps skipped")
+	require.Equal(t, gaps, detail)
+}
@@ -1,64 +1,183 @@
 package main
 
 import (
-	"bxs/block_getter"
-	"bxs/cache"
+	"bxs/abi/registry"
+	"bxs/archive"
 	"bxs/chain_params"
 	"bxs/config"
+	"bxs/health"
 	"bxs/logger"
 	"bxs/metrics"
-	"bxs/parser"
 	"bxs/repository"
-	"bxs/sequencer"
 	"bxs/service"
-	"bxs/types"
+	"bxs/tracing"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 )
 
-func createDBService() service.DBService {
+type repositories struct {
+	txDb         *gorm.DB
+	tokenPairDb  *gorm.DB
+	token        *repository.TokenRepository
+	pair         *repository.PairRepository
+	tx           *repository.TxRepository
+	action       *repository.ActionRepository
+	failedSwap   *repository.FailedSwapRepository
+	mevEvent     *repository.MevEventRepository
+	bulkLoadConf *config.BulkLoadConf
+}
+
+// createRepositories opens the databases of the indexed chains
+func createRepositories(chainIds []int) *repositories {
 	var (
-		txDb             *gorm.DB
-		txDbErr          error
-		tokenPairDb      *gorm.DB
-		tokenPairDbErr   error
-		tokenRepository  *repository.TokenRepository
-		pairRepository   *repository.PairRepository
-		txRepository     *repository.TxRepository
-		actionRepository *repository.ActionRepository
+		txDb           *gorm.DB
+		txDbErr        error
+		tokenPairDb    *gorm.DB
+		tokenPairDbErr error
+		repos          = &repositories{}
 	)
 
 	if config.G.TxDatabase.Enabled {
-		txDb, txDbErr = gorm.Open(postgres.Open(config.G.TxDatabase.DBDatasource.GetPostgresDsn()))
+		txDb, txDbErr = repository.OpenDB(config.G.TxDatabase)
 		if txDbErr != nil {
 			logger.G.Fatal("failed to connect to tx db", zap.Error(txDbErr))
 		}
 
-		txRepository = repository.NewTxRepository(txDb)
-		actionRepository = repository.NewActionRepository(txDb)
+		if err := repository.BackfillChainId(txDb, chainIds); err != nil {
+			logger.G.Fatal("backfill tx db chain_id err", zap.Error(err))
+		}
+
+		repos.txDb = txDb
+		// COPY is postgres only
+		if config.G.TxDatabase.Driver != repository.DriverSqlite {
+			repos.bulkLoadConf = config.G.TxDatabase.BulkLoad
+		}
 	}
 
 	if config.G.TokenPairDatabase.Enabled {
-		tokenPairDb, tokenPairDbErr = gorm.Open(postgres.Open(config.G.TokenPairDatabase.DBDatasource.GetPostgresDsn()))
-		if tokenPairDbErr != nil {
-			logger.G.Fatal("failed to connect to token_pair db", zap.Error(tokenPairDbErr))
+		if txDb != nil && config.G.TokenPairDatabase.Driver == repository.DriverSqlite &&
+			config.G.TxDatabase.Driver == repository.DriverSqlite &&
+			config.G.TokenPairDatabase.SqlitePath == config.G.TxDatabase.SqlitePath {
+			// share the single sqlite writer connection
+			tokenPairDb = txDb
+		} else {
+			tokenPairDb, tokenPairDbErr = repository.OpenDB(config.G.TokenPairDatabase)
+			if tokenPairDbErr != nil {
+				logger.G.Fatal("failed to connect to token_pair db", zap.Error(tokenPairDbErr))
+			}
 		}
 
-		tokenRepository = repository.NewTokenRepository(tokenPairDb)
-		pairRepository = repository.NewPairRepository(tokenPairDb)
+		repos.tokenPairDb = tokenPairDb
+	}
+
+	return repos
+}
+
+// forChain returns the repositories scoped to the chain
+func (r *repositories) forChain(chainId int) *repositories {
+	repos := *r
+	if r.txDb != nil {
+		repos.tx = repository.NewTxRepository(r.txDb, chainId)
+		repos.action = repository.NewActionRepository(r.txDb, chainId)
+		repos.failedSwap = repository.NewFailedSwapRepository(r.txDb, chainId)
+		repos.mevEvent = repository.NewMevEventRepository(r.txDb, chainId)
+	}
+	if r.tokenPairDb != nil {
+		repos.token = repository.NewTokenRepository(r.tokenPairDb, chainId)
+		repos.pair = repository.NewPairRepository(r.tokenPairDb, chainId)
+	}
+	return &repos
+}
+
+func pingDB(db *gorm.DB) health.Check {
+	return health.Ping(func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	})
+}
+
+// addHealthChecks adds the checks of the databases and the kafka producer, shared by the chains
+func addHealthChecks(checker *health.Checker, repos *repositories, kafkaSender service.KafkaSender) {
+	if repos.txDb != nil {
+		checker.Add("tx_db", false, pingDB(repos.txDb))
+	}
+	if repos.tokenPairDb != nil && repos.tokenPairDb != repos.txDb {
+		checker.Add("token_pair_db", false, pingDB(repos.tokenPairDb))
+	}
+	if config.G.Kafka.Enabled {
+		window := time.Duration(config.G.Health.KafkaErrorWindowSec) * time.Second
+		checker.Add("kafka", true, health.NoRecentError(kafkaSender.LastError, window))
+	}
+}
+
+func createDBService(chain string, repos *repositories) service.DBService {
+	return service.NewDBService(chain, repos.token, repos.pair, repos.tx, repos.action, repos.failedSwap, repos.mevEvent, repos.bulkLoadConf)
+}
+
+// parseBlockRange parses "from-to"
+func parseBlockRange(s string) (uint64, uint64, error) {
+	fromStr, toStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("block range %s is not from-to", s)
+	}
+
+	from, err := strconv.ParseUint(fromStr, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := strconv.ParseUint(toStr, 10, 64)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return service.NewDBService(tokenRepository, pairRepository, txRepository, actionRepository)
+	if from > to {
+		return 0, 0, fmt.Errorf("block range %s is empty", s)
+	}
+	return from, to, nil
+}
+
+func runArchiveCommand(a archive.Archive, verifyRange, pruneRange string) {
+	if verifyRange != "" {
+		from, to, rangeErr := parseBlockRange(verifyRange)
+		if rangeErr != nil {
+			logger.G.Fatal("archive verify range err", zap.Error(rangeErr))
+		}
+
+		bad, verifyErr := a.Verify(from, to)
+		if verifyErr != nil {
+			logger.G.Fatal("archive verify err", zap.Error(verifyErr))
+		}
+		if len(bad) > 0 {
+			logger.G.Fatal("archive verify failed", zap.Uint64s("blocks", bad))
+		}
+		logger.G.Info("archive verify ok", zap.Uint64("from", from), zap.Uint64("to", to))
+	}
+
+	if pruneRange != "" {
+		from, to, rangeErr := parseBlockRange(pruneRange)
+		if rangeErr != nil {
+			logger.G.Fatal("archive prune range err", zap.Error(rangeErr))
+		}
+
+		cnt, pruneErr := a.Prune(from, to)
+		if pruneErr != nil {
+			logger.G.Fatal("archive prune err", zap.Error(pruneErr))
+		}
+		logger.G.Info("archive prune finish", zap.Uint64("from", from), zap.Uint64("to", to), zap.Int("blocks", cnt))
+	}
 }
 
 func main() {
@@ -68,6 +187,14 @@ func main() {
 	flag.BoolVar(&showVersion, "v", false, "show version information")
 	var configFile string
 	flag.StringVar(&configFile, "c", "config.json", "config file")
+	var warmupOnly bool
+	flag.BoolVar(&warmupOnly, "warmup", false, "rebuild the cache from the token_pair database and exit")
+	var archiveVerify string
+	flag.StringVar(&archiveVerify, "archive-verify", "", "check the archived blocks in the range from-to and exit")
+	var archivePrune string
+	flag.StringVar(&archivePrune, "archive-prune", "", "remove the archived blocks in the range from-to and exit")
+	var chainName string
+	flag.StringVar(&chainName, "chain", "", "only index the configured chain of that name, all of them when empty")
 	flag.Parse()
 
 	if showVersion {
@@ -82,83 +209,153 @@ func main() {
 		logger.G.Fatal("load config file err", zap.Error(loadConfigErr))
 	}
 
-	chain_params.LoadNetwork(config.G.TestNet, config.G.XLaunchFactoryAddress)
 	logger.InitLogger()
-	metrics.Init(config.G.MetricsPort)
+	if config.G.AbiRegistry.Dir != "" {
+		kinds, loadAbiErr := registry.G.LoadDir(config.G.AbiRegistry.Dir)
+		if loadAbiErr != nil {
+			logger.G.Fatal("load abi registry err", zap.Error(loadAbiErr))
+		}
+		logger.G.Info("abi registry loaded", zap.Strings("kinds", kinds))
+		for _, kind := range kinds {
+			if !registry.BuiltIn(kind) {
+				logger.G.Warn("abi registry kind not used by any call or parser", zap.String("kind", kind))
+			}
+		}
+	}
 
-	wsEthClient, err := ethclient.Dial(config.G.Chain.WsEndpoint)
+	chains, err := chain_params.Load(&config.G)
 	if err != nil {
-		logger.G.Fatal("Failed to connect to the chain(ws): %v", zap.Error(err))
+		logger.G.Fatal("load chains err", zap.Error(err))
 	}
-
-	ethClientArchive, dialEthErrArchive := ethclient.Dial(config.G.Chain.EndpointArchive)
-	if dialEthErrArchive != nil {
-		logger.G.Fatal("Failed to connect to the bsc archive(http): %v", zap.Error(dialEthErrArchive))
+	metrics.Init(config.G.MetricsPort)
+	healthChecker := health.NewChecker(time.Duration(config.G.Health.TimeoutMs) * time.Millisecond)
+	health.Init(healthChecker)
+	shutdownTracing, err := tracing.Init(config.G.Tracing, GetVersion().Version)
+	if err != nil {
+		logger.G.Fatal("init tracing err", zap.Error(err))
 	}
+	defer func() {
+		// the blocks committed last are still batched in the exporter
+		flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelFlush()
+		if shutdownErr := shutdownTracing(flushCtx); shutdownErr != nil {
+			logger.G.Error("shutdown tracing err", zap.Error(shutdownErr))
+		}
+	}()
 
-	contractCallerArchive := service.NewContractCaller(ethClientArchive, config.G.ContractCaller.Retry.GetRetryParams())
+	pipelines := make([]*pipeline, 0, len(chains))
+	for _, chain := range chains {
+		if chainName == "" || chain.Name == chainName {
+			pipelines = append(pipelines, newPipeline(chain, len(chains) > 1))
+		}
+	}
+	if len(pipelines) == 0 {
+		logger.G.Fatal("chain not configured", zap.String("chain", chainName))
+	}
 
-	redisCli := redis.NewClient(&redis.Options{
-		Addr:     config.G.Redis.Addr,
-		Username: config.G.Redis.Username,
-		Password: config.G.Redis.Password,
-	})
-	cache := cache.NewTwoTierCache(redisCli)
+	if archiveVerify != "" || archivePrune != "" {
+		if len(pipelines) > 1 {
+			logger.G.Fatal("the archive commands need a single chain, select it with -chain")
+		}
+		runArchiveCommand(pipelines[0].openArchive(), archiveVerify, archivePrune)
+		logger.G.Sync()
+		return
+	}
 
-	priceService := service.NewPriceService(config.G.PriceService.FromChain, cache, contractCallerArchive, ethClientArchive, config.G.PriceService.PoolSize)
+	chainIds := make([]int, 0, len(pipelines))
+	for _, p := range pipelines {
+		chainIds = append(chainIds, p.chain.ChainID)
+	}
+	repos := createRepositories(chainIds)
+	for _, p := range pipelines {
+		p.cache = p.createCache()
+		if warmupOnly || config.G.CacheWarmup.Enabled {
+			p.warmup(repos.forChain(p.chain.ChainID))
+		}
+	}
 
-	sequencerForBlockHandler := sequencer.NewSequencer()
+	if warmupOnly {
+		for _, p := range pipelines {
+			p.close()
+		}
+		logger.G.Sync()
+		return
+	}
 
-	topicRouter := parser.NewTopicRouter()
-	kafkaSender := service.NewKafkaSender(config.G.Kafka)
+	// ctx is the root of every component, it is only cancelled when the shutdown timeout passes:
+	// a signal first stops the dispatch and lets the in flight blocks commit
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	blockParser := parser.NewBlockParser(
-		cache,
-		sequencerForBlockHandler,
-		priceService,
-		topicRouter,
-		kafkaSender,
-		createDBService(),
-	)
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	blockParser.Start(wg)
+	kafkaSender := service.NewKafkaSender(ctx, config.G.Kafka)
+	clickHouseWriter := service.NewClickHouseWriter(ctx, config.G.ClickHouse)
+	for _, p := range pipelines {
+		p.start(ctx, repos.forChain(p.chain.ChainID), kafkaSender, clickHouseWriter)
+	}
 
-	sequencerForBlockGetter := sequencer.NewSequencer()
-	blockGetter := block_getter.NewBlockGetter(config.G.BlockGetter.SubHeader, wsEthClient, cache, sequencerForBlockGetter, config.G.BlockGetter.Retry.GetRetryParams())
-	startBlockNumber := blockGetter.GetStartBlockNumber(config.G.BlockGetter.StartBlockNumber)
-	if startBlockNumber == 0 {
-		logger.G.Fatal("start block number is zero")
+	addHealthChecks(healthChecker, repos, kafkaSender)
+	for _, p := range pipelines {
+		p.addHealthChecks(healthChecker, config.G.Health)
 	}
 
-	sequencerForBlockGetter.Init(startBlockNumber)
-	sequencerForBlockHandler.Init(startBlockNumber)
+	sigCtx, stopSignal := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignal()
+	go func() {
+		<-sigCtx.Done()
+		if ctx.Err() != nil {
+			return
+		}
+
+		shutdownTimeout := time.Duration(config.G.ShutdownTimeoutSec) * time.Second
+		logger.G.Info("receive signal, wait the in flight blocks", zap.Duration("timeout", shutdownTimeout))
+		for _, p := range pipelines {
+			p.blockGetter.Stop()
+		}
 
-	priceService.Start(startBlockNumber)
-	blockGetter.Start()
-	blockGetter.StartDispatch(startBlockNumber)
+		select {
+		case <-ctx.Done():
+		case <-time.After(shutdownTimeout):
+			logger.G.Warn("shutdown timeout, cancel the in flight blocks")
+			cancel()
+		}
+	}()
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	committed := make(chan struct{})
 	go func() {
-		sig := <-sigChan
-		logger.G.Info("receive signal", zap.String("signal", sig.String()))
-		blockGetter.Stop()
+		wg := &sync.WaitGroup{}
+		for _, p := range pipelines {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				p.run()
+			}()
+		}
+		wg.Wait()
+		close(committed)
 	}()
 
-	var blockCtx *types.BlockContext
-	for {
-		blockCtx = blockGetter.Next()
-		if blockCtx == nil {
-			logger.G.Info("no more block to parse")
-			blockParser.Stop()
-			break
+	timeout := false
+	select {
+	case <-committed:
+		logger.G.Info("all block commited")
+		if err = kafkaSender.Close(); err != nil {
+			logger.G.Error("close kafka sender err", zap.Error(err))
 		}
-		blockParser.ParseBlockAsync(blockCtx)
+	case <-ctx.Done():
+		// the pipelines wait for the cancelled blocks forever, and may still be sending to kafka
+		timeout = true
 	}
 
-	logger.G.Info("wait all block commited")
-	wg.Wait()
-	logger.G.Info("all block commited")
+	for _, p := range pipelines {
+		p.reportUncommitted()
+	}
+	cancel()
+	for _, p := range pipelines {
+		p.close()
+	}
 	logger.G.Sync()
+
+	if timeout {
+		os.Exit(1)
+	}
 }
@@ -5,78 +5,225 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
-	"time"
 )
 
 var (
-	defaultMaxAge     = time.Second * 10
-	defaultAgeBuckets = uint32(100)
-	defaultObjectives = map[float64]float64{
-		0.99: 0.01,
-	}
+	// durationMsBuckets spans 1ms to 32s
+	durationMsBuckets = prometheus.ExponentialBuckets(1, 2, 16)
+	// blockDelayBuckets spans 0.5s to 17min, the delay of a block behind its chain time
+	blockDelayBuckets = prometheus.ExponentialBuckets(0.5, 2, 12)
 )
 
+/*
+The per chain series have a chain label, the name of the chain in the chains config.
+
+For the dashboards, the series replaced when the histograms and labeled counters were introduced:
+  - tx_cnt_by_block: the block_txs histogram, or rate(txs_total) for the txs per second
+  - block_queue_size: queue_depth{stage="block_getter_output"}, the other stages are new
+  - the quantiles of the *_duration_ms and block_delay summaries: histogram_quantile over their _bucket series
+  - txs_total, block_txs, bulk_load_* and cache_*: the chain label was added, sum without(chain) for the totals over the chains
+*/
 var (
-	CurrentHeight = prometheus.NewGauge(prometheus.GaugeOpts{Name: "current_height"})
-	NewestHeight  = prometheus.NewGauge(prometheus.GaugeOpts{Name: "newest_height"})
-	TxCntByBlock  = prometheus.NewGauge(prometheus.GaugeOpts{Name: "tx_cnt_by_block"})
+	CurrentHeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "current_height", Help: "the last committed block"}, []string{"chain"})
+	NewestHeight  = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "newest_height", Help: "the chain head"}, []string{"chain"})
+	BlockLag      = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "block_lag", Help: "blocks between the chain head and the committed block"}, []string{"chain"})
+
+	TxsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "txs_total"}, []string{"chain"})
+	BlockTxs = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "block_txs",
+		Help:    "txs committed by block",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"chain"})
+
+	EventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_total",
+			Help: "events resolved by protocol and event: created, buy, sell, pair_created, swap or sync",
+		},
+		[]string{"chain", "protocol", "event"},
+	)
+
+	DroppedEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dropped_events_total",
+			Help: "events dropped by reason: parse_error, wrong_emitter, not_wrapped_native, pair_not_cached, filtered or no_xlaunch_token",
+		},
+		[]string{"chain", "reason"},
+	)
 
-	GetBlockDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "get_block_duration_ms",
-		Help:       "get_block duration in Milliseconds",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	QueueDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "queue_depth",
+			Help: "blocks waiting in the queue of a pipeline stage",
+		},
+		[]string{"chain", "stage"},
+	)
+
+	GetBlockDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "get_block_duration_ms",
+		Help:    "get_block duration in Milliseconds",
+		Buckets: durationMsBuckets,
 	})
 
-	GetBlockReceiptsDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "get_block_receipts_duration_ms",
-		Help:       "get block receipts duration in Milliseconds",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	GetBlockReceiptsDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "get_block_receipts_duration_ms",
+		Help:    "get block receipts duration in Milliseconds",
+		Buckets: durationMsBuckets,
 	})
 
-	BlockDelay = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "block_delay",
-		Help:       "block delay in Seconds",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	GetLogsDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "get_logs_duration_ms",
+		Help:    "get logs of a block range duration in Milliseconds",
+		Buckets: durationMsBuckets,
 	})
 
-	BlockQueueSize = prometheus.NewGauge(prometheus.GaugeOpts{Name: "block_queue_size"})
+	BlockDelay = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "block_delay",
+		Help:    "block delay in Seconds",
+		Buckets: blockDelayBuckets,
+	})
 
-	ParseBlockDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "parse_block_duration_ms",
-		Help:       "parse block duration in Milliseconds",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	ParseBlockDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "parse_block_duration_ms",
+		Help:    "parse block duration in Milliseconds",
+		Buckets: durationMsBuckets,
 	})
 
-	DbOperationDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "db_operation_duration_ms",
-		Help:       "db operation duration in Milliseconds",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	ResolveBlockDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "resolve_block_duration_ms",
+		Help:    "resolve block events against the cache duration in Milliseconds",
+		Buckets: durationMsBuckets,
 	})
 
-	SendBlockKafkaDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "send_block_kafka_duration_ms",
-		Help:       "send block kafka duration in Milliseconds",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	DbOperationDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "db_operation_duration_ms",
+		Help:    "db operation duration in Milliseconds",
+		Buckets: durationMsBuckets,
 	})
 
-	CallContractDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "call_contract_duration_ms",
-		Help:       "call contract duration in Milliseconds",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	BulkLoadActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "bulk_load_active"}, []string{"chain"})
+
+	BulkLoadRows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "bulk_load_rows_total",
+		},
+		[]string{"chain", "table"},
+	)
+
+	BulkLoadRowsPerSecond = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "bulk_load_rows_per_second",
+		},
+		[]string{"chain", "table"},
+	)
+
+	BulkLoadDurationMs = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bulk_load_duration_ms",
+		Help:    "bulk load(COPY + merge) duration in Milliseconds",
+		Buckets: durationMsBuckets,
+	}, []string{"chain"})
+
+	SendBlockKafkaDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "send_block_kafka_duration_ms",
+		Help:    "send block kafka duration in Milliseconds",
+		Buckets: durationMsBuckets,
+	})
+
+	ClickHouseInsertRows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clickhouse_insert_rows_total",
+		},
+		[]string{"table"},
+	)
+
+	ClickHouseInsertErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clickhouse_insert_errors_total",
+		},
+		[]string{"table"},
+	)
+
+	ClickHouseDroppedRows = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clickhouse_dropped_rows_total",
+			Help: "rows not buffered as the clickhouse buffer is full",
+		},
+		[]string{"table"},
+	)
+
+	ClickHouseInsertDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "clickhouse_insert_duration_ms",
+		Help:    "clickhouse insert duration in Milliseconds",
+		Buckets: durationMsBuckets,
+	})
+
+	CacheHits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_hits_total",
+		},
+		[]string{"chain", "kind"},
+	)
+
+	CacheMisses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_misses_total",
+		},
+		[]string{"chain", "kind"},
+	)
+
+	CacheEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cache_evictions_total",
+		},
+		[]string{"chain", "kind", "reason"},
+	)
+
+	CacheSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cache_size",
+		},
+		[]string{"chain", "kind"},
+	)
+
+	SequencerBuffered = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sequencer_buffered",
+		},
+		[]string{"name"},
+	)
+
+	SequencerGapAgeSec = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sequencer_gap_age_sec",
+		},
+		[]string{"name"},
+	)
+
+	SequencerGaps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sequencer_gaps_total",
+		},
+		[]string{"name", "policy"},
+	)
+
+	SequencerSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sequencer_skipped_total",
+			Help: "sequences skipped by the skip gap policy, never committed unless backfilled",
+		},
+		[]string{"name"},
+	)
+
+	CallContractDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "call_contract_duration_ms",
+		Help:    "call contract duration in Milliseconds",
+		Buckets: durationMsBuckets,
+	})
+
+	MulticallBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "multicall_batch_size",
+		Help:    "calls coalesced in a multicall batch",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 8),
 	})
 
 	CallContractErrors = prometheus.NewCounterVec(
@@ -86,25 +233,19 @@ var (
 		[]string{"is_retryable"},
 	)
 
-	GetPairDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "get_pair_duration_ms",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	GetPairDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "get_pair_duration_ms",
+		Buckets: durationMsBuckets,
 	})
 
-	GetTokenDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "get_token_duration_ms",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	GetTokenDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "get_token_duration_ms",
+		Buckets: durationMsBuckets,
 	})
 
-	VerifyPairDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "verify_pair_duration_ms",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	VerifyPairDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "verify_pair_duration_ms",
+		Buckets: durationMsBuckets,
 	})
 
 	VerifyPairTotal = prometheus.NewCounterVec(
@@ -121,12 +262,18 @@ var (
 		[]string{"protocol"},
 	)
 
+	TokenMetadataTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "token_metadata_total",
+			Help: "token metadata enrichments by result: ok, retry, failed or dropped",
+		},
+		[]string{"result"},
+	)
+
 	Price              = prometheus.NewGauge(prometheus.GaugeOpts{Name: "price"})
-	GetPriceDurationMs = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "get_price_duration_ms",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	GetPriceDurationMs = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "get_price_duration_ms",
+		Buckets: durationMsBuckets,
 	})
 
 	GetPriceResult = prometheus.NewCounterVec(
@@ -136,36 +283,60 @@ var (
 		[]string{"result"},
 	)
 
-	CallContractForBNBPrice = prometheus.NewSummary(prometheus.SummaryOpts{
-		Name:       "call_contract_bnb_price",
-		MaxAge:     defaultMaxAge,
-		AgeBuckets: defaultAgeBuckets,
-		Objectives: defaultObjectives,
+	CallContractForBNBPrice = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "call_contract_bnb_price",
+		Buckets: prometheus.DefBuckets,
 	})
 )
 
 func init() {
 	prometheus.MustRegister(CurrentHeight)
 	prometheus.MustRegister(NewestHeight)
-	prometheus.MustRegister(TxCntByBlock)
+	prometheus.MustRegister(BlockLag)
+	prometheus.MustRegister(TxsTotal)
+	prometheus.MustRegister(BlockTxs)
+	prometheus.MustRegister(EventsTotal)
+	prometheus.MustRegister(DroppedEventsTotal)
+	prometheus.MustRegister(QueueDepth)
 
 	prometheus.MustRegister(GetBlockDurationMs)
 	prometheus.MustRegister(GetBlockReceiptsDurationMs)
+	prometheus.MustRegister(GetLogsDurationMs)
 	prometheus.MustRegister(BlockDelay)
-	prometheus.MustRegister(BlockQueueSize)
 
 	prometheus.MustRegister(ParseBlockDurationMs)
+	prometheus.MustRegister(ResolveBlockDurationMs)
 	prometheus.MustRegister(DbOperationDurationMs)
+	prometheus.MustRegister(BulkLoadActive)
+	prometheus.MustRegister(BulkLoadRows)
+	prometheus.MustRegister(BulkLoadRowsPerSecond)
+	prometheus.MustRegister(BulkLoadDurationMs)
 	prometheus.MustRegister(SendBlockKafkaDurationMs)
+	prometheus.MustRegister(ClickHouseInsertRows)
+	prometheus.MustRegister(ClickHouseInsertErrors)
+	prometheus.MustRegister(ClickHouseDroppedRows)
+	prometheus.MustRegister(ClickHouseInsertDurationMs)
+
+	prometheus.MustRegister(CacheHits)
+	prometheus.MustRegister(CacheMisses)
+	prometheus.MustRegister(CacheEvictions)
+	prometheus.MustRegister(CacheSize)
+
+	prometheus.MustRegister(SequencerBuffered)
+	prometheus.MustRegister(SequencerGapAgeSec)
+	prometheus.MustRegister(SequencerGaps)
+	prometheus.MustRegister(SequencerSkipped)
 
 	prometheus.MustRegister(CallContractDurationMs)
 	prometheus.MustRegister(CallContractErrors)
+	prometheus.MustRegister(MulticallBatchSize)
 	prometheus.MustRegister(GetPairDurationMs)
 	prometheus.MustRegister(GetTokenDurationMs)
 
 	prometheus.MustRegister(VerifyPairDurationMs)
 	prometheus.MustRegister(VerifyPairTotal)
 	prometheus.MustRegister(VerifyPairOkByProtocol)
+	prometheus.MustRegister(TokenMetadataTotal)
 
 	prometheus.MustRegister(Price)
 	prometheus.MustRegister(GetPriceDurationMs)
@@ -6,16 +6,25 @@ import (
 	"bxs/config"
 	"bxs/logger"
 	"bxs/metrics"
+	"bxs/repository/orm"
 	"bxs/sequencer"
 	"bxs/service"
+	"bxs/tracing"
 	"bxs/types"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ethereum/go-ethereum/common"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/panjf2000/ants/v2"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"math/big"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,47 +32,122 @@ type BlockParser interface {
 	Start(*sync.WaitGroup)
 	Stop()
 	ParseBlockAsync(bw *types.BlockContext)
+	// Committed returns the last block committed, 0 before the first one
+	Committed() uint64
+	// LastCommit returns when the last block was committed, when the parser was created before the first one
+	LastCommit() time.Time
 }
 
+/*
+blockParser parses blocks in two phases:
+  - parse: native token price, tx senders and log decoding, they do not touch the pair/token cache,
+    so PoolSize blocks are parsed at once and the receipts of a block are spread over ParseTxPoolSize goroutines
+  - resolve: events are resolved against the cache and the tx results built, in block order,
+    as a pair can be created and traded in the same or the next block. It runs in Commit, which the sequencer calls in order
+*/
 type blockParser struct {
-	cache        cache.Cache
-	sequencer    sequencer.Sequencer
-	priceService service.PriceService
-	topicRouter  TopicRouter
-	kafkaSender  service.KafkaSender
-	dbService    service.DBService
-	inputQueue   chan *types.BlockContext
-	outputQueue  chan *types.BlockContext
+	ctx              context.Context
+	chain            *chain_params.ChainParams
+	cache            cache.Cache
+	sequencer        sequencer.Sequencer
+	priceService     service.PriceService
+	topicRouter      TopicRouter
+	kafkaSender      service.KafkaSender
+	dbService        service.DBService
+	clickHouseWriter service.ClickHouseWriter
+	tokenSecurity    service.TokenSecurityService // nil when disabled
+	tokenMetadata    service.TokenMetadataService // nil when disabled
+	supplyRefresher  service.SupplyRefresher      // nil when disabled
+	launchDetector   *launchDetector              // nil when disabled
+	mevEnabled       bool
+	pairReserves     bool
+	failedSwaps      *failedSwapDecoder // nil when disabled
+	workPool         *ants.Pool
+	parseTxPoolSize  int
+	inputQueue       chan *types.BlockContext
+	outputQueue      chan *types.BlockContext
+	committed        atomic.Uint64
+	lastCommit       atomic.Int64 // unix nano
 }
 
 func NewBlockParser(
+	ctx context.Context,
+	chain *chain_params.ChainParams,
 	cache cache.Cache,
 	sequencer sequencer.Sequencer,
 	priceService service.PriceService,
+	contractCaller *service.ContractCaller,
 	topicRouter TopicRouter,
 	kafkaSender service.KafkaSender,
 	dbService service.DBService,
+	clickHouseWriter service.ClickHouseWriter,
+	tokenSecurity service.TokenSecurityService,
+	tokenMetadata service.TokenMetadataService,
+	supplyRefresher service.SupplyRefresher,
 ) BlockParser {
-	return &blockParser{
-		cache:        cache,
-		sequencer:    sequencer,
-		priceService: priceService,
-		topicRouter:  topicRouter,
-		kafkaSender:  kafkaSender,
-		dbService:    dbService,
-		inputQueue:   make(chan *types.BlockContext, config.G.BlockHandler.QueueSize),
-		outputQueue:  make(chan *types.BlockContext, config.G.BlockHandler.QueueSize),
+	poolSize := config.G.BlockHandler.PoolSize
+	if poolSize > 1 && !config.G.EnableSequencer {
+		logger.G.Warn("parse blocks one by one, parallel parsing needs the sequencer", zap.Int("pool size", poolSize))
+		poolSize = 1
 	}
+
+	workPool, err := ants.NewPool(max(poolSize, 1))
+	if err != nil {
+		logger.G.Fatal("ants pool(BlockParser) init err", zap.Error(err))
+	}
+
+	var detector *launchDetector
+	if config.G.LaunchDetector.Enabled {
+		detector = newLaunchDetector(config.G.LaunchDetector, cache)
+	}
+
+	var failedSwaps *failedSwapDecoder
+	if config.G.FailedSwaps.Enabled {
+		var reasoner revertReasoner
+		if config.G.FailedSwaps.RevertReason && contractCaller != nil {
+			reasoner = contractCaller
+		}
+		failedSwaps = newFailedSwapDecoder(chain, cache, reasoner)
+	}
+
+	p := &blockParser{
+		ctx:              ctx,
+		chain:            chain,
+		cache:            cache,
+		sequencer:        sequencer,
+		priceService:     priceService,
+		topicRouter:      topicRouter,
+		kafkaSender:      kafkaSender,
+		dbService:        dbService,
+		clickHouseWriter: clickHouseWriter,
+		tokenSecurity:    tokenSecurity,
+		tokenMetadata:    tokenMetadata,
+		supplyRefresher:  supplyRefresher,
+		pairReserves:     config.G.StateRefresh.PairReserves,
+		launchDetector:   detector,
+		mevEnabled:       config.G.MevAnalyzer.Enabled,
+		failedSwaps:      failedSwaps,
+		workPool:         workPool,
+		parseTxPoolSize:  config.G.BlockHandler.ParseTxPoolSize,
+		inputQueue:       make(chan *types.BlockContext, config.G.BlockHandler.QueueSize),
+		outputQueue:      make(chan *types.BlockContext, config.G.BlockHandler.QueueSize),
+	}
+	p.lastCommit.Store(time.Now().UnixNano())
+	return p
 }
 
 func (p *blockParser) Commit(x sequencer.Sequenceable) {
-	p.outputQueue <- x.(*types.BlockContext)
+	bc := x.(*types.BlockContext)
+	p.resolveBlock(bc)
+	p.outputQueue <- bc
+	metrics.QueueDepth.WithLabelValues(p.chain.Name, "block_parser_output").Set(float64(len(p.outputQueue)))
 }
 
 func (p *blockParser) Start(waitGroup *sync.WaitGroup) {
 	p.startCommitBlockResult(waitGroup)
 
 	go func() {
+		wg := &sync.WaitGroup{}
 	For:
 		for {
 			select {
@@ -72,37 +156,78 @@ func (p *blockParser) Start(waitGroup *sync.WaitGroup) {
 					logger.G.Info("block parser inputQueue is closed")
 					break For
 				}
-				p.parseBlock(bc)
+				metrics.QueueDepth.WithLabelValues(p.chain.Name, "block_parser_input").Set(float64(len(p.inputQueue)))
+
+				wg.Add(1)
+				p.workPool.Submit(func() {
+					defer wg.Done()
+					p.parseBlock(bc)
+				})
 			}
 		}
 
+		wg.Wait()
 		logger.G.Info("all block parse task finish")
 		p.doStop()
 	}()
 }
 
+// forEach calls fn for 0..n-1 on up to parseTxPoolSize goroutines, or one by one when it is not above 1
+func (p *blockParser) forEach(n int, fn func(i int)) {
+	if p.parseTxPoolSize <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	g := &errgroup.Group{}
+	g.SetLimit(p.parseTxPoolSize)
+	for i := 0; i < n; i++ {
+		g.Go(func() error {
+			fn(i)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
 func (p *blockParser) Stop() {
 	close(p.inputQueue)
 }
 
 func (p *blockParser) ParseBlockAsync(bc *types.BlockContext) {
 	p.inputQueue <- bc
+	metrics.QueueDepth.WithLabelValues(p.chain.Name, "block_parser_input").Set(float64(len(p.inputQueue)))
+}
+
+func (p *blockParser) Committed() uint64 {
+	return p.committed.Load()
 }
 
-func (p *blockParser) getNativeTokenPrice(blockNumber *big.Int, blockTimestamp uint64) decimal.Decimal {
+func (p *blockParser) LastCommit() time.Time {
+	return time.Unix(0, p.lastCommit.Load())
+}
+
+// getNativeTokenPrice retries until it gets the price, or the ctx is done
+func (p *blockParser) getNativeTokenPrice(ctx context.Context, blockNumber *big.Int, blockTimestamp uint64) (decimal.Decimal, error) {
 	for {
-		price, err := p.priceService.GetPrice(blockNumber)
-		if err != nil {
-			logger.G.Error("get price err", zap.Error(err), zap.Any("blockNumber", blockNumber), zap.Any("blockTimestamp", blockTimestamp))
-			time.Sleep(time.Second)
-			continue
+		price, err := p.priceService.GetPrice(ctx, blockNumber)
+		if err == nil {
+			return price, nil
+		}
+
+		logger.G.Error("get price err", zap.Error(err), zap.Any("blockNumber", blockNumber), zap.Any("blockTimestamp", blockTimestamp))
+		select {
+		case <-p.ctx.Done():
+			return types.ZeroDecimal, p.ctx.Err()
+		case <-time.After(time.Second):
 		}
-		return price
 	}
 }
 
-func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.Receipt) *types.TxResult {
-	tr := bc.NewTxResult(receipt.TransactionIndex)
+func (p *blockParser) decodeTxReceipt(receipt *ethtypes.Receipt) []types.Event {
+	var events []types.Event
 	for _, log := range receipt.Logs {
 		if len(log.Topics) == 0 {
 			continue
@@ -110,9 +235,20 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 
 		event, err := p.topicRouter.Route(log)
 		if err != nil {
+			if reason := dropReason(err); reason != "" {
+				metrics.DroppedEventsTotal.WithLabelValues(p.chain.Name, reason).Inc()
+			}
 			continue
 		}
+		events = append(events, event)
+	}
+	return events
+}
 
+func (p *blockParser) resolveTxReceipt(bc *types.BlockContext, receipt *ethtypes.Receipt, events []types.Event) *types.TxResult {
+	tr := bc.NewTxResult(receipt.TransactionIndex)
+	tr.Traders = newTraderResolver(p.chain, tr.Sender, receipt)
+	for _, event := range events {
 		bc.DecorateEvent(event)
 
 		if event.IsCreated() { // xLaunch event: created
@@ -123,6 +259,7 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 			tr.AddPair(pair)
 			tr.AddToken(token0)
 			tr.AddPoolUpdate(event.GetPoolUpdate())
+			metrics.EventsTotal.WithLabelValues(p.chain.Name, "xlaunch", "created").Inc()
 			continue
 		}
 
@@ -134,16 +271,18 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 				pair.Filtered = true
 				pair.FilterCode = types.FilterCodeNoXLaunchToken
 				p.cache.SetPair(pair)
+				metrics.DroppedEventsTotal.WithLabelValues(p.chain.Name, "no_xlaunch_token").Inc()
 				continue
 			}
 
 			pair.Token0.Symbol = token0.Symbol
 			pair.Token0.Decimal = token0.Decimals
-			pair.Token1.Symbol = types.WBNBSymbol
-			pair.Token1.Decimal = types.WBNBDecimal
+			pair.Token1.Symbol = p.chain.WrappedNativeSymbol
+			pair.Token1.Decimal = p.chain.WrappedNativeDecimals
 			p.cache.SetPair(pair)
 			tr.AddPairCreatedEvent(event)
 			tr.AddPair(pair)
+			metrics.EventsTotal.WithLabelValues(p.chain.Name, "pancakev2", "pair_created").Inc()
 			continue
 		}
 
@@ -152,6 +291,7 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 			pair, ok := p.cache.GetPair(pairAddr)
 			if !ok {
 				logger.G.Sugar().Warnf("pool %s not cached", pairAddr)
+				metrics.DroppedEventsTotal.WithLabelValues(p.chain.Name, "pair_not_cached").Inc()
 				continue
 			}
 
@@ -166,6 +306,11 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 			event.SetPair(pair)
 			tr.AddPoolUpdate(event.GetPoolUpdate())
 			tr.AddSwapEvent(event)
+			if event.IsBuy() {
+				metrics.EventsTotal.WithLabelValues(p.chain.Name, "xlaunch", "buy").Inc()
+			} else {
+				metrics.EventsTotal.WithLabelValues(p.chain.Name, "xlaunch", "sell").Inc()
+			}
 			continue
 		}
 
@@ -174,16 +319,19 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 			pair, ok := p.cache.GetPair(pairAddr)
 			if !ok {
 				logger.G.Sugar().Warnf("pair %s not cached, ignore it, tx hash %s", pairAddr, event.GetTxHash())
+				metrics.DroppedEventsTotal.WithLabelValues(p.chain.Name, "pair_not_cached").Inc()
 				continue
 			}
 
 			if pair.Filtered {
 				logger.G.Sugar().Infof("pair %s is filtered, filter code %d, tx hash %s", event.GetPairAddress(), pair.FilterCode, event.GetTxHash())
+				metrics.DroppedEventsTotal.WithLabelValues(p.chain.Name, "filtered").Inc()
 				continue
 			}
 
 			event.SetPair(pair)
 			tr.AddSwapEvent(event)
+			metrics.EventsTotal.WithLabelValues(p.chain.Name, "pancakev2", "swap").Inc()
 			continue
 		}
 
@@ -191,16 +339,19 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 			pair, ok := p.cache.GetPair(event.GetPairAddress())
 			if !ok {
 				logger.G.Sugar().Warnf("pair %s not cached, ignore it, tx hash %s", event.GetPairAddress(), event.GetTxHash())
+				metrics.DroppedEventsTotal.WithLabelValues(p.chain.Name, "pair_not_cached").Inc()
 				continue
 			}
 
 			if pair.Filtered {
 				logger.G.Sugar().Infof("pair %s is filtered, filter code %d, tx hash %s", event.GetPairAddress(), pair.FilterCode, event.GetTxHash())
+				metrics.DroppedEventsTotal.WithLabelValues(p.chain.Name, "filtered").Inc()
 				continue
 			}
 
 			event.SetPair(pair)
 			tr.AddPoolUpdate(event.GetPoolUpdate())
+			metrics.EventsTotal.WithLabelValues(p.chain.Name, "pancakev2", "sync").Inc()
 		}
 	}
 
@@ -210,7 +361,7 @@ func (p *blockParser) parseTxReceipt(bc *types.BlockContext, receipt *ethtypes.R
 
 func (p *blockParser) processTxPairCreatedEvents(tr *types.TxResult) *types.TxResult {
 	for _, event := range tr.PairCreatedEvents {
-		token0 := event.GetNonWBNBToken()
+		token0 := event.GetNonWrappedNativeToken()
 		if p.cache.MigrateTokenExist(token0) {
 			tr.AddAction(event.GetAction())
 			p.cache.DelMigrateToken(token0)
@@ -219,64 +370,181 @@ func (p *blockParser) processTxPairCreatedEvents(tr *types.TxResult) *types.TxRe
 	return tr
 }
 
-func (p *blockParser) preParseBlock(bc *types.BlockContext) {
-	bc.NativeTokenPrice = p.getNativeTokenPrice(bc.HeightTime.HeightBigInt, bc.HeightTime.Timestamp)
-
-	signer := ethtypes.MakeSigner(chain_params.G.ChainConfig, bc.HeightTime.HeightBigInt, bc.HeightTime.Timestamp)
-	for idx, tx := range bc.Transactions {
+func (p *blockParser) preParseBlock(ctx context.Context, bc *types.BlockContext) error {
+	price, err := p.getNativeTokenPrice(ctx, bc.HeightTime.HeightBigInt, bc.HeightTime.Timestamp)
+	if err != nil {
+		return err
+	}
+	bc.NativeTokenPrice = price
+
+	_, span := tracing.Start(ctx, "recover_senders", attribute.Int("txs", len(bc.Transactions)))
+	defer span.End()
+	signer := ethtypes.MakeSigner(p.chain.ChainConfig, bc.HeightTime.HeightBigInt, bc.HeightTime.Timestamp)
+	p.forEach(len(bc.Transactions), func(idx int) {
+		tx := bc.Transactions[idx]
+		if tx == nil { // logs mode only fetches the transactions having a matched log
+			return
+		}
 		sender, err := ethtypes.Sender(signer, tx)
 		if err != nil {
 			logger.G.Sugar().Fatalf("get tx [%v] sender err [%s]", tx, err)
 		}
 		bc.Senders[idx] = sender
+	})
+	return nil
+}
+
+func (p *blockParser) decodeFailedSwap(bc *types.BlockContext, receipt *ethtypes.Receipt) *types.FailedSwap {
+	txIndex := receipt.TransactionIndex
+	if txIndex >= uint(len(bc.Transactions)) || bc.Transactions[txIndex] == nil {
+		return nil
 	}
+	return p.failedSwaps.decode(bc.Transactions[txIndex], bc.Senders[txIndex], receipt, bc.HeightTime.HeightBigInt)
 }
 
-func (p *blockParser) parseBlock(bc *types.BlockContext) {
-	p.preParseBlock(bc)
+// resolveFailedSwaps keeps the failed swaps of the cached pools and tokens, then fetches the revert reasons of the kept ones
+func (p *blockParser) resolveFailedSwaps(bc *types.BlockContext) {
+	resolved := bc.FailedSwaps[:0]
+	for _, fs := range bc.FailedSwaps {
+		if p.failedSwaps.resolve(fs) {
+			resolved = append(resolved, fs)
+		}
+	}
+	bc.FailedSwaps = resolved
 
+	if p.failedSwaps.reasoner == nil {
+		return
+	}
+	p.forEach(len(resolved), func(i int) {
+		fs := resolved[i]
+		p.failedSwaps.setRevertReason(fs, bc.Transactions[fs.BlockIndex], bc.Senders[fs.BlockIndex])
+	})
+}
+
+func (p *blockParser) parseBlock(bc *types.BlockContext) {
 	now := time.Now()
-	for _, receipt := range bc.Receipts {
-		if receipt.Status != 1 {
-			continue
+	ctx, span := tracing.Start(bc.Context(), "parse", attribute.Int("receipts", len(bc.Receipts)))
+	if err := p.preParseBlock(ctx, bc); err != nil {
+		tracing.End(span, err)
+		tracing.End(trace.SpanFromContext(bc.Context()), err)
+		// shutting down, the block is left uncommitted and parsed again on restart
+		logger.G.Warn("parse block abandoned", zap.Uint64("block", bc.HeightTime.Height), zap.Error(err))
+		return
+	}
+
+	bc.Events = make([][]types.Event, len(bc.Receipts))
+	failedSwaps := make([]*types.FailedSwap, len(bc.Receipts))
+	p.forEach(len(bc.Receipts), func(i int) {
+		if bc.Receipts[i].Status != 1 {
+			if p.failedSwaps != nil {
+				failedSwaps[i] = p.decodeFailedSwap(bc, bc.Receipts[i])
+			}
+			return
+		}
+		bc.Events[i] = p.decodeTxReceipt(bc.Receipts[i])
+	})
+	for _, fs := range failedSwaps {
+		if fs != nil {
+			bc.FailedSwaps = append(bc.FailedSwaps, fs)
 		}
-		bc.SetTxResult(receipt.TransactionIndex, p.parseTxReceipt(bc, receipt))
 	}
+	span.End()
 	duration := time.Since(now).Milliseconds()
 	metrics.ParseBlockDurationMs.Observe(float64(duration))
 	logger.G.Info(fmt.Sprintf("parse block %d duration %dms", bc.HeightTime.HeightBigInt, duration))
 
-	p.sequencer.CommitWithSequence(bc, p)
+	if err := p.sequencer.CommitWithSequence(bc, p); err != nil {
+		logger.G.Warn("commit parsed block err", zap.Uint64("block", bc.HeightTime.Height), zap.Error(err))
+	}
+}
+
+func (p *blockParser) resolveBlock(bc *types.BlockContext) {
+	now := time.Now()
+	_, span := tracing.Start(bc.Context(), "resolve")
+	defer span.End()
+	for i, receipt := range bc.Receipts {
+		if receipt.Status != 1 {
+			continue
+		}
+		bc.SetTxResult(receipt.TransactionIndex, p.resolveTxReceipt(bc, receipt, bc.Events[i]))
+	}
+	bc.Events = nil
+	if len(bc.FailedSwaps) > 0 {
+		p.resolveFailedSwaps(bc)
+	}
+	if p.launchDetector != nil {
+		p.launchDetector.Detect(bc)
+	}
+	metrics.ResolveBlockDurationMs.Observe(float64(time.Since(now).Milliseconds()))
 }
 
 func (p *blockParser) commitBlockResult(bc *types.BlockContext) {
-	blockInfo := bc.GetKafkaMsg()
+	blockInfo := bc.GetKafkaMsg(p.chain.ChainID)
+	if p.mevEnabled {
+		// before the txs are stored, it sets their flags
+		blockInfo.MevEvents = analyzeMev(blockInfo.Height, blockInfo.Txs)
+	}
+	p.dbService.UpdateLag(bc.HeadHeight, bc.HeightTime.Height)
+
+	// the block span ends once the block is committed
+	defer trace.SpanFromContext(bc.Context()).End()
+	ctx, span := tracing.Start(bc.Context(), "commit")
+	defer span.End()
 
 	now := time.Now()
 	var err error
 	if len(blockInfo.NewTokens) > 0 {
-		err = p.dbService.AddTokens(blockInfo.NewTokens)
+		err = storeBatch(ctx, "db.add_tokens", len(blockInfo.NewTokens), func() error {
+			return p.dbService.AddTokens(blockInfo.NewTokens)
+		})
 		if err != nil {
 			logger.G.Fatal("add tokens err", zap.Any("height", blockInfo.Height), zap.Error(err))
 		}
+
+		if p.tokenMetadata != nil {
+			// enriched once stored, in the background
+			for _, token := range blockInfo.NewTokens {
+				p.tokenMetadata.Enrich(token)
+			}
+		}
 	}
 
 	if len(blockInfo.NewPairs) > 0 {
-		err = p.dbService.AddPairs(blockInfo.NewPairs)
+		err = storeBatch(ctx, "db.add_pairs", len(blockInfo.NewPairs), func() error {
+			return p.dbService.AddPairs(blockInfo.NewPairs)
+		})
 		if err != nil {
 			logger.G.Fatal("add pairs err", zap.Any("height", blockInfo.Height), zap.Error(err))
 		}
+
+		if p.tokenSecurity != nil {
+			p.watchListedTokens(blockInfo)
+		}
+	}
+
+	if len(blockInfo.PoolUpdates) > 0 {
+		p.refreshPoolState(ctx, blockInfo)
 	}
 
 	if len(blockInfo.Txs) > 0 {
-		err = p.dbService.AddTxs(blockInfo.Txs)
+		err = storeBatch(ctx, "db.add_txs", len(blockInfo.Txs), func() error {
+			return p.dbService.AddTxs(blockInfo.Txs)
+		})
 		if err != nil {
 			logger.G.Fatal("add txs err", zap.Any("height", blockInfo.Height), zap.Error(err))
 		}
 	}
+	// while bulk loading the txs are buffered across blocks, the finished block only advances once they are stored,
+	// a restart parses again the blocks committed since, and sends them to kafka again
+	txsStored, err := p.dbService.FlushTxs(false)
+	if err != nil {
+		logger.G.Fatal("flush txs err", zap.Any("height", blockInfo.Height), zap.Error(err))
+	}
 
 	if len(blockInfo.Actions) > 0 {
-		err = p.dbService.AddActions(blockInfo.Actions)
+		err = storeBatch(ctx, "db.add_actions", len(blockInfo.Actions), func() error {
+			return p.dbService.AddActions(blockInfo.Actions)
+		})
 		if err != nil {
 			logger.G.Fatal("add actions err", zap.Any("height", blockInfo.Height), zap.Error(err))
 		}
@@ -288,18 +556,58 @@ func (p *blockParser) commitBlockResult(bc *types.BlockContext) {
 				continue
 			}
 
-			for {
-				err = p.dbService.UpdateToken(action.Token, action.Pair)
-				if err != nil {
-					logger.G.Error("update token main pair err", zap.Error(err), zap.String("token", action.Token), zap.String("pair", action.Pair))
-					time.Sleep(time.Millisecond * 100)
-					continue
-				}
-				break
+			err = storeBatchWithRetry(ctx, "db.update_token_main_pair", 1, func() error {
+				return p.dbService.UpdateToken(action.Token, action.Pair)
+			})
+			if err != nil {
+				logger.G.Fatal("update token main pair err", zap.Error(err), zap.String("token", action.Token), zap.String("pair", action.Pair))
 			}
 		}
 	}
 
+	if len(blockInfo.MigratedPools) > 0 {
+		migrateActions := make([]*orm.Action, 0, len(blockInfo.MigratedPools))
+		for _, pool := range blockInfo.MigratedPools {
+			migrateActions = append(migrateActions, pool.GetOrmAction(p.chain.ChainID, bc.HeightTime.Height, bc.HeightTime.Time))
+		}
+
+		// only persisted for the cache warmer, not part of the kafka msg actions
+		err = storeBatch(ctx, "db.add_migrate_actions", len(migrateActions), func() error {
+			return p.dbService.AddActions(migrateActions)
+		})
+		if err != nil {
+			logger.G.Fatal("add migrate actions err", zap.Any("height", blockInfo.Height), zap.Error(err))
+		}
+	}
+
+	if len(blockInfo.MevEvents) > 0 {
+		mevEvents := make([]*orm.MevEvent, 0, len(blockInfo.MevEvents))
+		for _, e := range blockInfo.MevEvents {
+			mevEvents = append(mevEvents, e.GetOrmMevEvent(p.chain.ChainID, bc.HeightTime.Time))
+		}
+
+		err = storeBatch(ctx, "db.add_mev_events", len(mevEvents), func() error {
+			return p.dbService.AddMevEvents(mevEvents)
+		})
+		if err != nil {
+			logger.G.Fatal("add mev events err", zap.Any("height", blockInfo.Height), zap.Error(err))
+		}
+	}
+
+	if len(blockInfo.FailedSwaps) > 0 {
+		failedSwaps := make([]*orm.FailedSwap, 0, len(blockInfo.FailedSwaps))
+		for _, fs := range blockInfo.FailedSwaps {
+			failedSwaps = append(failedSwaps, fs.GetOrmFailedSwap(p.chain.ChainID, bc.HeightTime.Time))
+		}
+
+		err = storeBatch(ctx, "db.add_failed_swaps", len(failedSwaps), func() error {
+			return p.dbService.AddFailedSwaps(failedSwaps)
+		})
+		if err != nil {
+			logger.G.Fatal("add failed swaps err", zap.Any("height", blockInfo.Height), zap.Error(err))
+		}
+	}
+
 	duration := time.Since(now)
 	metrics.DbOperationDurationMs.Observe(float64(duration.Milliseconds()))
 	logger.G.Sugar().Debugf("block %d native token price %s", blockInfo.Height, blockInfo.NativeTokenPrice)
@@ -316,14 +624,92 @@ func (p *blockParser) commitBlockResult(bc *types.BlockContext) {
 		logger.G.Sugar().Debugf("%s", string(bytes))
 	}
 
-	err = p.kafkaSender.Send(blockInfo)
+	err = p.kafkaSender.Send(ctx, blockInfo)
 	if err != nil {
 		logger.G.Fatal("send kafka msg err", zap.Error(err), zap.Any("block", bc.HeightTime.Height))
 	}
 
-	p.cache.SetFinishedBlock(bc.HeightTime.Height)
-	metrics.CurrentHeight.Set(float64(bc.HeightTime.Height))
-	metrics.TxCntByBlock.Set(float64(len(blockInfo.Txs)))
+	_, writeSpan := tracing.Start(ctx, "clickhouse.write")
+	err = p.clickHouseWriter.Write(blockInfo)
+	tracing.End(writeSpan, err)
+	if err != nil {
+		logger.G.Error("write clickhouse err", zap.Error(err), zap.Any("block", bc.HeightTime.Height))
+	}
+
+	if txsStored {
+		p.cache.SetFinishedBlock(bc.HeightTime.Height)
+	}
+	p.committed.Store(bc.HeightTime.Height)
+	p.lastCommit.Store(time.Now().UnixNano())
+	metrics.CurrentHeight.WithLabelValues(p.chain.Name).Set(float64(bc.HeightTime.Height))
+	if bc.HeadHeight > bc.HeightTime.Height {
+		metrics.BlockLag.WithLabelValues(p.chain.Name).Set(float64(bc.HeadHeight - bc.HeightTime.Height))
+	} else {
+		metrics.BlockLag.WithLabelValues(p.chain.Name).Set(0)
+	}
+	metrics.TxsTotal.WithLabelValues(p.chain.Name).Add(float64(len(blockInfo.Txs)))
+	metrics.BlockTxs.WithLabelValues(p.chain.Name).Observe(float64(len(blockInfo.Txs)))
+}
+
+// storeBatch runs a repository batch of rows in its span
+func storeBatch(ctx context.Context, name string, rows int, store func() error) error {
+	_, span := tracing.Start(ctx, name, attribute.Int("rows", rows))
+	err := store()
+	tracing.End(span, err)
+	return err
+}
+
+// storeRetryInterval is the wait before a failed repository batch is run again
+const storeRetryInterval = 100 * time.Millisecond
+
+// storeBatchWithRetry runs a repository batch until it succeeds, it only gives up when ctx is done
+func storeBatchWithRetry(ctx context.Context, name string, rows int, store func() error) error {
+	for {
+		err := storeBatch(ctx, name, rows, store)
+		if err == nil {
+			return nil
+		}
+		logger.G.Error("store batch err, retry", zap.String("batch", name), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(storeRetryInterval):
+		}
+	}
+}
+
+// refreshPoolState stores the reserves of the pairs updated in the block, after the pairs created in it
+func (p *blockParser) refreshPoolState(ctx context.Context, blockInfo *types.KafkaMsg) {
+	if p.pairReserves {
+		pairs := make([]*orm.Pair, 0, len(blockInfo.PoolUpdates))
+		for _, pu := range blockInfo.PoolUpdates {
+			pairs = append(pairs, pu.GetOrmPair(blockInfo.Height))
+		}
+		err := storeBatchWithRetry(ctx, "db.update_pair_reserves", len(pairs), func() error {
+			return p.dbService.UpdatePairReserves(pairs)
+		})
+		if err != nil {
+			logger.G.Fatal("update pair reserves err", zap.Any("height", blockInfo.Height), zap.Error(err))
+		}
+	}
+
+	if p.supplyRefresher != nil {
+		for _, pu := range blockInfo.PoolUpdates {
+			p.supplyRefresher.Touch(common.HexToAddress(pu.Token0))
+		}
+	}
+}
+
+// watchListedTokens schedules the security checks of the tokens of the PancakeV2 pairs created in the block
+func (p *blockParser) watchListedTokens(blockInfo *types.KafkaMsg) {
+	program := types.GetProtocolName(types.ProtocolIdPancakeV2)
+	for _, pair := range blockInfo.NewPairs {
+		if pair.Program != program || pair.Block != blockInfo.Height {
+			continue
+		}
+		p.tokenSecurity.Watch(common.HexToAddress(pair.Token0), common.HexToAddress(pair.Address), pair.BlockAt)
+	}
 }
 
 func (p *blockParser) startCommitBlockResult(wg *sync.WaitGroup) {
@@ -333,8 +719,20 @@ func (p *blockParser) startCommitBlockResult(wg *sync.WaitGroup) {
 			bc, ok := <-p.outputQueue
 			if !ok {
 				logger.G.Info("commitBlockResult - output queue closed")
+				if _, err := p.dbService.FlushTxs(true); err != nil {
+					logger.G.Error("flush txs err", zap.Error(err))
+				} else if committed := p.committed.Load(); committed > 0 {
+					p.cache.SetFinishedBlock(committed)
+				}
+				// the root ctx may be cancelled already when the shutdown timed out
+				flushCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.G.ClickHouse.TimeoutMs)*time.Millisecond)
+				if err := p.clickHouseWriter.Flush(flushCtx); err != nil {
+					logger.G.Error("flush clickhouse err", zap.Error(err))
+				}
+				cancel()
 				return
 			}
+			metrics.QueueDepth.WithLabelValues(p.chain.Name, "block_parser_output").Set(float64(len(p.outputQueue)))
 
 			p.commitBlockResult(bc)
 		}
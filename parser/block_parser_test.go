@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type failingPriceService struct{}
+
+func (s *failingPriceService) Start(uint64) {}
+
+func (s *failingPriceService) UpdatedAt() time.Time {
+	return time.Time{}
+}
+
+func (s *failingPriceService) GetPrice(context.Context, *big.Int) (decimal.Decimal, error) {
+	return decimal.Zero, errors.New("price not available")
+}
+
+func TestBlockParser_GetNativeTokenPriceCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &blockParser{ctx: ctx, priceService: &failingPriceService{}}
+
+	time.AfterFunc(time.Millisecond*100, cancel)
+	_, err := p.getNativeTokenPrice(context.Background(), big.NewInt(1), 0)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBlockParser_ForEach(t *testing.T) {
+	for _, poolSize := range []int{0, 1, 4} {
+		p := &blockParser{parseTxPoolSize: poolSize}
+		visited := make([]int32, 100)
+		var cnt atomic.Int32
+		p.forEach(len(visited), func(i int) {
+			atomic.AddInt32(&visited[i], 1)
+			cnt.Add(1)
+		})
+
+		require.Equal(t, int32(100), cnt.Load())
+		for _, v := range visited {
+			require.Equal(t, int32(1), v)
+		}
+	}
+}
+
+func TestStoreBatchWithRetry(t *testing.T) {
+	attempts := 0
+	err := storeBatchWithRetry(context.Background(), "db.test", 1, func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("db unavailable")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*250, cancel)
+	err = storeBatchWithRetry(ctx, "db.test", 1, func() error {
+		return errors.New("db unavailable")
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorContains(t, err, "db unavailable")
+}
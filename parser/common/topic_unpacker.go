@@ -1,8 +1,37 @@
 package event_parser
 
-import "github.com/ethereum/go-ethereum/common"
+import (
+	"bxs/abi/registry"
+	"bxs/logger"
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+)
 
 type TopicUnpacker struct {
 	Topic    common.Hash
 	Unpacker EthLogUnpacker
 }
+
+// NewTopicUnpacker resolves the event of a kind by topic0 in the ABI registry, the topic and data lengths follow its inputs
+func NewTopicUnpacker(kind string, topic0 common.Hash) TopicUnpacker {
+	event, err := registry.G.Event(kind, topic0)
+	if err != nil {
+		logger.G.Fatal("abi registry event err", zap.String("kind", kind), zap.Error(err))
+	}
+
+	topicLen := 1
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			topicLen++
+		}
+	}
+
+	return TopicUnpacker{
+		Topic: topic0,
+		Unpacker: EthLogUnpacker{
+			AbiEvent:      event,
+			TopicLen:      topicLen,
+			DataUnpackLen: len(event.Inputs) - topicLen + 1,
+		},
+	}
+}
@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"bxs/abi/registry"
+	"bxs/cache"
+	"bxs/chain_params"
+	"bxs/logger"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"math/big"
+	"strings"
+)
+
+type revertReasoner interface {
+	RevertReason(msg ethereum.CallMsg, blockNumber *big.Int) (string, error)
+}
+
+/*
+failedSwapDecoder decodes the calldata of the reverted txs sent to a swap router of the chain, or with the calldata
+of an xLaunch pool swap. They are only candidates once decoded, when the block is parsed, in parallel.
+They are resolved with the other events of the block, in block order, as the pool or the token can be created in the same block:
+the pool swaps are kept when the pool is a cached xLaunch pool, the router swaps when the wrapped native token is next to
+a cached token in the path, the last hop of a buy, the first of a sell. Only the kept ones get their revert reason.
+*/
+type failedSwapDecoder struct {
+	chain    *chain_params.ChainParams
+	cache    cache.Cache
+	reasoner revertReasoner // nil when the revert reasons are not fetched
+}
+
+func newFailedSwapDecoder(chain *chain_params.ChainParams, cache cache.Cache, reasoner revertReasoner) *failedSwapDecoder {
+	return &failedSwapDecoder{
+		chain:    chain,
+		cache:    cache,
+		reasoner: reasoner,
+	}
+}
+
+// decode returns the candidate of a reverted tx, nil when its calldata is not a swap
+func (d *failedSwapDecoder) decode(tx *ethtypes.Transaction, sender common.Address, receipt *ethtypes.Receipt, blockNumber *big.Int) *types.FailedSwap {
+	to := tx.To()
+	data := tx.Data()
+	if to == nil || len(data) < 4 {
+		return nil
+	}
+
+	fs := &types.FailedSwap{
+		TxHash:     tx.Hash().String(),
+		Sender:     sender.String(),
+		To:         to.String(),
+		GasUsed:    receipt.GasUsed,
+		Block:      blockNumber.Uint64(),
+		BlockIndex: receipt.TransactionIndex,
+	}
+
+	var ok bool
+	if d.chain.IsRouter(*to) {
+		ok = decodeRouterSwap(fs, tx)
+	} else {
+		ok = decodePoolSwap(fs, tx)
+	}
+	if !ok {
+		return nil
+	}
+
+	gasPrice := receipt.EffectiveGasPrice
+	if gasPrice == nil {
+		gasPrice = tx.GasPrice()
+	}
+	fs.GasFee = decimal.NewFromBigInt(new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(receipt.GasUsed)), -int32(d.chain.WrappedNativeDecimals))
+	return fs
+}
+
+// resolve keeps a candidate of a cached xLaunch pool or token, and sets its token and amounts
+func (d *failedSwapDecoder) resolve(fs *types.FailedSwap) bool {
+	if fs.Path != nil && !d.resolveRouterSwap(fs) {
+		return false
+	}
+
+	tokenAddress := fs.TokenAddress
+	if fs.Path == nil {
+		pair, ok := d.cache.GetPair(fs.Pool)
+		if !ok || pair.ProtocolId != types.ProtocolIdXLaunch || pair.Token0 == nil {
+			return false
+		}
+		tokenAddress = pair.Token0.Address
+	}
+
+	token, ok := d.cache.GetToken(tokenAddress)
+	if !ok {
+		return false
+	}
+
+	fs.Token = tokenAddress.String()
+	if fs.TokenAmountWei != nil {
+		fs.TokenAmount = decimal.NewFromBigInt(fs.TokenAmountWei, -int32(token.Decimals))
+	}
+	if fs.NativeAmountWei != nil {
+		fs.NativeAmount = decimal.NewFromBigInt(fs.NativeAmountWei, -int32(d.chain.WrappedNativeDecimals))
+	}
+	return true
+}
+
+// setRevertReason re-simulates a kept swap on the parent block
+func (d *failedSwapDecoder) setRevertReason(fs *types.FailedSwap, tx *ethtypes.Transaction, sender common.Address) {
+	msg := ethereum.CallMsg{From: sender, To: tx.To(), Gas: tx.Gas(), Value: tx.Value(), Data: tx.Data()}
+	reason, err := d.reasoner.RevertReason(msg, new(big.Int).SetUint64(fs.Block-1))
+	if err != nil {
+		logger.G.Warn("get revert reason err", zap.String("tx", fs.TxHash), zap.Error(err))
+	}
+	fs.RevertReason = reason
+}
+
+func unpackCalldata(kind string, data []byte) (*abi.Method, map[string]any, bool) {
+	contractAbi, err := registry.G.ABI(kind)
+	if err != nil {
+		return nil, nil, false
+	}
+	method, err := contractAbi.MethodById(data[:4])
+	if err != nil {
+		return nil, nil, false
+	}
+
+	args := make(map[string]any)
+	if err = method.Inputs.UnpackIntoMap(args, data[4:]); err != nil {
+		return nil, nil, false
+	}
+	return method, args, true
+}
+
+func decodePoolSwap(fs *types.FailedSwap, tx *ethtypes.Transaction) bool {
+	method, args, ok := unpackCalldata(registry.KindXLaunchPool, tx.Data())
+	if !ok {
+		return false
+	}
+
+	switch method.Name {
+	case "buy":
+		fs.Event = types.Buy
+		fs.TokenAmountWei = bigIntArg(args, "_expectedTokenAmount")
+		fs.NativeAmountWei = tx.Value()
+	case "sell":
+		fs.Event = types.Sell
+		fs.TokenAmountWei = bigIntArg(args, "_tokenAmount")
+		fs.NativeAmountWei = bigIntArg(args, "_expectedNativeTokenAmount")
+	default:
+		return false
+	}
+	// an abi of the registry with other argument names or types
+	if fs.TokenAmountWei == nil {
+		return false
+	}
+	fs.Method = method.Name
+	fs.Pool = *tx.To()
+	return true
+}
+
+func bigIntArg(args map[string]any, names ...string) *big.Int {
+	for _, name := range names {
+		if v, ok := args[name].(*big.Int); ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func decodeRouterSwap(fs *types.FailedSwap, tx *ethtypes.Transaction) bool {
+	method, args, ok := unpackCalldata(registry.KindPancakeV2Router, tx.Data())
+	if !ok {
+		return false
+	}
+	path, ok := args["path"].([]common.Address)
+	if !ok || len(path) < 2 {
+		return false
+	}
+
+	fs.AmountIn = bigIntArg(args, "amountIn", "amountInMax")
+	fs.AmountOut = bigIntArg(args, "amountOutMin", "amountOut")
+	if strings.HasPrefix(method.Name, "swapExactETH") || strings.HasPrefix(method.Name, "swapETH") {
+		fs.AmountIn = tx.Value()
+	}
+	fs.Method = method.Name
+	fs.Path = path
+	return true
+}
+
+// resolveRouterSwap sets the direction of a router swap by the cached token next to the wrapped native token in its path
+func (d *failedSwapDecoder) resolveRouterSwap(fs *types.FailedSwap) bool {
+	path := fs.Path
+	isToken := func(address common.Address) bool {
+		_, ok := d.cache.GetToken(address)
+		return ok
+	}
+
+	switch {
+	case d.chain.IsWrappedNative(path[len(path)-2]) && isToken(path[len(path)-1]):
+		fs.Event = types.Buy
+		fs.TokenAddress = path[len(path)-1]
+		fs.TokenAmountWei = fs.AmountOut
+		if len(path) == 2 {
+			fs.NativeAmountWei = fs.AmountIn
+		}
+	case d.chain.IsWrappedNative(path[1]) && isToken(path[0]):
+		fs.Event = types.Sell
+		fs.TokenAddress = path[0]
+		fs.TokenAmountWei = fs.AmountIn
+		if len(path) == 2 {
+			fs.NativeAmountWei = fs.AmountOut
+		}
+	default:
+		return false
+	}
+	return true
+}
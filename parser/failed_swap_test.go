@@ -0,0 +1,138 @@
+package parser
+
+import (
+	pancakev2 "bxs/abi/pancake/v2"
+	"bxs/abi/xlaunch"
+	"bxs/cache"
+	"bxs/chain_params"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+type fakeReasoner struct {
+	blockNumber *big.Int
+	calls       []common.Address // the to of the re-simulated txs
+}
+
+func (r *fakeReasoner) RevertReason(msg ethereum.CallMsg, blockNumber *big.Int) (string, error) {
+	r.blockNumber = blockNumber
+	r.calls = append(r.calls, *msg.To)
+	return "Pancake: INSUFFICIENT_OUTPUT_AMOUNT", nil
+}
+
+func failedTx(t *testing.T, to common.Address, value *big.Int, contractAbi interface {
+	Pack(string, ...any) ([]byte, error)
+}, method string, args ...any) *ethtypes.Transaction {
+	data, err := contractAbi.Pack(method, args...)
+	require.NoError(t, err)
+	return ethtypes.NewTx(&ethtypes.LegacyTx{To: &to, Value: value, Gas: 300000, GasPrice: big.NewInt(1e9), Data: data})
+}
+
+func TestFailedSwapDecoder_Router(t *testing.T) {
+	chain := chain_params.G
+	token := common.HexToAddress("0x70")
+	c := cache.NewMockCache()
+	c.(*cache.MockCache).SetToken(&types.Token{Address: token, Decimals: 3})
+	d := newFailedSwapDecoder(chain, c, nil)
+	receipt := &ethtypes.Receipt{GasUsed: 100000, TransactionIndex: 3}
+
+	tx := failedTx(t, chain.Routers[0], big.NewInt(5e17), pancakev2.RouterAbi, "swapExactETHForTokens",
+		big.NewInt(1000), []common.Address{chain.WrappedNativeAddress, token}, common.HexToAddress("0x01"), big.NewInt(1))
+	fs := d.decode(tx, common.HexToAddress("0x01"), receipt, big.NewInt(100))
+	require.NotNil(t, fs)
+	require.Equal(t, "swapExactETHForTokens", fs.Method)
+	require.Empty(t, fs.Event)
+	require.True(t, decimal.RequireFromString("0.0001").Equal(fs.GasFee))
+	require.Equal(t, uint(3), fs.BlockIndex)
+
+	require.True(t, d.resolve(fs))
+	require.Equal(t, types.Buy, fs.Event)
+	require.Equal(t, token.String(), fs.Token)
+	require.True(t, decimal.RequireFromString("1").Equal(fs.TokenAmount))
+	require.True(t, decimal.RequireFromString("0.5").Equal(fs.NativeAmount))
+
+	// a sell through another token: the native amount is unknown
+	tx = failedTx(t, chain.Routers[0], big.NewInt(0), pancakev2.RouterAbi, "swapExactTokensForTokens",
+		big.NewInt(7000), big.NewInt(8), []common.Address{token, chain.WrappedNativeAddress, common.HexToAddress("0x71")}, common.HexToAddress("0x01"), big.NewInt(1))
+	fs = d.decode(tx, common.HexToAddress("0x01"), receipt, big.NewInt(100))
+	require.True(t, d.resolve(fs))
+	require.Equal(t, types.Sell, fs.Event)
+	require.True(t, decimal.RequireFromString("7").Equal(fs.TokenAmount))
+	require.Nil(t, fs.NativeAmountWei)
+
+	// a buy of a token not indexed
+	tx = failedTx(t, chain.Routers[0], big.NewInt(1), pancakev2.RouterAbi, "swapExactETHForTokens",
+		big.NewInt(1000), []common.Address{chain.WrappedNativeAddress, common.HexToAddress("0x71")}, common.HexToAddress("0x01"), big.NewInt(1))
+	fs = d.decode(tx, common.HexToAddress("0x01"), receipt, big.NewInt(100))
+	require.NotNil(t, fs)
+	require.False(t, d.resolve(fs))
+
+	// no wrapped native next to the token
+	tx = failedTx(t, chain.Routers[0], big.NewInt(0), pancakev2.RouterAbi, "swapExactTokensForTokens",
+		big.NewInt(7), big.NewInt(8), []common.Address{token, common.HexToAddress("0x71")}, common.HexToAddress("0x01"), big.NewInt(1))
+	fs = d.decode(tx, common.HexToAddress("0x01"), receipt, big.NewInt(100))
+	require.False(t, d.resolve(fs))
+}
+
+func TestFailedSwapDecoder_Pool(t *testing.T) {
+	pool := common.HexToAddress("0x72")
+	token := common.HexToAddress("0x70")
+	c := cache.NewMockCache()
+	c.(*cache.MockCache).SetToken(&types.Token{Address: token, Decimals: 18})
+	c.(*cache.MockCache).SetPair(&types.Pair{Address: pool, Token0: &types.TokenTinyInfo{Address: token}, ProtocolId: types.ProtocolIdXLaunch})
+	d := newFailedSwapDecoder(chain_params.G, c, nil)
+	receipt := &ethtypes.Receipt{GasUsed: 100000}
+
+	tx := failedTx(t, pool, big.NewInt(0), xlaunch.PairAbi, "sell", big.NewInt(10), big.NewInt(20))
+	fs := d.decode(tx, common.HexToAddress("0x01"), receipt, big.NewInt(100))
+	require.NotNil(t, fs)
+	require.Equal(t, types.Sell, fs.Event)
+	require.Equal(t, pool, fs.Pool)
+	require.Equal(t, big.NewInt(10), fs.TokenAmountWei)
+	require.Equal(t, big.NewInt(20), fs.NativeAmountWei)
+	require.True(t, d.resolve(fs))
+	require.Equal(t, token.String(), fs.Token)
+
+	// the same calldata to a contract that is not a cached pool
+	tx = failedTx(t, common.HexToAddress("0x73"), big.NewInt(0), xlaunch.PairAbi, "sell", big.NewInt(10), big.NewInt(20))
+	fs = d.decode(tx, common.HexToAddress("0x01"), receipt, big.NewInt(100))
+	require.NotNil(t, fs)
+	require.False(t, d.resolve(fs))
+
+	// not a swap
+	tx = failedTx(t, pool, big.NewInt(0), xlaunch.PairAbi, "migrateToDEX")
+	require.Nil(t, d.decode(tx, common.HexToAddress("0x01"), receipt, big.NewInt(100)))
+}
+
+// only the resolved swaps are re-simulated, on the parent block
+func TestBlockParser_ResolveFailedSwaps(t *testing.T) {
+	pool := common.HexToAddress("0x72")
+	other := common.HexToAddress("0x73")
+	token := common.HexToAddress("0x70")
+	c := cache.NewMockCache()
+	c.(*cache.MockCache).SetToken(&types.Token{Address: token, Decimals: 18})
+	c.(*cache.MockCache).SetPair(&types.Pair{Address: pool, Token0: &types.TokenTinyInfo{Address: token}, ProtocolId: types.ProtocolIdXLaunch})
+	reasoner := &fakeReasoner{}
+	p := &blockParser{chain: chain_params.G, failedSwaps: newFailedSwapDecoder(chain_params.G, c, reasoner)}
+
+	bc := &types.BlockContext{}
+	for i, to := range []common.Address{other, pool} {
+		tx := failedTx(t, to, big.NewInt(0), xlaunch.PairAbi, "sell", big.NewInt(10), big.NewInt(20))
+		bc.Transactions = append(bc.Transactions, tx)
+		bc.Senders = append(bc.Senders, common.HexToAddress("0x01"))
+		fs := p.failedSwaps.decode(tx, common.HexToAddress("0x01"), &ethtypes.Receipt{TransactionIndex: uint(i)}, big.NewInt(100))
+		bc.FailedSwaps = append(bc.FailedSwaps, fs)
+	}
+
+	p.resolveFailedSwaps(bc)
+	require.Len(t, bc.FailedSwaps, 1)
+	require.Equal(t, "Pancake: INSUFFICIENT_OUTPUT_AMOUNT", bc.FailedSwaps[0].RevertReason)
+	require.Equal(t, []common.Address{pool}, reasoner.calls)
+	require.Equal(t, big.NewInt(99), reasoner.blockNumber)
+}
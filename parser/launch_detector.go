@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"bxs/cache"
+	"bxs/config"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type funding struct {
+	funder common.Address
+	block  uint64
+}
+
+type launch struct {
+	flags   *types.LaunchFlags
+	wallets map[common.Address]struct{}
+}
+
+/*
+launchDetector flags the buys of the tokens launched on xLaunch, in block order:
+  - bought in the same tx or block as the token creation, or by the creator: a bundled launch
+  - bought by a wallet the creator sent native token to in the last FundingBlocks: a creator controlled wallet
+  - bought within SniperBlocks after the launch block: a sniper
+
+The launch block and creator come from the cached token. The funding transfers and the per token sums are kept in memory,
+so after a restart the sums of a token restart from the blocks parsed again, and in logs mode,
+where the plain transfers are not fetched, creator funded wallets are not found.
+*/
+type launchDetector struct {
+	conf       *config.LaunchDetectorConf
+	cache      cache.Cache
+	fundings   map[common.Address]funding // the last native transfer to a wallet
+	launches   map[common.Address]*launch // watched tokens
+	pruneEvery uint64
+}
+
+func newLaunchDetector(conf *config.LaunchDetectorConf, cache cache.Cache) *launchDetector {
+	return &launchDetector{
+		conf:       conf,
+		cache:      cache,
+		fundings:   make(map[common.Address]funding),
+		launches:   make(map[common.Address]*launch),
+		pruneEvery: max(min(conf.FundingBlocks, conf.WatchBlocks)/10, 1),
+	}
+}
+
+// Detect flags the buys of the tx results and sets the launch flags of the tokens with a flagged buy
+func (d *launchDetector) Detect(bc *types.BlockContext) {
+	height := bc.HeightTime.Height
+	createdTx := make(map[common.Address]uint) // tokens created in the block, by tx index
+	flagged := make(map[common.Address]*types.LaunchFlags)
+	var flaggedTokens []common.Address // in the order of their first flagged buy
+
+	for i, tr := range bc.TxResults {
+		if tr != nil {
+			for _, token := range tr.Tokens {
+				createdTx[token.Address] = uint(i)
+			}
+			for _, event := range tr.SwapEvents {
+				if !event.IsBuy() || event.GetPair() == nil {
+					continue
+				}
+				if f := d.detectBuy(event, height, uint(i), createdTx); f != nil {
+					token := event.GetPair().Token0.Address
+					if _, ok := flagged[token]; !ok {
+						flaggedTokens = append(flaggedTokens, token)
+					}
+					flagged[token] = f
+				}
+			}
+		}
+
+		d.recordFunding(bc, i)
+	}
+
+	for _, token := range flaggedTokens {
+		bc.LaunchFlags = append(bc.LaunchFlags, flagged[token])
+	}
+
+	if height%d.pruneEvery == 0 {
+		d.prune(height)
+	}
+}
+
+func (d *launchDetector) detectBuy(event types.Event, height uint64, txIndex uint, createdTx map[common.Address]uint) *types.LaunchFlags {
+	tokenAddress := event.GetPair().Token0.Address
+	token, ok := d.cache.GetToken(tokenAddress)
+	if !ok || token.BlockNumber > height || height-token.BlockNumber > d.conf.WatchBlocks {
+		return nil
+	}
+
+	maker := event.GetMaker()
+	var flags int
+	if height == token.BlockNumber {
+		flags |= types.TradeFlagSameBlock
+		if created, ok := createdTx[tokenAddress]; ok && created == txIndex {
+			flags |= types.TradeFlagSameTx
+		}
+	} else if height-token.BlockNumber <= d.conf.SniperBlocks {
+		flags |= types.TradeFlagEarly
+	}
+	if maker == token.Creator {
+		flags |= types.TradeFlagCreator
+	} else if f, ok := d.fundings[maker]; ok && f.funder == token.Creator && height-f.block <= d.conf.FundingBlocks {
+		// the fundings are only pruned every pruneEvery blocks, an older one may still be there
+		flags |= types.TradeFlagCreatorFunded
+	}
+	if flags == 0 {
+		return nil
+	}
+	event.AddFlags(flags)
+
+	l, ok := d.launches[tokenAddress]
+	if !ok {
+		l = &launch{
+			flags: &types.LaunchFlags{
+				Token:       tokenAddress.String(),
+				Creator:     token.Creator.String(),
+				LaunchBlock: token.BlockNumber,
+			},
+			wallets: make(map[common.Address]struct{}),
+		}
+		d.launches[tokenAddress] = l
+	}
+	l.wallets[maker] = struct{}{}
+	l.flags.Block = height
+	l.flags.Flags |= flags
+	l.flags.FlaggedBuys++
+	l.flags.FlaggedWallets = len(l.wallets)
+
+	f := *l.flags
+	return &f
+}
+
+// recordFunding remembers the recipient of a plain native transfer, the transactions are only all fetched in block mode
+func (d *launchDetector) recordFunding(bc *types.BlockContext, txIndex int) {
+	// the receipts are by tx index in block mode only
+	if txIndex >= len(bc.Transactions) || txIndex >= len(bc.Receipts) || bc.Receipts[txIndex].TransactionIndex != uint(txIndex) {
+		return
+	}
+	tx := bc.Transactions[txIndex]
+	if tx == nil || tx.To() == nil || tx.Value().Sign() <= 0 || len(tx.Data()) > 0 || bc.Receipts[txIndex].Status != 1 {
+		return
+	}
+	d.fundings[*tx.To()] = funding{funder: bc.Senders[txIndex], block: bc.HeightTime.Height}
+}
+
+func (d *launchDetector) prune(height uint64) {
+	for wallet, f := range d.fundings {
+		if height-f.block > d.conf.FundingBlocks {
+			delete(d.fundings, wallet)
+		}
+	}
+	for token, l := range d.launches {
+		if height-l.flags.LaunchBlock > d.conf.WatchBlocks {
+			delete(d.launches, token)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"bxs/cache"
+	"bxs/chain_params"
+	"bxs/config"
+	pxlaunch "bxs/parser/xlaunch"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+type testTx struct {
+	sender   common.Address
+	transfer *common.Address // a plain native transfer to it
+	result   *types.TxResult
+}
+
+func testBlockContext(height uint64, txs ...testTx) *types.BlockContext {
+	bc := &types.BlockContext{
+		HeightTime:      &types.HeightTime{Height: height},
+		TransactionsLen: uint(len(txs)),
+		Transactions:    make([]*ethtypes.Transaction, len(txs)),
+		Receipts:        make([]*ethtypes.Receipt, len(txs)),
+		Senders:         make([]common.Address, len(txs)),
+		TxResults:       make([]*types.TxResult, len(txs)),
+	}
+	for i, tx := range txs {
+		bc.Transactions[i] = ethtypes.NewTx(&ethtypes.LegacyTx{To: tx.transfer, Value: big.NewInt(1)})
+		bc.Receipts[i] = &ethtypes.Receipt{Status: ethtypes.ReceiptStatusSuccessful, TransactionIndex: uint(i)}
+		bc.Senders[i] = tx.sender
+		bc.TxResults[i] = tx.result
+	}
+	return bc
+}
+
+func testTrade(pair *types.Pair, maker common.Address, buy bool) *types.TxResult {
+	tr := &types.TxResult{Sender: maker}
+	ec := &types.EventCommon{Pair: pair}
+	if buy {
+		tr.AddSwapEvent(&pxlaunch.BuyEvent{EventCommon: ec})
+	} else {
+		tr.AddSwapEvent(&pxlaunch.SellEvent{EventCommon: ec})
+	}
+	return tr
+}
+
+func tradeFlags(tr *types.TxResult) int {
+	switch e := tr.SwapEvents[0].(type) {
+	case *pxlaunch.BuyEvent:
+		return e.Flags
+	case *pxlaunch.SellEvent:
+		return e.Flags
+	}
+	return -1
+}
+
+func TestLaunchDetector_Detect(t *testing.T) {
+	conf := *config.G.Cache
+	conf.SnapshotPath = ""
+	c, err := cache.NewMemoryCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+
+	creator := common.HexToAddress("0xc0")
+	funded := common.HexToAddress("0xf0")
+	sniper := common.HexToAddress("0xa1")
+	token := &types.Token{Address: common.HexToAddress("0x70"), Creator: creator, BlockNumber: 100}
+	pair := &types.Pair{Address: common.HexToAddress("0x71"), Token0: &types.TokenTinyInfo{Address: token.Address}}
+	c.SetToken(token)
+
+	d := newLaunchDetector(&config.LaunchDetectorConf{SniperBlocks: 3, WatchBlocks: 20, FundingBlocks: 20}, c)
+
+	createTx := testTrade(pair, creator, true)
+	createTx.AddToken(token)
+	bc := testBlockContext(100,
+		testTx{sender: creator, result: createTx},
+		testTx{sender: creator, transfer: &funded},
+		testTx{sender: sniper, result: testTrade(pair, sniper, true)},
+	)
+	d.Detect(bc)
+	require.Equal(t, types.TradeFlagSameTx|types.TradeFlagSameBlock|types.TradeFlagCreator, tradeFlags(bc.TxResults[0]))
+	require.Equal(t, types.TradeFlagSameBlock, tradeFlags(bc.TxResults[2]))
+	require.Len(t, bc.LaunchFlags, 1)
+	require.Equal(t, 2, bc.LaunchFlags[0].FlaggedBuys)
+
+	bc = testBlockContext(102,
+		testTx{sender: funded, result: testTrade(pair, funded, true)},
+		testTx{sender: sniper, result: testTrade(pair, sniper, true)},
+		testTx{sender: funded, result: testTrade(pair, funded, false)},
+	)
+	d.Detect(bc)
+	require.Equal(t, types.TradeFlagEarly|types.TradeFlagCreatorFunded, tradeFlags(bc.TxResults[0]))
+	require.Equal(t, types.TradeFlagEarly, tradeFlags(bc.TxResults[1]))
+	require.Zero(t, tradeFlags(bc.TxResults[2]))
+	require.Equal(t, &types.LaunchFlags{
+		Token:          token.Address.String(),
+		Creator:        creator.String(),
+		LaunchBlock:    100,
+		Block:          102,
+		Flags:          types.TradeFlagSameTx | types.TradeFlagSameBlock | types.TradeFlagCreator | types.TradeFlagCreatorFunded | types.TradeFlagEarly,
+		FlaggedBuys:    4,
+		FlaggedWallets: 3,
+	}, bc.LaunchFlags[0])
+
+	// past the sniper blocks only the creator funded wallets are flagged, and nothing past the watch blocks
+	bc = testBlockContext(110, testTx{sender: sniper, result: testTrade(pair, sniper, true)}, testTx{sender: funded, result: testTrade(pair, funded, true)})
+	d.Detect(bc)
+	require.Zero(t, tradeFlags(bc.TxResults[0]))
+	require.Equal(t, types.TradeFlagCreatorFunded, tradeFlags(bc.TxResults[1]))
+
+	bc = testBlockContext(121, testTx{sender: funded, result: testTrade(pair, funded, true)})
+	d.Detect(bc)
+	require.Zero(t, tradeFlags(bc.TxResults[0]))
+	require.Empty(t, bc.LaunchFlags)
+}
+
+// a funding older than FundingBlocks no longer flags, even before it is pruned
+func TestLaunchDetector_FundingAge(t *testing.T) {
+	conf := *config.G.Cache
+	conf.SnapshotPath = ""
+	c, err := cache.NewMemoryCache(chain_params.G.Name, chain_params.G.ChainID, &conf)
+	require.NoError(t, err)
+
+	creator := common.HexToAddress("0xc0")
+	funded := common.HexToAddress("0xf0")
+	token := &types.Token{Address: common.HexToAddress("0x70"), Creator: creator, BlockNumber: 100}
+	pair := &types.Pair{Address: common.HexToAddress("0x71"), Token0: &types.TokenTinyInfo{Address: token.Address}}
+	c.SetToken(token)
+
+	// pruned every 4 blocks, the funding of block 100 is kept by the prune of block 140
+	d := newLaunchDetector(&config.LaunchDetectorConf{SniperBlocks: 3, WatchBlocks: 100, FundingBlocks: 40}, c)
+	d.Detect(testBlockContext(100, testTx{sender: creator, transfer: &funded}))
+	d.Detect(testBlockContext(140))
+
+	bc := testBlockContext(140, testTx{sender: funded, result: testTrade(pair, funded, true)})
+	d.Detect(bc)
+	require.Equal(t, types.TradeFlagCreatorFunded, tradeFlags(bc.TxResults[0]))
+
+	bc = testBlockContext(141, testTx{sender: funded, result: testTrade(pair, funded, true)})
+	d.Detect(bc)
+	require.Zero(t, tradeFlags(bc.TxResults[0]))
+}
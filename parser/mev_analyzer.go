@@ -0,0 +1,218 @@
+package parser
+
+import (
+	"bxs/repository/orm"
+	"bxs/types"
+	"cmp"
+	"github.com/shopspring/decimal"
+	"slices"
+)
+
+func tradeOrder(a, b *orm.Tx) int {
+	return cmp.Or(cmp.Compare(a.BlockIndex, b.BlockIndex), cmp.Compare(a.TxIndex, b.TxIndex))
+}
+
+// sameTrader is true for the trades of a trader, or of one bot sending the txs
+func sameTrader(a, b *orm.Tx) bool {
+	return a.Maker == b.Maker || (a.Sender != "" && a.Sender == b.Sender)
+}
+
+// matchedProfitUsd is the USD received for the tokens sold minus the USD paid for the tokens bought,
+// both scaled to the token amount bought and sold
+func matchedProfitUsd(buy, sell *orm.Tx) decimal.Decimal {
+	if buy.Token0Amount.IsZero() || sell.Token0Amount.IsZero() {
+		return decimal.Zero
+	}
+	matched := decimal.Min(buy.Token0Amount, sell.Token0Amount)
+	return sell.AmountUsd.Mul(matched).Div(sell.Token0Amount).Sub(buy.AmountUsd.Mul(matched).Div(buy.Token0Amount))
+}
+
+/*
+analyzeMev finds the sandwiches and the arbitrages in the trades of a block, ordered by BlockIndex (tx index) and TxIndex (log index):
+  - sandwich: on a pool, a trader's front run, trades of other traders in the same direction (the victims),
+    then the trader's back run in the opposite direction, each in its own tx
+  - arbitrage: a tx buying and selling a token on two pools, a backrun when the tx right before it
+    traded one of these pools, by another trader
+
+It sets the TradeFlag* bits of the trades it finds.
+*/
+func analyzeMev(block uint64, txs []*orm.Tx) []*types.MevEvent {
+	pools := make(map[string][]*orm.Tx)
+	var poolOrder []string
+	for _, tx := range txs {
+		if tx.Event != types.Buy && tx.Event != types.Sell {
+			continue
+		}
+		if _, ok := pools[tx.PairAddress]; !ok {
+			poolOrder = append(poolOrder, tx.PairAddress)
+		}
+		pools[tx.PairAddress] = append(pools[tx.PairAddress], tx)
+	}
+
+	var events []*types.MevEvent
+	for _, pool := range poolOrder {
+		slices.SortStableFunc(pools[pool], tradeOrder)
+		events = append(events, findSandwiches(block, pools[pool])...)
+	}
+	events = append(events, findArbitrages(block, txs, pools)...)
+	return events
+}
+
+func findSandwiches(block uint64, trades []*orm.Tx) []*types.MevEvent {
+	var events []*types.MevEvent
+	used := make([]bool, len(trades))
+	for i, front := range trades {
+		if used[i] {
+			continue
+		}
+
+		var victims []int
+		for j := i + 1; j < len(trades); j++ {
+			t := trades[j]
+			if used[j] || t.BlockIndex == front.BlockIndex {
+				continue
+			}
+
+			if !sameTrader(front, t) {
+				if t.Event == front.Event {
+					victims = append(victims, j)
+				}
+				continue
+			}
+			if t.Event == front.Event {
+				continue
+			}
+
+			victims = slices.DeleteFunc(victims, func(k int) bool { return trades[k].BlockIndex == t.BlockIndex })
+			if len(victims) == 0 {
+				break
+			}
+			events = append(events, newSandwich(block, front, t, trades, victims))
+			used[i], used[j] = true, true
+			for _, k := range victims {
+				used[k] = true
+			}
+			break
+		}
+	}
+	return events
+}
+
+func newSandwich(block uint64, front, back *orm.Tx, trades []*orm.Tx, victims []int) *types.MevEvent {
+	front.Flags |= types.TradeFlagSandwichFront
+	back.Flags |= types.TradeFlagSandwichBack
+
+	e := &types.MevEvent{
+		Kind:        types.MevKindSandwich,
+		Block:       block,
+		Pool:        front.PairAddress,
+		Token:       front.Token0Address,
+		Attacker:    front.Maker,
+		AttackerTxs: []string{front.TxHash, back.TxHash},
+	}
+	for _, k := range victims {
+		trades[k].Flags |= types.TradeFlagSandwichVictim
+		e.Victims = append(e.Victims, trades[k].Maker)
+		e.VictimTxs = append(e.VictimTxs, trades[k].TxHash)
+	}
+
+	if front.Event == types.Buy {
+		e.ProfitUsd = matchedProfitUsd(front, back)
+	} else {
+		e.ProfitUsd = matchedProfitUsd(back, front)
+	}
+	return e
+}
+
+type tokenTrades struct {
+	buys  []*orm.Tx
+	sells []*orm.Tx
+}
+
+func findArbitrages(block uint64, txs []*orm.Tx, pools map[string][]*orm.Tx) []*types.MevEvent {
+	type txToken struct {
+		blockIndex uint
+		token      string
+	}
+	groups := make(map[txToken]*tokenTrades)
+	var groupOrder []txToken
+	for _, tx := range txs {
+		key := txToken{tx.BlockIndex, tx.Token0Address}
+		g, ok := groups[key]
+		if !ok {
+			g = &tokenTrades{}
+			groups[key] = g
+			groupOrder = append(groupOrder, key)
+		}
+		switch tx.Event {
+		case types.Buy:
+			g.buys = append(g.buys, tx)
+		case types.Sell:
+			g.sells = append(g.sells, tx)
+		}
+	}
+
+	var events []*types.MevEvent
+	for _, key := range groupOrder {
+		g := groups[key]
+		if len(g.buys) == 0 || len(g.sells) == 0 || !crossesPools(g) {
+			continue
+		}
+
+		e := &types.MevEvent{
+			Kind:        types.MevKindArbitrage,
+			Block:       block,
+			Pool:        g.buys[0].PairAddress,
+			Token:       key.token,
+			Attacker:    g.buys[0].Maker,
+			AttackerTxs: []string{g.buys[0].TxHash},
+			ProfitUsd:   matchedProfitUsd(sumTrades(g.buys), sumTrades(g.sells)),
+		}
+		for _, tx := range slices.Concat(g.buys, g.sells) {
+			tx.Flags |= types.TradeFlagArbitrage
+			if victim := backrunVictim(tx, pools[tx.PairAddress]); victim != nil && victim.Flags&types.TradeFlagBackrunVictim == 0 {
+				victim.Flags |= types.TradeFlagBackrunVictim
+				e.Kind = types.MevKindBackrun
+				e.Victims = append(e.Victims, victim.Maker)
+				e.VictimTxs = append(e.VictimTxs, victim.TxHash)
+			}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func crossesPools(g *tokenTrades) bool {
+	for _, buy := range g.buys {
+		for _, sell := range g.sells {
+			if buy.PairAddress != sell.PairAddress {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sumTrades sums the amounts of the trades into one
+func sumTrades(trades []*orm.Tx) *orm.Tx {
+	sum := &orm.Tx{}
+	for _, t := range trades {
+		sum.Token0Amount = sum.Token0Amount.Add(t.Token0Amount)
+		sum.AmountUsd = sum.AmountUsd.Add(t.AmountUsd)
+	}
+	return sum
+}
+
+// backrunVictim returns the trade of the pool in the tx right before the trade, when it is by another trader
+func backrunVictim(trade *orm.Tx, poolTrades []*orm.Tx) *orm.Tx {
+	idx := slices.Index(poolTrades, trade)
+	if idx <= 0 || trade.BlockIndex == 0 {
+		return nil
+	}
+
+	prev := poolTrades[idx-1]
+	if prev.BlockIndex != trade.BlockIndex-1 || sameTrader(prev, trade) {
+		return nil
+	}
+	return prev
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"bxs/repository/orm"
+	"bxs/types"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func mevTrade(txIndex, logIndex uint, pool, maker, event string, amount0, amountUsd int64) *orm.Tx {
+	return &orm.Tx{
+		TxHash:        "0x" + maker + pool,
+		Event:         event,
+		Maker:         maker,
+		Token0Address: "token",
+		Token0Amount:  decimal.NewFromInt(amount0),
+		AmountUsd:     decimal.NewFromInt(amountUsd),
+		BlockIndex:    txIndex,
+		TxIndex:       logIndex,
+		PairAddress:   pool,
+	}
+}
+
+func TestAnalyzeMev_Sandwich(t *testing.T) {
+	front := mevTrade(1, 10, "pool", "bot", types.Buy, 100, 100)
+	victim1 := mevTrade(2, 20, "pool", "alice", types.Buy, 50, 60)
+	other := mevTrade(3, 30, "pool", "bob", types.Sell, 10, 12)
+	victim2 := mevTrade(4, 40, "pool", "carol", types.Buy, 50, 70)
+	back := mevTrade(5, 50, "pool", "bot", types.Sell, 100, 130)
+	untouched := mevTrade(6, 60, "pool", "dave", types.Buy, 10, 14)
+
+	// the trades come in any order
+	events := analyzeMev(100, []*orm.Tx{back, victim2, front, untouched, other, victim1})
+	require.Len(t, events, 1)
+	e := events[0]
+	require.Equal(t, types.MevKindSandwich, e.Kind)
+	require.Equal(t, "pool", e.Pool)
+	require.Equal(t, "bot", e.Attacker)
+	require.Equal(t, []string{"alice", "carol"}, e.Victims)
+	require.Equal(t, []string{front.TxHash, back.TxHash}, e.AttackerTxs)
+	require.True(t, decimal.NewFromInt(30).Equal(e.ProfitUsd))
+
+	require.Equal(t, types.TradeFlagSandwichFront, front.Flags)
+	require.Equal(t, types.TradeFlagSandwichVictim, victim1.Flags)
+	require.Equal(t, types.TradeFlagSandwichVictim, victim2.Flags)
+	require.Equal(t, types.TradeFlagSandwichBack, back.Flags)
+	require.Zero(t, other.Flags)
+	require.Zero(t, untouched.Flags)
+}
+
+func TestAnalyzeMev_NoSandwich(t *testing.T) {
+	// no victim between the trades of the bot
+	txs := []*orm.Tx{
+		mevTrade(1, 10, "pool", "bot", types.Buy, 100, 100),
+		mevTrade(2, 20, "pool", "alice", types.Sell, 50, 60),
+		mevTrade(3, 30, "pool", "bot", types.Sell, 100, 130),
+	}
+	require.Empty(t, analyzeMev(100, txs))
+
+	// the victim is in the back run tx
+	txs = []*orm.Tx{
+		mevTrade(1, 10, "pool", "bot", types.Buy, 100, 100),
+		mevTrade(3, 20, "pool", "alice", types.Buy, 50, 60),
+		mevTrade(3, 30, "pool", "bot", types.Sell, 100, 130),
+	}
+	require.Empty(t, analyzeMev(100, txs))
+}
+
+func TestAnalyzeMev_Backrun(t *testing.T) {
+	victim := mevTrade(1, 10, "pool1", "alice", types.Buy, 100, 100)
+	buy := mevTrade(2, 20, "pool2", "bot", types.Buy, 100, 90)
+	sell := mevTrade(2, 21, "pool1", "bot", types.Sell, 100, 95)
+
+	events := analyzeMev(100, []*orm.Tx{victim, buy, sell})
+	require.Len(t, events, 1)
+	e := events[0]
+	require.Equal(t, types.MevKindBackrun, e.Kind)
+	require.Equal(t, "bot", e.Attacker)
+	require.Equal(t, []string{"alice"}, e.Victims)
+	require.True(t, decimal.NewFromInt(5).Equal(e.ProfitUsd))
+	require.Equal(t, types.TradeFlagBackrunVictim, victim.Flags)
+	require.Equal(t, types.TradeFlagArbitrage, buy.Flags)
+	require.Equal(t, types.TradeFlagArbitrage, sell.Flags)
+
+	// not right after another trader's trade: a plain arbitrage
+	buy, sell = mevTrade(2, 20, "pool2", "bot", types.Buy, 100, 90), mevTrade(2, 21, "pool1", "bot", types.Sell, 100, 95)
+	events = analyzeMev(100, []*orm.Tx{buy, sell})
+	require.Len(t, events, 1)
+	require.Equal(t, types.MevKindArbitrage, events[0].Kind)
+	require.Empty(t, events[0].Victims)
+}
@@ -2,6 +2,8 @@ package event_parser
 
 import (
 	pancakev2 "bxs/abi/pancake/v2"
+	"bxs/abi/registry"
+	"bxs/chain_params"
 	pcommon "bxs/parser/common"
 	"bxs/types"
 	"github.com/ethereum/go-ethereum/common"
@@ -12,49 +14,23 @@ const (
 	protocolName = types.ProtocolNamePancakeV2
 )
 
-var (
-	pairCreatedEventParser = &PairCreatedEventParser{
-		TopicUnpacker: pcommon.TopicUnpacker{
-			Topic: pancakev2.PairCreatedTopic0,
-			Unpacker: pcommon.EthLogUnpacker{
-				AbiEvent:      pancakev2.PairCreatedEvent,
-				TopicLen:      3,
-				DataUnpackLen: 2,
-			},
+/*
+newTopic2EventParser returns the parsers of a chain, the pair created parser only accepts the chain factory.
+The events are resolved in the ABI registry, after the ABIs of the registry directory are loaded.
+*/
+func newTopic2EventParser(chain *chain_params.ChainParams) map[common.Hash]pcommon.EventParser {
+	return map[common.Hash]pcommon.EventParser{
+		pancakev2.PairCreatedTopic0: &PairCreatedEventParser{
+			TopicUnpacker: pcommon.NewTopicUnpacker(registry.KindPancakeV2Factory, pancakev2.PairCreatedTopic0),
+			chain:         chain,
 		},
+		pancakev2.SwapTopic0: &SwapEventParser{pcommon.NewTopicUnpacker(registry.KindPancakeV2Pair, pancakev2.SwapTopic0)},
+		pancakev2.SyncTopic0: &SyncEventParser{pcommon.NewTopicUnpacker(registry.KindPancakeV2Pair, pancakev2.SyncTopic0)},
 	}
+}
 
-	swapEventParser = &SwapEventParser{
-		TopicUnpacker: pcommon.TopicUnpacker{
-			Topic: pancakev2.SwapTopic0,
-			Unpacker: pcommon.EthLogUnpacker{
-				AbiEvent:      pancakev2.SwapEvent,
-				TopicLen:      3,
-				DataUnpackLen: 4,
-			},
-		},
-	}
-
-	syncEventParser = &SyncEventParser{
-		TopicUnpacker: pcommon.TopicUnpacker{
-			Topic: pancakev2.SyncTopic0,
-			Unpacker: pcommon.EthLogUnpacker{
-				AbiEvent:      pancakev2.SyncEvent,
-				TopicLen:      1,
-				DataUnpackLen: 2,
-			},
-		},
-	}
-
-	topic2EventParser = map[common.Hash]pcommon.EventParser{
-		pancakev2.PairCreatedTopic0: pairCreatedEventParser,
-		pancakev2.SwapTopic0:        swapEventParser,
-		pancakev2.SyncTopic0:        syncEventParser,
-	}
-)
-
-func Reg(registrable pcommon.Registrable) {
-	for k, v := range topic2EventParser {
+func Reg(registrable pcommon.Registrable, chain *chain_params.ChainParams) {
+	for k, v := range newTopic2EventParser(chain) {
 		registrable.Register(k, v)
 	}
 }
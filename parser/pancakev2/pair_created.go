@@ -19,16 +19,9 @@ type PairCreatedEvent struct {
 	tokenReversed bool
 }
 
-func (e *PairCreatedEvent) IsWBNBPair() bool {
-	return types.IsWBNB(e.Token0) || types.IsWBNB(e.Token1)
-}
-
-func (e *PairCreatedEvent) GetNonWBNBToken() common.Address {
-	if types.IsWBNB(e.Token0) {
-		return e.Token1
-	} else {
-		return e.Token0
-	}
+// GetNonWrappedNativeToken returns token0, the parser orders the wrapped native token as token1
+func (e *PairCreatedEvent) GetNonWrappedNativeToken() common.Address {
+	return e.Token0
 }
 
 func (e *PairCreatedEvent) IsPairCreated() bool {
@@ -38,7 +31,7 @@ func (e *PairCreatedEvent) IsPairCreated() bool {
 func (e *PairCreatedEvent) GetAction() *orm.Action {
 	return &orm.Action{
 		Maker:        e.Maker.String(),
-		Token:        e.GetNonWBNBToken().String(),
+		Token:        e.GetNonWrappedNativeToken().String(),
 		Pair:         e.Address.String(),
 		Action:       action,
 		TxHash:       e.TxHash.String(),
@@ -10,20 +10,21 @@ import (
 )
 
 var (
-	ErrWrongFactory = errors.New("wrong factory")
-	ErrNotWBNBPair  = errors.New("not bnb pair")
+	ErrWrongFactory         = errors.New("wrong factory")
+	ErrNotWrappedNativePair = errors.New("not wrapped native pair")
 )
 
 type PairCreatedEventParser struct {
 	pcommon.TopicUnpacker
+	chain *chain_params.ChainParams
 }
 
-func checkFactory(address common.Address) bool {
-	return types.IsSameAddress(address, chain_params.G.PancakeV2FactoryAddress)
+func (o *PairCreatedEventParser) checkFactory(address common.Address) bool {
+	return types.IsSameAddress(address, o.chain.PancakeV2FactoryAddress)
 }
 
 func (o *PairCreatedEventParser) Parse(ethLog *ethtypes.Log) (types.Event, error) {
-	if !checkFactory(ethLog.Address) {
+	if !o.checkFactory(ethLog.Address) {
 		return nil, ErrWrongFactory
 	}
 
@@ -39,11 +40,11 @@ func (o *PairCreatedEventParser) Parse(ethLog *ethtypes.Log) (types.Event, error
 		Token1:      common.BytesToAddress(ethLog.Topics[2].Bytes()[12:]),
 	}
 
-	if !e.IsWBNBPair() {
-		return nil, ErrNotWBNBPair
+	if !o.chain.IsWrappedNative(e.Token0) && !o.chain.IsWrappedNative(e.Token1) {
+		return nil, ErrNotWrappedNativePair
 	}
 
-	e.Token0, e.Token1, e.tokenReversed = types.OrderAddress(e.Token0, e.Token1)
+	e.Token0, e.Token1, e.tokenReversed = o.chain.OrderAddress(e.Token0, e.Token1)
 	e.Pair = &types.Pair{
 		Address: e.Address,
 		Token0: &types.TokenTinyInfo{
@@ -4,6 +4,7 @@ import (
 	"bxs/logger"
 	"bxs/repository/orm"
 	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 	"math/big"
@@ -15,6 +16,11 @@ type SwapEvent struct {
 	Amount1InWei  *big.Int
 	Amount0OutWei *big.Int
 	Amount1OutWei *big.Int
+	To            common.Address
+}
+
+func (e *SwapEvent) GetRecipient() common.Address {
+	return e.To
 }
 
 func (e *SwapEvent) CanGetTx() bool {
@@ -25,6 +31,7 @@ func (e *SwapEvent) GetTx(nativeTokenPrice decimal.Decimal) *orm.Tx {
 	tx := &orm.Tx{
 		TxHash:        e.TxHash.String(),
 		Maker:         e.Maker.String(),
+		Sender:        e.Sender.String(),
 		Token0Address: e.Pair.Token0.Address.String(),
 		Token1Address: e.Pair.Token1.Address.String(),
 		Block:         e.BlockNumber,
@@ -33,6 +40,7 @@ func (e *SwapEvent) GetTx(nativeTokenPrice decimal.Decimal) *orm.Tx {
 		TxIndex:       e.LogIndex,
 		PairAddress:   e.Pair.Address.String(),
 		Program:       protocolName,
+		Flags:         e.Flags,
 	}
 
 	if e.Amount0InWei.Cmp(types.ZeroBigInt) > 0 {
@@ -53,10 +61,21 @@ func (e *SwapEvent) GetTx(nativeTokenPrice decimal.Decimal) *orm.Tx {
 		logger.G.Warn("wrong pancake v2 swap event", zap.Any("event", e))
 	}
 
-	tx.AmountUsd, tx.PriceUsd = types.CalcAmountAndPrice(nativeTokenPrice, tx.Token0Amount, tx.Token1Amount, e.Pair.Token1.Address)
+	tx.AmountUsd, tx.PriceUsd = types.CalcAmountAndPrice(nativeTokenPrice, tx.Token0Amount, tx.Token1Amount)
 	return tx
 }
 
+// IsBuy is true when token0 of the pair, the non wrapped native token, is bought
+func (e *SwapEvent) IsBuy() bool {
+	if e.Amount0InWei.Cmp(types.ZeroBigInt) > 0 {
+		return e.Pair.TokenReversed
+	}
+	if e.Amount1InWei.Cmp(types.ZeroBigInt) > 0 {
+		return !e.Pair.TokenReversed
+	}
+	return false
+}
+
 func (e *SwapEvent) IsSwap() bool {
 	return true
 }
@@ -3,6 +3,7 @@ package event_parser
 import (
 	pcommon "bxs/parser/common"
 	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"math/big"
 )
@@ -23,6 +24,7 @@ func (o *SwapEventParser) Parse(receiptLog *ethtypes.Log) (types.Event, error) {
 		Amount1InWei:  eventInput[1].(*big.Int),
 		Amount0OutWei: eventInput[2].(*big.Int),
 		Amount1OutWei: eventInput[3].(*big.Int),
+		To:            common.BytesToAddress(receiptLog.Topics[2].Bytes()[12:]),
 	}
 
 	e.Pair = &types.Pair{
@@ -1,11 +1,16 @@
 package parser
 
 import (
+	"bxs/abi/erc4337"
+	pancakev2 "bxs/abi/pancake/v2"
+	"bxs/abi/xlaunch"
+	"bxs/chain_params"
 	pcommon "bxs/parser/common"
 	ppancakev2 "bxs/parser/pancakev2"
 	pxlaunch "bxs/parser/xlaunch"
 	"bxs/types"
 	"errors"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 )
@@ -16,23 +21,68 @@ var (
 
 type TopicRouter interface {
 	Route(ethLog *ethtypes.Log) (types.Event, error)
+	FilterQueries() []ethereum.FilterQuery
 }
 
 type topicRouter struct {
 	topic2EventParser map[common.Hash]pcommon.EventParser
+	topic2Emitter     map[common.Hash]common.Address
+	entryPoints       []common.Address
 }
 
-func NewTopicRouter() TopicRouter {
+// NewTopicRouter routes the logs of a chain, the factory events are only accepted from the chain factories
+func NewTopicRouter(chain *chain_params.ChainParams) TopicRouter {
 	r := &topicRouter{
 		topic2EventParser: make(map[common.Hash]pcommon.EventParser),
+		topic2Emitter:     make(map[common.Hash]common.Address),
+		entryPoints:       chain.EntryPoints,
 	}
 
-	ppancakev2.Reg(r)
-	pxlaunch.Reg(r)
+	ppancakev2.Reg(r, chain)
+	pxlaunch.Reg(r, chain)
+
+	// events only accepted from a factory, the parsers drop them from any other address
+	r.topic2Emitter[pancakev2.PairCreatedTopic0] = chain.PancakeV2FactoryAddress
+	r.topic2Emitter[xlaunch.CreatedTopic0] = chain.XLaunchFactoryAddress
 
 	return r
 }
 
+/*
+FilterQueries returns the eth_getLogs filters (without block range) matching every registered topic:
+one filter for the factory events restricted to the factory addresses,
+one filter for the other events which are emitted by any pair or pool,
+and one filter for the UserOperationEvent of the entry points, not parsed but needed to resolve the traders.
+*/
+func (p *topicRouter) FilterQueries() []ethereum.FilterQuery {
+	var (
+		emitters      []common.Address
+		emitterTopics []common.Hash
+		anyTopics     []common.Hash
+	)
+
+	for topic := range p.topic2EventParser {
+		if emitter, ok := p.topic2Emitter[topic]; ok {
+			emitters = append(emitters, emitter)
+			emitterTopics = append(emitterTopics, topic)
+		} else {
+			anyTopics = append(anyTopics, topic)
+		}
+	}
+
+	queries := make([]ethereum.FilterQuery, 0, 3)
+	if len(emitterTopics) > 0 {
+		queries = append(queries, ethereum.FilterQuery{Addresses: emitters, Topics: [][]common.Hash{emitterTopics}})
+	}
+	if len(anyTopics) > 0 {
+		queries = append(queries, ethereum.FilterQuery{Topics: [][]common.Hash{anyTopics}})
+	}
+	if len(queries) > 0 && len(p.entryPoints) > 0 {
+		queries = append(queries, ethereum.FilterQuery{Addresses: p.entryPoints, Topics: [][]common.Hash{{erc4337.UserOperationEventTopic0}}})
+	}
+	return queries
+}
+
 func (p *topicRouter) Route(ethLog *ethtypes.Log) (types.Event, error) {
 	eventParser, ok := p.topic2EventParser[ethLog.Topics[0]]
 	if !ok {
@@ -42,6 +92,20 @@ func (p *topicRouter) Route(ethLog *ethtypes.Log) (types.Event, error) {
 	return eventParser.Parse(ethLog)
 }
 
+// dropReason labels the events Route fails to parse, it is empty for the logs no parser is registered for
+func dropReason(err error) string {
+	switch {
+	case errors.Is(err, ErrParserNotFound):
+		return ""
+	case errors.Is(err, ppancakev2.ErrWrongFactory), errors.Is(err, pxlaunch.ErrWrongFactoryAddress):
+		return "wrong_emitter"
+	case errors.Is(err, ppancakev2.ErrNotWrappedNativePair):
+		return "not_wrapped_native"
+	default:
+		return "parse_error"
+	}
+}
+
 func (p *topicRouter) Register(commonHash common.Hash, eventParser pcommon.EventParser) {
 	p.topic2EventParser[commonHash] = eventParser
 }
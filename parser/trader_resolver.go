@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"bxs/abi/erc4337"
+	"bxs/chain_params"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+type userOperation struct {
+	logIndex uint
+	sender   common.Address
+}
+
+/*
+traderResolver resolves the trader of the events of a tx, in order:
+  - an event of an ERC-4337 user operation is credited to its account: the entry point logs the UserOperationEvent
+    after the events of the operation, so the event belongs to the first UserOperationEvent logged after it
+  - an event paying a recipient is credited to it, an aggregator or a bot trades for the recipient;
+    unless the recipient is a router, the wrapped native token or a contract logging in the tx (the next pair of a multi hop swap)
+  - otherwise the event is credited to the tx sender
+
+The contracts logging in the tx are the emitters of the receipt logs. In logs mode the receipt only holds the matched logs,
+the pairs and pools of the swaps, the factories and the entry points: a multi hop swap is resolved the same,
+but a recipient contract only logging other events, like an aggregator logging its own event, is credited the event there,
+while the tx sender is in block mode. Fetching the full receipts would cost what the logs mode saves.
+*/
+type traderResolver struct {
+	chain    *chain_params.ChainParams
+	sender   common.Address
+	userOps  []userOperation // by log index
+	emitters map[common.Address]struct{}
+}
+
+func newTraderResolver(chain *chain_params.ChainParams, sender common.Address, receipt *ethtypes.Receipt) *traderResolver {
+	r := &traderResolver{
+		chain:    chain,
+		sender:   sender,
+		emitters: make(map[common.Address]struct{}, len(receipt.Logs)),
+	}
+
+	for _, ethLog := range receipt.Logs {
+		r.emitters[ethLog.Address] = struct{}{}
+		if len(ethLog.Topics) > 2 && ethLog.Topics[0] == erc4337.UserOperationEventTopic0 && chain.IsEntryPoint(ethLog.Address) {
+			r.userOps = append(r.userOps, userOperation{
+				logIndex: ethLog.Index,
+				sender:   common.BytesToAddress(ethLog.Topics[2].Bytes()[12:]),
+			})
+		}
+	}
+	return r
+}
+
+func (r *traderResolver) Resolve(event types.Event) common.Address {
+	logIndex := event.GetLogIndex()
+	for _, op := range r.userOps {
+		if logIndex < op.logIndex {
+			return op.sender
+		}
+	}
+
+	recipient := event.GetRecipient()
+	if recipient == types.ZeroAddress || r.chain.IsRouter(recipient) || r.chain.IsWrappedNative(recipient) {
+		return r.sender
+	}
+	if _, ok := r.emitters[recipient]; ok {
+		return r.sender
+	}
+	return recipient
+}
@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"bxs/abi/erc4337"
+	"bxs/chain_params"
+	ppancakev2 "bxs/parser/pancakev2"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func swapTo(logIndex uint, to common.Address) *ppancakev2.SwapEvent {
+	return &ppancakev2.SwapEvent{EventCommon: &types.EventCommon{LogIndex: logIndex}, To: to}
+}
+
+func userOperationLog(logIndex uint, account common.Address) *ethtypes.Log {
+	return &ethtypes.Log{
+		Address: erc4337.EntryPointV06Address,
+		Topics:  []common.Hash{erc4337.UserOperationEventTopic0, {}, common.BytesToHash(account.Bytes()), {}},
+		Index:   logIndex,
+	}
+}
+
+func TestTraderResolver_Resolve(t *testing.T) {
+	chain := chain_params.G
+	sender := common.HexToAddress("0x01")
+	recipient := common.HexToAddress("0x02")
+	pair := common.HexToAddress("0x03")
+
+	r := newTraderResolver(chain, sender, &ethtypes.Receipt{Logs: []*ethtypes.Log{{Address: pair, Index: 0}}})
+	require.Equal(t, recipient, r.Resolve(swapTo(0, recipient)))
+	require.Equal(t, sender, r.Resolve(swapTo(0, types.ZeroAddress)))
+	require.Equal(t, sender, r.Resolve(swapTo(0, chain.Routers[0])))
+	require.Equal(t, sender, r.Resolve(swapTo(0, chain.WrappedNativeAddress)))
+	// multi hop: the first pair pays the next one
+	require.Equal(t, sender, r.Resolve(swapTo(0, pair)))
+}
+
+func TestTraderResolver_UserOperations(t *testing.T) {
+	bundler := common.HexToAddress("0x01")
+	account1 := common.HexToAddress("0xa1")
+	account2 := common.HexToAddress("0xa2")
+	receipt := &ethtypes.Receipt{Logs: []*ethtypes.Log{
+		{Address: common.HexToAddress("0x03"), Index: 0},
+		userOperationLog(1, account1),
+		{Address: common.HexToAddress("0x04"), Index: 2},
+		userOperationLog(3, account2),
+	}}
+
+	r := newTraderResolver(chain_params.G, bundler, receipt)
+	require.Equal(t, account1, r.Resolve(swapTo(0, chain_params.G.Routers[0])))
+	require.Equal(t, account2, r.Resolve(swapTo(2, common.HexToAddress("0x05"))))
+	require.Equal(t, bundler, r.Resolve(swapTo(4, types.ZeroAddress)))
+
+	// a UserOperationEvent from another contract is ignored
+	receipt.Logs[1].Address = common.HexToAddress("0x06")
+	r = newTraderResolver(chain_params.G, bundler, receipt)
+	require.Equal(t, account2, r.Resolve(swapTo(0, types.ZeroAddress)))
+}
+
+// in logs mode the receipt only holds the matched logs, a recipient logging other events is no longer seen logging
+func TestTraderResolver_LogsMode(t *testing.T) {
+	sender := common.HexToAddress("0x01")
+	pair := common.HexToAddress("0x03")
+	aggregator := common.HexToAddress("0x07")
+	swapLog := &ethtypes.Log{Address: pair, Index: 0}
+
+	full := newTraderResolver(chain_params.G, sender, &ethtypes.Receipt{Logs: []*ethtypes.Log{swapLog, {Address: aggregator, Index: 1}}})
+	require.Equal(t, sender, full.Resolve(swapTo(0, aggregator)))
+
+	matched := newTraderResolver(chain_params.G, sender, &ethtypes.Receipt{Logs: []*ethtypes.Log{swapLog}})
+	require.Equal(t, aggregator, matched.Resolve(swapTo(0, aggregator)))
+	require.Equal(t, sender, matched.Resolve(swapTo(0, pair)))
+}
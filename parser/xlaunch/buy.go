@@ -19,6 +19,10 @@ type BuyEvent struct {
 	Migrated          bool
 }
 
+func (e *BuyEvent) GetRecipient() common.Address {
+	return e.Buyer
+}
+
 func (e *BuyEvent) CanGetTx() bool {
 	return true
 }
@@ -27,7 +31,8 @@ func (e *BuyEvent) GetTx(nativeTokenPrice decimal.Decimal) *orm.Tx {
 	tx := &orm.Tx{
 		TxHash:        e.TxHash.String(),
 		Event:         types.Buy,
-		Maker:         e.Buyer.String(),
+		Maker:         e.Maker.String(),
+		Sender:        e.Sender.String(),
 		Token0Address: e.Pair.Token0.Address.String(),
 		Token1Address: e.Pair.Token1.Address.String(),
 		Block:         e.BlockNumber,
@@ -36,10 +41,11 @@ func (e *BuyEvent) GetTx(nativeTokenPrice decimal.Decimal) *orm.Tx {
 		TxIndex:       e.LogIndex,
 		PairAddress:   e.Pair.Address.String(),
 		Program:       protocolName,
+		Flags:         e.Flags,
 	}
 
 	tx.Token0Amount, tx.Token1Amount = types.ParseAmount(e.TokenAmount, e.NativeTokenAmount, e.Pair)
-	tx.AmountUsd, tx.PriceUsd = types.CalcAmountAndPrice(nativeTokenPrice, tx.Token0Amount, tx.Token1Amount, e.Pair.Token1.Address)
+	tx.AmountUsd, tx.PriceUsd = types.CalcAmountAndPrice(nativeTokenPrice, tx.Token0Amount, tx.Token1Amount)
 	return tx
 }
 
@@ -76,6 +82,10 @@ func (e *BuyEvent) GetAction() *orm.Action {
 	return action
 }
 
+func (e *BuyEvent) IsBuy() bool {
+	return true
+}
+
 func (e *BuyEvent) IsBuyOrSell() bool {
 	return true
 }
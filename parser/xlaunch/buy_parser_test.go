@@ -1,20 +1,40 @@
 package event_parser
 
 import (
+	"bxs/abi/registry"
+	"bxs/chain/fake"
+	"bxs/chain_params"
 	"bxs/repository/orm"
 	"bxs/service"
 	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
+	"math/big"
 	"testing"
 )
 
+const buyTxHash = "0x5f829ffaca551f426a756a6a84e2e03bd9ec5c3ed06ab40a99479e98d3f71297"
+
+var (
+	buyPool  = common.HexToAddress("0xCe32c1326450C7AC8D9698E65d3303efB4F211c0")
+	buyToken = common.HexToAddress("0x27C3e2BD88e9C0fE5a99a1aCb0A0F3cd08363043")
+)
+
+// scriptBuy scripts the buy of the testnet tx 0xb93f156a59a1f9c92a0af06f430fa942a08392c46f126de104c24fd9d8fb75c9, its log 2
+func scriptBuy(c *service.TestChain) {
+	tokenAmount, _ := new(big.Int).SetString("44711015496156927491764", 10)
+	buy := c.EventLog(registry.KindXLaunchPool, "Buy", buyPool, []common.Hash{common.BytesToHash(c.Sender().Bytes())},
+		big.NewInt(4573267326732715), tokenAmount, big.NewInt(4573267326732715), tokenAmount, big.NewInt(45732673267327), false)
+	c.AddBlock(66567463, 1759300000, &fake.Tx{To: buyPool, Value: big.NewInt(4619000000000042), Logs: append(service.OtherLogs(2), buy)})
+	c.SetXLaunchPool(buyPool, buyToken, "Buy Token", "BUY", new(big.Int).Mul(big.NewInt(1e9), big.NewInt(1e18)))
+}
+
 func TestBuy(t *testing.T) {
-	// https://testnet.bscscan.com/tx/0xb93f156a59a1f9c92a0af06f430fa942a08392c46f126de104c24fd9d8fb75c9#eventlog#2
-	tc := service.GetTestContext()
-	ethLog := tc.GetEthLog("0xb93f156a59a1f9c92a0af06f430fa942a08392c46f126de104c24fd9d8fb75c9", 2)
+	tc := service.GetTestContext(t, "buy", scriptBuy)
+	ethLog := tc.GetEthLog(buyTxHash, 2)
 
-	event, pErr := topic2EventParser[ethLog.Topics[0]].Parse(ethLog)
+	event, pErr := newTopic2EventParser(chain_params.G)[ethLog.Topics[0]].Parse(ethLog)
 	require.NoError(t, pErr)
 
 	pairWrap := tc.PairService.GetPair(event.GetPairAddress())
@@ -24,16 +44,16 @@ func TestBuy(t *testing.T) {
 	expectAmt0, _ := decimal.NewFromString("44711.015496156927491764")
 	expectAmt1, _ := decimal.NewFromString("0.004573267326732715")
 	expectTx := &orm.Tx{
-		TxHash:        "0xb93f156a59a1f9c92a0af06f430fa942a08392c46f126de104c24fd9d8fb75c9",
+		TxHash:        buyTxHash,
 		Event:         types.Buy,
 		Token0Amount:  expectAmt0,
 		Token1Amount:  expectAmt1,
-		Token0Address: "0x27C3e2BD88e9C0fE5a99a1aCb0A0F3cd08363043",
+		Token0Address: buyToken.String(),
 		Token1Address: types.ZeroAddress.String(),
 		Block:         66567463,
 		BlockIndex:    0,
 		TxIndex:       2,
-		PairAddress:   "0xCe32c1326450C7AC8D9698E65d3303efB4F211c0",
+		PairAddress:   buyPool.String(),
 		Program:       protocolName,
 	}
 	tx.Diff(expectTx)
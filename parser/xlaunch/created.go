@@ -53,7 +53,7 @@ func (e *CreatedEvent) GetPairAddress() common.Address {
 	return e.PoolAddress
 }
 
-func (e *CreatedEvent) getPair() *types.Pair {
+func (e *CreatedEvent) getPair(nativeToken *types.TokenTinyInfo) *types.Pair {
 	pair := &types.Pair{
 		Address: e.PoolAddress,
 		Token0: &types.TokenTinyInfo{
@@ -61,7 +61,7 @@ func (e *CreatedEvent) getPair() *types.Pair {
 			Symbol:  e.Symbol,
 			Decimal: types.Decimal18,
 		},
-		Token1:     types.NativeTokenTinyInfo,
+		Token1:     nativeToken,
 		Block:      e.BlockNumber,
 		ProtocolId: protocolId,
 	}
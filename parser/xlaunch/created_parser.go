@@ -16,10 +16,11 @@ var (
 
 type CreatedEventParser struct {
 	pcommon.TopicUnpacker
+	chain *chain_params.ChainParams
 }
 
-func checkFactoryAddr(addr common.Address) bool {
-	return types.IsSameAddress(addr, chain_params.G.XLaunchFactoryAddress)
+func (o *CreatedEventParser) checkFactoryAddr(addr common.Address) bool {
+	return types.IsSameAddress(addr, o.chain.XLaunchFactoryAddress)
 }
 
 var (
@@ -28,7 +29,7 @@ var (
 )
 
 func (o *CreatedEventParser) Parse(ethLog *ethtypes.Log) (types.Event, error) {
-	if !checkFactoryAddr(ethLog.Address) {
+	if !o.checkFactoryAddr(ethLog.Address) {
 		return nil, ErrWrongFactoryAddress
 	}
 
@@ -59,6 +60,6 @@ func (o *CreatedEventParser) Parse(ethLog *ethtypes.Log) (types.Event, error) {
 
 	createdEvent.FormatString()
 
-	createdEvent.Pair = createdEvent.getPair()
+	createdEvent.Pair = createdEvent.getPair(types.NativeTokenTinyInfo(o.chain))
 	return createdEvent, nil
 }
@@ -1,41 +1,60 @@
 package event_parser
 
 import (
+	"bxs/abi/registry"
+	"bxs/chain/fake"
+	"bxs/chain_params"
 	"bxs/service"
 	"bxs/types"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
+	"math/big"
 	"testing"
 	"time"
 )
 
+const createdTxHash = "0x859fde65c9a472758563d08cf47f3610aae40bb488cc8d679aa6fc498500ace7"
+
+var (
+	createdPool    = common.HexToAddress("0x9182A7b564C43dbc2EE58Da9B270Fe13D1dd976e")
+	createdToken   = common.HexToAddress("0xDA519FB1b564A0CE2e10E48CEDbe5BFEd490623D")
+	createdCreator = common.HexToAddress("0x866925e79c447352711bF740183AA3Cc67371E16")
+)
+
+// scriptCreated scripts the launch of the testnet tx 0xff34b651bc1cf2b5cdd57cdb72dbe84ca953d4a1cd833f83441f2ac834d7cffc, its log 5
+func scriptCreated(c *service.TestChain) {
+	created := c.EventLog(registry.KindXLaunchFactory, "Created", chain_params.G.XLaunchFactoryAddress,
+		[]common.Hash{common.BytesToHash(createdPool.Bytes()), common.BytesToHash(createdCreator.Bytes()), common.BytesToHash(createdToken.Bytes())},
+		big.NewInt(0), initTokenAmountWei, new(big.Int).Mul(big.NewInt(1e7), big.NewInt(1e18)), "223", "zz", "10392",
+		"bafkreibut3ftcrldii42ffjpbm3tvlvgvtw7nhdribgpgkrm5xpr5z67qm", "ss", "", "", "")
+	c.AddBlock(69460293, 1760500000, &fake.Tx{To: chain_params.G.XLaunchFactoryAddress, Logs: append(service.OtherLogs(5), created)})
+}
+
 func TestCreated(t *testing.T) {
-	// https://testnet.bscscan.com/tx/0xff34b651bc1cf2b5cdd57cdb72dbe84ca953d4a1cd833f83441f2ac834d7cffc#eventlog#5
-	tc := service.GetTestContext()
-	ethLog := tc.GetEthLog("0xff34b651bc1cf2b5cdd57cdb72dbe84ca953d4a1cd833f83441f2ac834d7cffc", 5)
+	tc := service.GetTestContext(t, "created", scriptCreated)
+	ethLog := tc.GetEthLog(createdTxHash, 5)
 	blockTimestamp := tc.GetBlockTimestamp(ethLog.BlockNumber)
 
-	event, pErr := topic2EventParser[ethLog.Topics[0]].Parse(ethLog)
+	event, pErr := newTopic2EventParser(chain_params.G)[ethLog.Topics[0]].Parse(ethLog)
 	require.NoError(t, pErr)
 
 	event.SetBlockTime(time.Unix(int64(blockTimestamp), 0))
 	pair := event.GetPair()
 
-	token0InitAmount, err := decimal.NewFromString("10666666.666666666666666666")
-	require.NoError(t, err)
-	token1InitAmount, err := decimal.NewFromString("0.069333333333333333")
-	require.NoError(t, err)
+	// the pools are launched with 800000000 tokens and no BNB since 20251023, whatever the event says
+	token0InitAmount := decimal.NewFromInt(800000000)
+	token1InitAmount := decimal.Zero
 	expectBlockTime := time.Unix(int64(blockTimestamp), 0)
 	expectPair := &types.Pair{
-		Address:       common.HexToAddress("0x9182A7b564C43dbc2EE58Da9B270Fe13D1dd976e"),
+		Address:       createdPool,
 		TokenReversed: false,
 		Token0: &types.TokenTinyInfo{
-			Address: common.HexToAddress("0xDA519FB1b564A0CE2e10E48CEDbe5BFEd490623D"),
+			Address: createdToken,
 			Symbol:  "zz",
 			Decimal: 18,
 		},
-		Token1:      types.NativeTokenTinyInfo,
+		Token1:      types.NativeTokenTinyInfo(chain_params.G),
 		InitAmount0: token0InitAmount,
 		InitAmount1: token1InitAmount,
 		Block:       69460293,
@@ -50,8 +69,8 @@ func TestCreated(t *testing.T) {
 	token0 := event.GetToken0()
 	expectToken0TotalSupply, _ := decimal.NewFromString("10000000")
 	expectToken0 := &types.Token{
-		Address:     common.HexToAddress("0xDA519FB1b564A0CE2e10E48CEDbe5BFEd490623D"),
-		Creator:     common.HexToAddress("0x866925e79c447352711bF740183AA3Cc67371E16"),
+		Address:     createdToken,
+		Creator:     createdCreator,
 		Name:        "223",
 		Symbol:      "zz",
 		Decimals:    18,
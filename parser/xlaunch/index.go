@@ -1,7 +1,9 @@
 package event_parser
 
 import (
+	"bxs/abi/registry"
 	"bxs/abi/xlaunch"
+	"bxs/chain_params"
 	pcommon "bxs/parser/common"
 	"bxs/types"
 	"github.com/ethereum/go-ethereum/common"
@@ -16,49 +18,23 @@ var (
 	xLaunchTokenDecimal = int8(18)
 )
 
-var (
-	createdEventParser = &CreatedEventParser{
-		pcommon.TopicUnpacker{
-			Topic: xlaunch.CreatedTopic0,
-			Unpacker: pcommon.EthLogUnpacker{
-				AbiEvent:      xlaunch.CreatedEvent,
-				TopicLen:      4,
-				DataUnpackLen: 11,
-			},
-		},
-	}
-
-	buyEventParser = &BuyEventParser{
-		pcommon.TopicUnpacker{
-			Topic: xlaunch.BuyTopic0,
-			Unpacker: pcommon.EthLogUnpacker{
-				AbiEvent:      xlaunch.BuyEvent,
-				TopicLen:      2,
-				DataUnpackLen: 6,
-			},
+/*
+newTopic2EventParser returns the parsers of a chain, the created parser only accepts the chain factory.
+The events are resolved in the ABI registry, after the ABIs of the registry directory are loaded.
+*/
+func newTopic2EventParser(chain *chain_params.ChainParams) map[common.Hash]pcommon.EventParser {
+	return map[common.Hash]pcommon.EventParser{
+		xlaunch.CreatedTopic0: &CreatedEventParser{
+			TopicUnpacker: pcommon.NewTopicUnpacker(registry.KindXLaunchFactory, xlaunch.CreatedTopic0),
+			chain:         chain,
 		},
+		xlaunch.BuyTopic0:  &BuyEventParser{pcommon.NewTopicUnpacker(registry.KindXLaunchPool, xlaunch.BuyTopic0)},
+		xlaunch.SellTopic0: &SellEventParser{pcommon.NewTopicUnpacker(registry.KindXLaunchPool, xlaunch.SellTopic0)},
 	}
+}
 
-	sellEventParser = &SellEventParser{
-		pcommon.TopicUnpacker{
-			Topic: xlaunch.SellTopic0,
-			Unpacker: pcommon.EthLogUnpacker{
-				AbiEvent:      xlaunch.SellEvent,
-				TopicLen:      2,
-				DataUnpackLen: 5,
-			},
-		},
-	}
-
-	topic2EventParser = map[common.Hash]pcommon.EventParser{
-		xlaunch.CreatedTopic0: createdEventParser,
-		xlaunch.BuyTopic0:     buyEventParser,
-		xlaunch.SellTopic0:    sellEventParser,
-	}
-)
-
-func Reg(registrable pcommon.Registrable) {
-	for k, v := range topic2EventParser {
+func Reg(registrable pcommon.Registrable, chain *chain_params.ChainParams) {
+	for k, v := range newTopic2EventParser(chain) {
 		registrable.Register(k, v)
 	}
 }
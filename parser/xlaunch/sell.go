@@ -18,6 +18,10 @@ type SellEvent struct {
 	Fee               *big.Int
 }
 
+func (e *SellEvent) GetRecipient() common.Address {
+	return e.Seller
+}
+
 func (e *SellEvent) CanGetTx() bool {
 	return true
 }
@@ -26,7 +30,8 @@ func (e *SellEvent) GetTx(nativeTokenPrice decimal.Decimal) *orm.Tx {
 	tx := &orm.Tx{
 		TxHash:        e.TxHash.String(),
 		Event:         types.Sell,
-		Maker:         e.Seller.String(),
+		Maker:         e.Maker.String(),
+		Sender:        e.Sender.String(),
 		Token0Address: e.Pair.Token0.Address.String(),
 		Token1Address: e.Pair.Token1.Address.String(),
 		Block:         e.BlockNumber,
@@ -35,10 +40,11 @@ func (e *SellEvent) GetTx(nativeTokenPrice decimal.Decimal) *orm.Tx {
 		TxIndex:       e.LogIndex,
 		PairAddress:   e.Pair.Address.String(),
 		Program:       types.ProtocolNameXLaunch,
+		Flags:         e.Flags,
 	}
 
 	tx.Token0Amount, tx.Token1Amount = types.ParseAmount(e.TokenAmount, e.NativeTokenAmount, e.Pair)
-	tx.AmountUsd, tx.PriceUsd = types.CalcAmountAndPrice(nativeTokenPrice, tx.Token0Amount, tx.Token1Amount, e.Pair.Token1.Address)
+	tx.AmountUsd, tx.PriceUsd = types.CalcAmountAndPrice(nativeTokenPrice, tx.Token0Amount, tx.Token1Amount)
 	return tx
 }
 
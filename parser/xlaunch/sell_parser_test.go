@@ -1,20 +1,40 @@
 package event_parser
 
 import (
+	"bxs/abi/registry"
+	"bxs/chain/fake"
+	"bxs/chain_params"
 	"bxs/repository/orm"
 	"bxs/service"
 	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
+	"math/big"
 	"testing"
 )
 
+const sellTxHash = "0xfdb81c284653ef04c9a193da20c02c27d3259e5ebffde6669c1bab8e10a84262"
+
+var (
+	sellPool  = common.HexToAddress("0x87485818145cEC5017a6466AAD2Ef5FEeA99aaae")
+	sellToken = common.HexToAddress("0xFA4dA14E995408Fd456928F4a0512AC348de1794")
+)
+
+// scriptSell scripts the sell of the testnet tx 0x7cb0894568573d4bd590f185fa166fb73f64bbb827b362c0017de6473ad2849e, its log 2
+func scriptSell(c *service.TestChain) {
+	tokenAmount := new(big.Int).Mul(big.NewInt(82947864), big.NewInt(1e18))
+	sell := c.EventLog(registry.KindXLaunchPool, "Sell", sellPool, []common.Hash{common.BytesToHash(c.Sender().Bytes())},
+		big.NewInt(1483817027422798559), tokenAmount, big.NewInt(1483817027422798559), tokenAmount, big.NewInt(14838170274227985))
+	c.AddBlock(65764330, 1758900000, &fake.Tx{To: sellPool, Logs: append(service.OtherLogs(2), sell)})
+	c.SetXLaunchPool(sellPool, sellToken, "Sell Token", "SELL", new(big.Int).Mul(big.NewInt(1e9), big.NewInt(1e18)))
+}
+
 func TestSell(t *testing.T) {
-	// https://testnet.bscscan.com/tx/0x7cb0894568573d4bd590f185fa166fb73f64bbb827b362c0017de6473ad2849e#eventlog#2
-	tc := service.GetTestContext()
-	ethLog := tc.GetEthLog("0x7cb0894568573d4bd590f185fa166fb73f64bbb827b362c0017de6473ad2849e", 2)
+	tc := service.GetTestContext(t, "sell", scriptSell)
+	ethLog := tc.GetEthLog(sellTxHash, 2)
 
-	event, pErr := topic2EventParser[ethLog.Topics[0]].Parse(ethLog)
+	event, pErr := newTopic2EventParser(chain_params.G)[ethLog.Topics[0]].Parse(ethLog)
 	require.NoError(t, pErr)
 
 	pairWrap := tc.PairService.GetPair(event.GetPairAddress())
@@ -24,16 +44,16 @@ func TestSell(t *testing.T) {
 	expectAmt0, _ := decimal.NewFromString("82947864")
 	expectAmt1, _ := decimal.NewFromString("1.483817027422798559")
 	expectTx := &orm.Tx{
-		TxHash:        "0x7cb0894568573d4bd590f185fa166fb73f64bbb827b362c0017de6473ad2849e",
+		TxHash:        sellTxHash,
 		Event:         types.Sell,
 		Token0Amount:  expectAmt0,
 		Token1Amount:  expectAmt1,
-		Token0Address: "0xFA4dA14E995408Fd456928F4a0512AC348de1794",
+		Token0Address: sellToken.String(),
 		Token1Address: types.ZeroAddress.String(),
 		Block:         65764330,
 		BlockIndex:    0,
 		TxIndex:       2,
-		PairAddress:   "0x87485818145cEC5017a6466AAD2Ef5FEeA99aaae",
+		PairAddress:   sellPool.String(),
 		Program:       types.ProtocolNameXLaunch,
 	}
 	require.True(t, tx.Equal(expectTx), "expect: %v, actual: %v", expectTx, tx)
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bxs/archive"
+	"bxs/block_getter"
+	"bxs/cache"
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/health"
+	"bxs/logger"
+	"bxs/parser"
+	"bxs/sequencer"
+	"bxs/service"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+pipeline indexes a chain: its block getter and parser, with their cache, clients and sequencers.
+The pipelines of the chains share the databases, the kafka sender and the clickhouse writer.
+*/
+type pipeline struct {
+	chain            *chain_params.ChainParams
+	multiChain       bool // names and paths are suffixed with the chain name
+	cache            cache.Cache
+	redisCli         *redis.Client // nil unless the cache is on redis
+	wsEthClient      *ethclient.Client
+	ethClientArchive *ethclient.Client // answers nothing in replay mode, the calls are answered from the archive
+	callStore        archive.CallStore // nil when the blocks are neither archived nor replayed
+	getterSequencer  sequencer.Sequencer
+	parserSequencer  sequencer.Sequencer
+	blockParser      parser.BlockParser
+	blockGetter      block_getter.BlockGetter
+	priceService     service.PriceService
+	startBlockNumber uint64
+	wg               *sync.WaitGroup
+}
+
+func newPipeline(chain *chain_params.ChainParams, multiChain bool) *pipeline {
+	return &pipeline{
+		chain:      chain,
+		multiChain: multiChain,
+		wg:         &sync.WaitGroup{},
+	}
+}
+
+// name scopes a name to the chain when several chains are indexed
+func (p *pipeline) name(name string) string {
+	if !p.multiChain {
+		return name
+	}
+	return p.chain.Name + "/" + name
+}
+
+// filePath suffixes the base name of a file with the chain when several chains are indexed: data/cache.db -> data/cache.bsc.db
+func (p *pipeline) filePath(path string) string {
+	if !p.multiChain || path == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + p.chain.Name + ext
+}
+
+// dirPath puts a directory of the chain under dir when several chains are indexed: data/archive -> data/archive/bsc
+func (p *pipeline) dirPath(dir string) string {
+	if !p.multiChain {
+		return dir
+	}
+	return filepath.Join(dir, p.chain.Name)
+}
+
+func (p *pipeline) createCache() cache.Cache {
+	conf := *config.G.Cache
+	conf.BoltPath = p.filePath(conf.BoltPath)
+	conf.SnapshotPath = p.filePath(conf.SnapshotPath)
+
+	switch conf.Backend {
+	case "bolt":
+		c, err := cache.NewBoltCache(p.chain.Name, p.chain.ChainID, &conf)
+		if err != nil {
+			logger.G.Fatal("open bolt cache err", zap.String("chain", p.chain.Name), zap.Error(err))
+		}
+		return c
+	case "memory":
+		c, err := cache.NewMemoryCache(p.chain.Name, p.chain.ChainID, &conf)
+		if err != nil {
+			logger.G.Fatal("load memory cache snapshot err", zap.String("chain", p.chain.Name), zap.Error(err))
+		}
+		return c
+	case "redis", "":
+		p.redisCli = redis.NewClient(&redis.Options{
+			Addr:     config.G.Redis.Addr,
+			Username: config.G.Redis.Username,
+			Password: config.G.Redis.Password,
+		})
+		return cache.NewTwoTierCache(p.chain.Name, p.chain.ChainID, p.redisCli, &conf)
+	default:
+		logger.G.Fatal("unknown cache backend", zap.String("backend", conf.Backend))
+		return nil
+	}
+}
+
+func (p *pipeline) openArchive() archive.Archive {
+	a, err := archive.NewArchive(p.dirPath(config.G.Archive.Dir), p.chain.ChainConfig)
+	if err != nil {
+		logger.G.Fatal("open archive err", zap.String("chain", p.chain.Name), zap.Error(err))
+	}
+	return a
+}
+
+// replay reports if the blocks are replayed from the archive
+func replay() bool {
+	return config.G.BlockGetter.Mode == block_getter.ModeReplay
+}
+
+// createArchive returns nil when the blocks are neither archived nor replayed
+func (p *pipeline) createArchive() archive.Archive {
+	if !config.G.Archive.Enabled && !replay() {
+		return nil
+	}
+	return p.openArchive()
+}
+
+// createCallStore returns nil when the blocks are neither archived nor replayed
+func (p *pipeline) createCallStore() archive.CallStore {
+	if !config.G.Archive.Enabled && !replay() {
+		return nil
+	}
+	calls, err := archive.NewCallStore(p.dirPath(config.G.Archive.Dir))
+	if err != nil {
+		logger.G.Fatal("open archive calls err", zap.String("chain", p.chain.Name), zap.Error(err))
+	}
+	return calls
+}
+
+func (p *pipeline) warmup(repos *repositories) {
+	// parsing with a partial cache drops the swaps of every pair missing from it
+	err := service.NewCacheWarmer(p.chain, p.cache, repos.token, repos.pair, repos.action, config.G.CacheWarmup.BatchSize).Warmup()
+	if err != nil {
+		logger.G.Fatal("cache warmup err", zap.String("chain", p.chain.Name), zap.Error(err))
+	}
+}
+
+// start connects to the nodes of the chain and starts its getter and parser from the start block
+func (p *pipeline) start(ctx context.Context, repos *repositories, kafkaSender service.KafkaSender, clickHouseWriter service.ClickHouseWriter) {
+	var (
+		err           error
+		ethClientPool block_getter.EthClientPool
+	)
+	// replay reads the blocks from the archive and the head from its highest block, and the calls from the archive, it makes no RPC
+	if replay() {
+		p.ethClientArchive = ethclient.NewClient(rpc.DialInProc(rpc.NewServer()))
+	} else {
+		p.wsEthClient, err = ethclient.Dial(p.chain.Rpc.WsEndpoint)
+		if err != nil {
+			logger.G.Fatal("Failed to connect to the chain(ws): %v", zap.String("chain", p.chain.Name), zap.Error(err))
+		}
+		ethClientPool = block_getter.NewEthClientPool(p.chain.Rpc.WsEndpoint, config.G.BlockGetter.PoolSize)
+
+		p.ethClientArchive, err = ethclient.Dial(p.chain.Rpc.EndpointArchive)
+		if err != nil {
+			logger.G.Fatal("Failed to connect to the archive node(http): %v", zap.String("chain", p.chain.Name), zap.Error(err))
+		}
+	}
+
+	p.startWithClients(ctx, ethClientPool, createDBService(p.chain.Name, repos), kafkaSender, clickHouseWriter)
+}
+
+// startWithClients builds the getter and the parser of the chain on the connected clients and starts them from the start block
+func (p *pipeline) startWithClients(
+	ctx context.Context,
+	ethClientPool block_getter.EthClientPool,
+	dbService service.DBService,
+	kafkaSender service.KafkaSender,
+	clickHouseWriter service.ClickHouseWriter,
+) {
+	if replay() && !config.G.PriceService.FromChain {
+		logger.G.Fatal("replay needs price_service.from_chain, the exchange prices are not archived", zap.String("chain", p.chain.Name))
+	}
+	p.callStore = p.createCallStore()
+	contractCallerArchive := service.NewContractCaller(ctx, p.chain, p.ethClientArchive, config.G.ContractCaller.Retry.GetRetryParams(), config.G.ContractCaller.Multicall)
+	if p.callStore != nil {
+		contractCallerArchive = contractCallerArchive.WithCallStore(p.callStore, replay())
+	}
+	p.priceService = service.NewPriceService(ctx, config.G.PriceService.FromChain, p.cache, contractCallerArchive, p.ethClientArchive, config.G.PriceService.PoolSize)
+
+	// the security checks and the supply refresh read the state of the head, it is not archived
+	if replay() && (config.G.TokenSecurity.Enabled || config.G.StateRefresh.TokenSupply) {
+		logger.G.Warn("token security and the supply refresh are not run in replay mode", zap.String("chain", p.chain.Name))
+	}
+	var tokenSecurity service.TokenSecurityService
+	if config.G.TokenSecurity.Enabled && !replay() {
+		if p.chain.PancakeV2RouterAddress == (common.Address{}) {
+			logger.G.Fatal("token security needs the pancake v2 router", zap.String("chain", p.chain.Name))
+		}
+		tokenSecurity = service.NewTokenSecurityService(ctx, p.chain, config.G.TokenSecurity, contractCallerArchive, dbService)
+		tokenSecurity.Start()
+	}
+	var tokenMetadata service.TokenMetadataService
+	if config.G.TokenMetadata.Enabled {
+		tokenMetadata = service.NewTokenMetadataService(ctx, config.G.TokenMetadata, dbService)
+		tokenMetadata.Start()
+	}
+	var supplyRefresher service.SupplyRefresher
+	if config.G.StateRefresh.TokenSupply && !replay() {
+		supplyRefresher = service.NewSupplyRefresher(ctx, p.chain.ChainID, config.G.StateRefresh, p.cache, contractCallerArchive, dbService)
+		supplyRefresher.Start()
+	}
+
+	p.parserSequencer = sequencer.NewSequencer(ctx, p.name("block_parser"))
+	topicRouter := parser.NewTopicRouter(p.chain)
+	p.blockParser = parser.NewBlockParser(
+		ctx,
+		p.chain,
+		p.cache,
+		p.parserSequencer,
+		p.priceService,
+		contractCallerArchive,
+		topicRouter,
+		kafkaSender,
+		dbService,
+		clickHouseWriter,
+		tokenSecurity,
+		tokenMetadata,
+		supplyRefresher,
+	)
+	p.wg.Add(1)
+	p.blockParser.Start(p.wg)
+
+	p.getterSequencer = sequencer.NewSequencer(ctx, p.name("block_getter"))
+	p.blockGetter = block_getter.NewBlockGetter(ctx, p.chain.Name, config.G.BlockGetter.SubHeader, p.wsEthClient, ethClientPool, p.cache, p.getterSequencer, config.G.BlockGetter.Retry.GetRetryParams(), topicRouter.FilterQueries(), p.createArchive())
+	p.startBlockNumber = p.blockGetter.GetStartBlockNumber(p.chain.StartBlockNumber)
+	if p.startBlockNumber == 0 {
+		logger.G.Fatal("start block number is zero", zap.String("chain", p.chain.Name))
+	}
+
+	p.getterSequencer.Init(p.startBlockNumber)
+	p.parserSequencer.Init(p.startBlockNumber)
+
+	// the replayed prices are read from the archive block by block, there is no head to prefetch them from
+	if !replay() {
+		p.priceService.Start(p.startBlockNumber)
+	}
+	p.blockGetter.Start()
+	p.blockGetter.StartDispatch(p.startBlockNumber)
+}
+
+// addHealthChecks adds the checks of the chain, once started
+func (p *pipeline) addHealthChecks(checker *health.Checker, conf *config.HealthConf) {
+	if p.wsEthClient != nil {
+		checker.Add(p.name("rpc"), false, health.Ping(func(ctx context.Context) error {
+			_, err := p.wsEthClient.BlockNumber(ctx)
+			return err
+		}))
+	}
+	if !replay() {
+		checker.Add(p.name("rpc_archive"), false, health.Ping(func(ctx context.Context) error {
+			_, err := p.ethClientArchive.BlockNumber(ctx)
+			return err
+		}))
+	}
+	if p.redisCli != nil {
+		checker.Add(p.name("redis"), false, health.Ping(func(ctx context.Context) error {
+			return p.redisCli.Ping(ctx).Err()
+		}))
+	}
+
+	checker.Add(p.name("lag"), false, health.MaxLag(p.blockGetter.Head, p.blockParser.Committed, conf.MaxLagBlocks))
+	// the blocks skipped by the skip gap policy are missing from the index until backfilled
+	checker.Add(p.name("getter_gaps"), false, health.NoGap(p.getterSequencer.Gaps))
+	checker.Add(p.name("parser_gaps"), false, health.NoGap(p.parserSequencer.Gaps))
+	// a stalled sequencer stops the commits, an idle chain does not: every block is committed, empty or not
+	checker.Add(p.name("last_commit"), true, health.MaxAge(p.blockParser.LastCommit, time.Duration(conf.MaxCommitAgeSec)*time.Second))
+	checker.Add(p.name("price"), true, health.MaxAge(p.priceService.UpdatedAt, time.Duration(conf.MaxPriceAgeSec)*time.Second))
+}
+
+// run feeds the parser until the getter is stopped and returns once every parsed block is committed
+func (p *pipeline) run() {
+	for {
+		blockCtx := p.blockGetter.Next()
+		if blockCtx == nil {
+			logger.G.Info("no more block to parse", zap.String("chain", p.chain.Name))
+			p.blockParser.Stop()
+			break
+		}
+		p.blockParser.ParseBlockAsync(blockCtx)
+	}
+
+	logger.G.Info("wait all block commited", zap.String("chain", p.chain.Name))
+	p.wg.Wait()
+}
+
+// reportUncommitted logs the dispatched blocks not committed yet, they are parsed again on restart
+func (p *pipeline) reportUncommitted() {
+	finishedBlock := p.cache.GetFinishedBlock()
+	dispatched := p.blockGetter.Dispatched()
+	from := max(finishedBlock+1, p.startBlockNumber)
+	if dispatched < from {
+		logger.G.Info("no uncommitted block", zap.String("chain", p.chain.Name), zap.Uint64("finished block", finishedBlock))
+		return
+	}
+
+	logger.G.Warn("uncommitted blocks",
+		zap.String("chain", p.chain.Name),
+		zap.Uint64("from", from),
+		zap.Uint64("to", dispatched),
+		zap.Uint64("finished block", finishedBlock),
+		zap.Uint64s("parsed not committed", p.parserSequencer.Buffered()),
+		zap.Uint64s("parser missing", p.parserSequencer.Missing()),
+		zap.Any("getter skipped gaps", p.getterSequencer.Gaps()),
+		zap.Any("parser skipped gaps", p.parserSequencer.Gaps()))
+}
+
+func (p *pipeline) close() {
+	if p.wsEthClient != nil {
+		p.wsEthClient.Close()
+	}
+	if p.ethClientArchive != nil {
+		p.ethClientArchive.Close()
+	}
+	if p.callStore != nil {
+		if err := p.callStore.Close(); err != nil {
+			logger.G.Error("close archive calls err", zap.String("chain", p.chain.Name), zap.Error(err))
+		}
+	}
+	if err := p.cache.Close(); err != nil {
+		logger.G.Error("close cache err", zap.String("chain", p.chain.Name), zap.Error(err))
+	}
+}
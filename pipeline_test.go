@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bxs/abi/registry"
+	"bxs/block_getter"
+	"bxs/cache"
+	"bxs/chain/fake"
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/health"
+	"bxs/metrics"
+	"bxs/service"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the emitted messages")
+
+var (
+	e18            = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	xLaunchFactory = common.HexToAddress("0x00000000000000000000000000000000000f1a00")
+	xLaunchPool    = common.HexToAddress("0x00000000000000000000000000000000000f1a01")
+	xLaunchToken   = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	pancakePair    = common.HexToAddress("0x00000000000000000000000000000000000f1a02")
+	trader         = common.HexToAddress("0x00000000000000000000000000000000000b0b01")
+)
+
+func wei(n int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(n), e18)
+}
+
+func addressTopic(address common.Address) common.Hash {
+	return common.BytesToHash(address.Bytes())
+}
+
+// eventLog builds the log of an event of the registry, the indexed arguments are given as topics
+func eventLog(t *testing.T, kind, name string, address common.Address, topics []common.Hash, args ...any) *ethtypes.Log {
+	contractAbi, err := registry.G.ABI(kind)
+	require.NoError(t, err)
+	event, ok := contractAbi.Events[name]
+	require.True(t, ok, name)
+
+	data, err := event.Inputs.NonIndexed().Pack(args...)
+	require.NoError(t, err)
+	return &ethtypes.Log{
+		Address: address,
+		Topics:  append([]common.Hash{event.ID}, topics...),
+		Data:    data,
+	}
+}
+
+/*
+scriptChain scripts a token launched on xLaunch, traded until its pool migrates,
+then listed and traded on a PancakeV2 pair, and an empty block
+*/
+func scriptChain(t *testing.T, chain *chain_params.ChainParams, from uint64) *fake.Chain {
+	fc := fake.NewChain(chain)
+
+	getReserves, err := registry.G.Pack(registry.KindPancakeV2Pair, "getReserves")
+	require.NoError(t, err)
+	method, err := registry.G.Method(registry.KindPancakeV2Pair, "getReserves")
+	require.NoError(t, err)
+	reserves, err := method.Outputs.Pack(wei(1000), wei(600000), uint32(0)) // 600 USD
+	require.NoError(t, err)
+	fc.SetCall(chain.PricePairAddress, getReserves, reserves)
+
+	blockTime := uint64(1760000000)
+	created := eventLog(t, registry.KindXLaunchFactory, "Created", xLaunchFactory,
+		[]common.Hash{addressTopic(xLaunchPool), addressTopic(fc.Sender()), addressTopic(xLaunchToken)},
+		big.NewInt(0), wei(800000000), wei(1000000000), "Fake Dog", "FDOG", "tid-1", "bafkreifakedog", "the fake dog", "t.me/fakedog", "fakedog", "fakedog.io")
+	fc.AddBlock(from, blockTime, &fake.Tx{To: xLaunchFactory, Logs: []*ethtypes.Log{created}})
+
+	buy := eventLog(t, registry.KindXLaunchPool, "Buy", xLaunchPool, []common.Hash{addressTopic(fc.Sender())},
+		wei(1), wei(10000000), wei(1), wei(10000000), big.NewInt(1e16), false)
+	sell := eventLog(t, registry.KindXLaunchPool, "Sell", xLaunchPool, []common.Hash{addressTopic(fc.Sender())},
+		big.NewInt(5e17), wei(5000000), big.NewInt(5e17), wei(5000000), big.NewInt(5e15))
+	fc.AddBlock(from+1, blockTime+3,
+		&fake.Tx{To: xLaunchPool, Value: e18, Logs: []*ethtypes.Log{buy}},
+		&fake.Tx{To: xLaunchPool, Logs: []*ethtypes.Log{sell}},
+		&fake.Tx{To: xLaunchPool, Failed: true},
+	)
+
+	migrate := eventLog(t, registry.KindXLaunchPool, "Buy", xLaunchPool, []common.Hash{addressTopic(fc.Sender())},
+		wei(20), wei(790000000), wei(20), wei(800000000), big.NewInt(2e17), true)
+	fc.AddBlock(from+2, blockTime+6, &fake.Tx{To: xLaunchPool, Value: wei(20), Logs: []*ethtypes.Log{migrate}})
+
+	// the token sorts before WBNB, it is token0 of the pair
+	pairCreated := eventLog(t, registry.KindPancakeV2Factory, "PairCreated", chain.PancakeV2FactoryAddress,
+		[]common.Hash{addressTopic(xLaunchToken), addressTopic(chain.WrappedNativeAddress)}, pancakePair, big.NewInt(1))
+	listed := eventLog(t, registry.KindPancakeV2Pair, "Sync", pancakePair, nil, wei(200000000), wei(20))
+	swap := eventLog(t, registry.KindPancakeV2Pair, "Swap", pancakePair,
+		[]common.Hash{addressTopic(chain.PancakeV2RouterAddress), addressTopic(trader)},
+		big.NewInt(0), wei(2), wei(18000000), big.NewInt(0))
+	swapped := eventLog(t, registry.KindPancakeV2Pair, "Sync", pancakePair, nil, wei(182000000), wei(22))
+	fc.AddBlock(from+3, blockTime+9,
+		&fake.Tx{To: chain.PancakeV2FactoryAddress, Logs: []*ethtypes.Log{pairCreated, listed}},
+		&fake.Tx{To: chain.PancakeV2RouterAddress, Value: wei(2), Logs: []*ethtypes.Log{swapped, swap}},
+	)
+
+	fc.AddBlock(from+4, blockTime+12)
+	return fc
+}
+
+// setFakeChainConf configures the pipeline for the scripted chain, until the test ends
+func setFakeChainConf(t *testing.T) *chain_params.ChainParams {
+	saved := config.G
+	t.Cleanup(func() { config.G = saved })
+	blockGetterConf := *config.G.BlockGetter
+	blockGetterConf.Mode = block_getter.ModeBlock
+	blockGetterConf.SubHeader = false
+	blockGetterConf.PoolSize = 4
+	blockGetterConf.QueueSize = 10
+	config.G.BlockGetter = &blockGetterConf
+	config.G.BlockHandler = &config.BlockHandlerConf{PoolSize: 4, ParseTxPoolSize: 2, QueueSize: 10}
+	config.G.PriceService = &config.PriceServiceConf{FromChain: true}
+	config.G.Archive = &config.ArchiveConf{}
+	config.G.EnableSequencer = true
+	config.G.LaunchDetector = &config.LaunchDetectorConf{Enabled: true, SniperBlocks: 3, WatchBlocks: 1200, FundingBlocks: 600}
+	config.G.MevAnalyzer = &config.MevAnalyzerConf{Enabled: true}
+
+	chain, err := chain_params.FromConf(&config.ChainDefConf{Preset: chain_params.PresetChapel, Rpc: &config.ChainConf{}, XLaunchFactory: xLaunchFactory})
+	require.NoError(t, err)
+	chain.StartBlockNumber = 60000000
+	return chain
+}
+
+func TestPipeline_FakeChain(t *testing.T) {
+	chain := setFakeChainConf(t)
+	fc := scriptChain(t, chain, chain.StartBlockNumber)
+
+	provider := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(provider) })
+	spanRecorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+
+	metrics.EventsTotal.Reset()
+	metrics.DroppedEventsTotal.Reset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := newPipeline(chain, false)
+	p.cache = cache.NewMockCache()
+	p.wsEthClient = fc.Client()
+	p.ethClientArchive = fc.Client()
+	dbService := service.NewMemoryDBService()
+	kafkaSender := service.NewMemoryKafkaSender()
+	p.startWithClients(ctx, block_getter.NewStaticEthClientPool(fc.Client()), dbService, kafkaSender, service.NewClickHouseWriter(ctx, &config.ClickHouseConf{}))
+
+	require.Eventually(t, func() bool {
+		return p.blockGetter.Dispatched() == fc.Head()
+	}, 10*time.Second, 10*time.Millisecond)
+	p.blockGetter.Stop()
+	p.run()
+
+	// every block is committed, the indexer is ready
+	checker := health.NewChecker(time.Second)
+	p.addHealthChecks(checker, config.G.Health)
+	report := checker.Run(ctx, false)
+	reportJson, _ := json.Marshal(report)
+	require.Equal(t, health.StatusOk, report.Status, string(reportJson))
+	names := make([]string, 0, len(report.Checks))
+	for _, check := range report.Checks {
+		names = append(names, check.Name)
+	}
+	require.Equal(t, []string{"rpc", "rpc_archive", "lag", "getter_gaps", "parser_gaps", "last_commit", "price"}, names)
+	p.close()
+
+	msgs := kafkaSender.Msgs()
+	require.Len(t, msgs, int(fc.Head()-chain.StartBlockNumber+1))
+	require.Len(t, dbService.Tokens, 1)
+	require.Len(t, dbService.Pairs, 2)
+
+	actual, err := json.MarshalIndent(msgs, "", "  ")
+	require.NoError(t, err)
+	golden := filepath.Join("testdata", "pipeline_fake_chain.golden")
+	if *update {
+		require.NoError(t, os.MkdirAll("testdata", 0755))
+		require.NoError(t, os.WriteFile(golden, append(actual, '\n'), 0644))
+	}
+
+	expected, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(actual))
+
+	requireBlockTraces(t, spanRecorder.Ended(), len(msgs))
+	requireEventCounts(t, chain.Name)
+}
+
+// the archived blocks are parsed again with no RPC, the prices and calls answered from the archive
+func TestPipeline_Replay(t *testing.T) {
+	chain := setFakeChainConf(t)
+	config.G.Archive = &config.ArchiveConf{Enabled: true, Dir: t.TempDir()}
+	fc := scriptChain(t, chain, chain.StartBlockNumber)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := newPipeline(chain, false)
+	p.cache = cache.NewMockCache()
+	p.wsEthClient = fc.Client()
+	p.ethClientArchive = fc.Client()
+	kafkaSender := service.NewMemoryKafkaSender()
+	p.startWithClients(ctx, block_getter.NewStaticEthClientPool(fc.Client()), service.NewMemoryDBService(), kafkaSender, service.NewClickHouseWriter(ctx, &config.ClickHouseConf{}))
+	require.Eventually(t, func() bool {
+		return p.blockGetter.Dispatched() == fc.Head()
+	}, 10*time.Second, 10*time.Millisecond)
+	p.blockGetter.Stop()
+	p.run()
+	p.close()
+
+	blockGetterConf := *config.G.BlockGetter
+	blockGetterConf.Mode = block_getter.ModeReplay
+	config.G.BlockGetter = &blockGetterConf
+
+	// the client of the archive node answers no call
+	replayed := newPipeline(chain, false)
+	replayed.cache = cache.NewMockCache()
+	replayed.ethClientArchive = ethclient.NewClient(rpc.DialInProc(rpc.NewServer()))
+	replayedSender := service.NewMemoryKafkaSender()
+	replayed.startWithClients(ctx, nil, service.NewMemoryDBService(), replayedSender, service.NewClickHouseWriter(ctx, &config.ClickHouseConf{}))
+	replayed.run()
+	replayed.close()
+
+	expected, err := json.Marshal(kafkaSender.Msgs())
+	require.NoError(t, err)
+	actual, err := json.Marshal(replayedSender.Msgs())
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), string(actual))
+}
+
+// requireEventCounts checks every scripted event is counted, the migrating buy with the buys
+func requireEventCounts(t *testing.T, chainName string) {
+	for labels, cnt := range map[[2]string]float64{
+		{"xlaunch", "created"}:        1,
+		{"xlaunch", "buy"}:            2,
+		{"xlaunch", "sell"}:           1,
+		{"pancakev2", "pair_created"}: 1,
+		{"pancakev2", "swap"}:         1,
+		{"pancakev2", "sync"}:         2,
+	} {
+		require.Equal(t, cnt, testutil.ToFloat64(metrics.EventsTotal.WithLabelValues(chainName, labels[0], labels[1])), labels)
+	}
+	require.Zero(t, testutil.CollectAndCount(metrics.DroppedEventsTotal))
+}
+
+// requireBlockTraces checks each block is a trace with a span per stage, and the tokens created are stored in theirs
+func requireBlockTraces(t *testing.T, spans []sdktrace.ReadOnlySpan, blocks int) {
+	traceSpans := make(map[trace.TraceID][]string)
+	var roots []sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		traceId := span.SpanContext().TraceID()
+		traceSpans[traceId] = append(traceSpans[traceId], span.Name())
+		if span.Name() == "block" {
+			require.False(t, span.Parent().IsValid())
+			roots = append(roots, span)
+		}
+	}
+	require.Len(t, roots, blocks)
+
+	stages := []string{"get_block", "eth_getBlockByNumber", "eth_getBlockReceipts", "parse", "price", "recover_senders", "resolve", "commit"}
+	var tokenBatches int
+	for _, root := range roots {
+		names := traceSpans[root.SpanContext().TraceID()]
+		for _, stage := range stages {
+			require.Contains(t, names, stage)
+		}
+		if slices.Contains(names, "db.add_tokens") {
+			tokenBatches++
+		}
+	}
+	require.Equal(t, 1, tokenBatches)
+}
@@ -5,13 +5,15 @@ import (
 	"gorm.io/gorm"
 )
 
+// ActionRepository lists the actions of a single chain
 type ActionRepository struct {
 	*BaseRepository[orm.Action]
+	chainId int
 }
 
-func NewActionRepository(db *gorm.DB) *ActionRepository {
+func NewActionRepository(db *gorm.DB, chainId int) *ActionRepository {
 	baseRepo := NewBaseRepository[orm.Action](db)
-	return &ActionRepository{BaseRepository: baseRepo}
+	return &ActionRepository{BaseRepository: baseRepo, chainId: chainId}
 }
 
 func (r *ActionRepository) GetById(id string) (*orm.Action, error) {
@@ -26,3 +28,12 @@ func (r *ActionRepository) GetById(id string) (*orm.Action, error) {
 func (r *ActionRepository) DeleteById(id string) error {
 	return r.db.Where("id = ?", id).Delete(&orm.Action{}).Error
 }
+
+func (r *ActionRepository) ListByAction(action string) ([]*orm.Action, error) {
+	var actions []*orm.Action
+	err := r.db.Where("chain_id = ? AND action = ?", r.chainId, action).Find(&actions).Error
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
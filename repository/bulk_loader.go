@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+BulkLoad writes entities with postgres COPY into a temporary staging table,
+then merges the staging table into the target table in the same transaction:
+
+	CREATE TEMP TABLE staging (LIKE target INCLUDING DEFAULTS) ON COMMIT DROP
+	COPY staging (columns) FROM STDIN
+	INSERT INTO target (columns) SELECT columns FROM staging ON CONFLICT (conflictColumns) DO NOTHING
+
+Columns filled by database defaults (e.g. uuid primary keys) are left out of the COPY,
+so the staging table fills them the same way the target table would.
+It returns the number of rows actually inserted into the target table.
+*/
+func (r *BaseRepository[T]) BulkLoad(entities []*T, conflictColumns ...string) (int64, error) {
+	if len(entities) == 0 {
+		return 0, nil
+	}
+
+	stmt := &gorm.Statement{DB: r.db}
+	if err := stmt.Parse(new(T)); err != nil {
+		return 0, err
+	}
+
+	fields := copyFields(stmt.Schema)
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = field.DBName
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	rows := make([][]any, len(entities))
+	for i, entity := range entities {
+		row, err := copyRow(ctx, fields, reflect.ValueOf(entity).Elem(), now)
+		if err != nil {
+			return 0, err
+		}
+		rows[i] = row
+	}
+
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var inserted int64
+	err = conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("bulk load needs a pgx connection, got %T", driverConn)
+		}
+
+		inserted, err = copyAndMerge(ctx, stdlibConn.Conn(), stmt.Schema.Table, columns, rows, conflictColumns)
+		return err
+	})
+
+	return inserted, err
+}
+
+func copyAndMerge(ctx context.Context, conn *pgx.Conn, table string, columns []string, rows [][]any, conflictColumns []string) (int64, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	staging := "staging_" + table
+	_, err = tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP",
+		pgx.Identifier{staging}.Sanitize(), pgx.Identifier{table}.Sanitize()))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{staging}, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return 0, err
+	}
+
+	columnList := quoteColumns(columns)
+	merge := fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s",
+		pgx.Identifier{table}.Sanitize(), columnList, columnList, pgx.Identifier{staging}.Sanitize())
+	if len(conflictColumns) > 0 {
+		merge += fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", quoteColumns(conflictColumns))
+	}
+
+	tag, err := tx.Exec(ctx, merge)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func copyFields(s *schema.Schema) []*schema.Field {
+	fields := make([]*schema.Field, 0, len(s.Fields))
+	for _, field := range s.Fields {
+		if field.DBName == "" || !field.Creatable {
+			continue
+		}
+		if field.HasDefaultValue && field.DefaultValue != "" {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func copyRow(ctx context.Context, fields []*schema.Field, entity reflect.Value, now time.Time) ([]any, error) {
+	row := make([]any, len(fields))
+	for i, field := range fields {
+		if field.AutoCreateTime > 0 {
+			row[i] = now
+			continue
+		}
+
+		value, _ := field.ValueOf(ctx, entity)
+		if d, ok := value.(decimal.Decimal); ok {
+			numeric := pgtype.Numeric{}
+			if err := numeric.Scan(d.String()); err != nil {
+				return nil, err
+			}
+			value = numeric
+		}
+		row[i] = value
+	}
+	return row, nil
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"bxs/repository/orm"
+	"bxs/types"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm/schema"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCopyFields(t *testing.T) {
+	s, err := schema.Parse(&orm.Tx{}, &sync.Map{}, schema.NamingStrategy{})
+	require.NoError(t, err)
+
+	columns := make([]string, 0, len(s.Fields))
+	for _, field := range copyFields(s) {
+		columns = append(columns, field.DBName)
+	}
+
+	require.NotContains(t, columns, "id")
+	require.Contains(t, columns, "created_at")
+	require.Contains(t, columns, "token0_amount")
+	require.Contains(t, columns, "tx_index")
+}
+
+func TestTxRepository_BulkLoad(t *testing.T) {
+	txRepository := prepareTxTest()
+	if txRepository.db.Name() != DriverPostgres {
+		t.Skip("bulk load needs postgres, set BXS_TEST_PG_DSN")
+	}
+
+	txs := make([]*orm.Tx, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		txs = append(txs, &orm.Tx{
+			TxHash:        fmt.Sprintf("0xb%d", i),
+			Event:         "buy",
+			Token0Amount:  decimal.NewFromFloat(0.001),
+			Token1Amount:  decimal.NewFromFloat(0.002),
+			Maker:         "0xb1",
+			Token0Address: "0xb1",
+			Token1Address: "0xb1",
+			AmountUsd:     decimal.NewFromFloat(0.003),
+			PriceUsd:      decimal.NewFromFloat(0.004),
+			Block:         100,
+			BlockAt:       time.Now(),
+			BlockIndex:    uint(i),
+			TxIndex:       1,
+			PairAddress:   "0xb1",
+			Program:       types.ProtocolNameXLaunch,
+		})
+	}
+
+	inserted, err := txRepository.BulkLoad(txs, "token0_address", "block", "block_index", "tx_index")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(txs)), inserted)
+
+	// replaying the same rows must not insert anything
+	inserted, err = txRepository.BulkLoad(txs, "token0_address", "block", "block_index", "tx_index")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), inserted)
+
+	txIds := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		txQueried, getErr := txRepository.GetByUniqIndex(tx.Token0Address, tx.Block, tx.BlockIndex, tx.TxIndex)
+		require.Nil(t, getErr)
+		require.True(t, tx.Equal(txQueried))
+		txIds = append(txIds, txQueried.Id.String())
+	}
+	cleanupTxTest(txRepository, txIds...)
+}
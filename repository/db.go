@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"bxs/config"
+	"fmt"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"reflect"
+	"strings"
+)
+
+const (
+	DriverPostgres = "postgres"
+	DriverSqlite   = "sqlite"
+)
+
+// sqliteUUIDv4 is the sqlite counterpart of uuid_generate_v4(), in the canonical 8-4-4-4-12 form
+const sqliteUUIDv4 = `lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' ||
+	substr('89ab', 1 + (abs(random()) % 4), 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))`
+
+// sqliteTxDDL is the tx table of sqliteDDLs, also the one its legacy tables are rebuilt into
+const sqliteTxDDL = `CREATE TABLE IF NOT EXISTS tx (
+	id TEXT PRIMARY KEY DEFAULT (` + sqliteUUIDv4 + `),
+	tx_hash TEXT,
+	event TEXT,
+	token0_amount TEXT,
+	token1_amount TEXT,
+	maker TEXT,
+	sender TEXT,
+	token0_address TEXT NOT NULL,
+	token1_address TEXT,
+	amount_usd TEXT,
+	price_usd TEXT,
+	block INTEGER NOT NULL,
+	block_at DATETIME,
+	block_index INTEGER NOT NULL,
+	tx_index INTEGER NOT NULL,
+	pair_address TEXT,
+	program TEXT,
+	flags INTEGER NOT NULL DEFAULT 0,
+	chain_id INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME
+)`
+
+/*
+sqliteDDLs creates the tables of the embedded(sqlite) storage.
+The postgres tables are managed outside the indexer, the columns and tables added since are in
+the migrations/postgres files, applied by MigratePostgres. The sqlite ones are created on open,
+with the same unique keys the CreateBatch conflict columns rely on.
+Decimals are kept as TEXT so no precision is lost to sqlite REAL affinity.
+*/
+var sqliteDDLs = []string{
+	`CREATE TABLE IF NOT EXISTS token (
+		address TEXT NOT NULL,
+		creator TEXT,
+		name TEXT,
+		symbol TEXT,
+		decimal INTEGER,
+		total_supply TEXT,
+		chain_id INTEGER NOT NULL,
+		block INTEGER,
+		block_at DATETIME,
+		program TEXT,
+		created_at DATETIME,
+		main_pair TEXT,
+		cid TEXT,
+		tid TEXT,
+		description TEXT,
+		telegram TEXT,
+		twitter TEXT,
+		website TEXT,
+		buy_tax TEXT,
+		sell_tax TEXT,
+		transfer_tax TEXT,
+		security_flags INTEGER NOT NULL DEFAULT 0,
+		security_at DATETIME,
+		logo TEXT,
+		metadata_flags INTEGER NOT NULL DEFAULT 0,
+		metadata_at DATETIME,
+		UNIQUE (address, chain_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS pair (
+		name TEXT,
+		address TEXT NOT NULL,
+		token0 TEXT,
+		token1 TEXT,
+		chain_id INTEGER NOT NULL,
+		reserve0 TEXT,
+		reserve1 TEXT,
+		reserve_block INTEGER NOT NULL DEFAULT 0,
+		block INTEGER,
+		block_at DATETIME,
+		program TEXT,
+		created_at DATETIME,
+		UNIQUE (address, chain_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS token_supply_change (
+		id TEXT PRIMARY KEY DEFAULT (` + sqliteUUIDv4 + `),
+		token TEXT NOT NULL,
+		chain_id INTEGER NOT NULL,
+		block INTEGER NOT NULL,
+		old_supply TEXT,
+		new_supply TEXT,
+		created_at DATETIME
+	)`,
+	sqliteTxDDL,
+	`CREATE TABLE IF NOT EXISTS action (
+		id TEXT PRIMARY KEY DEFAULT (` + sqliteUUIDv4 + `),
+		maker TEXT,
+		token TEXT,
+		pair TEXT,
+		action TEXT,
+		tx_hash TEXT,
+		creator TEXT,
+		block INTEGER,
+		block_at DATETIME,
+		chain_id INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME
+	)`,
+	`CREATE TABLE IF NOT EXISTS failed_swap (
+		id TEXT PRIMARY KEY DEFAULT (` + sqliteUUIDv4 + `),
+		chain_id INTEGER NOT NULL,
+		tx_hash TEXT NOT NULL,
+		sender TEXT,
+		to_address TEXT,
+		method TEXT,
+		event TEXT,
+		token TEXT,
+		token_amount TEXT,
+		native_amount TEXT,
+		revert_reason TEXT,
+		gas_used INTEGER,
+		gas_fee TEXT,
+		block INTEGER NOT NULL,
+		block_index INTEGER,
+		block_at DATETIME,
+		created_at DATETIME,
+		UNIQUE (chain_id, tx_hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS mev_event (
+		id TEXT PRIMARY KEY DEFAULT (` + sqliteUUIDv4 + `),
+		chain_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		block INTEGER NOT NULL,
+		block_at DATETIME,
+		pool TEXT NOT NULL,
+		token TEXT,
+		attacker TEXT,
+		attacker_txs TEXT NOT NULL,
+		victims TEXT,
+		victim_txs TEXT,
+		profit_usd TEXT,
+		created_at DATETIME,
+		UNIQUE (chain_id, block, kind, pool, attacker_txs)
+	)`,
+}
+
+// sqliteColumns are added to the tables created before them
+var sqliteColumns = []struct {
+	table  string
+	column string
+	ddl    string
+}{
+	{"tx", "sender", "ALTER TABLE tx ADD COLUMN sender TEXT"},
+	{"tx", "flags", "ALTER TABLE tx ADD COLUMN flags INTEGER NOT NULL DEFAULT 0"},
+	{"token", "buy_tax", "ALTER TABLE token ADD COLUMN buy_tax TEXT"},
+	{"token", "sell_tax", "ALTER TABLE token ADD COLUMN sell_tax TEXT"},
+	{"token", "transfer_tax", "ALTER TABLE token ADD COLUMN transfer_tax TEXT"},
+	{"token", "security_flags", "ALTER TABLE token ADD COLUMN security_flags INTEGER NOT NULL DEFAULT 0"},
+	{"token", "security_at", "ALTER TABLE token ADD COLUMN security_at DATETIME"},
+	{"token", "logo", "ALTER TABLE token ADD COLUMN logo TEXT"},
+	{"token", "metadata_flags", "ALTER TABLE token ADD COLUMN metadata_flags INTEGER NOT NULL DEFAULT 0"},
+	{"token", "metadata_at", "ALTER TABLE token ADD COLUMN metadata_at DATETIME"},
+	{"pair", "reserve_block", "ALTER TABLE pair ADD COLUMN reserve_block INTEGER NOT NULL DEFAULT 0"},
+	{"tx", "chain_id", "ALTER TABLE tx ADD COLUMN chain_id INTEGER NOT NULL DEFAULT 0"},
+	{"action", "chain_id", "ALTER TABLE action ADD COLUMN chain_id INTEGER NOT NULL DEFAULT 0"},
+}
+
+// sqliteIndexes are created once the columns are added, the tx key has the chain since the chains share the table
+
+var sqliteIndexes = []string{
+	"CREATE UNIQUE INDEX IF NOT EXISTS tx_chain_id_uniq ON tx (chain_id, token0_address, block, block_index, tx_index)",
+	"CREATE INDEX IF NOT EXISTS failed_swap_token ON failed_swap (chain_id, token, block)",
+	"CREATE INDEX IF NOT EXISTS mev_event_token ON mev_event (chain_id, token, block)",
+}
+
+func OpenDB(conf *config.DBConf) (*gorm.DB, error) {
+	switch conf.Driver {
+	case DriverSqlite:
+		return OpenSqlite(conf.SqlitePath)
+	case DriverPostgres, "":
+		db, err := gorm.Open(postgres.Open(conf.DBDatasource.GetPostgresDsn()))
+		if err != nil || !conf.Migrate {
+			return db, err
+		}
+		return db, MigratePostgres(db)
+	default:
+		return nil, fmt.Errorf("unknown db driver: %s", conf.Driver)
+	}
+}
+
+func OpenSqlite(path string) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := gorm.Open(sqlite.Open(dsn))
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite has a single writer, serialize on one connection instead of failing with SQLITE_BUSY
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	for _, ddl := range sqliteDDLs {
+		if err = db.Exec(ddl).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range sqliteColumns {
+		var n int64
+		err = db.Raw("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&n).Error
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			if err = db.Exec(c.ddl).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = dropSqliteTxKey(db); err != nil {
+		return nil, err
+	}
+
+	for _, ddl := range sqliteIndexes {
+		if err = db.Exec(ddl).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	err = db.Callback().Create().Before("gorm:create").Register("bxs:fill_uuid", fillUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+/*
+dropSqliteTxKey rebuilds a tx table created with UNIQUE (token0_address, block, block_index, tx_index),
+it would reject the txs of another chain at the same position and sqlite can not drop a table constraint.
+The rows are copied to a table created with sqliteTxDDL, in a single transaction.
+*/
+func dropSqliteTxKey(db *gorm.DB) error {
+	var indexes []struct {
+		Name   string
+		Unique bool
+		Origin string
+	}
+	if err := db.Raw(`SELECT name, "unique", origin FROM pragma_index_list('tx')`).Scan(&indexes).Error; err != nil {
+		return err
+	}
+
+	legacy := false
+	for _, index := range indexes {
+		if !index.Unique || index.Origin != "u" {
+			continue
+		}
+		var columns []string
+		if err := db.Raw("SELECT name FROM pragma_index_info(?) ORDER BY seqno", index.Name).Scan(&columns).Error; err != nil {
+			return err
+		}
+		if strings.Join(columns, ",") == "token0_address,block,block_index,tx_index" {
+			legacy = true
+		}
+	}
+	if !legacy {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE tx RENAME TO tx_legacy").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(sqliteTxDDL).Error; err != nil {
+			return err
+		}
+		var columns []string
+		if err := tx.Raw("SELECT name FROM pragma_table_info('tx') ORDER BY cid").Scan(&columns).Error; err != nil {
+			return err
+		}
+		// the legacy table has every column, sqliteColumns were added to it first
+		list := strings.Join(columns, ", ")
+		if err := tx.Exec("INSERT INTO tx (" + list + ") SELECT " + list + " FROM tx_legacy").Error; err != nil {
+			return err
+		}
+		return tx.Exec("DROP TABLE tx_legacy").Error
+	})
+}
+
+/*
+fillUUID sets zero uuid primary keys before insert.
+gorm writes DEFAULT for them when a batch mixes set and unset ids, which sqlite does not accept.
+*/
+func fillUUID(db *gorm.DB) {
+	if db.Statement.Schema == nil || db.Statement.Schema.PrioritizedPrimaryField == nil {
+		return
+	}
+
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field.FieldType != reflect.TypeOf(uuid.UUID{}) {
+		return
+	}
+
+	fill := func(rv reflect.Value) {
+		if _, isZero := field.ValueOf(db.Statement.Context, rv); isZero {
+			if err := field.Set(db.Statement.Context, rv, uuid.New()); err != nil {
+				db.AddError(err)
+			}
+		}
+	}
+
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			fill(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		fill(rv)
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB opens the postgres given by BXS_TEST_PG_DSN, or a fresh sqlite file when it is not set
+func openTestDB() *gorm.DB {
+	if dsn := os.Getenv("BXS_TEST_PG_DSN"); dsn != "" {
+		db, err := gorm.Open(postgres.Open(dsn))
+		if err != nil {
+			panic(err)
+		}
+		return db
+	}
+
+	dir, err := os.MkdirTemp("", "bxs-repository-test")
+	if err != nil {
+		panic(err)
+	}
+
+	db, err := OpenSqlite(filepath.Join(dir, "test.db"))
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func TestOpenSqlite_AddColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := gorm.Open(sqlite.Open(path))
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("CREATE TABLE tx (id TEXT PRIMARY KEY, maker TEXT, token0_address TEXT, block INTEGER, block_index INTEGER, tx_index INTEGER)").Error)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	// opening twice checks the column is only added once
+	for range 2 {
+		db, err = OpenSqlite(path)
+		require.NoError(t, err)
+
+		var n int64
+		require.NoError(t, db.Raw("SELECT COUNT(*) FROM pragma_table_info('tx') WHERE name = 'sender'").Scan(&n).Error)
+		require.EqualValues(t, 1, n)
+
+		sqlDB, err = db.DB()
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	}
+}
+
+// a tx table keyed without the chain is rebuilt with the chain key, its rows kept
+func TestOpenSqlite_RebuildTxKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := gorm.Open(sqlite.Open(path))
+	require.NoError(t, err)
+	require.NoError(t, db.Exec(`CREATE TABLE tx (id TEXT PRIMARY KEY, tx_hash TEXT, event TEXT, token0_amount TEXT, token1_amount TEXT,
+		maker TEXT, token0_address TEXT NOT NULL, token1_address TEXT, amount_usd TEXT, price_usd TEXT, block INTEGER NOT NULL,
+		block_at DATETIME, block_index INTEGER NOT NULL, tx_index INTEGER NOT NULL, pair_address TEXT, program TEXT, created_at DATETIME,
+		UNIQUE (token0_address, block, block_index, tx_index))`).Error)
+	require.NoError(t, db.Exec("INSERT INTO tx (id, tx_hash, token0_address, block, block_index, tx_index) VALUES ('a', '0xa1', '0xa1', 1, 1, 1)").Error)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	for range 2 {
+		db, err = OpenSqlite(path)
+		require.NoError(t, err)
+		require.NoError(t, BackfillChainId(db, []int{56}))
+
+		// the same position on another chain is not a duplicate
+		require.NoError(t, db.Exec("INSERT OR IGNORE INTO tx (tx_hash, token0_address, block, block_index, tx_index, chain_id) VALUES ('0xa1', '0xa1', 1, 1, 1, 97)").Error)
+		var rows []struct {
+			TxHash  string
+			ChainId int
+		}
+		require.NoError(t, db.Raw("SELECT tx_hash, chain_id FROM tx ORDER BY chain_id").Scan(&rows).Error)
+		require.Len(t, rows, 2)
+		require.Equal(t, 56, rows[0].ChainId)
+		require.Equal(t, 97, rows[1].ChainId)
+
+		// the same position on the same chain is
+		require.Error(t, db.Exec("INSERT INTO tx (tx_hash, token0_address, block, block_index, tx_index, chain_id) VALUES ('0xa1', '0xa1', 1, 1, 1, 56)").Error)
+
+		sqlDB, err = db.DB()
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	}
+}
+
+func TestBackfillChainId(t *testing.T) {
+	db, err := OpenSqlite(filepath.Join(t.TempDir(), "backfill.db"))
+	require.NoError(t, err)
+	require.NoError(t, BackfillChainId(db, []int{56, 97}))
+
+	require.NoError(t, db.Exec("INSERT INTO tx (token0_address, block, block_index, tx_index) VALUES ('0xa1', 1, 1, 1)").Error)
+	require.NoError(t, db.Exec("INSERT INTO action (action, block) VALUES ('migrate', 1)").Error)
+
+	// several chains can not tell the chain of the rows
+	require.ErrorContains(t, BackfillChainId(db, []int{56, 97}), "1 tx and 1 action rows have no chain_id")
+
+	require.NoError(t, BackfillChainId(db, []int{56}))
+	for _, table := range []string{"tx", "action"} {
+		var n int64
+		require.NoError(t, db.Table(table).Where("chain_id = 56").Count(&n).Error)
+		require.EqualValues(t, 1, n, table)
+	}
+	require.NoError(t, BackfillChainId(db, []int{56, 97}))
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"bxs/repository/orm"
+	"gorm.io/gorm"
+)
+
+// FailedSwapRepository lists the failed swaps of a single chain
+type FailedSwapRepository struct {
+	*BaseRepository[orm.FailedSwap]
+	chainId int
+}
+
+func NewFailedSwapRepository(db *gorm.DB, chainId int) *FailedSwapRepository {
+	baseRepo := NewBaseRepository[orm.FailedSwap](db)
+	return &FailedSwapRepository{BaseRepository: baseRepo, chainId: chainId}
+}
+
+func (r *FailedSwapRepository) ListByToken(token string) ([]*orm.FailedSwap, error) {
+	var swaps []*orm.FailedSwap
+	err := r.db.Where("chain_id = ? AND token = ?", r.chainId, token).Order("block, block_index").Find(&swaps).Error
+	if err != nil {
+		return nil, err
+	}
+	return swaps, nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"bxs/chain_params"
+	"bxs/repository/orm"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFailedSwapRepository_CreateBatch(t *testing.T) {
+	db := openTestDB()
+	repo := NewFailedSwapRepository(db, chain_params.G.ChainID)
+	otherChain := NewFailedSwapRepository(db, chain_params.G.ChainID+1)
+	token := "0xf5"
+	defer db.Where("token = ?", token).Delete(&orm.FailedSwap{})
+
+	swaps := []*orm.FailedSwap{
+		{ChainId: chain_params.G.ChainID, TxHash: "0xa2", Token: token, Event: "sell", Block: 101, TokenAmount: decimal.NewFromInt(2)},
+		{ChainId: chain_params.G.ChainID, TxHash: "0xa1", Token: token, Event: "buy", Block: 100, RevertReason: "slippage", GasFee: decimal.RequireFromString("0.0001")},
+		{ChainId: chain_params.G.ChainID + 1, TxHash: "0xa1", Token: token, Event: "buy", Block: 100},
+	}
+	// committed again after a restart, the swaps are stored once
+	for range 2 {
+		require.NoError(t, repo.CreateBatch(swaps, "chain_id", "tx_hash"))
+	}
+
+	stored, err := repo.ListByToken(token)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	require.Equal(t, "0xa1", stored[0].TxHash)
+	require.Equal(t, "slippage", stored[0].RevertReason)
+	require.True(t, decimal.RequireFromString("0.0001").Equal(stored[0].GasFee))
+	require.True(t, decimal.NewFromInt(2).Equal(stored[1].TokenAmount))
+
+	stored, err = otherChain.ListByToken(token)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+}
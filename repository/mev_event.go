@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"bxs/repository/orm"
+	"gorm.io/gorm"
+)
+
+// MevEventRepository lists the mev events of a single chain
+type MevEventRepository struct {
+	*BaseRepository[orm.MevEvent]
+	chainId int
+}
+
+func NewMevEventRepository(db *gorm.DB, chainId int) *MevEventRepository {
+	baseRepo := NewBaseRepository[orm.MevEvent](db)
+	return &MevEventRepository{BaseRepository: baseRepo, chainId: chainId}
+}
+
+func (r *MevEventRepository) ListByToken(token string) ([]*orm.MevEvent, error) {
+	var events []*orm.MevEvent
+	err := r.db.Where("chain_id = ? AND token = ?", r.chainId, token).Order("block, kind").Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
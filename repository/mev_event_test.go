@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"bxs/chain_params"
+	"bxs/repository/orm"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMevEventRepository_CreateBatch(t *testing.T) {
+	db := openTestDB()
+	repo := NewMevEventRepository(db, chain_params.G.ChainID)
+	token := "0xf6"
+	defer db.Where("token = ?", token).Delete(&orm.MevEvent{})
+
+	events := []*orm.MevEvent{
+		{ChainId: chain_params.G.ChainID, Kind: "sandwich", Block: 100, Pool: "0xb1", Token: token, Attacker: "0xc1",
+			AttackerTxs: []string{"0xa1", "0xa3"}, Victims: []string{"0xd1"}, VictimTxs: []string{"0xa2"}, ProfitUsd: decimal.RequireFromString("1.5")},
+		{ChainId: chain_params.G.ChainID, Kind: "arbitrage", Block: 100, Pool: "0xb1", Token: token, Attacker: "0xc2",
+			AttackerTxs: []string{"0xa4"}},
+	}
+	// committed again after a restart, the events are stored once
+	for range 2 {
+		require.NoError(t, repo.CreateBatch(events, "chain_id", "block", "kind", "pool", "attacker_txs"))
+	}
+
+	stored, err := repo.ListByToken(token)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	require.Equal(t, "arbitrage", stored[0].Kind)
+	require.Equal(t, []string{"0xa1", "0xa3"}, stored[1].AttackerTxs)
+	require.Equal(t, []string{"0xa2"}, stored[1].VictimTxs)
+	require.True(t, decimal.RequireFromString("1.5").Equal(stored[1].ProfitUsd))
+}
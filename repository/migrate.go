@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"embed"
+	"fmt"
+	"gorm.io/gorm"
+	"io/fs"
+	"sort"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+/*
+MigratePostgres applies the postgres migrations in name order.
+The base tables are managed outside the indexer, the migrations only add the columns and tables
+the indexer writes since, each one is idempotent so they are all applied again on every start.
+The same changes are in sqliteDDLs and sqliteColumns for the embedded storage.
+*/
+func MigratePostgres(db *gorm.DB) error {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ddl, err := postgresMigrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err = db.Exec(string(ddl)).Error; err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// chainIdTables are the tables the chains share since chain_id was added to them, their rows before have chain_id 0
+var chainIdTables = []string{"tx", "action"}
+
+/*
+BackfillChainId sets the chain of the rows indexed before the chains shared the tx and action tables.
+Those rows were indexed by a single chain, they are given chainIds[0] when only one chain is indexed,
+with several chains it can not tell theirs and fails until the indexer is started once with the right one alone.
+Without it the rows would be missed by the chain scoped queries and indexed again under the chain key.
+*/
+func BackfillChainId(db *gorm.DB, chainIds []int) error {
+	counts := make(map[string]int64, len(chainIdTables))
+	var total int64
+	for _, table := range chainIdTables {
+		var n int64
+		if err := db.Table(table).Where("chain_id = 0").Count(&n).Error; err != nil {
+			return err
+		}
+		counts[table] = n
+		total += n
+	}
+	if total == 0 {
+		return nil
+	}
+	if len(chainIds) != 1 {
+		return fmt.Errorf("%d tx and %d action rows have no chain_id, start once with the chain they were indexed from alone",
+			counts["tx"], counts["action"])
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range chainIdTables {
+			if err := tx.Table(table).Where("chain_id = 0").Update("chain_id", chainIds[0]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// postgresBaseTables are the tables managed outside the indexer, the migrations only alter them
+var postgresBaseTables = map[string]bool{"token": true, "pair": true, "tx": true, "action": true}
+
+func readMigrations(t *testing.T) string {
+	names, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	require.NoError(t, err)
+	require.NotEmpty(t, names)
+
+	var all strings.Builder
+	for _, name := range names {
+		ddl, err := postgresMigrations.ReadFile(name)
+		require.NoError(t, err)
+		all.Write(ddl)
+	}
+	return all.String()
+}
+
+// every column and table added to the sqlite storage is added to postgres by a migration
+func TestPostgresMigrations_MatchSqlite(t *testing.T) {
+	migrations := readMigrations(t)
+
+	for _, c := range sqliteColumns {
+		require.Contains(t, migrations, "ALTER TABLE "+c.table+" ADD COLUMN IF NOT EXISTS "+c.column+" ", c.ddl)
+	}
+
+	tableName := regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`)
+	for _, ddl := range sqliteDDLs {
+		table := tableName.FindStringSubmatch(ddl)[1]
+		if !postgresBaseTables[table] {
+			require.Contains(t, migrations, "CREATE TABLE IF NOT EXISTS "+table+" ", table)
+		}
+	}
+}
+
+func TestMigratePostgres(t *testing.T) {
+	dsn := os.Getenv("BXS_TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("BXS_TEST_PG_DSN not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn))
+	require.NoError(t, err)
+	// applied twice, every migration is idempotent
+	require.NoError(t, MigratePostgres(db))
+	require.NoError(t, MigratePostgres(db))
+}
@@ -0,0 +1,2 @@
+-- the tx sender, next to the maker resolved from recipients, routers and user operations
+ALTER TABLE tx ADD COLUMN IF NOT EXISTS sender TEXT;
@@ -0,0 +1,2 @@
+-- the types.TradeFlag* bits of a trade: bundled launch, sniper, sandwich...
+ALTER TABLE tx ADD COLUMN IF NOT EXISTS flags INTEGER NOT NULL DEFAULT 0;
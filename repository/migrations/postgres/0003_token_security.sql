@@ -0,0 +1,6 @@
+-- set by the token security simulation, once the token is listed on PancakeV2
+ALTER TABLE token ADD COLUMN IF NOT EXISTS buy_tax NUMERIC;
+ALTER TABLE token ADD COLUMN IF NOT EXISTS sell_tax NUMERIC;
+ALTER TABLE token ADD COLUMN IF NOT EXISTS transfer_tax NUMERIC;
+ALTER TABLE token ADD COLUMN IF NOT EXISTS security_flags INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE token ADD COLUMN IF NOT EXISTS security_at TIMESTAMPTZ;
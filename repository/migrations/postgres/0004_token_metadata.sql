@@ -0,0 +1,4 @@
+-- set by the metadata enrichment, from the CID of the token
+ALTER TABLE token ADD COLUMN IF NOT EXISTS logo TEXT;
+ALTER TABLE token ADD COLUMN IF NOT EXISTS metadata_flags INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE token ADD COLUMN IF NOT EXISTS metadata_at TIMESTAMPTZ;
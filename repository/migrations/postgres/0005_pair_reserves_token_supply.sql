@@ -0,0 +1,13 @@
+-- the block of the refreshed reserves, the ones of an older block do not replace them
+ALTER TABLE pair ADD COLUMN IF NOT EXISTS reserve_block BIGINT NOT NULL DEFAULT 0;
+
+-- the changes of the totalSupply of a token, seen when it is read again
+CREATE TABLE IF NOT EXISTS token_supply_change (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    token TEXT NOT NULL,
+    chain_id INTEGER NOT NULL,
+    block BIGINT NOT NULL,
+    old_supply NUMERIC,
+    new_supply NUMERIC,
+    created_at TIMESTAMPTZ
+);
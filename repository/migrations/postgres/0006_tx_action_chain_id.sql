@@ -0,0 +1,27 @@
+-- the chains share the tx and action tables, the rows indexed before get chain_id 0,
+-- repository.BackfillChainId sets it on start and refuses to start with several chains until it is set
+ALTER TABLE tx ADD COLUMN IF NOT EXISTS chain_id INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE action ADD COLUMN IF NOT EXISTS chain_id INTEGER NOT NULL DEFAULT 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS tx_chain_id_uniq ON tx (chain_id, token0_address, block, block_index, tx_index);
+
+-- the key without the chain would reject the txs of another chain at the same position
+DO $$
+DECLARE
+    c RECORD;
+BEGIN
+    FOR c IN SELECT conname FROM pg_constraint
+        WHERE conrelid = 'tx'::regclass AND contype = 'u'
+          AND pg_get_constraintdef(oid) = 'UNIQUE (token0_address, block, block_index, tx_index)'
+    LOOP
+        EXECUTE format('ALTER TABLE tx DROP CONSTRAINT %I', c.conname);
+    END LOOP;
+
+    FOR c IN SELECT i.relname FROM pg_index x JOIN pg_class i ON i.oid = x.indexrelid
+        WHERE x.indrelid = 'tx'::regclass AND x.indisunique AND NOT x.indisprimary
+          AND NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conindid = x.indexrelid)
+          AND pg_get_indexdef(x.indexrelid) LIKE '%(token0_address, block, block_index, tx_index)'
+    LOOP
+        EXECUTE format('DROP INDEX %I', c.relname);
+    END LOOP;
+END $$;
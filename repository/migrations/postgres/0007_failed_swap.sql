@@ -0,0 +1,23 @@
+-- the reverted swaps of the indexed tokens, a tx is stored once per chain
+CREATE TABLE IF NOT EXISTS failed_swap (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    chain_id INTEGER NOT NULL,
+    tx_hash TEXT NOT NULL,
+    sender TEXT,
+    to_address TEXT,
+    method TEXT,
+    event TEXT,
+    token TEXT,
+    token_amount NUMERIC,
+    native_amount NUMERIC,
+    revert_reason TEXT,
+    gas_used BIGINT,
+    gas_fee NUMERIC,
+    block BIGINT NOT NULL,
+    block_index INTEGER,
+    block_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ,
+    UNIQUE (chain_id, tx_hash)
+);
+
+CREATE INDEX IF NOT EXISTS failed_swap_token ON failed_swap (chain_id, token, block);
@@ -0,0 +1,20 @@
+-- the sandwiches and arbitrages of the indexed trades, the tx lists are json arrays of hashes and addresses,
+-- an event is stored once per chain like in the clickhouse mev_events table
+CREATE TABLE IF NOT EXISTS mev_event (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    chain_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    block BIGINT NOT NULL,
+    block_at TIMESTAMPTZ,
+    pool TEXT NOT NULL,
+    token TEXT,
+    attacker TEXT,
+    attacker_txs JSONB NOT NULL,
+    victims JSONB,
+    victim_txs JSONB,
+    profit_usd NUMERIC,
+    created_at TIMESTAMPTZ,
+    UNIQUE (chain_id, block, kind, pool, attacker_txs)
+);
+
+CREATE INDEX IF NOT EXISTS mev_event_token ON mev_event (chain_id, token, block);
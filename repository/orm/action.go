@@ -16,6 +16,7 @@ type Action struct {
 	Creator      string          `json:"creator"`
 	Block        uint64          `json:"block"`
 	BlockAt      time.Time       `json:"block_at"`
+	ChainId      int             `json:"-"` // the kafka msg has the chain id of its actions
 	Token0Amount decimal.Decimal `gorm:"-" json:"token0_amount,omitempty"`
 	Token1Amount decimal.Decimal `gorm:"-" json:"token1_amount,omitempty"`
 	CreatedAt    time.Time       `gorm:"autoCreateTime" json:"created_at,omitempty"`
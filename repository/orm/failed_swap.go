@@ -0,0 +1,32 @@
+package orm
+
+import (
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// FailedSwap is a reverted swap of an indexed token, see types.FailedSwap
+type FailedSwap struct {
+	Id           uuid.UUID       `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id,omitempty"`
+	ChainId      int             `json:"chain_id"`
+	TxHash       string          `json:"tx_hash"`
+	Sender       string          `json:"sender"`
+	ToAddress    string          `json:"to_address"` // the xLaunch pool or the router
+	Method       string          `json:"method"`
+	Event        string          `json:"event"`
+	Token        string          `json:"token"`
+	TokenAmount  decimal.Decimal `json:"token_amount"`
+	NativeAmount decimal.Decimal `json:"native_amount"`
+	RevertReason string          `json:"revert_reason"`
+	GasUsed      uint64          `json:"gas_used"`
+	GasFee       decimal.Decimal `json:"gas_fee"`
+	Block        uint64          `json:"block"`
+	BlockIndex   uint            `json:"block_index"`
+	BlockAt      time.Time       `json:"block_at"`
+	CreatedAt    time.Time       `gorm:"autoCreateTime" json:"created_at,omitempty"`
+}
+
+func (f *FailedSwap) TableName() string {
+	return "failed_swap"
+}
@@ -0,0 +1,28 @@
+package orm
+
+import (
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// MevEvent is a sandwich or an arbitrage found in the trades of a block, see types.MevEvent
+type MevEvent struct {
+	Id          uuid.UUID       `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id,omitempty"`
+	ChainId     int             `json:"chain_id"`
+	Kind        string          `json:"kind"`
+	Block       uint64          `json:"block"`
+	BlockAt     time.Time       `json:"block_at"`
+	Pool        string          `json:"pool"`
+	Token       string          `json:"token"`
+	Attacker    string          `json:"attacker"`
+	AttackerTxs []string        `gorm:"serializer:json" json:"attacker_txs"`
+	Victims     []string        `gorm:"serializer:json" json:"victims"`
+	VictimTxs   []string        `gorm:"serializer:json" json:"victim_txs"`
+	ProfitUsd   decimal.Decimal `json:"profit_usd"`
+	CreatedAt   time.Time       `gorm:"autoCreateTime" json:"created_at,omitempty"`
+}
+
+func (e *MevEvent) TableName() string {
+	return "mev_event"
+}
@@ -6,17 +6,18 @@ import (
 )
 
 type Pair struct {
-	Name      string          `json:"name"`
-	Address   string          `json:"address"`
-	Token0    string          `json:"token0"`
-	Token1    string          `json:"token1"`
-	ChainId   int             `json:"chain_id"`
-	Reserve0  decimal.Decimal `json:"reserve0"`
-	Reserve1  decimal.Decimal `json:"reserve1"`
-	Block     uint64          `json:"block"`
-	BlockAt   time.Time       `json:"block_at"`
-	Program   string          `json:"program"`
-	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at,omitempty"`
+	Name         string          `json:"name"`
+	Address      string          `json:"address"`
+	Token0       string          `json:"token0"`
+	Token1       string          `json:"token1"`
+	ChainId      int             `json:"chain_id"`
+	Reserve0     decimal.Decimal `json:"reserve0"`
+	Reserve1     decimal.Decimal `json:"reserve1"`
+	ReserveBlock uint64          `json:"reserve_block"` // the block of the reserves, the ones of an older block do not replace them
+	Block        uint64          `json:"block"`
+	BlockAt      time.Time       `json:"block_at"`
+	Program      string          `json:"program"`
+	CreatedAt    time.Time       `gorm:"autoCreateTime" json:"created_at,omitempty"`
 }
 
 func (p *Pair) TableName() string {
@@ -1,6 +1,7 @@
 package orm
 
 import (
+	"github.com/shopspring/decimal"
 	"time"
 )
 
@@ -23,6 +24,16 @@ type Token struct {
 	Telegram    string    `json:"telegram"`
 	Twitter     string    `json:"twitter"`
 	Website     string    `json:"website"`
+	// set by the token security simulation, once the token is listed on PancakeV2
+	BuyTax        decimal.Decimal `json:"buy_tax"`
+	SellTax       decimal.Decimal `json:"sell_tax"`
+	TransferTax   decimal.Decimal `json:"transfer_tax"`
+	SecurityFlags int             `json:"security_flags"`
+	SecurityAt    time.Time       `json:"security_at"`
+	// set by the metadata enrichment, from the CID of the token
+	Logo          string    `json:"logo"`
+	MetadataFlags int       `json:"metadata_flags"`
+	MetadataAt    time.Time `json:"metadata_at"`
 }
 
 func (t *Token) TableName() string {
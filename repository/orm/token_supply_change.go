@@ -0,0 +1,22 @@
+package orm
+
+import (
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// TokenSupplyChange is a change of the totalSupply of a token, seen when it was read again at Block
+type TokenSupplyChange struct {
+	Id        uuid.UUID       `gorm:"type:uuid;primaryKey;default:uuid_generate_v4()" json:"id,omitempty"`
+	Token     string          `json:"token"`
+	ChainId   int             `json:"chain_id"`
+	Block     uint64          `json:"block"`
+	OldSupply decimal.Decimal `json:"old_supply"`
+	NewSupply decimal.Decimal `json:"new_supply"`
+	CreatedAt time.Time       `gorm:"autoCreateTime" json:"created_at,omitempty"`
+}
+
+func (c *TokenSupplyChange) TableName() string {
+	return "token_supply_change"
+}
@@ -14,7 +14,8 @@ type Tx struct {
 	Event         string          `json:"event"`
 	Token0Amount  decimal.Decimal `json:"token0Amount"`
 	Token1Amount  decimal.Decimal `json:"token1Amount"`
-	Maker         string          `json:"maker"`
+	Maker         string          `json:"maker"`  // the trader
+	Sender        string          `json:"sender"` // the tx sender
 	Token0Address string          `json:"token0_address"`
 	Token1Address string          `json:"token1_address"`
 	AmountUsd     decimal.Decimal `json:"amount_usd"`
@@ -25,6 +26,8 @@ type Tx struct {
 	TxIndex       uint            `json:"tx_index"`
 	PairAddress   string          `json:"pair_address"`
 	Program       string          `json:"program"`
+	ChainId       int             `json:"-"`     // the kafka msg has the chain id of its txs
+	Flags         int             `json:"flags"` // types.TradeFlag* bits
 	CreatedAt     time.Time       `gorm:"autoCreateTime" json:"created_at,omitempty"`
 }
 
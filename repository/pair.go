@@ -1,29 +1,102 @@
 package repository
 
 import (
-	"bxs/chain_params"
 	"bxs/repository/orm"
 	"gorm.io/gorm"
+	"strings"
+	"time"
 )
 
+// PairRepository reads and deletes the pairs of a single chain
 type PairRepository struct {
 	*BaseRepository[orm.Pair]
+	chainId int
 }
 
-func NewPairRepository(db *gorm.DB) *PairRepository {
+func NewPairRepository(db *gorm.DB, chainId int) *PairRepository {
 	baseRepo := NewBaseRepository[orm.Pair](db)
-	return &PairRepository{BaseRepository: baseRepo}
+	return &PairRepository{BaseRepository: baseRepo, chainId: chainId}
 }
 
 func (r *PairRepository) GetByAddressAndChainId(address string) (*orm.Pair, error) {
 	var pair orm.Pair
-	err := r.db.Where("address = ? AND chain_id = ?", address, chain_params.G.ChainID).First(&pair).Error
+	err := r.db.Where("address = ? AND chain_id = ?", address, r.chainId).First(&pair).Error
 	if err != nil {
 		return nil, err
 	}
 	return &pair, nil
 }
 
+// ListByProgramSince lists the pairs of a program created at or after since, in block order
+func (r *PairRepository) ListByProgramSince(program string, since time.Time) ([]*orm.Pair, error) {
+	var pairs []*orm.Pair
+	err := r.db.Where("chain_id = ? AND program = ? AND block_at >= ?", r.chainId, program, since).Order("block").Find(&pairs).Error
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// updateReservesBatch is the number of pairs updated by a statement, 4 parameters each
+const updateReservesBatch = 1000
+
+/*
+UpdateReserves sets the reserves of the pairs, unless they already have the ones of a later block.
+The pairs are updated by a single UPDATE ... FROM (VALUES ...) per updateReservesBatch pairs,
+a pair given twice keeps its last reserves.
+*/
+func (r *PairRepository) UpdateReserves(pairs []*orm.Pair) error {
+	last := make(map[string]int, len(pairs))
+	for i, pair := range pairs {
+		last[pair.Address] = i
+	}
+	unique := make([]*orm.Pair, 0, len(last))
+	for i, pair := range pairs {
+		if last[pair.Address] == i {
+			unique = append(unique, pair)
+		}
+	}
+
+	// the parameters of VALUES are untyped on postgres, sqlite keeps the decimals as TEXT and must not cast them
+	row := "(?, ?, ?, ?)"
+	if r.db.Dialector.Name() == DriverPostgres {
+		row = "(?::text, ?::numeric, ?::numeric, ?::bigint)"
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for from := 0; from < len(unique); from += updateReservesBatch {
+			batch := unique[from:min(from+updateReservesBatch, len(unique))]
+			rows := make([]string, 0, len(batch))
+			args := make([]any, 0, len(batch)*4+1)
+			for _, pair := range batch {
+				rows = append(rows, row)
+				args = append(args, pair.Address, pair.Reserve0, pair.Reserve1, pair.ReserveBlock)
+			}
+			args = append(args, r.chainId)
+
+			// the columns of VALUES are column1..column4 on both postgres and sqlite
+			err := tx.Exec(`UPDATE pair SET reserve0 = v.column2, reserve1 = v.column3, reserve_block = v.column4
+				FROM (VALUES `+strings.Join(rows, ", ")+`) AS v
+				WHERE pair.address = v.column1 AND pair.chain_id = ? AND pair.reserve_block <= v.column4`, args...).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (r *PairRepository) DeleteByAddressAndChainId(address string) error {
-	return r.db.Where("address = ? AND chain_id = ?", address, chain_params.G.ChainID).Delete(&orm.Pair{}).Error
+	return r.db.Where("address = ? AND chain_id = ?", address, r.chainId).Delete(&orm.Pair{}).Error
+}
+
+// ListAfter pages through the pairs of the chain ordered by address, starting after the given one
+func (r *PairRepository) ListAfter(address string, limit int) ([]*orm.Pair, error) {
+	var pairs []*orm.Pair
+	err := r.db.Where("chain_id = ? AND address > ?", r.chainId, address).
+		Order("address").Limit(limit).Find(&pairs).Error
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
 }
@@ -5,18 +5,12 @@ import (
 	"bxs/repository/orm"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 	"testing"
+	"time"
 )
 
 func preparePairTest() *PairRepository {
-	dsn := "host=localhost user=postgres password=12345678 dbname=test port=5432 sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn))
-	if err != nil {
-		panic(err)
-	}
-	return NewPairRepository(db)
+	return NewPairRepository(openTestDB(), chain_params.G.ChainID)
 }
 
 func cleanupPairTest(pairRepository *PairRepository, addresses ...string) {
@@ -91,3 +85,55 @@ func TestPairRepository_CreateDup(t *testing.T) {
 		require.True(t, pairs[i].Equal(pairQueried))
 	}
 }
+
+func TestPairRepository_UpdateReserves(t *testing.T) {
+	pairRepository := preparePairTest()
+	pair := &orm.Pair{
+		Name:         "n1",
+		Address:      "0x01",
+		Token0:       "0x0a",
+		Token1:       "0x0b",
+		ChainId:      chain_params.G.ChainID,
+		Reserve0:     decimal.NewFromInt(1),
+		Reserve1:     decimal.NewFromInt(2),
+		ReserveBlock: 10,
+	}
+	require.NoError(t, pairRepository.Create(pair))
+
+	err := pairRepository.UpdateReserves([]*orm.Pair{
+		{Address: pair.Address, Reserve0: decimal.NewFromInt(9), Reserve1: decimal.NewFromInt(9), ReserveBlock: 12}, // replaced by the last one
+		{Address: "0x02", Reserve0: decimal.NewFromInt(5), Reserve1: decimal.NewFromInt(6), ReserveBlock: 12},       // not stored
+		{Address: pair.Address, Reserve0: decimal.RequireFromString("3.000000000000000000001"), Reserve1: decimal.NewFromInt(4), ReserveBlock: 12},
+	})
+	require.NoError(t, err)
+
+	// the reserves of an older block are ignored
+	err = pairRepository.UpdateReserves([]*orm.Pair{
+		{Address: pair.Address, Reserve0: decimal.NewFromInt(7), Reserve1: decimal.NewFromInt(8), ReserveBlock: 11},
+	})
+	require.NoError(t, err)
+
+	pairQueried, err := pairRepository.GetByAddressAndChainId(pair.Address)
+	require.NoError(t, err)
+	require.True(t, decimal.RequireFromString("3.000000000000000000001").Equal(pairQueried.Reserve0))
+	require.True(t, decimal.NewFromInt(4).Equal(pairQueried.Reserve1))
+	require.Equal(t, uint64(12), pairQueried.ReserveBlock)
+	cleanupPairTest(pairRepository, pair.Address)
+}
+
+func TestPairRepository_ListByProgramSince(t *testing.T) {
+	pairRepository := preparePairTest()
+	now := time.Now().UTC().Truncate(time.Second)
+	pairs := []*orm.Pair{
+		{Name: "l1", Address: "0x31", Token0: "0x3a", ChainId: chain_params.G.ChainID, Program: "pancakev2", Block: 2, BlockAt: now},
+		{Name: "l2", Address: "0x32", Token0: "0x3b", ChainId: chain_params.G.ChainID, Program: "pancakev2", Block: 1, BlockAt: now.Add(-48 * time.Hour)},
+		{Name: "l3", Address: "0x33", Token0: "0x3c", ChainId: chain_params.G.ChainID, Program: "xlaunch", Block: 3, BlockAt: now},
+	}
+	require.NoError(t, pairRepository.CreateBatch(pairs))
+	defer cleanupPairTest(pairRepository, "0x31", "0x32", "0x33")
+
+	listed, err := pairRepository.ListByProgramSince("pancakev2", now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	require.Equal(t, "0x31", listed[0].Address)
+}
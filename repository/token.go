@@ -1,24 +1,25 @@
 package repository
 
 import (
-	"bxs/chain_params"
 	"bxs/repository/orm"
 	_ "gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// TokenRepository reads and deletes the tokens of a single chain
 type TokenRepository struct {
 	*BaseRepository[orm.Token]
+	chainId int
 }
 
-func NewTokenRepository(db *gorm.DB) *TokenRepository {
+func NewTokenRepository(db *gorm.DB, chainId int) *TokenRepository {
 	baseRepo := NewBaseRepository[orm.Token](db)
-	return &TokenRepository{BaseRepository: baseRepo}
+	return &TokenRepository{BaseRepository: baseRepo, chainId: chainId}
 }
 
 func (r *TokenRepository) GetByAddressAndChainId(address string) (*orm.Token, error) {
 	var token orm.Token
-	err := r.db.Where("address = ? AND chain_id = ?", address, chain_params.G.ChainID).First(&token).Error
+	err := r.db.Where("address = ? AND chain_id = ?", address, r.chainId).First(&token).Error
 	if err != nil {
 		return nil, err
 	}
@@ -27,10 +28,62 @@ func (r *TokenRepository) GetByAddressAndChainId(address string) (*orm.Token, er
 
 func (r *TokenRepository) UpdateMainPair(address string, mainPair string) error {
 	return r.db.Model(&orm.Token{}).
-		Where("address = ? AND chain_id = ?", address, chain_params.G.ChainID).
+		Where("address = ? AND chain_id = ?", address, r.chainId).
 		Update("main_pair", mainPair).Error
 }
 
+// UpdateSecurity sets the tax and security columns of the token
+func (r *TokenRepository) UpdateSecurity(token *orm.Token) error {
+	return r.db.Model(&orm.Token{}).
+		Where("address = ? AND chain_id = ?", token.Address, r.chainId).
+		Select("buy_tax", "sell_tax", "transfer_tax", "security_flags", "security_at").
+		Updates(token).Error
+}
+
+// UpdateMetadata sets the social links, logo and metadata columns of the token
+func (r *TokenRepository) UpdateMetadata(token *orm.Token) error {
+	return r.db.Model(&orm.Token{}).
+		Where("address = ? AND chain_id = ?", token.Address, r.chainId).
+		Select("telegram", "twitter", "website", "logo", "metadata_flags", "metadata_at").
+		Updates(token).Error
+}
+
+// UpdateTotalSupply sets the new total supply of the tokens and logs their changes, at once
+func (r *TokenRepository) UpdateTotalSupply(changes []*orm.TokenSupplyChange) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, change := range changes {
+			err := tx.Model(&orm.Token{}).
+				Where("address = ? AND chain_id = ?", change.Token, r.chainId).
+				Update("total_supply", change.NewSupply.String()).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(changes).Error
+	})
+}
+
+// ListSupplyChanges returns the supply changes of a token, oldest first
+func (r *TokenRepository) ListSupplyChanges(address string) ([]*orm.TokenSupplyChange, error) {
+	var changes []*orm.TokenSupplyChange
+	err := r.db.Where("token = ? AND chain_id = ?", address, r.chainId).Order("block").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
 func (r *TokenRepository) DeleteByAddressAndChainId(address string) error {
-	return r.db.Where("address = ? AND chain_id = ?", address, chain_params.G.ChainID).Delete(&orm.Token{}).Error
+	return r.db.Where("address = ? AND chain_id = ?", address, r.chainId).Delete(&orm.Token{}).Error
+}
+
+// ListAfter pages through the tokens of the chain ordered by address, starting after the given one
+func (r *TokenRepository) ListAfter(address string, limit int) ([]*orm.Token, error) {
+	var tokens []*orm.Token
+	err := r.db.Where("chain_id = ? AND address > ?", r.chainId, address).
+		Order("address").Limit(limit).Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
 }
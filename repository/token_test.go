@@ -3,19 +3,14 @@ package repository
 import (
 	"bxs/chain_params"
 	"bxs/repository/orm"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 	"testing"
+	"time"
 )
 
 func prepareTokenTest() *TokenRepository {
-	dsn := "host=localhost user=postgres password=12345678 dbname=test port=5432 sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn))
-	if err != nil {
-		panic(err)
-	}
-	return NewTokenRepository(db)
+	return NewTokenRepository(openTestDB(), chain_params.G.ChainID)
 }
 
 func cleanupTokenTest(tokenRepository *TokenRepository, addresses ...string) {
@@ -143,3 +138,110 @@ func TestTokenRepository_UpdateMainPair(t *testing.T) {
 	require.Equal(t, "0x06", tokenQueried.MainPair)
 	cleanupTokenTest(tokenRepository, token.Address)
 }
+
+func TestTokenRepository_UpdateSecurity(t *testing.T) {
+	tokenRepository := prepareTokenTest()
+	token := &orm.Token{
+		Address:     "0x01",
+		Name:        "n1",
+		Symbol:      "s1",
+		Decimal:     18,
+		TotalSupply: "1",
+		ChainId:     chain_params.G.ChainID,
+	}
+
+	tokenRepository.Create(token)
+	checkedAt := time.Unix(1700000000, 0).UTC()
+	err := tokenRepository.UpdateSecurity(&orm.Token{
+		Address:       token.Address,
+		BuyTax:        decimal.RequireFromString("0.05"),
+		SellTax:       decimal.RequireFromString("0.1"),
+		SecurityFlags: 8,
+		SecurityAt:    checkedAt,
+	})
+	require.NoError(t, err)
+
+	tokenQueried, err := tokenRepository.GetByAddressAndChainId(token.Address)
+	require.Nil(t, err)
+	require.True(t, token.Equal(tokenQueried))
+	require.True(t, decimal.RequireFromString("0.05").Equal(tokenQueried.BuyTax))
+	require.True(t, decimal.RequireFromString("0.1").Equal(tokenQueried.SellTax))
+	require.True(t, tokenQueried.TransferTax.IsZero())
+	require.Equal(t, 8, tokenQueried.SecurityFlags)
+	require.True(t, checkedAt.Equal(tokenQueried.SecurityAt))
+	cleanupTokenTest(tokenRepository, token.Address)
+}
+
+func TestTokenRepository_UpdateMetadata(t *testing.T) {
+	tokenRepository := prepareTokenTest()
+	token := &orm.Token{
+		Address:     "0x01",
+		Name:        "n1",
+		Symbol:      "s1",
+		Decimal:     18,
+		TotalSupply: "1",
+		ChainId:     chain_params.G.ChainID,
+		Description: "d1",
+		Telegram:    "@group1",
+		Twitter:     "not a link",
+	}
+
+	tokenRepository.Create(token)
+	enrichedAt := time.Unix(1700000000, 0).UTC()
+	err := tokenRepository.UpdateMetadata(&orm.Token{
+		Address:       token.Address,
+		Telegram:      "https://t.me/group1",
+		Logo:          "https://ipfs.io/ipfs/bafkrei01",
+		MetadataFlags: 9,
+		MetadataAt:    enrichedAt,
+	})
+	require.NoError(t, err)
+
+	tokenQueried, err := tokenRepository.GetByAddressAndChainId(token.Address)
+	require.Nil(t, err)
+	require.True(t, token.Equal(tokenQueried))
+	require.Equal(t, "d1", tokenQueried.Description)
+	require.Equal(t, "https://t.me/group1", tokenQueried.Telegram)
+	require.Empty(t, tokenQueried.Twitter)
+	require.Equal(t, "https://ipfs.io/ipfs/bafkrei01", tokenQueried.Logo)
+	require.Equal(t, 9, tokenQueried.MetadataFlags)
+	require.True(t, enrichedAt.Equal(tokenQueried.MetadataAt))
+	cleanupTokenTest(tokenRepository, token.Address)
+}
+
+func TestTokenRepository_UpdateTotalSupply(t *testing.T) {
+	tokenRepository := prepareTokenTest()
+	token := &orm.Token{
+		Address:     "0x01",
+		Name:        "n1",
+		Symbol:      "s1",
+		Decimal:     18,
+		TotalSupply: "1000",
+		ChainId:     chain_params.G.ChainID,
+	}
+
+	tokenRepository.Create(token)
+	err := tokenRepository.UpdateTotalSupply([]*orm.TokenSupplyChange{
+		{
+			Token:     token.Address,
+			ChainId:   chain_params.G.ChainID,
+			Block:     100,
+			OldSupply: decimal.RequireFromString("1000"),
+			NewSupply: decimal.RequireFromString("900.5"),
+		},
+	})
+	require.NoError(t, err)
+
+	tokenQueried, err := tokenRepository.GetByAddressAndChainId(token.Address)
+	require.Nil(t, err)
+	require.Equal(t, "900.5", tokenQueried.TotalSupply)
+
+	changes, err := tokenRepository.ListSupplyChanges(token.Address)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, uint64(100), changes[0].Block)
+	require.True(t, decimal.RequireFromString("1000").Equal(changes[0].OldSupply))
+	require.True(t, decimal.RequireFromString("900.5").Equal(changes[0].NewSupply))
+	tokenRepository.db.Where("token = ?", token.Address).Delete(&orm.TokenSupplyChange{})
+	cleanupTokenTest(tokenRepository, token.Address)
+}
@@ -5,18 +5,21 @@ import (
 	"gorm.io/gorm"
 )
 
+// TxRepository reads and deletes the txs of a single chain
 type TxRepository struct {
 	*BaseRepository[orm.Tx]
+	chainId int
 }
 
-func NewTxRepository(db *gorm.DB) *TxRepository {
+func NewTxRepository(db *gorm.DB, chainId int) *TxRepository {
 	baseRepo := NewBaseRepository[orm.Tx](db)
-	return &TxRepository{BaseRepository: baseRepo}
+	return &TxRepository{BaseRepository: baseRepo, chainId: chainId}
 }
 
 func (r *TxRepository) GetByUniqIndex(token0Address string, block uint64, blockIndex, txIndex uint) (*orm.Tx, error) {
 	tx := &orm.Tx{}
-	err := r.db.Where("token0_address = ? AND block = ? AND block_index = ? AND tx_index = ?",
+	err := r.db.Where("chain_id = ? AND token0_address = ? AND block = ? AND block_index = ? AND tx_index = ?",
+		r.chainId,
 		token0Address,
 		block,
 		blockIndex,
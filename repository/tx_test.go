@@ -1,23 +1,17 @@
 package repository
 
 import (
+	"bxs/chain_params"
 	"bxs/repository/orm"
 	"bxs/types"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 	"testing"
 	"time"
 )
 
 func prepareTxTest() *TxRepository {
-	dsn := "host=localhost user=postgres password=12345678 dbname=test port=5432 sslmode=disable"
-	db, err := gorm.Open(postgres.Open(dsn))
-	if err != nil {
-		panic(err)
-	}
-	return NewTxRepository(db)
+	return NewTxRepository(openTestDB(), chain_params.G.ChainID)
 }
 
 func cleanupTxTest(txRepository *TxRepository, Ids ...string) {
@@ -44,6 +38,7 @@ func TestTxRepository_Create(t *testing.T) {
 		TxIndex:       1,
 		PairAddress:   "0xa1",
 		Program:       types.ProtocolNameXLaunch,
+		ChainId:       chain_params.G.ChainID,
 	}
 	createErr := txRepository.Create(tx)
 	require.NoError(t, createErr)
@@ -73,6 +68,7 @@ func TestTxRepository_CreateDup(t *testing.T) {
 			TxIndex:       1,
 			PairAddress:   "0xa1",
 			Program:       types.ProtocolNameXLaunch,
+			ChainId:       chain_params.G.ChainID,
 		},
 		{
 			TxHash:        "0xa2",
@@ -90,6 +86,7 @@ func TestTxRepository_CreateDup(t *testing.T) {
 			TxIndex:       2,
 			PairAddress:   "0xa2",
 			Program:       types.ProtocolNameXLaunch,
+			ChainId:       chain_params.G.ChainID,
 		},
 		{
 			TxHash:        "0xa3",
@@ -107,13 +104,14 @@ func TestTxRepository_CreateDup(t *testing.T) {
 			TxIndex:       3,
 			PairAddress:   "0xa3",
 			Program:       types.ProtocolNameXLaunch,
+			ChainId:       chain_params.G.ChainID,
 		},
 	}
 
 	createErr := txRepository.Create(txes[0])
 	require.NoError(t, createErr)
 
-	createBatchErr := txRepository.CreateBatch(txes, "token0_address", "block", "block_index", "tx_index")
+	createBatchErr := txRepository.CreateBatch(txes, "chain_id", "token0_address", "block", "block_index", "tx_index")
 	require.NoError(t, createBatchErr)
 
 	txIds := make([]string, 0, 3)
@@ -126,3 +124,23 @@ func TestTxRepository_CreateDup(t *testing.T) {
 
 	defer cleanupTxTest(txRepository, txIds...)
 }
+
+// the chains share the table, a tx at the same position of another chain is not a duplicate
+func TestTxRepository_CreateChains(t *testing.T) {
+	db := openTestDB()
+	txRepository := NewTxRepository(db, chain_params.G.ChainID)
+	otherRepository := NewTxRepository(db, chain_params.G.ChainID+1)
+
+	tx := func(chainId int) *orm.Tx {
+		return &orm.Tx{TxHash: "0xc1", Event: "buy", Token0Address: "0xc1", Block: 1, BlockIndex: 1, TxIndex: 1, ChainId: chainId}
+	}
+	require.NoError(t, txRepository.CreateBatch([]*orm.Tx{tx(chain_params.G.ChainID)}, "chain_id", "token0_address", "block", "block_index", "tx_index"))
+	require.NoError(t, otherRepository.CreateBatch([]*orm.Tx{tx(chain_params.G.ChainID + 1)}, "chain_id", "token0_address", "block", "block_index", "tx_index"))
+
+	stored, err := txRepository.GetByUniqIndex("0xc1", 1, 1, 1)
+	require.NoError(t, err)
+	other, err := otherRepository.GetByUniqIndex("0xc1", 1, 1, 1)
+	require.NoError(t, err)
+	require.NotEqual(t, stored.Id, other.Id)
+	cleanupTxTest(txRepository, stored.Id.String(), other.Id.String())
+}
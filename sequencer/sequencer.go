@@ -3,10 +3,23 @@ package sequencer
 import (
 	"bxs/config"
 	"bxs/logger"
+	"bxs/metrics"
+	"context"
+	"errors"
+	"fmt"
 	"go.uber.org/zap"
+	"sort"
 	"sync"
+	"time"
 )
 
+const (
+	GapPolicyWait = "wait"
+	GapPolicySkip = "skip"
+)
+
+var ErrSequenceCommitted = errors.New("sequence already committed or skipped")
+
 type Sequenceable interface {
 	GetSequence() uint64
 }
@@ -15,28 +28,82 @@ type Committable interface {
 	Commit(value Sequenceable)
 }
 
+// Gap is a range of sequences skipped by the skip gap policy
+type Gap struct {
+	From      uint64    `json:"from"`
+	To        uint64    `json:"to"`
+	SkippedAt time.Time `json:"skipped_at"`
+}
+
+/*
+Sequencer commits values in sequence order, whatever the order they arrive in.
+
+Values arriving ahead of the next sequence are kept in a reorder buffer of BufferSize values,
+CommitWithSequence blocks when the buffer is full, until the next sequence arrives or the ctx is done.
+
+A gap is the range from the next sequence to the lowest buffered one. When it is older than GapTimeoutSec
+it is logged and counted, then with the skip policy it is skipped and recorded in Gaps,
+with the wait policy the sequencer keeps waiting for it.
+The skipped gaps are only kept in memory: they fail the readiness check and are logged at error level
+so they can be backfilled, a restart from past them loses them.
+*/
 type Sequencer interface {
 	Init(height uint64)
-	CommitWithSequence(value Sequenceable, output Committable)
+	CommitWithSequence(value Sequenceable, output Committable) error
+	// Buffered returns the buffered sequences in ascending order
+	Buffered() []uint64
+	// Missing returns the sequences from the next one to the highest buffered one that have not arrived
+	Missing() []uint64
+	Gaps() []Gap
+}
+
+type pending struct {
+	value  Sequenceable
+	output Committable
 }
 
 type sequencer struct {
-	active   bool
-	mu       sync.Mutex
-	cond     *sync.Cond
-	sequence uint64
+	ctx        context.Context
+	name       string
+	active     bool
+	bufferSize int
+	gapTimeout time.Duration
+	skipGap    bool
+	mu         sync.Mutex
+	cond       *sync.Cond
+	sequence   uint64 // last committed
+	buffer     map[uint64]*pending
+	waitSince  time.Time // since when the buffered values wait for the next sequence
+	warnedGap  uint64
+	gaps       []Gap
+}
+
+func NewSequencer(ctx context.Context, name string) Sequencer {
+	conf := config.G.Sequencer
+	return newSequencer(ctx, name, config.G.EnableSequencer, conf.BufferSize,
+		time.Duration(conf.GapTimeoutSec)*time.Second, conf.GapPolicy == GapPolicySkip)
 }
 
-func NewSequencer() Sequencer {
+func newSequencer(ctx context.Context, name string, active bool, bufferSize int, gapTimeout time.Duration, skipGap bool) *sequencer {
 	s := &sequencer{
-		active: config.G.EnableSequencer,
+		ctx:        ctx,
+		name:       name,
+		active:     active,
+		bufferSize: bufferSize,
+		gapTimeout: gapTimeout,
+		skipGap:    skipGap,
+		buffer:     make(map[uint64]*pending),
 	}
 	s.cond = sync.NewCond(&s.mu)
+
+	if s.active {
+		go s.monitor()
+	}
 	return s
 }
 
 func (s *sequencer) Init(sequence uint64) {
-	logger.G.Info("init sequencer", zap.Uint64("sequence", sequence))
+	logger.G.Info("init sequencer", zap.String("name", s.name), zap.Uint64("sequence", sequence))
 	if s.sequence == 0 {
 		s.sequence = sequence - 1
 	} else {
@@ -44,22 +111,169 @@ func (s *sequencer) Init(sequence uint64) {
 	}
 }
 
-func (s *sequencer) CommitWithSequence(value Sequenceable, output Committable) {
+func (s *sequencer) CommitWithSequence(value Sequenceable, output Committable) error {
 	if !s.active {
 		output.Commit(value)
-		return
+		return nil
 	}
 
 	sequence := value.GetSequence()
 
 	s.mu.Lock()
-	for s.sequence+1 != sequence {
+	defer s.mu.Unlock()
+	for {
+		if sequence <= s.sequence {
+			return fmt.Errorf("%w: %d, last %d", ErrSequenceCommitted, sequence, s.sequence)
+		}
+
+		// the next sequence is never blocked, it frees the buffer
+		if sequence == s.sequence+1 || s.bufferSize <= 0 || len(s.buffer) < s.bufferSize {
+			break
+		}
+
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
 		s.cond.Wait()
 	}
 
-	output.Commit(value)
-	s.sequence = sequence
+	if len(s.buffer) == 0 {
+		s.waitSince = time.Now()
+	}
+	s.buffer[sequence] = &pending{value: value, output: output}
+	s.drain()
+	return nil
+}
+
+// drain commits the buffered values from the next sequence on, mu must be held
+func (s *sequencer) drain() {
+	committed := false
+	for {
+		p, ok := s.buffer[s.sequence+1]
+		if !ok {
+			break
+		}
 
-	s.cond.Broadcast()
-	s.mu.Unlock()
+		delete(s.buffer, s.sequence+1)
+		p.output.Commit(p.value)
+		s.sequence++
+		committed = true
+	}
+
+	metrics.SequencerBuffered.WithLabelValues(s.name).Set(float64(len(s.buffer)))
+	if committed {
+		s.waitSince = time.Now()
+		s.cond.Broadcast()
+	}
+}
+
+func (s *sequencer) monitor() {
+	interval := time.Second
+	if s.gapTimeout > 0 && s.gapTimeout/2 < interval {
+		interval = s.gapTimeout / 2
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			// wake the producers waiting for space, they return the ctx err
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			return
+		case <-ticker.C:
+			s.checkGap()
+		}
+	}
+}
+
+func (s *sequencer) checkGap() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buffer) == 0 {
+		metrics.SequencerGapAgeSec.WithLabelValues(s.name).Set(0)
+		return
+	}
+
+	age := time.Since(s.waitSince)
+	metrics.SequencerGapAgeSec.WithLabelValues(s.name).Set(age.Seconds())
+	if s.gapTimeout <= 0 || age < s.gapTimeout {
+		return
+	}
+
+	gap := Gap{From: s.sequence + 1, To: s.lowestBuffered() - 1}
+	if s.skipGap {
+		gap.SkippedAt = time.Now()
+		s.gaps = append(s.gaps, gap)
+		metrics.SequencerGaps.WithLabelValues(s.name, GapPolicySkip).Inc()
+		metrics.SequencerSkipped.WithLabelValues(s.name).Add(float64(gap.To - gap.From + 1))
+		logger.G.Error("sequencer skip gap, never committed unless backfilled",
+			zap.String("name", s.name),
+			zap.Uint64("from", gap.From),
+			zap.Uint64("to", gap.To),
+			zap.Duration("age", age))
+
+		s.sequence = gap.To
+		s.drain()
+		return
+	}
+
+	if s.warnedGap != gap.From {
+		s.warnedGap = gap.From
+		metrics.SequencerGaps.WithLabelValues(s.name, GapPolicyWait).Inc()
+		logger.G.Warn("sequencer wait gap",
+			zap.String("name", s.name),
+			zap.Uint64("from", gap.From),
+			zap.Uint64("to", gap.To),
+			zap.Duration("age", age),
+			zap.Int("buffered", len(s.buffer)))
+	}
+}
+
+// lowestBuffered returns the lowest buffered sequence, mu must be held and the buffer not empty
+func (s *sequencer) lowestBuffered() uint64 {
+	lowest := ^uint64(0)
+	for sequence := range s.buffer {
+		lowest = min(lowest, sequence)
+	}
+	return lowest
+}
+
+func (s *sequencer) buffered() []uint64 {
+	sequences := make([]uint64, 0, len(s.buffer))
+	for sequence := range s.buffer {
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool { return sequences[i] < sequences[j] })
+	return sequences
+}
+
+func (s *sequencer) Buffered() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buffered()
+}
+
+func (s *sequencer) Missing() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missing []uint64
+	next := s.sequence + 1
+	for _, sequence := range s.buffered() {
+		for ; next < sequence; next++ {
+			missing = append(missing, next)
+		}
+		next = sequence + 1
+	}
+	return missing
+}
+
+func (s *sequencer) Gaps() []Gap {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Gap(nil), s.gaps...)
 }
@@ -0,0 +1,126 @@
+package sequencer
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+type value uint64
+
+func (v value) GetSequence() uint64 {
+	return uint64(v)
+}
+
+type output struct {
+	mu        sync.Mutex
+	sequences []uint64
+}
+
+func (o *output) Commit(v Sequenceable) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sequences = append(o.sequences, v.GetSequence())
+}
+
+func (o *output) get() []uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]uint64(nil), o.sequences...)
+}
+
+func TestSequencer_Reorder(t *testing.T) {
+	s := newSequencer(context.Background(), "test", true, 0, 0, false)
+	s.Init(10)
+	o := &output{}
+
+	require.NoError(t, s.CommitWithSequence(value(12), o))
+	require.NoError(t, s.CommitWithSequence(value(14), o))
+	require.Empty(t, o.get())
+	require.Equal(t, []uint64{12, 14}, s.Buffered())
+	require.Equal(t, []uint64{10, 11, 13}, s.Missing())
+
+	require.NoError(t, s.CommitWithSequence(value(10), o))
+	require.NoError(t, s.CommitWithSequence(value(11), o))
+	require.Equal(t, []uint64{10, 11, 12}, o.get())
+	require.Equal(t, []uint64{13}, s.Missing())
+
+	require.NoError(t, s.CommitWithSequence(value(13), o))
+	require.Equal(t, []uint64{10, 11, 12, 13, 14}, o.get())
+	require.Empty(t, s.Buffered())
+
+	require.ErrorIs(t, s.CommitWithSequence(value(12), o), ErrSequenceCommitted)
+}
+
+func TestSequencer_BoundedBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newSequencer(ctx, "test", true, 1, 0, false)
+	s.Init(1)
+	o := &output{}
+
+	require.NoError(t, s.CommitWithSequence(value(2), o))
+
+	// the buffer is full, 3 waits for 1 to be committed
+	done := make(chan error)
+	go func() {
+		done <- s.CommitWithSequence(value(3), o)
+	}()
+	select {
+	case <-done:
+		t.Fatal("commit should block on a full buffer")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	require.NoError(t, s.CommitWithSequence(value(1), o))
+	require.NoError(t, <-done)
+	require.Equal(t, []uint64{1, 2, 3}, o.get())
+
+	// a waiting commit returns when the ctx is done
+	require.NoError(t, s.CommitWithSequence(value(5), o))
+	go func() {
+		done <- s.CommitWithSequence(value(6), o)
+	}()
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestSequencer_SkipGap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newSequencer(ctx, "test", true, 0, time.Millisecond*50, true)
+	s.Init(1)
+	o := &output{}
+
+	require.NoError(t, s.CommitWithSequence(value(1), o))
+	require.NoError(t, s.CommitWithSequence(value(4), o))
+	require.NoError(t, s.CommitWithSequence(value(5), o))
+
+	require.Eventually(t, func() bool {
+		return len(o.get()) == 3
+	}, time.Second, time.Millisecond*10)
+	require.Equal(t, []uint64{1, 4, 5}, o.get())
+
+	gaps := s.Gaps()
+	require.Len(t, gaps, 1)
+	require.Equal(t, uint64(2), gaps[0].From)
+	require.Equal(t, uint64(3), gaps[0].To)
+
+	// the skipped sequence is rejected when it arrives late
+	require.ErrorIs(t, s.CommitWithSequence(value(2), o), ErrSequenceCommitted)
+}
+
+func TestSequencer_WaitGap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newSequencer(ctx, "test", true, 0, time.Millisecond*20, false)
+	s.Init(1)
+	o := &output{}
+
+	require.NoError(t, s.CommitWithSequence(value(2), o))
+	time.Sleep(time.Millisecond * 100)
+	require.Empty(t, o.get())
+	require.Empty(t, s.Gaps())
+	require.Equal(t, []uint64{1}, s.Missing())
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"bxs/cache"
+	"bxs/chain_params"
+	"bxs/logger"
+	"bxs/repository"
+	"bxs/repository/orm"
+	"bxs/types"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"time"
+)
+
+/*
+CacheWarmer rebuilds the token and pair cache from the token_pair database.
+Pairs and tokens are only cached when they are created, so after the cache is lost
+every later swap on them is dropped as "not cached" until the cache is rebuilt.
+
+Besides tokens and pairs it reconstructs:
+  - filtered pairs: pairs whose token is not a known xLaunch token
+  - pending migrations: pools that finished the bonding curve (ActionMigrated)
+    while the token has no pancake main pair yet
+*/
+type CacheWarmer interface {
+	Warmup() error
+}
+
+type cacheWarmer struct {
+	chain            *chain_params.ChainParams
+	cache            cache.Cache
+	tokenRepository  *repository.TokenRepository
+	pairRepository   *repository.PairRepository
+	actionRepository *repository.ActionRepository
+	batchSize        int
+}
+
+func NewCacheWarmer(
+	chain *chain_params.ChainParams,
+	cache cache.Cache,
+	tokenRepository *repository.TokenRepository,
+	pairRepository *repository.PairRepository,
+	actionRepository *repository.ActionRepository,
+	batchSize int,
+) CacheWarmer {
+	return &cacheWarmer{
+		chain:            chain,
+		cache:            cache,
+		tokenRepository:  tokenRepository,
+		pairRepository:   pairRepository,
+		actionRepository: actionRepository,
+		batchSize:        batchSize,
+	}
+}
+
+func (w *cacheWarmer) Warmup() error {
+	if w.tokenRepository == nil || w.pairRepository == nil {
+		return errors.New("cache warmup needs the token_pair database")
+	}
+
+	now := time.Now()
+	tokenCnt, err := w.warmupTokens()
+	if err != nil {
+		return fmt.Errorf("warmup tokens: %w", err)
+	}
+
+	pairCnt, filteredCnt, err := w.warmupPairs()
+	if err != nil {
+		return fmt.Errorf("warmup pairs: %w", err)
+	}
+
+	pendingCnt, err := w.warmupMigrations()
+	if err != nil {
+		return fmt.Errorf("warmup migrations: %w", err)
+	}
+
+	logger.G.Info("cache warmup finish",
+		zap.Int("tokens", tokenCnt),
+		zap.Int("pairs", pairCnt),
+		zap.Int("filtered pairs", filteredCnt),
+		zap.Int("pending migrations", pendingCnt),
+		zap.Duration("duration", time.Since(now)))
+	return nil
+}
+
+func (w *cacheWarmer) warmupTokens() (int, error) {
+	cnt := 0
+	after := ""
+	for {
+		tokens, err := w.tokenRepository.ListAfter(after, w.batchSize)
+		if err != nil {
+			return cnt, err
+		}
+
+		for _, token := range tokens {
+			w.cache.SetToken(tokenFromOrm(token))
+		}
+
+		cnt += len(tokens)
+		if len(tokens) < w.batchSize {
+			return cnt, nil
+		}
+		after = tokens[len(tokens)-1].Address
+		logger.G.Info("cache warmup tokens", zap.Int("loaded", cnt))
+	}
+}
+
+func (w *cacheWarmer) warmupPairs() (int, int, error) {
+	cnt, filteredCnt := 0, 0
+	after := ""
+	for {
+		pairs, err := w.pairRepository.ListAfter(after, w.batchSize)
+		if err != nil {
+			return cnt, filteredCnt, err
+		}
+
+		for _, ormPair := range pairs {
+			pair, pairErr := w.pairFromOrm(ormPair)
+			if pairErr != nil {
+				logger.G.Warn("cache warmup skip pair", zap.String("pair", ormPair.Address), zap.Error(pairErr))
+				continue
+			}
+
+			if pair.Filtered {
+				filteredCnt++
+			}
+			w.cache.SetPair(pair)
+		}
+
+		cnt += len(pairs)
+		if len(pairs) < w.batchSize {
+			return cnt, filteredCnt, nil
+		}
+		after = pairs[len(pairs)-1].Address
+		logger.G.Info("cache warmup pairs", zap.Int("loaded", cnt))
+	}
+}
+
+func (w *cacheWarmer) warmupMigrations() (int, error) {
+	if w.actionRepository == nil {
+		logger.G.Warn("cache warmup skip pending migrations, tx database is not enabled")
+		return 0, nil
+	}
+
+	actions, err := w.actionRepository.ListByAction(types.ActionMigrated)
+	if err != nil {
+		return 0, err
+	}
+
+	cnt := 0
+	for _, action := range actions {
+		token, getErr := w.tokenRepository.GetByAddressAndChainId(action.Token)
+		if getErr != nil {
+			if errors.Is(getErr, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return cnt, getErr
+		}
+
+		// the main pair is set when the pancake pair of the migrated token is created
+		if token.MainPair != "" {
+			continue
+		}
+
+		w.cache.SetMigrateToken(common.HexToAddress(action.Token))
+		cnt++
+	}
+	return cnt, nil
+}
+
+func tokenFromOrm(token *orm.Token) *types.Token {
+	totalSupply, err := decimal.NewFromString(token.TotalSupply)
+	if err != nil {
+		logger.G.Warn("cache warmup token total supply", zap.String("token", token.Address), zap.Error(err))
+		totalSupply = types.ZeroDecimal
+	}
+
+	return &types.Token{
+		Address:     common.HexToAddress(token.Address),
+		Creator:     common.HexToAddress(token.Creator),
+		Name:        token.Name,
+		Symbol:      token.Symbol,
+		Decimals:    token.Decimal,
+		TotalSupply: totalSupply,
+		BlockNumber: token.Block,
+		BlockTime:   token.BlockAt,
+		Program:     token.Program,
+		Cid:         token.Cid,
+		Tid:         token.Tid,
+		Description: token.Description,
+		Telegram:    token.Telegram,
+		Twitter:     token.Twitter,
+		Website:     token.Website,
+	}
+}
+
+func (w *cacheWarmer) tokenTinyInfo(address common.Address) (*types.TokenTinyInfo, bool) {
+	if types.IsNativeToken(address) {
+		return types.NativeTokenTinyInfo(w.chain), true
+	}
+
+	if w.chain.IsWrappedNative(address) {
+		return types.WrappedNativeTokenTinyInfo(w.chain), true
+	}
+
+	token, ok := w.cache.GetToken(address)
+	if !ok {
+		return &types.TokenTinyInfo{Address: address}, false
+	}
+	return token.GetTokenTinyInfo(), true
+}
+
+func (w *cacheWarmer) pairFromOrm(ormPair *orm.Pair) (*types.Pair, error) {
+	protocolId, ok := types.GetProtocolId(ormPair.Program)
+	if !ok {
+		return nil, fmt.Errorf("unknown program %s", ormPair.Program)
+	}
+
+	token0, token0Ok := w.tokenTinyInfo(common.HexToAddress(ormPair.Token0))
+	token1, _ := w.tokenTinyInfo(common.HexToAddress(ormPair.Token1))
+
+	// the stored reserves are the last ones when the pool updates refresh them, not the init amounts
+	pair := &types.Pair{
+		Address:     common.HexToAddress(ormPair.Address),
+		Token0:      token0,
+		Token1:      token1,
+		InitAmount0: ormPair.Reserve0,
+		InitAmount1: ormPair.Reserve1,
+		Block:       ormPair.Block,
+		BlockAt:     ormPair.BlockAt,
+		ProtocolId:  protocolId,
+	}
+
+	/*
+		pancake sorts the pair tokens by address, the parser keeps the non wrapped native token as token0,
+		so the tokens were swapped exactly when the wrapped native token sorts before the token
+	*/
+	if protocolId == types.ProtocolIdPancakeV2 {
+		pair.TokenReversed = bytes.Compare(w.chain.WrappedNativeAddress.Bytes(), token0.Address.Bytes()) < 0
+	}
+
+	if !token0Ok {
+		pair.Filtered = true
+		pair.FilterCode = types.FilterCodeNoXLaunchToken
+	}
+
+	return pair, nil
+}
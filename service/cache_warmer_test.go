@@ -0,0 +1,89 @@
+package service
+
+import (
+	"bxs/cache"
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/repository"
+	"bxs/repository/orm"
+	"bxs/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheWarmer_Warmup(t *testing.T) {
+	db, err := repository.OpenSqlite(filepath.Join(t.TempDir(), "warmup.db"))
+	require.NoError(t, err)
+	chain := chain_params.G
+	chainId := chain.ChainID
+	tokenRepository := repository.NewTokenRepository(db, chainId)
+	pairRepository := repository.NewPairRepository(db, chainId)
+	actionRepository := repository.NewActionRepository(db, chainId)
+
+	wbnb := chain.WrappedNativeAddress
+	token := common.HexToAddress("0xffffffffffffffffffffffffffffffffffff0001")
+	migratedToken := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	unknownToken := common.HexToAddress("0x0000000000000000000000000000000000000003")
+	pool := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	pancakePair := common.HexToAddress("0x00000000000000000000000000000000000000a2")
+	filteredPair := common.HexToAddress("0x00000000000000000000000000000000000000a3")
+	migratedPool := common.HexToAddress("0x00000000000000000000000000000000000000a4")
+
+	require.NoError(t, tokenRepository.CreateBatch([]*orm.Token{
+		{Address: token.String(), Symbol: "T", Decimal: 18, TotalSupply: "1000", ChainId: chainId, Program: types.ProtocolNameXLaunch},
+		{Address: migratedToken.String(), Symbol: "M", Decimal: 18, TotalSupply: "1000", ChainId: chainId, Program: types.ProtocolNameXLaunch},
+	}))
+	require.NoError(t, pairRepository.CreateBatch([]*orm.Pair{
+		{Address: pool.String(), Token0: token.String(), Token1: types.ZeroAddress.String(), ChainId: chainId,
+			Reserve0: decimal.NewFromInt(1), Reserve1: decimal.NewFromInt(2), Program: types.ProtocolNameXLaunch},
+		{Address: pancakePair.String(), Token0: token.String(), Token1: wbnb.String(), ChainId: chainId,
+			Program: types.ProtocolNamePancakeV2},
+		{Address: filteredPair.String(), Token0: unknownToken.String(), Token1: wbnb.String(), ChainId: chainId,
+			Program: types.ProtocolNamePancakeV2},
+	}))
+	require.NoError(t, actionRepository.CreateBatch([]*orm.Action{
+		(&types.MigratedPool{Pool: migratedPool.String(), Token: migratedToken.String()}).GetOrmAction(chainId, 1, db.NowFunc()),
+	}))
+
+	c, err := cache.NewMemoryCache(chain.Name, chainId, config.G.Cache)
+	require.NoError(t, err)
+	// a small batch size, so paging is exercised
+	w := NewCacheWarmer(chain, c, tokenRepository, pairRepository, actionRepository, 1)
+	require.NoError(t, w.Warmup())
+
+	cachedToken, ok := c.GetToken(token)
+	require.True(t, ok)
+	require.Equal(t, "T", cachedToken.Symbol)
+	require.True(t, cachedToken.TotalSupply.Equal(decimal.NewFromInt(1000)))
+
+	cachedPool, ok := c.GetPair(pool)
+	require.True(t, ok)
+	require.Equal(t, types.ProtocolIdXLaunch, cachedPool.ProtocolId)
+	require.Equal(t, "T", cachedPool.Token0.Symbol)
+	require.True(t, cachedPool.InitAmount1.Equal(decimal.NewFromInt(2)))
+	require.False(t, cachedPool.Filtered)
+
+	cachedPancakePair, ok := c.GetPair(pancakePair)
+	require.True(t, ok)
+	require.Equal(t, chain.WrappedNativeSymbol, cachedPancakePair.Token1.Symbol)
+	// 0xbb4c... (WBNB) sorts before 0xffff..., so pancake had WBNB as token0
+	require.True(t, cachedPancakePair.TokenReversed)
+
+	cachedFilteredPair, ok := c.GetPair(filteredPair)
+	require.True(t, ok)
+	require.True(t, cachedFilteredPair.Filtered)
+	require.Equal(t, types.FilterCodeNoXLaunchToken, cachedFilteredPair.FilterCode)
+
+	require.True(t, c.MigrateTokenExist(migratedToken))
+	require.False(t, c.MigrateTokenExist(token))
+
+	// once the pancake pair is created the migration is no longer pending
+	require.NoError(t, tokenRepository.UpdateMainPair(migratedToken.String(), pancakePair.String()))
+	c, err = cache.NewMemoryCache(chain.Name, chainId, config.G.Cache)
+	require.NoError(t, err)
+	require.NoError(t, NewCacheWarmer(chain, c, tokenRepository, pairRepository, actionRepository, 100).Warmup())
+	require.False(t, c.MigrateTokenExist(migratedToken))
+}
@@ -1,11 +1,8 @@
 package service
 
 import (
-	"bxs/abi/bep20"
-	uniswapv2 "bxs/abi/uniswap/v2"
-	"bxs/abi/xlaunch"
+	"bxs/abi/registry"
 	"encoding/hex"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 )
@@ -24,50 +21,16 @@ func (r *CallContractReq) String() string {
 		"}"
 }
 
-func BuildCallContractReqDynamic(blockNumber *big.Int, address *common.Address, abi *abi.ABI, name string, args ...interface{}) *CallContractReq {
-	data, err := abi.Pack(name, args...)
+// BuildCallContractReq packs the call of a method of a contract kind of the ABI registry
+func BuildCallContractReq(blockNumber *big.Int, address *common.Address, kind, method string, args ...interface{}) (*CallContractReq, error) {
+	data, err := registry.G.Pack(kind, method, args...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return &CallContractReq{
 		BlockNumber: blockNumber,
 		Address:     address,
 		Data:        data,
-	}
-}
-
-var (
-	AbiNames = []struct {
-		Abi   *abi.ABI
-		Names []string
-	}{
-		{
-			Abi:   bep20.Abi,
-			Names: []string{"name", "symbol", "decimals", "totalSupply"},
-		},
-		{
-			Abi:   uniswapv2.PairAbi,
-			Names: []string{"token0", "token1"},
-		},
-		{
-			Abi:   xlaunch.PairAbi,
-			Names: []string{"token"},
-		},
-	}
-
-	Name2Data map[string][]byte
-)
-
-func init() {
-	Name2Data = make(map[string][]byte)
-	for _, abiName := range AbiNames {
-		for _, name := range abiName.Names {
-			data, err := abiName.Abi.Pack(name)
-			if err != nil {
-				panic(err)
-			}
-			Name2Data[name] = data
-		}
-	}
+	}, nil
 }
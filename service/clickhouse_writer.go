@@ -0,0 +1,577 @@
+package service
+
+import (
+	"bxs/config"
+	"bxs/logger"
+	"bxs/metrics"
+	"bxs/types"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+/*
+ClickHouseWriter copies trades, pool updates, actions and token creations of every committed block
+into ClickHouse for analytics, through the ClickHouse HTTP interface (INSERT ... FORMAT JSONEachRow).
+
+All tables are ReplacingMergeTree, ordered by pair (or token) and then by the row identity
+(block, block_index, tx_index) for trades, so rows written again on replay collapse on merge.
+
+Write only buffers the rows, they are inserted by the flush loop every FlushIntervalMs, or as soon as BatchSize rows
+are buffered, so a slow ClickHouse never holds the commit of a block. The rows over MaxBufferRows are dropped.
+*/
+type ClickHouseWriter interface {
+	Write(block *types.KafkaMsg) error
+	Flush(ctx context.Context) error
+}
+
+const (
+	chTableTrades         = "trades"
+	chTablePoolUpdates    = "pool_updates"
+	chTableActions        = "actions"
+	chTableTokenCreations = "token_creations"
+	chTableLaunchFlags    = "launch_flags"
+	chTableMevEvents      = "mev_events"
+	chTableFailedSwaps    = "failed_swaps"
+)
+
+var chTableDDLs = []struct {
+	table string
+	ddl   string
+}{
+	{chTableTrades, `CREATE TABLE IF NOT EXISTS %s.trades (
+	chain_id UInt32,
+	tx_hash String,
+	event LowCardinality(String),
+	maker String,
+	sender String,
+	token0_address String,
+	token1_address String,
+	token0_amount Decimal(76, 18),
+	token1_amount Decimal(76, 18),
+	amount_usd Decimal(76, 18),
+	price_usd Decimal(76, 18),
+	block UInt64,
+	block_at DateTime('UTC'),
+	block_index UInt32,
+	tx_index UInt32,
+	pair_address String,
+	program LowCardinality(String),
+	flags UInt32
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(block_at)
+ORDER BY (chain_id, pair_address, block_at, block, block_index, tx_index)`},
+	{chTablePoolUpdates, `CREATE TABLE IF NOT EXISTS %s.pool_updates (
+	chain_id UInt32,
+	address String,
+	token0 String,
+	token1 String,
+	amount0 Decimal(76, 18),
+	amount1 Decimal(76, 18),
+	block UInt64,
+	block_at DateTime('UTC'),
+	log_index UInt32
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(block_at)
+ORDER BY (chain_id, address, block_at, block, log_index)`},
+	{chTableActions, `CREATE TABLE IF NOT EXISTS %s.actions (
+	chain_id UInt32,
+	maker String,
+	token String,
+	pair String,
+	action LowCardinality(String),
+	tx_hash String,
+	creator String,
+	block UInt64,
+	block_at DateTime('UTC')
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(block_at)
+ORDER BY (chain_id, token, block_at, block, tx_hash, action)`},
+	{chTableTokenCreations, `CREATE TABLE IF NOT EXISTS %s.token_creations (
+	chain_id UInt32,
+	address String,
+	creator String,
+	name String,
+	symbol String,
+	decimal Int8,
+	total_supply String,
+	block UInt64,
+	block_at DateTime('UTC'),
+	program LowCardinality(String),
+	cid String,
+	tid String
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(block_at)
+ORDER BY (chain_id, address, block)`},
+	{chTableLaunchFlags, `CREATE TABLE IF NOT EXISTS %s.launch_flags (
+	chain_id UInt32,
+	token String,
+	creator String,
+	launch_block UInt64,
+	block UInt64,
+	flags UInt32,
+	flagged_buys UInt32,
+	flagged_wallets UInt32
+) ENGINE = ReplacingMergeTree(block)
+ORDER BY (chain_id, token)`},
+	{chTableMevEvents, `CREATE TABLE IF NOT EXISTS %s.mev_events (
+	chain_id UInt32,
+	kind LowCardinality(String),
+	block UInt64,
+	block_at DateTime('UTC'),
+	pool String,
+	token String,
+	attacker String,
+	attacker_txs Array(String),
+	victims Array(String),
+	victim_txs Array(String),
+	profit_usd Decimal(76, 18)
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(block_at)
+ORDER BY (chain_id, block, kind, pool, attacker_txs)`},
+	{chTableFailedSwaps, `CREATE TABLE IF NOT EXISTS %s.failed_swaps (
+	chain_id UInt32,
+	tx_hash String,
+	sender String,
+	to String,
+	method LowCardinality(String),
+	event LowCardinality(String),
+	token String,
+	token_amount Decimal(76, 18),
+	native_amount Decimal(76, 18),
+	revert_reason String,
+	gas_used UInt64,
+	gas_fee Decimal(76, 18),
+	block UInt64,
+	block_at DateTime('UTC'),
+	block_index UInt32
+) ENGINE = ReplacingMergeTree
+PARTITION BY toYYYYMM(block_at)
+ORDER BY (chain_id, token, block, block_index)`},
+}
+
+// chColumnDDLs add the columns to the tables created before them
+var chColumnDDLs = []string{
+	`ALTER TABLE %s.trades ADD COLUMN IF NOT EXISTS sender String AFTER maker`,
+	`ALTER TABLE %s.trades ADD COLUMN IF NOT EXISTS flags UInt32`,
+}
+
+type chTradeRow struct {
+	ChainId       int    `json:"chain_id"`
+	TxHash        string `json:"tx_hash"`
+	Event         string `json:"event"`
+	Maker         string `json:"maker"`
+	Sender        string `json:"sender"`
+	Token0Address string `json:"token0_address"`
+	Token1Address string `json:"token1_address"`
+	Token0Amount  string `json:"token0_amount"`
+	Token1Amount  string `json:"token1_amount"`
+	AmountUsd     string `json:"amount_usd"`
+	PriceUsd      string `json:"price_usd"`
+	Block         uint64 `json:"block"`
+	BlockAt       int64  `json:"block_at"`
+	BlockIndex    uint   `json:"block_index"`
+	TxIndex       uint   `json:"tx_index"`
+	PairAddress   string `json:"pair_address"`
+	Program       string `json:"program"`
+	Flags         int    `json:"flags"`
+}
+
+type chPoolUpdateRow struct {
+	ChainId  int    `json:"chain_id"`
+	Address  string `json:"address"`
+	Token0   string `json:"token0"`
+	Token1   string `json:"token1"`
+	Amount0  string `json:"amount0"`
+	Amount1  string `json:"amount1"`
+	Block    uint64 `json:"block"`
+	BlockAt  int64  `json:"block_at"`
+	LogIndex uint   `json:"log_index"`
+}
+
+type chActionRow struct {
+	ChainId int    `json:"chain_id"`
+	Maker   string `json:"maker"`
+	Token   string `json:"token"`
+	Pair    string `json:"pair"`
+	Action  string `json:"action"`
+	TxHash  string `json:"tx_hash"`
+	Creator string `json:"creator"`
+	Block   uint64 `json:"block"`
+	BlockAt int64  `json:"block_at"`
+}
+
+type chTokenCreationRow struct {
+	ChainId     int    `json:"chain_id"`
+	Address     string `json:"address"`
+	Creator     string `json:"creator"`
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Decimal     int8   `json:"decimal"`
+	TotalSupply string `json:"total_supply"`
+	Block       uint64 `json:"block"`
+	BlockAt     int64  `json:"block_at"`
+	Program     string `json:"program"`
+	Cid         string `json:"cid"`
+	Tid         string `json:"tid"`
+}
+
+// chLaunchFlagsRow replaces the previous row of the token, the latest block wins
+type chLaunchFlagsRow struct {
+	ChainId        int    `json:"chain_id"`
+	Token          string `json:"token"`
+	Creator        string `json:"creator"`
+	LaunchBlock    uint64 `json:"launch_block"`
+	Block          uint64 `json:"block"`
+	Flags          int    `json:"flags"`
+	FlaggedBuys    int    `json:"flagged_buys"`
+	FlaggedWallets int    `json:"flagged_wallets"`
+}
+
+type chMevEventRow struct {
+	ChainId     int      `json:"chain_id"`
+	Kind        string   `json:"kind"`
+	Block       uint64   `json:"block"`
+	BlockAt     int64    `json:"block_at"`
+	Pool        string   `json:"pool"`
+	Token       string   `json:"token"`
+	Attacker    string   `json:"attacker"`
+	AttackerTxs []string `json:"attacker_txs"`
+	Victims     []string `json:"victims"`
+	VictimTxs   []string `json:"victim_txs"`
+	ProfitUsd   string   `json:"profit_usd"`
+}
+
+type chFailedSwapRow struct {
+	ChainId      int    `json:"chain_id"`
+	TxHash       string `json:"tx_hash"`
+	Sender       string `json:"sender"`
+	To           string `json:"to"`
+	Method       string `json:"method"`
+	Event        string `json:"event"`
+	Token        string `json:"token"`
+	TokenAmount  string `json:"token_amount"`
+	NativeAmount string `json:"native_amount"`
+	RevertReason string `json:"revert_reason"`
+	GasUsed      uint64 `json:"gas_used"`
+	GasFee       string `json:"gas_fee"`
+	Block        uint64 `json:"block"`
+	BlockAt      int64  `json:"block_at"`
+	BlockIndex   uint   `json:"block_index"`
+}
+
+type clickHouseWriter struct {
+	ctx        context.Context
+	conf       *config.ClickHouseConf
+	httpClient *resty.Client
+	mu         sync.Mutex
+	rows       map[string][]any
+	rowCnt     int // the rows buffered and being inserted
+	dropped    int // by the Write in progress
+	flushMu    sync.Mutex
+	full       chan struct{}
+}
+
+func NewClickHouseWriter(ctx context.Context, conf *config.ClickHouseConf) ClickHouseWriter {
+	w := &clickHouseWriter{
+		ctx:  ctx,
+		conf: conf,
+		rows: make(map[string][]any),
+		full: make(chan struct{}, 1),
+	}
+
+	if !conf.Enabled {
+		return w
+	}
+
+	httpClient := resty.New()
+	httpClient.SetBaseURL(conf.Endpoint)
+	httpClient.SetTimeout(time.Millisecond * time.Duration(conf.TimeoutMs))
+	httpClient.SetRetryCount(conf.MaxRetry)
+	httpClient.SetRetryWaitTime(time.Millisecond * 100)
+	httpClient.SetRetryMaxWaitTime(time.Second * 2)
+	if conf.Username != "" {
+		httpClient.SetHeader("X-ClickHouse-User", conf.Username)
+		httpClient.SetHeader("X-ClickHouse-Key", conf.Password)
+	}
+	w.httpClient = httpClient
+
+	if err := w.createTables(); err != nil {
+		logger.G.Fatal("clickhouse create tables err", zap.Error(err))
+	}
+
+	go w.startFlushLoop()
+	return w
+}
+
+func (w *clickHouseWriter) exec(ctx context.Context, query string, body []byte) error {
+	req := w.httpClient.R().SetContext(ctx).SetQueryParam("query", query)
+	if len(body) > 0 {
+		req.SetBody(body)
+	}
+
+	resp, err := req.Post("/")
+	if err != nil {
+		return err
+	}
+
+	if resp.IsError() {
+		return fmt.Errorf("clickhouse status %d: %s", resp.StatusCode(), resp.String())
+	}
+	return nil
+}
+
+func (w *clickHouseWriter) createTables() error {
+	err := w.exec(w.ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", w.conf.Database), nil)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range chTableDDLs {
+		if err = w.exec(w.ctx, fmt.Sprintf(t.ddl, w.conf.Database), nil); err != nil {
+			return fmt.Errorf("create table %s: %w", t.table, err)
+		}
+	}
+	for _, ddl := range chColumnDDLs {
+		if err = w.exec(w.ctx, fmt.Sprintf(ddl, w.conf.Database), nil); err != nil {
+			return fmt.Errorf("add column: %w", err)
+		}
+	}
+	return nil
+}
+
+func (w *clickHouseWriter) startFlushLoop() {
+	ticker := time.NewTicker(time.Millisecond * time.Duration(w.conf.FlushIntervalMs))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-w.full:
+		}
+		if err := w.Flush(w.ctx); err != nil {
+			logger.G.Error("clickhouse flush err", zap.Error(err))
+		}
+	}
+}
+
+func (w *clickHouseWriter) append(table string, row any) {
+	if w.conf.MaxBufferRows > 0 && w.rowCnt >= w.conf.MaxBufferRows {
+		metrics.ClickHouseDroppedRows.WithLabelValues(table).Inc()
+		w.dropped++
+		return
+	}
+	w.rows[table] = append(w.rows[table], row)
+	w.rowCnt++
+}
+
+func (w *clickHouseWriter) Write(block *types.KafkaMsg) error {
+	if !w.conf.Enabled {
+		return nil
+	}
+
+	chainId := block.ChainId
+	blockAt := int64(block.Timestamp)
+
+	w.mu.Lock()
+	w.dropped = 0
+	for _, tx := range block.Txs {
+		w.append(chTableTrades, &chTradeRow{
+			ChainId:       chainId,
+			TxHash:        tx.TxHash,
+			Event:         tx.Event,
+			Maker:         tx.Maker,
+			Sender:        tx.Sender,
+			Token0Address: tx.Token0Address,
+			Token1Address: tx.Token1Address,
+			Token0Amount:  tx.Token0Amount.String(),
+			Token1Amount:  tx.Token1Amount.String(),
+			AmountUsd:     tx.AmountUsd.String(),
+			PriceUsd:      tx.PriceUsd.String(),
+			Block:         tx.Block,
+			BlockAt:       tx.BlockAt.Unix(),
+			BlockIndex:    tx.BlockIndex,
+			TxIndex:       tx.TxIndex,
+			PairAddress:   tx.PairAddress,
+			Program:       tx.Program,
+			Flags:         tx.Flags,
+		})
+	}
+
+	for _, pu := range block.PoolUpdates {
+		w.append(chTablePoolUpdates, &chPoolUpdateRow{
+			ChainId:  chainId,
+			Address:  pu.Address,
+			Token0:   pu.Token0,
+			Token1:   pu.Token1,
+			Amount0:  pu.Amount0.String(),
+			Amount1:  pu.Amount1.String(),
+			Block:    block.Height,
+			BlockAt:  blockAt,
+			LogIndex: pu.LogIndex,
+		})
+	}
+
+	for _, action := range block.Actions {
+		w.append(chTableActions, &chActionRow{
+			ChainId: chainId,
+			Maker:   action.Maker,
+			Token:   action.Token,
+			Pair:    action.Pair,
+			Action:  action.Action,
+			TxHash:  action.TxHash,
+			Creator: action.Creator,
+			Block:   action.Block,
+			BlockAt: action.BlockAt.Unix(),
+		})
+	}
+
+	for _, token := range block.NewTokens {
+		w.append(chTableTokenCreations, &chTokenCreationRow{
+			ChainId:     chainId,
+			Address:     token.Address,
+			Creator:     token.Creator,
+			Name:        token.Name,
+			Symbol:      token.Symbol,
+			Decimal:     token.Decimal,
+			TotalSupply: token.TotalSupply,
+			Block:       token.Block,
+			BlockAt:     token.BlockAt.Unix(),
+			Program:     token.Program,
+			Cid:         token.Cid,
+			Tid:         token.Tid,
+		})
+	}
+
+	for _, f := range block.LaunchFlags {
+		w.append(chTableLaunchFlags, &chLaunchFlagsRow{
+			ChainId:        chainId,
+			Token:          f.Token,
+			Creator:        f.Creator,
+			LaunchBlock:    f.LaunchBlock,
+			Block:          f.Block,
+			Flags:          f.Flags,
+			FlaggedBuys:    f.FlaggedBuys,
+			FlaggedWallets: f.FlaggedWallets,
+		})
+	}
+
+	for _, e := range block.MevEvents {
+		w.append(chTableMevEvents, &chMevEventRow{
+			ChainId:     chainId,
+			Kind:        e.Kind,
+			Block:       e.Block,
+			BlockAt:     blockAt,
+			Pool:        e.Pool,
+			Token:       e.Token,
+			Attacker:    e.Attacker,
+			AttackerTxs: e.AttackerTxs,
+			Victims:     e.Victims,
+			VictimTxs:   e.VictimTxs,
+			ProfitUsd:   e.ProfitUsd.String(),
+		})
+	}
+
+	for _, fs := range block.FailedSwaps {
+		w.append(chTableFailedSwaps, &chFailedSwapRow{
+			ChainId:      chainId,
+			TxHash:       fs.TxHash,
+			Sender:       fs.Sender,
+			To:           fs.To,
+			Method:       fs.Method,
+			Event:        fs.Event,
+			Token:        fs.Token,
+			TokenAmount:  fs.TokenAmount.String(),
+			NativeAmount: fs.NativeAmount.String(),
+			RevertReason: fs.RevertReason,
+			GasUsed:      fs.GasUsed,
+			GasFee:       fs.GasFee.String(),
+			Block:        fs.Block,
+			BlockAt:      blockAt,
+			BlockIndex:   fs.BlockIndex,
+		})
+	}
+	dropped := w.dropped
+	full := w.rowCnt >= w.conf.BatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("clickhouse buffer full, %d rows dropped", dropped)
+	}
+	return nil
+}
+
+/*
+Flush inserts all buffered rows, one INSERT per table, the buffer is swapped under the lock and inserted outside it.
+Rows of a table that failed to insert are buffered again and retried on the next flush.
+*/
+func (w *clickHouseWriter) Flush(ctx context.Context) error {
+	if !w.conf.Enabled {
+		return nil
+	}
+
+	// the flush loop and the final flush of the pipelines insert one after the other, in the order of the rows
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	pending := w.rows
+	w.rows = make(map[string][]any)
+	w.mu.Unlock()
+
+	var firstErr error
+	for table, rows := range pending {
+		if len(rows) == 0 {
+			continue
+		}
+
+		now := time.Now()
+		err := w.insert(ctx, table, rows)
+		w.mu.Lock()
+		if err != nil {
+			// before the rows written since
+			w.rows[table] = append(rows, w.rows[table]...)
+		} else {
+			w.rowCnt -= len(rows)
+		}
+		w.mu.Unlock()
+
+		if err != nil {
+			metrics.ClickHouseInsertErrors.WithLabelValues(table).Inc()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("insert %s: %w", table, err)
+			}
+			continue
+		}
+
+		metrics.ClickHouseInsertDurationMs.Observe(float64(time.Since(now).Milliseconds()))
+		metrics.ClickHouseInsertRows.WithLabelValues(table).Add(float64(len(rows)))
+	}
+
+	return firstErr
+}
+
+func (w *clickHouseWriter) insert(ctx context.Context, table string, rows []any) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+
+	return w.exec(ctx, fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", w.conf.Database, table), body.Bytes())
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bufio"
+	"bxs/config"
+	"bxs/metrics"
+	"bxs/repository/orm"
+	"bxs/types"
+	"context"
+	"encoding/json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type chStub struct {
+	mu      sync.Mutex
+	queries []string
+	rows    map[string][]map[string]any
+}
+
+func (s *chStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := r.URL.Query().Get("query")
+	s.queries = append(s.queries, query)
+
+	if strings.HasPrefix(query, "INSERT INTO ") {
+		table := strings.Fields(query)[2]
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			row := make(map[string]any)
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.rows[table] = append(s.rows[table], row)
+		}
+	} else {
+		io.Copy(io.Discard, r.Body)
+	}
+}
+
+func TestClickHouseWriter_Write(t *testing.T) {
+	stub := &chStub{rows: make(map[string][]map[string]any)}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	w := NewClickHouseWriter(context.Background(), &config.ClickHouseConf{
+		Enabled:         true,
+		Endpoint:        server.URL,
+		Database:        "bxs_test",
+		BatchSize:       2,
+		FlushIntervalMs: 60000,
+		TimeoutMs:       1000,
+	})
+
+	blockAt := time.Unix(1700000000, 0)
+	msg := &types.KafkaMsg{
+		Height:    100,
+		Timestamp: uint64(blockAt.Unix()),
+		Txs: []*orm.Tx{
+			{
+				TxHash:       "0x01",
+				Event:        types.Buy,
+				Maker:        "0xb1",
+				Sender:       "0xb2",
+				Token0Amount: decimal.NewFromInt(10),
+				Token1Amount: decimal.NewFromInt(1),
+				Block:        100,
+				BlockAt:      blockAt,
+				BlockIndex:   3,
+				TxIndex:      7,
+				PairAddress:  "0xa1",
+			},
+		},
+		PoolUpdates: []*types.PoolUpdate{
+			{LogIndex: 8, Address: "0xa1", Amount0: decimal.NewFromInt(5), Amount1: decimal.NewFromInt(6)},
+		},
+	}
+
+	// the block fills the batch of 2 rows, so the flush loop inserts it right away
+	require.NoError(t, w.Write(msg))
+	require.Eventually(t, func() bool {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		return len(stub.rows["bxs_test.pool_updates"]) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+
+	require.Equal(t, "CREATE DATABASE IF NOT EXISTS bxs_test", stub.queries[0])
+	require.Len(t, stub.rows["bxs_test.trades"], 1)
+	require.Len(t, stub.rows["bxs_test.pool_updates"], 1)
+
+	trade := stub.rows["bxs_test.trades"][0]
+	require.Equal(t, "0x01", trade["tx_hash"])
+	require.Equal(t, "0xb1", trade["maker"])
+	require.Equal(t, "0xb2", trade["sender"])
+	require.Equal(t, "10", trade["token0_amount"])
+	require.EqualValues(t, 3, trade["block_index"])
+	require.EqualValues(t, 7, trade["tx_index"])
+	require.EqualValues(t, blockAt.Unix(), trade["block_at"])
+
+	poolUpdate := stub.rows["bxs_test.pool_updates"][0]
+	require.EqualValues(t, 100, poolUpdate["block"])
+	require.EqualValues(t, 8, poolUpdate["log_index"])
+}
+
+// the rows over the buffer are dropped while the inserts fail, the buffered ones are inserted once they succeed
+func TestClickHouseWriter_MaxBufferRows(t *testing.T) {
+	stub := &chStub{rows: make(map[string][]map[string]any)}
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		stub.ServeHTTP(rw, r)
+	}))
+	defer server.Close()
+
+	w := NewClickHouseWriter(context.Background(), &config.ClickHouseConf{
+		Enabled:         true,
+		Endpoint:        server.URL,
+		Database:        "bxs_test",
+		BatchSize:       100,
+		FlushIntervalMs: 60000,
+		TimeoutMs:       1000,
+		MaxBufferRows:   2,
+	})
+	failing.Store(true)
+
+	dropped := testutil.ToFloat64(metrics.ClickHouseDroppedRows.WithLabelValues(chTableTrades))
+	msg := &types.KafkaMsg{Txs: []*orm.Tx{{TxHash: "0x01"}, {TxHash: "0x02"}}}
+	require.NoError(t, w.Write(msg))
+	require.Error(t, w.Flush(context.Background()))
+	require.ErrorContains(t, w.Write(&types.KafkaMsg{Txs: []*orm.Tx{{TxHash: "0x03"}}}), "1 rows dropped")
+	require.Equal(t, dropped+1, testutil.ToFloat64(metrics.ClickHouseDroppedRows.WithLabelValues(chTableTrades)))
+
+	failing.Store(false)
+	require.NoError(t, w.Flush(context.Background()))
+	stub.mu.Lock()
+	defer stub.mu.Unlock()
+	require.Len(t, stub.rows["bxs_test.trades"], 2)
+	require.Equal(t, "0x01", stub.rows["bxs_test.trades"][0]["tx_hash"])
+}
+
+func TestClickHouseWriter_Disabled(t *testing.T) {
+	w := NewClickHouseWriter(context.Background(), &config.ClickHouseConf{Enabled: false})
+	require.NoError(t, w.Write(&types.KafkaMsg{Txs: []*orm.Tx{{}}}))
+	require.NoError(t, w.Flush(context.Background()))
+}
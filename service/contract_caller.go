@@ -1,21 +1,30 @@
 package service
 
 import (
-	pancakev2 "bxs/abi/pancake/v2"
-	"bxs/abi/xlaunch"
+	"bxs/abi/registry"
+	"bxs/archive"
 	"bxs/chain_params"
 	"bxs/config"
+	"bxs/logger"
 	"bxs/metrics"
+	"bxs/tracing"
 	"bxs/types"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/avast/retry-go/v4"
 	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
 	"math/big"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,17 +37,28 @@ var (
 )
 
 type ContractCaller struct {
-	ctx         context.Context
-	ethClient   *ethclient.Client
-	retryParams *config.RetryParams
+	ctx          context.Context
+	chain        *chain_params.ChainParams
+	ethClient    *ethclient.Client
+	retryParams  *config.RetryParams
+	multicall    *multicallBatcher // nil when the calls are not batched
+	calls        archive.CallStore // nil when the answers are not archived
+	replay       bool              // the calls are answered from calls, the node is not called
+	noSimulateV1 *atomic.Bool      // shared with the copies, the node answered eth_simulateV1 is not a method
 }
 
-func NewContractCaller(ethClient *ethclient.Client, retryParams *config.RetryParams) *ContractCaller {
-	return &ContractCaller{
-		ctx:         context.Background(),
-		ethClient:   ethClient,
-		retryParams: retryParams,
+func NewContractCaller(ctx context.Context, chain *chain_params.ChainParams, ethClient *ethclient.Client, retryParams *config.RetryParams, multicallConf *config.MulticallConf) *ContractCaller {
+	c := &ContractCaller{
+		ctx:          ctx,
+		chain:        chain,
+		ethClient:    ethClient,
+		retryParams:  retryParams,
+		noSimulateV1: &atomic.Bool{},
 	}
+	if multicallConf != nil && multicallConf.Enabled && chain.Multicall3Address != (common.Address{}) {
+		c.multicall = newMulticallBatcher(chain.Multicall3Address, multicallConf, c.callContractWithRetry)
+	}
+	return c
 }
 
 func IsRetryableErr(err error) bool {
@@ -51,10 +71,10 @@ func IsRetryableErr(err error) bool {
 	return true
 }
 
-func (c *ContractCaller) callContract(req *CallContractReq) ([]byte, error) {
+func (c *ContractCaller) callContract(ctx context.Context, req *CallContractReq) ([]byte, error) {
 	now := time.Now()
 	bytes, err := c.ethClient.CallContract(
-		c.ctx,
+		ctx,
 		ethereum.CallMsg{
 			To:   req.Address,
 			Data: req.Data,
@@ -79,17 +99,87 @@ func (c *ContractCaller) callContract(req *CallContractReq) ([]byte, error) {
 	return bytes, nil
 }
 
+/*
+WithCallStore returns a caller archiving the answers of c to calls, the calls on the head state, the prices and the revert reasons,
+or answering them from calls in replay mode.
+*/
+func (c *ContractCaller) WithCallStore(calls archive.CallStore, replay bool) *ContractCaller {
+	cc := *c
+	cc.calls = calls
+	cc.replay = replay
+	return &cc
+}
+
+// archiveCall keeps the answer of a call, failing to archive it only loses it for the replay
+func (c *ContractCaller) archiveCall(key string, value []byte) {
+	if c.calls == nil || c.replay {
+		return
+	}
+	if err := c.calls.Put(key, value); err != nil {
+		logger.G.Warn("archive call err", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// WithContext returns a caller sharing the client and the multicall batcher of c, its calls are made under ctx and traced in its span
+func (c *ContractCaller) WithContext(ctx context.Context) *ContractCaller {
+	cc := *c
+	cc.ctx = ctx
+	return &cc
+}
+
+// CallContract batches the calls on the head state through Multicall3 when enabled
 func (c *ContractCaller) CallContract(req *CallContractReq) ([]byte, error) {
-	ctxWithTimeout, _ := context.WithTimeout(c.ctx, c.retryParams.Timeout)
+	// the calls at a block are the prices, archived as prices
+	key := ""
+	if c.calls != nil && req.BlockNumber == nil {
+		key = fmt.Sprintf("eth_call %s %s", req.Address.Hex(), hexutil.Encode(req.Data))
+		if c.replay {
+			return c.calls.Get(key)
+		}
+	}
+
+	batched := c.multicall != nil && req.BlockNumber == nil
+	attrs := []attribute.KeyValue{
+		attribute.String("contract.address", req.Address.String()),
+		attribute.Bool("multicall", batched),
+	}
+	if len(req.Data) >= 4 {
+		attrs = append(attrs, attribute.String("contract.selector", hexutil.Encode(req.Data[:4])))
+	}
+	if req.BlockNumber != nil {
+		attrs = append(attrs, tracing.BlockNumber(req.BlockNumber.Uint64()))
+	}
+	ctx, span := tracing.Start(c.ctx, "eth_call", attrs...)
+
+	var (
+		bytes []byte
+		err   error
+	)
+	if batched {
+		bytes, err = c.multicall.Call(ctx, req)
+	} else {
+		bytes, err = c.callContractWithRetry(ctx, req)
+	}
+	tracing.End(span, err)
+	if err == nil && key != "" {
+		c.archiveCall(key, bytes)
+	}
+	return bytes, err
+}
+
+func (c *ContractCaller) callContractWithRetry(ctx context.Context, req *CallContractReq) ([]byte, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.retryParams.Timeout)
+	defer cancel()
 	return retry.DoWithData(func() ([]byte, error) {
-		return c.callContract(req)
+		return c.callContract(ctxWithTimeout, req)
 	}, c.retryParams.Attempts, c.retryParams.Delay, retry.Context(ctxWithTimeout))
 }
 
-func (c *ContractCaller) queryValues(address *common.Address, name string, outputLength int) ([]interface{}, error) {
-	req := &CallContractReq{
-		Address: address,
-		Data:    Name2Data[name], // TODO check
+// queryValues calls a no-argument method of a contract kind, both packed and unpacked with the ABI registry
+func (c *ContractCaller) queryValues(address *common.Address, kind, method string, outputLength int) ([]interface{}, error) {
+	req, err := BuildCallContractReq(nil, address, kind, method)
+	if err != nil {
+		return nil, err
 	}
 
 	bytes, err := c.CallContract(req)
@@ -101,12 +191,7 @@ func (c *ContractCaller) queryValues(address *common.Address, name string, outpu
 		return nil, ErrOutputEmpty
 	}
 
-	upk, ok := Name2Unpacker[name]
-	if !ok {
-		return nil, ErrUnpackerNotFound
-	}
-
-	values, unpackErr := upk.Unpack(name, bytes, outputLength)
+	values, unpackErr := unpackerOf(kind).Unpack(method, bytes, outputLength)
 	if unpackErr != nil {
 		return nil, unpackErr
 	}
@@ -114,8 +199,8 @@ func (c *ContractCaller) queryValues(address *common.Address, name string, outpu
 	return values, nil
 }
 
-func (c *ContractCaller) queryString(address *common.Address, name string) (string, error) {
-	values, err := c.queryValues(address, name, 1)
+func (c *ContractCaller) queryString(address *common.Address, kind, method string) (string, error) {
+	values, err := c.queryValues(address, kind, method, 1)
 	if err != nil {
 		return "", err
 	}
@@ -123,15 +208,15 @@ func (c *ContractCaller) queryString(address *common.Address, name string) (stri
 }
 
 func (c *ContractCaller) CallName(address *common.Address) (string, error) {
-	return c.queryString(address, "name")
+	return c.queryString(address, registry.KindBep20, "name")
 }
 
 func (c *ContractCaller) CallSymbol(address *common.Address) (string, error) {
-	return c.queryString(address, "symbol")
+	return c.queryString(address, registry.KindBep20, "symbol")
 }
 
-func (c *ContractCaller) queryInt(address *common.Address, name string) (int, error) {
-	values, err := c.queryValues(address, name, 1)
+func (c *ContractCaller) queryInt(address *common.Address, kind, method string) (int, error) {
+	values, err := c.queryValues(address, kind, method, 1)
 	if err != nil {
 		return 0, err
 	}
@@ -139,11 +224,11 @@ func (c *ContractCaller) queryInt(address *common.Address, name string) (int, er
 }
 
 func (c *ContractCaller) CallDecimals(address *common.Address) (int, error) {
-	return c.queryInt(address, "decimals")
+	return c.queryInt(address, registry.KindBep20, "decimals")
 }
 
-func (c *ContractCaller) queryBigInt(address *common.Address, name string) (*big.Int, error) {
-	values, err := c.queryValues(address, name, 1)
+func (c *ContractCaller) queryBigInt(address *common.Address, kind, method string) (*big.Int, error) {
+	values, err := c.queryValues(address, kind, method, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -151,11 +236,11 @@ func (c *ContractCaller) queryBigInt(address *common.Address, name string) (*big
 }
 
 func (c *ContractCaller) CallTotalSupply(address *common.Address) (*big.Int, error) {
-	return c.queryBigInt(address, "totalSupply")
+	return c.queryBigInt(address, registry.KindBep20, "totalSupply")
 }
 
-func (c *ContractCaller) queryAddress(address *common.Address, name string) (common.Address, error) {
-	values, err := c.queryValues(address, name, 1)
+func (c *ContractCaller) queryAddress(address *common.Address, kind, method string) (common.Address, error) {
+	values, err := c.queryValues(address, kind, method, 1)
 	if err != nil {
 		return types.ZeroAddress, err
 	}
@@ -163,15 +248,15 @@ func (c *ContractCaller) queryAddress(address *common.Address, name string) (com
 }
 
 func (c *ContractCaller) CallToken0(address *common.Address) (common.Address, error) {
-	return c.queryAddress(address, "token0")
+	return c.queryAddress(address, registry.KindPancakeV2Pair, "token0")
 }
 
 func (c *ContractCaller) CallToken1(address *common.Address) (common.Address, error) {
-	return c.queryAddress(address, "token1")
+	return c.queryAddress(address, registry.KindPancakeV2Pair, "token1")
 }
 
 func (c *ContractCaller) CallToken(address *common.Address) (common.Address, error) {
-	return c.queryAddress(address, "token")
+	return c.queryAddress(address, registry.KindXLaunchPool, "token")
 }
 
 /*
@@ -179,7 +264,10 @@ CallGetPair
 for uniswap/pancake v2
 */
 func (c *ContractCaller) CallGetPair(factoryAddress, token0Address, token1Address *common.Address) (common.Address, error) {
-	req := BuildCallContractReqDynamic(nil, factoryAddress, pancakev2.FactoryAbi, "getPair", token0Address, token1Address)
+	req, err := BuildCallContractReq(nil, factoryAddress, registry.KindPancakeV2Factory, "getPair", token0Address, token1Address)
+	if err != nil {
+		return types.ZeroAddress, err
+	}
 
 	bytes, err := c.CallContract(req)
 	if err != nil {
@@ -203,7 +291,10 @@ func (c *ContractCaller) CallGetPair(factoryAddress, token0Address, token1Addres
 }
 
 func (c *ContractCaller) CallGetLaunchByAddress(factoryAddress, tokenAddress *common.Address) (bool, error) {
-	req := BuildCallContractReqDynamic(nil, factoryAddress, xlaunch.FactoryAbi, "getLaunchByAddress", tokenAddress)
+	req, err := BuildCallContractReq(nil, factoryAddress, registry.KindXLaunchFactory, "getLaunchByAddress", tokenAddress)
+	if err != nil {
+		return false, err
+	}
 
 	bytes, err := c.CallContract(req)
 	if err != nil {
@@ -228,10 +319,13 @@ func (c *ContractCaller) CallGetLaunchByAddress(factoryAddress, tokenAddress *co
 
 /*
 callGetReserves
-for uniswap/pancake v2
+for uniswap/pancake v2, of the chain price pair
 */
 func (c *ContractCaller) callGetReserves(blockNumber *big.Int) ([]interface{}, error) {
-	req := BuildCallContractReqDynamic(blockNumber, &chain_params.G.PancakeV2BusdWbnbPairAddress, pancakev2.PairAbi, "getReserves")
+	req, err := BuildCallContractReq(blockNumber, &c.chain.PricePairAddress, registry.KindPancakeV2Pair, "getReserves")
+	if err != nil {
+		return nil, err
+	}
 
 	bytes, err := c.CallContract(req)
 	if err != nil {
@@ -254,7 +348,32 @@ func (c *ContractCaller) callGetReserves(blockNumber *big.Int) ([]interface{}, e
 	return values, nil
 }
 
+func priceKey(blockNumber *big.Int) string {
+	return "price " + blockNumber.String()
+}
+
+// archivePrice keeps the price of a block, also when it is got from the cache
+func (c *ContractCaller) archivePrice(blockNumber *big.Int, price decimal.Decimal) {
+	c.archiveCall(priceKey(blockNumber), []byte(price.String()))
+}
+
 func (c *ContractCaller) GetPriceByBlockNumber(blockNumber *big.Int) (decimal.Decimal, error) {
+	if c.replay && c.calls != nil {
+		value, err := c.calls.Get(priceKey(blockNumber))
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return decimal.NewFromString(string(value))
+	}
+
+	price, err := c.getPriceByBlockNumber(blockNumber)
+	if err == nil {
+		c.archivePrice(blockNumber, price)
+	}
+	return price, err
+}
+
+func (c *ContractCaller) getPriceByBlockNumber(blockNumber *big.Int) (decimal.Decimal, error) {
 	values, err := c.callGetReserves(blockNumber)
 	if err != nil {
 		return decimal.Zero, err
@@ -270,5 +389,58 @@ func (c *ContractCaller) GetPriceByBlockNumber(blockNumber *big.Int) (decimal.De
 		return decimal.Zero, ErrReserve1NotBigInt
 	}
 
-	return decimal.NewFromBigInt(reserve1, 0).Div(decimal.NewFromBigInt(reserve0, 0)), nil
+	reserveNative, reserveStable := reserve0, reserve1
+	if !c.chain.PricePairNativeIsToken0 {
+		reserveNative, reserveStable = reserve1, reserve0
+	}
+
+	native := decimal.NewFromBigInt(reserveNative, -int32(c.chain.WrappedNativeDecimals))
+	if native.IsZero() {
+		return decimal.Zero, ErrOutputEmpty
+	}
+	return decimal.NewFromBigInt(reserveStable, -int32(c.chain.PricePairStableDecimals)).Div(native), nil
+}
+
+/*
+RevertReason re-simulates a call on the state at a block and returns its revert reason,
+empty when the call does not revert, the error message when the revert data is not an Error(string).
+*/
+func (c *ContractCaller) RevertReason(msg ethereum.CallMsg, blockNumber *big.Int) (string, error) {
+	key := ""
+	if c.calls != nil {
+		key = fmt.Sprintf("revert %s %v %v %s %v", msg.From.Hex(), msg.To, msg.Value, hexutil.Encode(msg.Data), blockNumber)
+		if c.replay {
+			reason, err := c.calls.Get(key)
+			return string(reason), err
+		}
+	}
+
+	reason, err := c.revertReason(msg, blockNumber)
+	if err == nil && key != "" {
+		c.archiveCall(key, []byte(reason))
+	}
+	return reason, err
+}
+
+func (c *ContractCaller) revertReason(msg ethereum.CallMsg, blockNumber *big.Int) (string, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(c.ctx, c.retryParams.Timeout)
+	defer cancel()
+
+	_, err := c.ethClient.CallContract(ctxWithTimeout, msg, blockNumber)
+	if err == nil {
+		return "", nil
+	}
+	if IsRetryableErr(err) {
+		return "", err
+	}
+
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			if reason, unpackErr := abi.UnpackRevert(common.FromHex(data)); unpackErr == nil {
+				return reason, nil
+			}
+		}
+	}
+	return err.Error(), nil
 }
@@ -1,42 +1,118 @@
 package service
 
 import (
+	"bxs/abi/registry"
+	"bxs/archive"
+	"bxs/chain/fake"
+	"bxs/chain_params"
+	"bxs/config"
+	"context"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/stretchr/testify/require"
+	"math/big"
 	"testing"
 )
 
+func nameData(t *testing.T, kind, method string) []byte {
+	data, err := registry.G.Pack(kind, method)
+	require.NoError(t, err)
+	return data
+}
+
 func TestContractCaller_CallContract(t *testing.T) {
-	cc := GetTestContext().ContractCaller
 	address := common.HexToAddress("0x4200000000000000000000000000000000000006")
+	cc := GetTestContext(t, "call_contract", func(c *TestChain) {
+		c.SetCall(address, nameData(t, registry.KindPancakeV2Pair, "getReserves"), nil)
+		c.SetResult(address, registry.KindBep20, "name", nil, "Wrapped Ether")
+	}).ContractCaller
 	req := &CallContractReq{
 		Address: &address,
 	}
 
 	// call erc20 contract with a method not exist, should return non err and empty bytes
-	req.Data = Name2Data["getReserves"]
+	req.Data = nameData(t, registry.KindPancakeV2Pair, "getReserves")
 	bytes, err := cc.CallContract(req)
 	require.Nil(t, err)
 	require.Equal(t, 0, len(bytes))
 
 	// call erc20 contract with a method exist, should return non err and non-empty bytes
-	req.Data = Name2Data["name"]
+	req.Data = nameData(t, registry.KindBep20, "name")
 	bytes, err = cc.CallContract(req)
 	require.Nil(t, err)
 	require.True(t, len(bytes) > 0)
 }
 
 func TestContractCaller_queryValues(t *testing.T) {
-	cc := GetTestContext().ContractCaller
 	pairAddress := common.HexToAddress("0xc9034c3E7F58003E6ae0C8438e7c8f4598d5ACAA")
+	cc := GetTestContext(t, "query_values", func(c *TestChain) {
+		c.SetCall(pairAddress, nameData(t, registry.KindBep20, "name"), nil)
+		c.SetResult(pairAddress, registry.KindPancakeV2Pair, "token0", nil, common.HexToAddress("0xae13d989daC2f0dEbFf460aC112a837C89BAa7cd"))
+	}).ContractCaller
 
 	// call pair contract with a method not exist, should return err and empty values
-	values, err := cc.queryValues(&pairAddress, "name", 1)
+	values, err := cc.queryValues(&pairAddress, registry.KindBep20, "name", 1)
 	require.Equal(t, ErrOutputEmpty, err)
 	require.Equal(t, 0, len(values))
 
 	// call pair contract with a method exist, should return non err and non-empty values
-	values, err = cc.queryValues(&pairAddress, "token0", 1)
+	values, err = cc.queryValues(&pairAddress, registry.KindPancakeV2Pair, "token0", 1)
 	require.Nil(t, err)
 	require.True(t, len(values) > 0)
 }
+
+func TestUnpackerOf(t *testing.T) {
+	dsToken, err := registry.G.Method(registry.KindDsToken, "name")
+	require.NoError(t, err)
+	var name [32]byte
+	copy(name[:], "MKR")
+	data, err := dsToken.Outputs.Pack(name)
+	require.NoError(t, err)
+
+	// the bytes32 name of DSToken is unpacked as a BEP20 name
+	values, err := unpackerOf(registry.KindBep20).Unpack("name", data, 1)
+	require.NoError(t, err)
+	require.Equal(t, name, values[0])
+
+	_, err = unpackerOf(registry.KindPancakeV2Pair).Unpack("token0", data[:1], 1)
+	require.Equal(t, UnpackErr, err)
+}
+
+// the calls answered by the node are archived, then answered from the archive without it
+func TestContractCaller_CallStore(t *testing.T) {
+	calls, err := archive.NewCallStore(t.TempDir())
+	require.NoError(t, err)
+	defer calls.Close()
+
+	token := common.HexToAddress("0x70")
+	fc := fake.NewChain(chain_params.G)
+	fc.SetCall(token, nameData(t, registry.KindBep20, "symbol"), common.LeftPadBytes([]byte{1}, 32))
+	pricePair, err := registry.G.Method(registry.KindPancakeV2Pair, "getReserves")
+	require.NoError(t, err)
+	e18 := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	reserves, err := pricePair.Outputs.Pack(e18, new(big.Int).Mul(big.NewInt(600), e18), uint32(0))
+	require.NoError(t, err)
+	fc.SetCall(chain_params.G.PricePairAddress, nameData(t, registry.KindPancakeV2Pair, "getReserves"), reserves)
+
+	req := &CallContractReq{Address: &token, Data: nameData(t, registry.KindBep20, "symbol")}
+	retryParams := config.G.ContractCaller.Retry.GetRetryParams()
+	recorder := NewContractCaller(context.Background(), chain_params.G, fc.Client(), retryParams, &config.MulticallConf{}).WithCallStore(calls, false)
+	result, err := recorder.CallContract(req)
+	require.NoError(t, err)
+	price, err := recorder.GetPriceByBlockNumber(big.NewInt(100))
+	require.NoError(t, err)
+
+	noNode := ethclient.NewClient(rpc.DialInProc(rpc.NewServer()))
+	replayer := NewContractCaller(context.Background(), chain_params.G, noNode, retryParams, &config.MulticallConf{}).WithCallStore(calls, true)
+	replayedResult, err := replayer.CallContract(req)
+	require.NoError(t, err)
+	require.Equal(t, result, replayedResult)
+	replayedPrice, err := replayer.GetPriceByBlockNumber(big.NewInt(100))
+	require.NoError(t, err)
+	require.True(t, price.Equal(replayedPrice), replayedPrice)
+
+	// a call not archived is not sent to the node
+	_, err = replayer.GetPriceByBlockNumber(big.NewInt(101))
+	require.ErrorIs(t, err, archive.ErrCallNotFound)
+}
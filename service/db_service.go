@@ -1,25 +1,49 @@
 package service
 
 import (
+	"bxs/config"
+	"bxs/logger"
+	"bxs/metrics"
 	"bxs/repository"
 	"bxs/repository/orm"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"time"
 )
 
 type DBService interface {
 	AddTokens(tokens []*orm.Token) error
 	AddPairs(pairs []*orm.Pair) error
 	AddTxs(txs []*orm.Tx) error
+	// FlushTxs stores the txs buffered by the bulk load, it returns true once none is left buffered
+	FlushTxs(force bool) (bool, error)
 	AddActions(actions []*orm.Action) error
+	AddFailedSwaps(swaps []*orm.FailedSwap) error
+	AddMevEvents(events []*orm.MevEvent) error
 	UpdateToken(tokenAddress, mainPairAddress string) error
+	UpdateTokenSecurity(token *orm.Token) error
+	UpdateTokenMetadata(token *orm.Token) error
+	UpdateTokenSupply(changes []*orm.TokenSupplyChange) error
+	UpdatePairReserves(pairs []*orm.Pair) error
+	ListPairsSince(program string, since time.Time) ([]*orm.Pair, error)
+	UpdateLag(headHeight, height uint64)
 }
 
 type dbService struct {
-	tokenRepository  *repository.TokenRepository
-	pairRepository   *repository.PairRepository
-	txRepository     *repository.TxRepository
-	actionRepository *repository.ActionRepository
-	enableTokenPair  bool
-	enableTx         bool
+	chain                string
+	tokenRepository      *repository.TokenRepository
+	pairRepository       *repository.PairRepository
+	txRepository         *repository.TxRepository
+	actionRepository     *repository.ActionRepository
+	failedSwapRepository *repository.FailedSwapRepository
+	mevEventRepository   *repository.MevEventRepository
+	enableTokenPair      bool
+	enableTx             bool
+	bulkLoadConf         *config.BulkLoadConf
+	bulkLoad             atomic.Bool
+	loadTxs              func(txs []*orm.Tx) (int64, error)
+	pendingTxs           []*orm.Tx // buffered across blocks while bulk loading, by the commit goroutine only
+	pendingSince         time.Time
 }
 
 func (s *dbService) AddTokens(tokens []*orm.Token) error {
@@ -43,29 +67,172 @@ func (s *dbService) AddTxs(txs []*orm.Tx) error {
 		return nil
 	}
 
-	return s.txRepository.CreateBatch(txs, "token0_address", "block", "block_index", "tx_index")
+	if s.bulkLoad.Load() {
+		if len(s.pendingTxs) == 0 {
+			s.pendingSince = time.Now()
+		}
+		s.pendingTxs = append(s.pendingTxs, txs...)
+		return nil
+	}
+
+	if _, err := s.FlushTxs(true); err != nil {
+		return err
+	}
+	return s.txRepository.CreateBatch(txs, "chain_id", "token0_address", "block", "block_index", "tx_index")
+}
+
+/*
+FlushTxs bulk loads the buffered txs once BatchRows are buffered or the first one waited FlushIntervalMs,
+right away when force is set or the bulk load is off.
+*/
+func (s *dbService) FlushTxs(force bool) (bool, error) {
+	if len(s.pendingTxs) == 0 {
+		return true, nil
+	}
+	if !force && s.bulkLoad.Load() && len(s.pendingTxs) < s.bulkLoadConf.BatchRows &&
+		time.Since(s.pendingSince) < time.Duration(s.bulkLoadConf.FlushIntervalMs)*time.Millisecond {
+		return false, nil
+	}
+
+	if err := s.bulkLoadTxs(s.pendingTxs); err != nil {
+		return false, err
+	}
+	s.pendingTxs = nil
+	return true, nil
+}
+
+func (s *dbService) bulkLoadTxs(txs []*orm.Tx) error {
+	now := time.Now()
+	inserted, err := s.loadTxs(txs)
+	if err != nil {
+		return err
+	}
+
+	duration := time.Since(now)
+	metrics.BulkLoadDurationMs.WithLabelValues(s.chain).Observe(float64(duration.Milliseconds()))
+	metrics.BulkLoadRows.WithLabelValues(s.chain, "tx").Add(float64(inserted))
+	if duration > 0 {
+		metrics.BulkLoadRowsPerSecond.WithLabelValues(s.chain, "tx").Set(float64(inserted) / duration.Seconds())
+	}
+	return nil
+}
+
+/*
+UpdateLag switches AddTxs between the per-batch insert and the COPY based bulk load, which buffers the txs across blocks.
+Bulk load is turned on once the committed height falls LagThreshold blocks behind the chain head,
+and turned off again when it has caught up to half of that, so it does not flap around the threshold.
+*/
+func (s *dbService) UpdateLag(headHeight, height uint64) {
+	if s.bulkLoadConf == nil || !s.bulkLoadConf.Enabled || headHeight < height {
+		return
+	}
+
+	lag := headHeight - height
+	if !s.bulkLoad.Load() && lag >= s.bulkLoadConf.LagThreshold {
+		logger.G.Info("bulk load on", zap.Uint64("height", height), zap.Uint64("lag", lag))
+		s.bulkLoad.Store(true)
+		metrics.BulkLoadActive.WithLabelValues(s.chain).Set(1)
+	} else if s.bulkLoad.Load() && lag < s.bulkLoadConf.LagThreshold/2 {
+		logger.G.Info("bulk load off", zap.Uint64("height", height), zap.Uint64("lag", lag))
+		s.bulkLoad.Store(false)
+		metrics.BulkLoadActive.WithLabelValues(s.chain).Set(0)
+	}
 }
 
 func (s *dbService) AddActions(actions []*orm.Action) error {
+	if !s.enableTx {
+		return nil
+	}
+
 	return s.actionRepository.CreateBatch(actions)
 }
 
+func (s *dbService) AddFailedSwaps(swaps []*orm.FailedSwap) error {
+	if !s.enableTx {
+		return nil
+	}
+
+	return s.failedSwapRepository.CreateBatch(swaps, "chain_id", "tx_hash")
+}
+
+func (s *dbService) AddMevEvents(events []*orm.MevEvent) error {
+	if !s.enableTx {
+		return nil
+	}
+
+	return s.mevEventRepository.CreateBatch(events, "chain_id", "block", "kind", "pool", "attacker_txs")
+}
+
 func (s *dbService) UpdateToken(tokenAddress, mainPairAddress string) error {
 	return s.tokenRepository.UpdateMainPair(tokenAddress, mainPairAddress)
 }
 
+func (s *dbService) UpdateTokenSecurity(token *orm.Token) error {
+	if !s.enableTokenPair {
+		return nil
+	}
+
+	return s.tokenRepository.UpdateSecurity(token)
+}
+
+func (s *dbService) UpdateTokenMetadata(token *orm.Token) error {
+	if !s.enableTokenPair {
+		return nil
+	}
+
+	return s.tokenRepository.UpdateMetadata(token)
+}
+
+func (s *dbService) UpdateTokenSupply(changes []*orm.TokenSupplyChange) error {
+	if !s.enableTokenPair {
+		return nil
+	}
+
+	return s.tokenRepository.UpdateTotalSupply(changes)
+}
+
+func (s *dbService) UpdatePairReserves(pairs []*orm.Pair) error {
+	if !s.enableTokenPair {
+		return nil
+	}
+
+	return s.pairRepository.UpdateReserves(pairs)
+}
+
+func (s *dbService) ListPairsSince(program string, since time.Time) ([]*orm.Pair, error) {
+	if !s.enableTokenPair {
+		return nil, nil
+	}
+
+	return s.pairRepository.ListByProgramSince(program, since)
+}
+
 func NewDBService(
+	chain string,
 	tokenRepository *repository.TokenRepository,
 	pairRepository *repository.PairRepository,
 	txRepository *repository.TxRepository,
 	actionRepository *repository.ActionRepository,
+	failedSwapRepository *repository.FailedSwapRepository,
+	mevEventRepository *repository.MevEventRepository,
+	bulkLoadConf *config.BulkLoadConf,
 ) DBService {
-	return &dbService{
-		tokenRepository:  tokenRepository,
-		pairRepository:   pairRepository,
-		txRepository:     txRepository,
-		actionRepository: actionRepository,
-		enableTokenPair:  tokenRepository != nil && pairRepository != nil,
-		enableTx:         txRepository != nil,
+	s := &dbService{
+		chain:                chain,
+		tokenRepository:      tokenRepository,
+		pairRepository:       pairRepository,
+		txRepository:         txRepository,
+		actionRepository:     actionRepository,
+		failedSwapRepository: failedSwapRepository,
+		mevEventRepository:   mevEventRepository,
+		enableTokenPair:      tokenRepository != nil && pairRepository != nil,
+		enableTx:             txRepository != nil,
+		bulkLoadConf:         bulkLoadConf,
+	}
+	if txRepository != nil {
+		s.loadTxs = func(txs []*orm.Tx) (int64, error) {
+			return txRepository.BulkLoad(txs, "chain_id", "token0_address", "block", "block_index", "tx_index")
+		}
 	}
+	return s
 }
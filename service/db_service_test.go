@@ -0,0 +1,52 @@
+package service
+
+import (
+	"bxs/config"
+	"bxs/repository/orm"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// while bulk loading the txs of the blocks are copied together, and all of them once the bulk load is off
+func TestDBService_BufferTxs(t *testing.T) {
+	conf := &config.BulkLoadConf{Enabled: true, LagThreshold: 100, BatchRows: 3, FlushIntervalMs: 60000}
+	var loaded [][]*orm.Tx
+	s := &dbService{enableTx: true, bulkLoadConf: conf}
+	s.loadTxs = func(txs []*orm.Tx) (int64, error) {
+		loaded = append(loaded, txs)
+		return int64(len(txs)), nil
+	}
+	s.UpdateLag(1000, 100)
+
+	for block := uint64(1); block <= 2; block++ {
+		require.NoError(t, s.AddTxs([]*orm.Tx{{Block: block}}))
+		stored, err := s.FlushTxs(false)
+		require.NoError(t, err)
+		require.False(t, stored)
+	}
+	require.Empty(t, loaded)
+
+	require.NoError(t, s.AddTxs([]*orm.Tx{{Block: 3}}))
+	stored, err := s.FlushTxs(false)
+	require.NoError(t, err)
+	require.True(t, stored)
+	require.Len(t, loaded, 1)
+	require.Len(t, loaded[0], 3)
+
+	// the first tx buffered waited long enough
+	require.NoError(t, s.AddTxs([]*orm.Tx{{Block: 4}}))
+	s.pendingSince = time.Now().Add(-time.Minute)
+	stored, err = s.FlushTxs(false)
+	require.NoError(t, err)
+	require.True(t, stored)
+	require.Len(t, loaded, 2)
+
+	// the bulk load turned off leaves no tx buffered
+	require.NoError(t, s.AddTxs([]*orm.Tx{{Block: 5}}))
+	s.UpdateLag(1000, 990)
+	stored, err = s.FlushTxs(false)
+	require.NoError(t, err)
+	require.True(t, stored)
+	require.Len(t, loaded, 3)
+}
@@ -4,27 +4,41 @@ import (
 	"bxs/config"
 	"bxs/logger"
 	"bxs/metrics"
+	"bxs/tracing"
 	"bxs/types"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
+	"sync"
 	"time"
 )
 
 type KafkaSender interface {
-	Send(block *types.KafkaMsg) error
+	// Send puts the trace context of ctx, the span of the block, in the message headers
+	Send(ctx context.Context, block *types.KafkaMsg) error
+	// Close flushes the buffered messages and closes the producer, Send must not be called after it
+	Close() error
+	// LastError returns the last error of the producer and when it failed, a nil error when it never did
+	LastError() (time.Time, error)
 }
 
 type kafkaSender struct {
+	ctx           context.Context
 	ID            string
 	conf          *config.KafkaConf
 	sendTimeout   time.Duration
 	asyncProducer sarama.AsyncProducer
+	lastErrMu     sync.Mutex
+	lastErrAt     time.Time
+	lastErr       error
 }
 
-func NewKafkaSender(conf *config.KafkaConf) KafkaSender {
+func NewKafkaSender(ctx context.Context, conf *config.KafkaConf) KafkaSender {
 	client := &kafkaSender{
+		ctx:         ctx,
 		conf:        conf,
 		sendTimeout: time.Millisecond * time.Duration(conf.SendTimeoutByMs),
 	}
@@ -47,8 +61,21 @@ func NewKafkaSender(conf *config.KafkaConf) KafkaSender {
 	return client
 }
 
-func (s *kafkaSender) Close() {
-	_ = s.asyncProducer.Close()
+func (s *kafkaSender) Close() error {
+	return s.asyncProducer.Close()
+}
+
+func (s *kafkaSender) setLastError(err error) {
+	s.lastErrMu.Lock()
+	defer s.lastErrMu.Unlock()
+	s.lastErrAt = time.Now()
+	s.lastErr = err
+}
+
+func (s *kafkaSender) LastError() (time.Time, error) {
+	s.lastErrMu.Lock()
+	defer s.lastErrMu.Unlock()
+	return s.lastErrAt, s.lastErr
 }
 
 func (s *kafkaSender) processErrors() {
@@ -61,24 +88,37 @@ func (s *kafkaSender) processErrors() {
 				return
 			}
 			logger.G.Info("kafka asyncProducer error", zap.Error(err))
+			s.setLastError(err)
 		}
 	}()
 }
 
-func (s *kafkaSender) Send(block *types.KafkaMsg) error {
+func (s *kafkaSender) Send(ctx context.Context, block *types.KafkaMsg) error {
 	if !s.conf.Enabled {
 		return nil
 	}
 
+	ctx, span := tracing.Start(ctx, "kafka.send", attribute.String("messaging.destination.name", s.conf.Topic))
+	err := s.send(ctx, block)
+	tracing.End(span, err)
+	return err
+}
+
+func (s *kafkaSender) send(ctx context.Context, block *types.KafkaMsg) error {
 	data, err := json.Marshal(block)
 	if err != nil {
 		return fmt.Errorf("json.Marshal error: %v, %v", err, block)
 	}
 
 	now := time.Now()
-	s.asyncProducer.Input() <- &sarama.ProducerMessage{
-		Topic: s.conf.Topic,
-		Value: sarama.ByteEncoder(data),
+	select {
+	case s.asyncProducer.Input() <- &sarama.ProducerMessage{
+		Topic:   s.conf.Topic,
+		Value:   sarama.ByteEncoder(data),
+		Headers: tracing.KafkaHeaders(ctx),
+	}:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
 	}
 	metrics.SendBlockKafkaDurationMs.Observe(float64(time.Since(now).Milliseconds()))
 
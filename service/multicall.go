@@ -0,0 +1,176 @@
+package service
+
+import (
+	"bxs/abi/multicall3"
+	"bxs/abi/registry"
+	"bxs/config"
+	"bxs/metrics"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"sync"
+	"time"
+)
+
+type multicallResult struct {
+	bytes []byte
+	err   error
+}
+
+type multicallReq struct {
+	ctx  context.Context // the ctx of the caller, its span is the parent of the call
+	req  *CallContractReq
+	done chan *multicallResult
+}
+
+/*
+multicallBatcher coalesces the calls made within a window into one Multicall3 aggregate3 call, with allowFailure,
+sent through call, so a batch is a single RPC round-trip with the retries of CallContract.
+Each caller gets the return data of its call, or nil bytes and no error when it failed, as a reverted eth_call.
+When the aggregate call fails each caller gets the error, when it returns nothing (no Multicall3 on the chain)
+the calls are sent one by one, each under the ctx of its caller.
+
+The aggregate call is shared by the callers of the batch: it is traced in the span of the first one and is not
+cancelled by any of them, a caller whose ctx is done returns its error without waiting for the batch,
+and is left out of the batch when it is done before the flush.
+*/
+type multicallBatcher struct {
+	address  common.Address
+	window   time.Duration
+	maxBatch int
+	call     func(ctx context.Context, req *CallContractReq) ([]byte, error)
+	mu       sync.Mutex
+	pending  []*multicallReq
+	timer    *time.Timer
+}
+
+func newMulticallBatcher(address common.Address, conf *config.MulticallConf, call func(ctx context.Context, req *CallContractReq) ([]byte, error)) *multicallBatcher {
+	return &multicallBatcher{
+		address:  address,
+		window:   time.Duration(conf.WindowMs) * time.Millisecond,
+		maxBatch: max(conf.MaxBatch, 1),
+		call:     call,
+	}
+}
+
+func (b *multicallBatcher) Call(ctx context.Context, req *CallContractReq) ([]byte, error) {
+	r := &multicallReq{ctx: ctx, req: req, done: make(chan *multicallResult, 1)}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, r)
+	if len(b.pending) >= b.maxBatch {
+		batch := b.takeLocked()
+		b.mu.Unlock()
+		b.flush(batch)
+	} else {
+		if len(b.pending) == 1 {
+			b.timer = time.AfterFunc(b.window, b.flushPending)
+		}
+		b.mu.Unlock()
+	}
+
+	select {
+	case result := <-r.done:
+		return result.bytes, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *multicallBatcher) takeLocked() []*multicallReq {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+func (b *multicallBatcher) flushPending() {
+	b.mu.Lock()
+	batch := b.takeLocked()
+	b.mu.Unlock()
+	if len(batch) > 0 {
+		b.flush(batch)
+	}
+}
+
+func (b *multicallBatcher) flush(batch []*multicallReq) {
+	live := batch[:0]
+	for _, r := range batch {
+		if r.ctx.Err() == nil {
+			live = append(live, r)
+		}
+	}
+	batch = live
+	if len(batch) == 0 {
+		return
+	}
+
+	metrics.MulticallBatchSize.Observe(float64(len(batch)))
+	if len(batch) == 1 {
+		b.callOneByOne(batch)
+		return
+	}
+
+	calls := make([]multicall3.Call3, len(batch))
+	for i, r := range batch {
+		calls[i] = multicall3.Call3{Target: *r.req.Address, AllowFailure: true, CallData: r.req.Data}
+	}
+	req, err := BuildCallContractReq(nil, &b.address, registry.KindMulticall3, "aggregate3", calls)
+	if err != nil {
+		b.callOneByOne(batch)
+		return
+	}
+
+	bytes, err := b.call(context.WithoutCancel(batch[0].ctx), req)
+	if err != nil {
+		for _, r := range batch {
+			r.done <- &multicallResult{err: err}
+		}
+		return
+	}
+
+	results, ok := unpackAggregate3(bytes)
+	if !ok || len(results) != len(batch) {
+		b.callOneByOne(batch)
+		return
+	}
+
+	for i, r := range batch {
+		if results[i].Success {
+			r.done <- &multicallResult{bytes: results[i].ReturnData}
+		} else {
+			r.done <- &multicallResult{}
+		}
+	}
+}
+
+func (b *multicallBatcher) callOneByOne(batch []*multicallReq) {
+	var wg sync.WaitGroup
+	for _, r := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bytes, err := b.call(r.ctx, r.req)
+			r.done <- &multicallResult{bytes: bytes, err: err}
+		}()
+	}
+	wg.Wait()
+}
+
+func unpackAggregate3(bytes []byte) ([]multicall3.Result, bool) {
+	if len(bytes) == 0 {
+		return nil, false
+	}
+
+	var results []multicall3.Result
+	contractAbi, err := registry.G.ABI(registry.KindMulticall3)
+	if err != nil {
+		return nil, false
+	}
+	if err = contractAbi.UnpackIntoInterface(&results, "aggregate3", bytes); err != nil {
+		return nil, false
+	}
+	return results, true
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"bxs/abi/multicall3"
+	"bxs/abi/registry"
+	"bxs/config"
+	"bytes"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMulticall echoes the call data of the calls to targets other than failTarget
+type fakeMulticall struct {
+	mu         sync.Mutex
+	address    common.Address
+	failTarget common.Address
+	noCode     bool // the aggregate call returns nothing, as with no Multicall3 deployed
+	batches    [][]multicall3.Call3
+	direct     int
+	ctxs       []context.Context // of the direct calls
+}
+
+func (f *fakeMulticall) call(ctx context.Context, req *CallContractReq) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if *req.Address != f.address {
+		f.direct++
+		f.ctxs = append(f.ctxs, ctx)
+		return req.Data, nil
+	}
+	if f.noCode {
+		return nil, nil
+	}
+
+	method := multicall3.Multicall3Abi.Methods["aggregate3"]
+	values, err := method.Inputs.Unpack(req.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	var calls []multicall3.Call3
+	if err = method.Inputs.Copy(&calls, values); err != nil {
+		return nil, err
+	}
+	f.batches = append(f.batches, calls)
+
+	results := make([]multicall3.Result, len(calls))
+	for i, call := range calls {
+		if call.Target != f.failTarget {
+			results[i] = multicall3.Result{Success: true, ReturnData: call.CallData}
+		}
+	}
+	return method.Outputs.Pack(results)
+}
+
+func callConcurrently(b *multicallBatcher, reqs []*CallContractReq) [][]byte {
+	out := make([][]byte, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out[i], _ = b.Call(context.Background(), req)
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+func TestMulticallBatcher_Coalesce(t *testing.T) {
+	token, failing := common.HexToAddress("0x70"), common.HexToAddress("0x71")
+	f := &fakeMulticall{address: multicall3.Address, failTarget: failing}
+	b := newMulticallBatcher(multicall3.Address, &config.MulticallConf{WindowMs: 50, MaxBatch: 3}, f.call)
+
+	reqs := []*CallContractReq{
+		{Address: &token, Data: nameData(t, registry.KindBep20, "name")},
+		{Address: &token, Data: nameData(t, registry.KindBep20, "symbol")},
+		{Address: &failing, Data: nameData(t, registry.KindBep20, "decimals")},
+	}
+	out := callConcurrently(b, reqs)
+
+	// the third call fills the batch, it is sent without waiting for the window
+	require.Len(t, f.batches, 1)
+	require.Len(t, f.batches[0], 3)
+	require.True(t, bytes.Equal(nameData(t, registry.KindBep20, "name"), out[0]))
+	require.True(t, bytes.Equal(nameData(t, registry.KindBep20, "symbol"), out[1]))
+	require.Nil(t, out[2])
+	require.Zero(t, f.direct)
+
+	// a single call in the window is sent as is
+	out = callConcurrently(b, reqs[:1])
+	require.Len(t, f.batches, 1)
+	require.Equal(t, 1, f.direct)
+	require.True(t, bytes.Equal(nameData(t, registry.KindBep20, "name"), out[0]))
+}
+
+func TestMulticallBatcher_NoMulticall3(t *testing.T) {
+	token := common.HexToAddress("0x70")
+	f := &fakeMulticall{address: multicall3.Address, noCode: true}
+	b := newMulticallBatcher(multicall3.Address, &config.MulticallConf{WindowMs: 10, MaxBatch: 2}, f.call)
+
+	out := callConcurrently(b, []*CallContractReq{
+		{Address: &token, Data: nameData(t, registry.KindBep20, "name")},
+		{Address: &token, Data: nameData(t, registry.KindBep20, "totalSupply")},
+	})
+	require.Equal(t, 2, f.direct)
+	require.True(t, bytes.Equal(nameData(t, registry.KindBep20, "name"), out[0]))
+	require.True(t, bytes.Equal(nameData(t, registry.KindBep20, "totalSupply"), out[1]))
+}
+
+type ctxKey struct{}
+
+func TestMulticallBatcher_Context(t *testing.T) {
+	token := common.HexToAddress("0x70")
+	f := &fakeMulticall{address: multicall3.Address}
+	b := newMulticallBatcher(multicall3.Address, &config.MulticallConf{WindowMs: 50, MaxBatch: 10}, f.call)
+
+	// a call sent on its own is made under the ctx of its caller
+	ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+	out, err := b.Call(ctx, &CallContractReq{Address: &token, Data: nameData(t, registry.KindBep20, "name")})
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(nameData(t, registry.KindBep20, "name"), out))
+	require.Len(t, f.ctxs, 1)
+	require.Equal(t, "caller", f.ctxs[0].Value(ctxKey{}))
+
+	// a cancelled caller returns without waiting for the window, and is left out of the batch
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	now := time.Now()
+	_, err = b.Call(cancelled, &CallContractReq{Address: &token, Data: nameData(t, registry.KindBep20, "symbol")})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(now), 50*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	require.Equal(t, 1, f.direct)
+	require.Empty(t, f.batches)
+}
@@ -2,13 +2,14 @@ package service
 
 import (
 	"bxs/cache"
-	"bxs/chain_params"
 	"bxs/logger"
 	"bxs/metrics"
+	"bxs/tracing"
 	"bxs/types"
 	"context"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"golang.org/x/sync/singleflight"
 	"math/big"
@@ -68,28 +69,34 @@ func (s *pairService) doGetToken(tokenAddress common.Address) (*types.Token, err
 		}
 	)
 
+	// the calls are traced under the span of the token
+	ctx, span := tracing.Start(s.ctx, "pair_service.get_token", attribute.String("token.address", tokenAddress.String()))
+	contractCaller := s.contractCaller.WithContext(ctx)
+
+	// issued at once, they are coalesced into one multicall when the contract caller batches
 	var wg sync.WaitGroup
 	wg.Add(4)
 	go func() {
 		defer wg.Done()
-		nameRes.name, nameRes.err = s.contractCaller.CallName(&tokenAddress)
+		nameRes.name, nameRes.err = contractCaller.CallName(&tokenAddress)
 	}()
 
 	go func() {
 		defer wg.Done()
-		symbolRes.symbol, symbolRes.err = s.contractCaller.CallSymbol(&tokenAddress)
+		symbolRes.symbol, symbolRes.err = contractCaller.CallSymbol(&tokenAddress)
 	}()
 
 	go func() {
 		defer wg.Done()
-		decimalsRes.decimals, decimalsRes.err = s.contractCaller.CallDecimals(&tokenAddress)
+		decimalsRes.decimals, decimalsRes.err = contractCaller.CallDecimals(&tokenAddress)
 	}()
 
 	go func() {
 		defer wg.Done()
-		supplyRes.supply, supplyRes.err = s.contractCaller.CallTotalSupply(&tokenAddress)
+		supplyRes.supply, supplyRes.err = contractCaller.CallTotalSupply(&tokenAddress)
 	}()
 	wg.Wait()
+	tracing.End(span, decimalsRes.err)
 
 	if nameRes.err == nil {
 		token.Name = nameRes.name
@@ -146,7 +153,7 @@ func (s *pairService) getPairTokens(pair *types.Pair) *types.PairWrap {
 	}
 
 	pair.Token0 = token0.GetTokenTinyInfo()
-	pair.Token1 = types.NativeTokenTinyInfo
+	pair.Token1 = types.NativeTokenTinyInfo(s.contractCaller.chain)
 
 	pairWrap.NewToken0 = !fromCache
 	pairWrap.NewToken1 = false
@@ -166,7 +173,11 @@ func (s *pairService) GetPairTokens(pair *types.Pair) *types.PairWrap {
 
 func (s *pairService) getPair(pairAddress common.Address) *types.PairWrap {
 	doResult, _, _ := s.group.Do(pairAddress.String()+"gp", func() (interface{}, error) {
-		pair := s.doGetPair(pairAddress)
+		ctx, span := tracing.Start(s.ctx, "pair_service.get_pair", attribute.String("pair.address", pairAddress.String()))
+		defer span.End()
+		contractCaller := s.contractCaller.WithContext(ctx)
+
+		pair := s.doGetPair(contractCaller, pairAddress)
 		if pair.Filtered {
 			s.SetPair(pair)
 			return &types.PairWrap{
@@ -177,7 +188,7 @@ func (s *pairService) getPair(pairAddress common.Address) *types.PairWrap {
 			}, nil
 		}
 
-		if !s.verifyPair(pair) {
+		if !s.verifyPair(contractCaller, pair) {
 			s.SetPair(pair)
 			return &types.PairWrap{
 				Pair:      pair,
@@ -204,13 +215,13 @@ func (s *pairService) GetPair(pairAddress common.Address) *types.PairWrap {
 	return s.getPair(pairAddress)
 }
 
-func (s *pairService) doGetPair(pairAddress common.Address) *types.Pair {
+func (s *pairService) doGetPair(contractCaller *ContractCaller, pairAddress common.Address) *types.Pair {
 	pair := &types.Pair{
 		Address: pairAddress,
 	}
 
 	now := time.Now()
-	token0Addr, err := s.contractCaller.CallToken(&pairAddress)
+	token0Addr, err := contractCaller.CallToken(&pairAddress)
 	if err != nil {
 		logger.G.Info("Err: CallToken err, this pair will filtered",
 			zap.Error(err),
@@ -233,22 +244,22 @@ func (s *pairService) doGetPair(pairAddress common.Address) *types.Pair {
 	return pair
 }
 
-func (s *pairService) verifyXLaunch(pair *types.Pair) bool {
-	verified, err := s.contractCaller.CallGetLaunchByAddress(&chain_params.G.XLaunchFactoryAddress, &pair.Address)
+func (s *pairService) verifyXLaunch(contractCaller *ContractCaller, pair *types.Pair) bool {
+	verified, err := contractCaller.CallGetLaunchByAddress(&s.contractCaller.chain.XLaunchFactoryAddress, &pair.Address)
 	if err != nil {
 		return false
 	}
 	return verified
 }
 
-func (s *pairService) verifyPair(pair *types.Pair) bool {
+func (s *pairService) verifyPair(contractCaller *ContractCaller, pair *types.Pair) bool {
 	now := time.Now()
 	defer func() {
 		duration := float64(time.Since(now).Milliseconds())
 		metrics.VerifyPairDurationMs.Observe(duration)
 	}()
 
-	if s.verifyXLaunch(pair) {
+	if s.verifyXLaunch(contractCaller, pair) {
 		pair.ProtocolId = types.ProtocolIdXLaunch
 		metrics.VerifyPairTotal.WithLabelValues("success").Inc()
 		metrics.VerifyPairOkByProtocol.WithLabelValues("xlaunch").Inc()
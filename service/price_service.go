@@ -4,23 +4,31 @@ import (
 	"bxs/cache"
 	"bxs/logger"
 	"bxs/metrics"
+	"bxs/tracing"
 	"bxs/types"
 	"context"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/panjf2000/ants/v2"
 	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"math/big"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type PriceService interface {
 	Start(startBlockNumber uint64)
-	GetPrice(blockNumber *big.Int) (decimal.Decimal, error)
+	// GetPrice traces the price lookup in the span of ctx
+	GetPrice(ctx context.Context, blockNumber *big.Int) (decimal.Decimal, error)
+	// UpdatedAt returns when a price was last got from the chain, or the time of the latest exchange price
+	UpdatedAt() time.Time
 }
 
 type priceService struct {
+	ctx            context.Context
 	fromChain      bool
 	cache          cache.Cache
 	contractCaller *ContractCaller
@@ -31,9 +39,11 @@ type priceService struct {
 	price          decimal.Decimal
 	timestampSec   int64
 	lock           sync.RWMutex
+	chainPriceAt   atomic.Int64 // unix nano of the last price got from the chain or its cache
 }
 
 func NewPriceService(
+	ctx context.Context,
 	fromChain bool,
 	cache cache.Cache,
 	contractCaller *ContractCaller,
@@ -50,6 +60,7 @@ func NewPriceService(
 	}
 
 	ps := &priceService{
+		ctx:            ctx,
 		fromChain:      fromChain,
 		cache:          cache,
 		contractCaller: contractCaller,
@@ -59,6 +70,7 @@ func NewPriceService(
 		priceGetter:    NewPriceGetterBitget(),
 	}
 
+	ps.chainPriceAt.Store(time.Now().UnixNano())
 	if !fromChain {
 		p, ts, err := ps.priceGetter.GetLatest()
 		if err != nil {
@@ -80,7 +92,7 @@ func (ps *priceService) updatePrice(price decimal.Decimal, timestamp int64) {
 func (ps *priceService) Start(startBlockNumber uint64) {
 	if !ps.fromChain {
 		go func() {
-			for {
+			for ps.ctx.Err() == nil {
 				p, t, err := ps.priceGetter.GetLatest()
 				if err != nil {
 					logger.G.Error("get latest price err", zap.Error(err))
@@ -101,17 +113,17 @@ func (ps *priceService) Start(startBlockNumber uint64) {
 	}
 
 	go func() {
-		for {
-			headerBlockNumber, err := ps.ethClient.BlockNumber(context.Background())
+		for ps.ctx.Err() == nil {
+			headerBlockNumber, err := ps.ethClient.BlockNumber(ps.ctx)
 			if err != nil {
 				logger.G.Error("ethClient.BigIntHeight", zap.Error(err))
 				time.Sleep(time.Second)
 				continue
 			}
 
-			for startBlockNumber <= headerBlockNumber {
+			for startBlockNumber <= headerBlockNumber && ps.ctx.Err() == nil {
 				ps.workPool.Submit(func() {
-					ps.GetPrice(big.NewInt(int64(startBlockNumber)))
+					ps.GetPrice(ps.ctx, big.NewInt(int64(startBlockNumber)))
 					startBlockNumber++
 				})
 			}
@@ -119,7 +131,23 @@ func (ps *priceService) Start(startBlockNumber uint64) {
 	}()
 }
 
-func (ps *priceService) GetPrice(blockNumber *big.Int) (decimal.Decimal, error) {
+func (ps *priceService) UpdatedAt() time.Time {
+	if !ps.fromChain {
+		ps.lock.RLock()
+		defer ps.lock.RUnlock()
+		return time.Unix(ps.timestampSec, 0)
+	}
+	return time.Unix(0, ps.chainPriceAt.Load())
+}
+
+func (ps *priceService) GetPrice(ctx context.Context, blockNumber *big.Int) (decimal.Decimal, error) {
+	ctx, span := tracing.Start(ctx, "price", tracing.BlockNumber(blockNumber.Uint64()))
+	price, err := ps.getCachedPrice(ctx, blockNumber)
+	tracing.End(span, err)
+	return price, err
+}
+
+func (ps *priceService) getCachedPrice(ctx context.Context, blockNumber *big.Int) (decimal.Decimal, error) {
 	if !ps.fromChain {
 		ps.lock.RLock()
 		defer ps.lock.RUnlock()
@@ -130,17 +158,20 @@ func (ps *priceService) GetPrice(blockNumber *big.Int) (decimal.Decimal, error)
 	}
 
 	cachePrice, ok := ps.cache.GetPrice(blockNumber)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cached", ok))
 	if ok {
+		ps.contractCaller.archivePrice(blockNumber, cachePrice)
+		ps.chainPriceAt.Store(time.Now().UnixNano())
 		return cachePrice, nil
 	}
 
-	return ps.getPrice(blockNumber)
+	return ps.getPrice(ctx, blockNumber)
 }
 
-func (ps *priceService) getPrice(blockNumber *big.Int) (decimal.Decimal, error) {
+func (ps *priceService) getPrice(ctx context.Context, blockNumber *big.Int) (decimal.Decimal, error) {
 	now := time.Now()
 
-	bnbPrice, err := ps.contractCaller.GetPriceByBlockNumber(blockNumber)
+	bnbPrice, err := ps.contractCaller.WithContext(ctx).GetPriceByBlockNumber(blockNumber)
 	if err != nil {
 		logger.G.Error("GetPriceByBlockNumber err", zap.Error(err), zap.Uint64("blockNumber", blockNumber.Uint64()))
 		return types.ZeroDecimal, err
@@ -148,6 +179,7 @@ func (ps *priceService) getPrice(blockNumber *big.Int) (decimal.Decimal, error)
 
 	metrics.CallContractForBNBPrice.Observe(time.Since(now).Seconds())
 	ps.cache.SetPrice(blockNumber, bnbPrice)
+	ps.chainPriceAt.Store(time.Now().UnixNano())
 
 	return bnbPrice, nil
 }
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"bxs/abi/multicall3"
+	"bxs/abi/registry"
+	"bxs/logger"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.uber.org/zap"
+	"math/big"
+	"strings"
+)
+
+// SimOverride overrides the state of an account for a simulation
+type SimOverride struct {
+	Balance *hexutil.Big  `json:"balance,omitempty"`
+	Code    hexutil.Bytes `json:"code,omitempty"`
+}
+
+type SimCall struct {
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value,omitempty"`
+	Data  hexutil.Bytes   `json:"data"`
+}
+
+type SimCallError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+	Data    string `json:"data"`
+}
+
+type SimCallResult struct {
+	ReturnData hexutil.Bytes  `json:"returnData"`
+	Status     hexutil.Uint64 `json:"status"`
+	Error      *SimCallError  `json:"error"`
+}
+
+func (r *SimCallResult) Reverted() bool {
+	return r.Status == 0
+}
+
+// RevertReason is the Error(string) of the revert data, or the error message
+func (r *SimCallResult) RevertReason() string {
+	if r.Error == nil {
+		return ""
+	}
+	if reason, err := abi.UnpackRevert(common.FromHex(r.Error.Data)); err == nil {
+		return reason
+	}
+	return strings.TrimPrefix(r.Error.Message, "execution reverted: ")
+}
+
+type simBlock struct {
+	StateOverrides map[common.Address]*SimOverride `json:"stateOverrides,omitempty"`
+	Calls          []*SimCall                      `json:"calls"`
+}
+
+type simOpts struct {
+	BlockStateCalls []*simBlock `json:"blockStateCalls"`
+	Validation      bool        `json:"validation"`
+}
+
+type simBlockResult struct {
+	Calls []*SimCallResult `json:"calls"`
+}
+
+/*
+Simulate runs the calls in sequence on top of a block with eth_simulateV1, each one seeing the state changes of the previous ones,
+which a single eth_call can not. The nonce, balance and gas price checks are off, the overrides fund the callers.
+On a node without eth_simulateV1 they are run by a single eth_call, see simulateWithCall.
+*/
+func (c *ContractCaller) Simulate(blockNumber *big.Int, overrides map[common.Address]*SimOverride, calls []*SimCall) ([]*SimCallResult, error) {
+	if !c.noSimulateV1.Load() {
+		results, err := c.simulateV1(blockNumber, overrides, calls)
+		if !isMethodNotFound(err) {
+			return results, err
+		}
+		logger.G.Warn("eth_simulateV1 not supported, simulate with eth_call", zap.Error(err))
+		c.noSimulateV1.Store(true)
+	}
+	return c.simulateWithCall(blockNumber, overrides, calls)
+}
+
+func (c *ContractCaller) simulateV1(blockNumber *big.Int, overrides map[common.Address]*SimOverride, calls []*SimCall) ([]*SimCallResult, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(c.ctx, c.retryParams.Timeout)
+	defer cancel()
+
+	opts := &simOpts{BlockStateCalls: []*simBlock{{StateOverrides: overrides, Calls: calls}}}
+	var result []*simBlockResult
+	err := c.ethClient.Client().CallContext(ctxWithTimeout, &result, "eth_simulateV1", opts, hexutil.EncodeBig(blockNumber))
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != 1 || len(result[0].Calls) != len(calls) {
+		return nil, ErrWrongOutputLength
+	}
+	return result[0].Calls, nil
+}
+
+func isMethodNotFound(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+	return err != nil && strings.Contains(err.Error(), "does not exist")
+}
+
+type simCallArgs struct {
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value,omitempty"`
+	Data  hexutil.Bytes   `json:"data"`
+}
+
+/*
+simulateWithCall runs the calls with a single eth_call: the caller is given the code of Multicall3 with a state override,
+and calls its own aggregate3Value, so the calls are sent in sequence from it, each one seeing the state changes of the previous ones.
+The calls must share their caller. A token treating the contracts apart from the wallets can behave differently than with eth_simulateV1.
+*/
+func (c *ContractCaller) simulateWithCall(blockNumber *big.Int, overrides map[common.Address]*SimOverride, calls []*SimCall) ([]*SimCallResult, error) {
+	if len(calls) == 0 {
+		return nil, nil
+	}
+	from := calls[0].From
+	value := new(big.Int)
+	call3s := make([]multicall3.Call3Value, len(calls))
+	for i, call := range calls {
+		if call.From != from || call.To == nil {
+			return nil, fmt.Errorf("simulate with eth_call: call %d is not sent from %s to a contract", i, from)
+		}
+		call3s[i] = multicall3.Call3Value{Target: *call.To, AllowFailure: true, Value: new(big.Int), CallData: call.Data}
+		if call.Value != nil {
+			call3s[i].Value = call.Value.ToInt()
+			value.Add(value, call3s[i].Value)
+		}
+	}
+	data, err := registry.G.Pack(registry.KindMulticall3, "aggregate3Value", call3s)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := c.CodeAt(c.chain.Multicall3Address, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no Multicall3 code at %s", c.chain.Multicall3Address)
+	}
+	callOverrides := make(map[common.Address]*SimOverride, len(overrides)+1)
+	for address, override := range overrides {
+		callOverrides[address] = override
+	}
+	override := &SimOverride{Code: code}
+	if o, ok := overrides[from]; ok {
+		override.Balance = o.Balance
+	}
+	callOverrides[from] = override
+
+	ctxWithTimeout, cancel := context.WithTimeout(c.ctx, c.retryParams.Timeout)
+	defer cancel()
+	var output hexutil.Bytes
+	args := &simCallArgs{From: from, To: &from, Value: (*hexutil.Big)(value), Data: data}
+	err = c.ethClient.Client().CallContext(ctxWithTimeout, &output, "eth_call", args, hexutil.EncodeBig(blockNumber), callOverrides)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := registry.G.Unpack(registry.KindMulticall3, "aggregate3Value", output)
+	if err != nil || len(values) != 1 {
+		return nil, ErrWrongOutputLength
+	}
+	returned := *abi.ConvertType(values[0], new([]multicall3.Result)).(*[]multicall3.Result)
+	if len(returned) != len(calls) {
+		return nil, ErrWrongOutputLength
+	}
+
+	results := make([]*SimCallResult, len(returned))
+	for i, r := range returned {
+		results[i] = &SimCallResult{ReturnData: r.ReturnData, Status: 1}
+		if !r.Success {
+			results[i] = &SimCallResult{Error: &SimCallError{Message: "execution reverted", Data: hexutil.Encode(r.ReturnData)}}
+		}
+	}
+	return results, nil
+}
+
+func (c *ContractCaller) BlockNumber() (uint64, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(c.ctx, c.retryParams.Timeout)
+	defer cancel()
+	return c.ethClient.BlockNumber(ctxWithTimeout)
+}
+
+func (c *ContractCaller) CodeAt(address common.Address, blockNumber *big.Int) ([]byte, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(c.ctx, c.retryParams.Timeout)
+	defer cancel()
+	return c.ethClient.CodeAt(ctxWithTimeout, address, blockNumber)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bxs/abi/bep20"
+	"bxs/abi/multicall3"
+	"bxs/abi/registry"
+	"bxs/chain_params"
+	"bxs/config"
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+// noSimulateAPI is a node without eth_simulateV1, its eth_call runs the aggregate3Value of the overridden caller
+type noSimulateAPI struct {
+	t         *testing.T
+	overrides map[common.Address]*SimOverride
+	calls     []multicall3.Call3Value
+	value     *big.Int
+}
+
+func (api *noSimulateAPI) GetCode(address common.Address, _ rpc.BlockNumberOrHash) hexutil.Bytes {
+	if address == chain_params.G.Multicall3Address {
+		return hexutil.Bytes{0x60, 0x80}
+	}
+	return nil
+}
+
+func (api *noSimulateAPI) Call(args simCallArgs, _ rpc.BlockNumberOrHash, overrides map[common.Address]*SimOverride) (hexutil.Bytes, error) {
+	api.overrides = overrides
+	api.value = args.Value.ToInt()
+	method, err := registry.G.Method(registry.KindMulticall3, "aggregate3Value")
+	require.NoError(api.t, err)
+	inputs, err := method.Inputs.Unpack(args.Data[4:])
+	require.NoError(api.t, err)
+	api.calls = *abi.ConvertType(inputs[0], new([]multicall3.Call3Value)).(*[]multicall3.Call3Value)
+
+	balance, err := bep20.Abi.Methods["balanceOf"].Outputs.Pack(big.NewInt(7))
+	require.NoError(api.t, err)
+	return method.Outputs.Pack([]multicall3.Result{
+		{Success: true},
+		{Success: false, ReturnData: common.FromHex(simReverted(api.t, "trading not open").Error.Data)},
+		{Success: true, ReturnData: balance},
+	})
+}
+
+func TestContractCaller_SimulateWithCall(t *testing.T) {
+	api := &noSimulateAPI{t: t}
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("eth", api))
+	retryParams := config.G.ContractCaller.Retry.GetRetryParams()
+	cc := NewContractCaller(context.Background(), chain_params.G, ethclient.NewClient(rpc.DialInProc(server)), retryParams, nil)
+
+	token := common.HexToAddress("0x70")
+	overrides := map[common.Address]*SimOverride{simTrader: {Balance: (*hexutil.Big)(big.NewInt(2e17))}}
+	calls := []*SimCall{
+		{From: simTrader, To: &token, Value: (*hexutil.Big)(big.NewInt(1e17)), Data: mustPack(registry.KindBep20, "approve", simReceiver, big.NewInt(1))},
+		tokenCall(token, "transfer", simReceiver, big.NewInt(1)),
+		tokenCall(token, "balanceOf", simTrader),
+	}
+	results, err := cc.Simulate(big.NewInt(100), overrides, calls)
+	require.NoError(t, err)
+	require.True(t, cc.noSimulateV1.Load())
+
+	// the caller runs the calls with the code of Multicall3, funded for their value
+	require.Equal(t, hexutil.Bytes{0x60, 0x80}, api.overrides[simTrader].Code)
+	require.Equal(t, big.NewInt(2e17), api.overrides[simTrader].Balance.ToInt())
+	require.Equal(t, big.NewInt(1e17), api.value)
+	require.Len(t, api.calls, 3)
+	require.Equal(t, big.NewInt(1e17), api.calls[0].Value)
+	require.Equal(t, []byte(calls[1].Data), api.calls[1].CallData)
+
+	require.Len(t, results, 3)
+	require.False(t, results[0].Reverted())
+	require.True(t, results[1].Reverted())
+	require.Equal(t, "trading not open", results[1].RevertReason())
+	require.Equal(t, big.NewInt(7), unpackBalance(results[2]))
+}
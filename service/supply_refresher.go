@@ -0,0 +1,178 @@
+package service
+
+import (
+	"bxs/cache"
+	"bxs/config"
+	"bxs/logger"
+	"bxs/repository/orm"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/panjf2000/ants/v2"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"math/big"
+	"sync"
+	"time"
+)
+
+type supplyReader interface {
+	BlockNumber() (uint64, error)
+	CallTotalSupply(address *common.Address) (*big.Int, error)
+}
+
+type SupplyRefresher interface {
+	Start()
+	// Touch marks a token as traded, its totalSupply is read again at the end of the interval
+	Touch(token common.Address)
+}
+
+/*
+supplyRefresher reads again the totalSupply of the tokens traded in each SupplyIntervalSec, on the head state.
+A supply different from the one of the cache, burnt or minted since, is set on the cached and the stored token,
+and logged as a supply change with the head block it was read at.
+Only the tokens in the cache are read, their decimals and last supply are taken from it.
+*/
+type supplyRefresher struct {
+	ctx       context.Context
+	chainId   int
+	conf      *config.StateRefreshConf
+	cache     cache.Cache
+	reader    supplyReader
+	dbService DBService
+	workPool  *ants.Pool
+	mu        sync.Mutex
+	active    map[common.Address]struct{}
+}
+
+func NewSupplyRefresher(
+	ctx context.Context,
+	chainId int,
+	conf *config.StateRefreshConf,
+	cache cache.Cache,
+	contractCaller *ContractCaller,
+	dbService DBService,
+) SupplyRefresher {
+	return newSupplyRefresher(ctx, chainId, conf, cache, contractCaller, dbService)
+}
+
+func newSupplyRefresher(
+	ctx context.Context,
+	chainId int,
+	conf *config.StateRefreshConf,
+	cache cache.Cache,
+	reader supplyReader,
+	dbService DBService,
+) *supplyRefresher {
+	workPool, err := ants.NewPool(max(conf.SupplyPoolSize, 1))
+	if err != nil {
+		logger.G.Fatal("ants pool(SupplyRefresher) init err", zap.Error(err))
+	}
+
+	return &supplyRefresher{
+		ctx:       ctx,
+		chainId:   chainId,
+		conf:      conf,
+		cache:     cache,
+		reader:    reader,
+		dbService: dbService,
+		workPool:  workPool,
+		active:    make(map[common.Address]struct{}),
+	}
+}
+
+func (r *supplyRefresher) Touch(token common.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active[token] = struct{}{}
+}
+
+func (r *supplyRefresher) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Duration(r.conf.SupplyIntervalSec) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+				r.refresh()
+			}
+		}
+	}()
+}
+
+// refresh reads the tokens traded since the last refresh, and stores the supply changes
+func (r *supplyRefresher) refresh() {
+	r.mu.Lock()
+	active := r.active
+	r.active = make(map[common.Address]struct{})
+	r.mu.Unlock()
+	if len(active) == 0 {
+		return
+	}
+
+	block, err := r.reader.BlockNumber()
+	if err != nil {
+		logger.G.Warn("supply refresh block number err", zap.Error(err))
+		return
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		changes []*orm.TokenSupplyChange
+	)
+	for address := range active {
+		wg.Add(1)
+		err = r.workPool.Submit(func() {
+			defer wg.Done()
+			if change := r.readSupply(address, block); change != nil {
+				mu.Lock()
+				changes = append(changes, change)
+				mu.Unlock()
+			}
+		})
+		if err != nil {
+			wg.Done()
+			logger.G.Error("submit supply refresh err", zap.Error(err))
+		}
+	}
+	wg.Wait()
+
+	if len(changes) > 0 {
+		if err = r.dbService.UpdateTokenSupply(changes); err != nil {
+			logger.G.Error("update token supply err", zap.Int("changes", len(changes)), zap.Error(err))
+		}
+	}
+}
+
+// readSupply returns the change of the supply of a cached token, nil when it did not change or could not be read
+func (r *supplyRefresher) readSupply(address common.Address, block uint64) *orm.TokenSupplyChange {
+	token, ok := r.cache.GetToken(address)
+	if !ok {
+		return nil
+	}
+
+	supplyWei, err := r.reader.CallTotalSupply(&address)
+	if err != nil {
+		logger.G.Debug("supply refresh call err", zap.String("token", address.String()), zap.Error(err))
+		return nil
+	}
+
+	supply := decimal.NewFromBigInt(supplyWei, -int32(token.Decimals))
+	if supply.Equal(token.TotalSupply) {
+		return nil
+	}
+
+	updated := *token
+	updated.TotalSupply = supply
+	r.cache.SetToken(&updated)
+
+	return &orm.TokenSupplyChange{
+		Token:     address.String(),
+		ChainId:   r.chainId,
+		Block:     block,
+		OldSupply: token.TotalSupply,
+		NewSupply: supply,
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"bxs/cache"
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/repository/orm"
+	"bxs/types"
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+type fakeSupplyReader struct {
+	supplies map[common.Address]*big.Int
+}
+
+func (r *fakeSupplyReader) BlockNumber() (uint64, error) {
+	return 200, nil
+}
+
+func (r *fakeSupplyReader) CallTotalSupply(address *common.Address) (*big.Int, error) {
+	supply, ok := r.supplies[*address]
+	if !ok {
+		return nil, errors.New("execution reverted")
+	}
+	return supply, nil
+}
+
+type fakeSupplyDBService struct {
+	DBService
+	changes []*orm.TokenSupplyChange
+}
+
+func (s *fakeSupplyDBService) UpdateTokenSupply(changes []*orm.TokenSupplyChange) error {
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+func TestSupplyRefresher_Refresh(t *testing.T) {
+	burnt, unchanged, reverted, unknown := common.HexToAddress("0x70"), common.HexToAddress("0x71"), common.HexToAddress("0x72"), common.HexToAddress("0x73")
+	c, err := cache.NewMemoryCache(chain_params.G.Name, chain_params.G.ChainID, config.G.Cache)
+	require.NoError(t, err)
+	for _, address := range []common.Address{burnt, unchanged, reverted} {
+		c.SetToken(&types.Token{Address: address, Decimals: 2, TotalSupply: decimal.NewFromInt(1000)})
+	}
+
+	reader := &fakeSupplyReader{supplies: map[common.Address]*big.Int{
+		burnt:     big.NewInt(90000),
+		unchanged: big.NewInt(100000),
+		unknown:   big.NewInt(1),
+	}}
+	dbService := &fakeSupplyDBService{}
+	conf := &config.StateRefreshConf{TokenSupply: true, SupplyIntervalSec: 60, SupplyPoolSize: 2}
+	r := newSupplyRefresher(context.Background(), chain_params.G.ChainID, conf, c, reader, dbService)
+
+	for _, address := range []common.Address{burnt, unchanged, reverted, unknown, burnt} {
+		r.Touch(address)
+	}
+	r.refresh()
+
+	require.Len(t, dbService.changes, 1)
+	change := dbService.changes[0]
+	require.Equal(t, burnt.String(), change.Token)
+	require.Equal(t, uint64(200), change.Block)
+	require.True(t, decimal.NewFromInt(1000).Equal(change.OldSupply))
+	require.True(t, decimal.NewFromInt(900).Equal(change.NewSupply))
+
+	cached, ok := c.GetToken(burnt)
+	require.True(t, ok)
+	require.True(t, decimal.NewFromInt(900).Equal(cached.TotalSupply))
+
+	// the tokens are read again only once traded again
+	require.Empty(t, r.active)
+	r.refresh()
+	require.Len(t, dbService.changes, 1)
+}
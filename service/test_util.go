@@ -1,15 +1,26 @@
 package service
 
 import (
+	"bxs/abi/registry"
 	"bxs/cache"
+	"bxs/chain/fake"
+	"bxs/chain/fixture"
 	"bxs/chain_params"
 	"bxs/config"
+	"bxs/repository/orm"
+	"bxs/types"
 	"context"
 	"github.com/ethereum/go-ethereum/common"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
 	"math/big"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
 )
 
 var (
@@ -19,26 +30,87 @@ var (
 )
 
 type TestContext struct {
+	t              testing.TB
 	ethClient      *ethclient.Client
 	Cache          cache.Cache
 	ContractCaller *ContractCaller
 	PairService    PairService
 }
 
-func GetTestContext() *TestContext {
-	ethClient, err := ethclient.Dial("https://bsc-testnet-dataseed.bnbchain.org")
-	if err != nil {
-		panic(err)
+// TestChain scripts the fake chain the fixture of a test is recorded from
+type TestChain struct {
+	*fake.Chain
+	t testing.TB
+}
+
+// SetResult answers the call of a method of a contract kind with its packed outputs
+func (c *TestChain) SetResult(address common.Address, kind, method string, args []any, outputs ...any) {
+	data, err := registry.G.Pack(kind, method, args...)
+	require.NoError(c.t, err)
+	abiMethod, err := registry.G.Method(kind, method)
+	require.NoError(c.t, err)
+	result, err := abiMethod.Outputs.Pack(outputs...)
+	require.NoError(c.t, err)
+	c.SetCall(address, data, result)
+}
+
+// SetXLaunchPool answers the calls the pair service makes to get an xLaunch pool and its token
+func (c *TestChain) SetXLaunchPool(pool, token common.Address, name, symbol string, totalSupply *big.Int) {
+	c.SetResult(pool, registry.KindXLaunchPool, "token", nil, token)
+	c.SetResult(chain_params.G.XLaunchFactoryAddress, registry.KindXLaunchFactory, "getLaunchByAddress", []any{pool},
+		true, struct{ Cid, Description, Telegram, Twitter, Website string }{})
+	c.SetResult(token, registry.KindBep20, "name", nil, name)
+	c.SetResult(token, registry.KindBep20, "symbol", nil, symbol)
+	c.SetResult(token, registry.KindBep20, "decimals", nil, uint8(18))
+	c.SetResult(token, registry.KindBep20, "totalSupply", nil, totalSupply)
+}
+
+// EventLog builds the log of an event of the registry, the indexed arguments are given as topics
+func (c *TestChain) EventLog(kind, name string, address common.Address, topics []common.Hash, args ...any) *ethtypes.Log {
+	contractAbi, err := registry.G.ABI(kind)
+	require.NoError(c.t, err)
+	event, ok := contractAbi.Events[name]
+	require.True(c.t, ok, name)
+
+	data, err := event.Inputs.NonIndexed().Pack(args...)
+	require.NoError(c.t, err)
+	return &ethtypes.Log{
+		Address: address,
+		Topics:  append([]common.Hash{event.ID}, topics...),
+		Data:    data,
 	}
+}
 
+// OtherLogs are n logs of another contract, they put the log under test at its index in the receipt
+func OtherLogs(n int) []*ethtypes.Log {
+	logs := make([]*ethtypes.Log, n)
+	for i := range logs {
+		logs[i] = &ethtypes.Log{Address: common.HexToAddress("0xe0"), Topics: []common.Hash{common.BigToHash(big.NewInt(int64(i)))}}
+	}
+	return logs
+}
+
+/*
+GetTestContext connects a test to its JSON-RPC fixture, see fixture.DialTest.
+The fixture is recorded with -record from the testnet chain scripted by script.
+*/
+func GetTestContext(t testing.TB, fixtureName string, script func(c *TestChain)) *TestContext {
 	factoryAddress := common.HexToAddress("0x735baeA88c3e3817Ac8dA2fBc11A3f5Fe2EF79bA")
 	chain_params.LoadNetwork(true, factoryAddress)
 
-	contractCaller := NewContractCaller(ethClient, config.G.ContractCaller.Retry.GetRetryParams())
+	ethClient := fixture.DialTest(t, fixtureName, func() http.Handler {
+		c := &TestChain{Chain: fake.NewChain(chain_params.G), t: t}
+		script(c)
+		return c.Handler()
+	})
+
+	// one eth_call per call, the calls batched in a multicall depend on their timing and would not be replayed
+	contractCaller := NewContractCaller(context.Background(), chain_params.G, ethClient, config.G.ContractCaller.Retry.GetRetryParams(), &config.MulticallConf{})
 	cache := cache.NewMockCache()
 	pairService_ := NewPairService(cache, contractCaller)
 
 	return &TestContext{
+		t:              t,
 		ethClient:      ethClient,
 		Cache:          cache,
 		ContractCaller: contractCaller,
@@ -48,18 +120,153 @@ func GetTestContext() *TestContext {
 
 func (g *TestContext) GetEthLog(txHashStr string, logIndex int) *ethtypes.Log {
 	txHash := common.HexToHash(txHashStr)
-	txReceipt, apiErr := g.ethClient.TransactionReceipt(context.Background(), txHash)
-	if apiErr != nil {
-		panic(apiErr)
-	}
+	txReceipt, err := g.ethClient.TransactionReceipt(context.Background(), txHash)
+	require.NoError(g.t, err)
+	require.Greater(g.t, len(txReceipt.Logs), logIndex)
 
 	return txReceipt.Logs[logIndex]
 }
 
 func (g *TestContext) GetBlockTimestamp(blockNumber uint64) uint64 {
 	blockHeader, err := g.ethClient.HeaderByNumber(context.Background(), big.NewInt(int64(blockNumber)))
-	if err != nil {
-		panic(err)
-	}
+	require.NoError(g.t, err)
 	return blockHeader.Time
 }
+
+// MemoryDBService keeps the stored rows in memory, in the order they are added
+type MemoryDBService struct {
+	mu             sync.Mutex
+	Tokens         []*orm.Token
+	Pairs          []*orm.Pair
+	Txs            []*orm.Tx
+	Actions        []*orm.Action
+	FailedSwaps    []*orm.FailedSwap
+	MevEvents      []*orm.MevEvent
+	MainPairs      map[string]string // token -> main pair
+	SupplyChanges  []*orm.TokenSupplyChange
+	ReserveUpdates []*orm.Pair
+}
+
+func NewMemoryDBService() *MemoryDBService {
+	return &MemoryDBService{MainPairs: make(map[string]string)}
+}
+
+func (s *MemoryDBService) AddTokens(tokens []*orm.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Tokens = append(s.Tokens, tokens...)
+	return nil
+}
+
+func (s *MemoryDBService) AddPairs(pairs []*orm.Pair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Pairs = append(s.Pairs, pairs...)
+	return nil
+}
+
+func (s *MemoryDBService) AddTxs(txs []*orm.Tx) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Txs = append(s.Txs, txs...)
+	return nil
+}
+
+func (s *MemoryDBService) AddActions(actions []*orm.Action) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Actions = append(s.Actions, actions...)
+	return nil
+}
+
+func (s *MemoryDBService) AddFailedSwaps(swaps []*orm.FailedSwap) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FailedSwaps = append(s.FailedSwaps, swaps...)
+	return nil
+}
+
+func (s *MemoryDBService) AddMevEvents(events []*orm.MevEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MevEvents = append(s.MevEvents, events...)
+	return nil
+}
+
+func (s *MemoryDBService) UpdateToken(tokenAddress, mainPairAddress string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.MainPairs[tokenAddress] = mainPairAddress
+	return nil
+}
+
+func (s *MemoryDBService) UpdateTokenSecurity(token *orm.Token) error {
+	return nil
+}
+
+func (s *MemoryDBService) UpdateTokenMetadata(token *orm.Token) error {
+	return nil
+}
+
+func (s *MemoryDBService) UpdateTokenSupply(changes []*orm.TokenSupplyChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SupplyChanges = append(s.SupplyChanges, changes...)
+	return nil
+}
+
+func (s *MemoryDBService) UpdatePairReserves(pairs []*orm.Pair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ReserveUpdates = append(s.ReserveUpdates, pairs...)
+	return nil
+}
+
+func (s *MemoryDBService) ListPairsSince(program string, since time.Time) ([]*orm.Pair, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pairs []*orm.Pair
+	for _, pair := range s.Pairs {
+		if pair.Program == program && !pair.BlockAt.Before(since) {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs, nil
+}
+
+func (s *MemoryDBService) FlushTxs(bool) (bool, error) {
+	return true, nil
+}
+
+func (s *MemoryDBService) UpdateLag(headHeight, height uint64) {}
+
+// MemoryKafkaSender keeps the sent messages in memory, in the order they are sent
+type MemoryKafkaSender struct {
+	mu   sync.Mutex
+	msgs []*types.KafkaMsg
+}
+
+func NewMemoryKafkaSender() *MemoryKafkaSender {
+	return &MemoryKafkaSender{}
+}
+
+func (s *MemoryKafkaSender) Send(ctx context.Context, msg *types.KafkaMsg) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msgs = append(s.msgs, msg)
+	return nil
+}
+
+func (s *MemoryKafkaSender) Close() error {
+	return nil
+}
+
+func (s *MemoryKafkaSender) LastError() (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (s *MemoryKafkaSender) Msgs() []*types.KafkaMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.msgs)
+}
@@ -0,0 +1,434 @@
+package service
+
+import (
+	"bxs/config"
+	"bxs/logger"
+	"bxs/metrics"
+	"bxs/repository/orm"
+	"bxs/types"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/panjf2000/ants/v2"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errMetadataInvalid is a content the gateway served that can not be used, it is not fetched again
+var errMetadataInvalid = errors.New("invalid token metadata")
+
+var (
+	// ipfsPathRegexp is a CID with an optional path in it, the only refs fetched from the gateway
+	ipfsPathRegexp     = regexp.MustCompile(`^[A-Za-z0-9]+(/[A-Za-z0-9._-]+)*$`)
+	telegramPathRegexp = regexp.MustCompile(`^(\+[A-Za-z0-9_-]{8,}|joinchat/[A-Za-z0-9_-]{8,}|[A-Za-z][A-Za-z0-9_]{3,31}(/[0-9]+)?)$`)
+	twitterPathRegexp  = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}(/status/[0-9]+)?$`)
+	telegramHosts      = map[string]bool{"t.me": true, "telegram.me": true, "telegram.dog": true}
+	twitterHosts       = map[string]bool{"x.com": true, "twitter.com": true, "mobile.twitter.com": true, "mobile.x.com": true}
+)
+
+// tokenMetadataJson is the metadata JSON a token CID may point to
+type tokenMetadataJson struct {
+	Name        string `json:"name"`
+	Symbol      string `json:"symbol"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	Telegram    string `json:"telegram"`
+	Twitter     string `json:"twitter"`
+	Website     string `json:"website"`
+}
+
+type TokenMetadataService interface {
+	Start()
+	// Enrich schedules the enrichment of a created token, it never blocks
+	Enrich(token *orm.Token)
+}
+
+type pendingMetadata struct {
+	token    *orm.Token
+	attempts int
+	next     time.Time
+	running  bool
+}
+
+/*
+tokenMetadataService resolves the CID of the created tokens through an IPFS HTTP gateway, and normalises their social links.
+The CID is either the logo image or a metadata JSON with the image and the links, the image is fetched to check it.
+A gateway error is retried with an exponential backoff up to MaxAttempts, an invalid content is not,
+the token is then stored with the flags of what could not be resolved.
+Only the configured gateway is fetched, and only CIDs, ipfs:// and /ipfs/ paths: an image on another host is invalid,
+so a token creator can not have the indexer request a URL of their choosing.
+The tokens waiting are kept in memory, the ones created before a restart are not enriched.
+*/
+type tokenMetadataService struct {
+	ctx       context.Context
+	conf      *config.TokenMetadataConf
+	client    *http.Client
+	dbService DBService
+	workPool  *ants.Pool
+	mu        sync.Mutex
+	pending   map[string]*pendingMetadata
+}
+
+func NewTokenMetadataService(ctx context.Context, conf *config.TokenMetadataConf, dbService DBService) TokenMetadataService {
+	return newTokenMetadataService(ctx, conf, dbService)
+}
+
+func newTokenMetadataService(ctx context.Context, conf *config.TokenMetadataConf, dbService DBService) *tokenMetadataService {
+	workPool, err := ants.NewPool(max(conf.PoolSize, 1))
+	if err != nil {
+		logger.G.Fatal("ants pool(TokenMetadataService) init err", zap.Error(err))
+	}
+
+	return &tokenMetadataService{
+		ctx:       ctx,
+		conf:      conf,
+		client:    newGatewayClient(conf),
+		dbService: dbService,
+		workPool:  workPool,
+		pending:   make(map[string]*pendingMetadata),
+	}
+}
+
+func (s *tokenMetadataService) Enrich(token *orm.Token) {
+	if token.Cid == "" && token.Telegram == "" && token.Twitter == "" && token.Website == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.pending[token.Address]; ok {
+		return
+	}
+	if len(s.pending) >= s.conf.QueueSize {
+		metrics.TokenMetadataTotal.WithLabelValues("dropped").Inc()
+		return
+	}
+	s.pending[token.Address] = &pendingMetadata{token: token}
+}
+
+func (s *tokenMetadataService) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.dispatchDue(now)
+			}
+		}
+	}()
+}
+
+// dispatchDue submits the enrichments due, out of the lock the enrichments take when they finish
+func (s *tokenMetadataService) dispatchDue(now time.Time) {
+	var due []*pendingMetadata
+	s.mu.Lock()
+	for _, p := range s.pending {
+		if !p.running && !now.Before(p.next) {
+			p.running = true
+			due = append(due, p)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, p := range due {
+		err := s.workPool.Submit(func() {
+			s.process(p)
+		})
+		if err != nil {
+			logger.G.Error("submit token metadata err", zap.Error(err))
+			s.mu.Lock()
+			p.running = false
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *tokenMetadataService) process(p *pendingMetadata) {
+	metadata, err := s.Resolve(p.token)
+	if err != nil {
+		p.attempts++
+		if p.attempts < s.conf.MaxAttempts {
+			metrics.TokenMetadataTotal.WithLabelValues("retry").Inc()
+			logger.G.Debug("token metadata retry", zap.String("token", p.token.Address), zap.Int("attempts", p.attempts), zap.Error(err))
+			s.mu.Lock()
+			p.running = false
+			p.next = time.Now().Add(time.Duration(s.conf.BackoffSec) * time.Second << (p.attempts - 1))
+			s.mu.Unlock()
+			return
+		}
+
+		metrics.TokenMetadataTotal.WithLabelValues("failed").Inc()
+		logger.G.Warn("token metadata failed", zap.String("token", p.token.Address), zap.Error(err))
+		metadata = s.normalizeLinks(p.token, &tokenMetadataJson{})
+		metadata.Flags |= types.TokenMetadataFlagFailed
+	} else {
+		metrics.TokenMetadataTotal.WithLabelValues("ok").Inc()
+	}
+
+	if err = s.dbService.UpdateTokenMetadata(metadata.GetOrmToken()); err != nil {
+		logger.G.Error("update token metadata err", zap.String("token", p.token.Address), zap.Error(err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, p.token.Address)
+}
+
+/*
+Resolve fetches the CID of a token, and the image of its metadata JSON, then normalises its social links.
+It returns an error only when the gateway failed, so the token is tried again.
+*/
+func (s *tokenMetadataService) Resolve(token *orm.Token) (*types.TokenMetadata, error) {
+	var meta tokenMetadataJson
+	var flags int
+	var logo string
+
+	if token.Cid != "" {
+		data, err := s.fetch(token.Cid, max(s.conf.MaxMetadataBytes, s.conf.MaxImageBytes))
+		switch {
+		case errors.Is(err, errMetadataInvalid):
+			flags |= types.TokenMetadataFlagInvalid
+		case err != nil:
+			return nil, err
+		case isImage(data):
+			flags |= types.TokenMetadataFlagResolved
+			path, _ := ipfsPath(token.Cid)
+			logo = s.gatewayURL(path)
+		case int64(len(data)) <= s.conf.MaxMetadataBytes && json.Unmarshal(data, &meta) == nil:
+			flags |= types.TokenMetadataFlagResolved
+			logo, err = s.resolveImage(meta.Image)
+			if errors.Is(err, errMetadataInvalid) {
+				flags |= types.TokenMetadataFlagImageInvalid
+			} else if err != nil {
+				return nil, err
+			}
+		default:
+			flags |= types.TokenMetadataFlagResolved | types.TokenMetadataFlagInvalid
+		}
+	}
+
+	metadata := s.normalizeLinks(token, &meta)
+	metadata.Logo = logo
+	metadata.Flags |= flags
+	return metadata, nil
+}
+
+// resolveImage fetches the image of a metadata JSON and returns its URL on the gateway
+func (s *tokenMetadataService) resolveImage(image string) (string, error) {
+	if image == "" {
+		return "", errMetadataInvalid
+	}
+
+	data, err := s.fetch(image, s.conf.MaxImageBytes)
+	if err != nil {
+		return "", err
+	}
+	if !isImage(data) {
+		return "", errMetadataInvalid
+	}
+
+	path, _ := ipfsPath(image)
+	return s.gatewayURL(path), nil
+}
+
+// normalizeLinks takes the links of the token creation, or of the metadata JSON when empty
+func (s *tokenMetadataService) normalizeLinks(token *orm.Token, meta *tokenMetadataJson) *types.TokenMetadata {
+	metadata := &types.TokenMetadata{
+		Token:      token.Address,
+		EnrichedAt: time.Now(),
+	}
+
+	var ok bool
+	if metadata.Telegram, ok = normalizeTelegram(firstNonEmpty(token.Telegram, meta.Telegram)); !ok {
+		metadata.Flags |= types.TokenMetadataFlagTelegramInvalid
+	}
+	if metadata.Twitter, ok = normalizeTwitter(firstNonEmpty(token.Twitter, meta.Twitter)); !ok {
+		metadata.Flags |= types.TokenMetadataFlagTwitterInvalid
+	}
+	if metadata.Website, ok = normalizeWebsite(firstNonEmpty(token.Website, meta.Website)); !ok {
+		metadata.Flags |= types.TokenMetadataFlagWebsiteInvalid
+	}
+	return metadata
+}
+
+/*
+newGatewayClient returns a client following only the redirects to the gateway host or to its subdomains,
+where the subdomain gateways serve the CIDs.
+*/
+func newGatewayClient(conf *config.TokenMetadataConf) *http.Client {
+	gateway, err := url.Parse(conf.Gateway)
+	if err != nil {
+		gateway = &url.URL{}
+	}
+
+	return &http.Client{
+		Timeout: time.Duration(conf.TimeoutMs) * time.Millisecond,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host != gateway.Host && !strings.HasSuffix(req.URL.Hostname(), "."+gateway.Hostname()) {
+				return fmt.Errorf("%w: redirect to %s off the gateway", errMetadataInvalid, req.URL.Host)
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// ipfsPath returns the CID and path of a CID, of an ipfs:// or /ipfs/ path, or of the /ipfs/ path of a gateway URL
+func ipfsPath(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if isHTTPURL(ref) {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", false
+		}
+		_, path, ok := strings.Cut(u.EscapedPath(), "/ipfs/")
+		if !ok || u.RawQuery != "" {
+			return "", false
+		}
+		ref = path
+	} else {
+		ref = strings.TrimPrefix(ref, "ipfs://")
+		ref = strings.TrimPrefix(ref, "/")
+		ref = strings.TrimPrefix(ref, "ipfs/")
+	}
+	return ref, ipfsPathRegexp.MatchString(ref)
+}
+
+// gatewayURL returns the gateway URL of a CID and path
+func (s *tokenMetadataService) gatewayURL(path string) string {
+	return strings.TrimSuffix(s.conf.Gateway, "/") + "/" + path
+}
+
+// fetch reads a CID from the gateway, a ref not on IPFS, a content over maxBytes or refused by the gateway is invalid,
+// any other failure is retried
+func (s *tokenMetadataService) fetch(ref string, maxBytes int64) ([]byte, error) {
+	path, ok := ipfsPath(ref)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not on IPFS", errMetadataInvalid, ref)
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.gatewayURL(path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errMetadataInvalid, err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		return nil, fmt.Errorf("%w: %s status %d", errMetadataInvalid, ref, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("fetch %s status %d", ref, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: %s over %d bytes", errMetadataInvalid, ref, maxBytes)
+	}
+	return data, nil
+}
+
+func isImage(data []byte) bool {
+	if strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return true
+	}
+	// svg is sniffed as text
+	head := data[:min(len(data), 512)]
+	return bytes.Contains(head, []byte("<svg"))
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// socialPath returns the path of a link to one of hosts, or the handle of a bare @handle or handle
+func socialPath(link string, hosts map[string]bool) (string, bool) {
+	link = strings.TrimSpace(link)
+	if handle, ok := strings.CutPrefix(link, "@"); ok {
+		return handle, true
+	}
+	if !strings.Contains(link, "/") && !strings.Contains(link, ".") {
+		return link, true
+	}
+
+	if !isHTTPURL(link) {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil || !hosts[strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")] {
+		return "", false
+	}
+	return strings.Trim(u.Path, "/"), true
+}
+
+// normalizeTelegram returns the https://t.me link of a group, channel or invite, false when not one
+func normalizeTelegram(link string) (string, bool) {
+	if link == "" {
+		return "", true
+	}
+	path, ok := socialPath(link, telegramHosts)
+	if !ok || !telegramPathRegexp.MatchString(path) {
+		return "", false
+	}
+	return "https://t.me/" + path, true
+}
+
+// normalizeTwitter returns the https://x.com link of an account or a post, false when not one
+func normalizeTwitter(link string) (string, bool) {
+	if link == "" {
+		return "", true
+	}
+	path, ok := socialPath(link, twitterHosts)
+	if !ok || !twitterPathRegexp.MatchString(path) {
+		return "", false
+	}
+	return "https://x.com/" + path, true
+}
+
+// normalizeWebsite returns the http(s) URL of a website, https when no scheme is given, false when not one
+func normalizeWebsite(link string) (string, bool) {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return "", true
+	}
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil ||
+		!strings.Contains(u.Hostname(), ".") || strings.ContainsAny(link, " \t\n") {
+		return "", false
+	}
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), true
+}
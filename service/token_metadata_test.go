@@ -0,0 +1,218 @@
+package service
+
+import (
+	"bxs/config"
+	"bxs/repository/orm"
+	"bxs/types"
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// fakeGateway serves the contents by CID, the CIDs of failing answer 504 that many times
+type fakeGateway struct {
+	mu        sync.Mutex
+	contents  map[string][]byte
+	failing   map[string]int
+	redirects map[string]string
+}
+
+func (g *fakeGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cid := strings.TrimPrefix(r.URL.Path, "/ipfs/")
+	if g.failing[cid] > 0 {
+		g.failing[cid]--
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	if location, ok := g.redirects[cid]; ok {
+		http.Redirect(w, r, location, http.StatusFound)
+		return
+	}
+	content, ok := g.contents[cid]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Write(content)
+}
+
+type fakeMetadataDBService struct {
+	DBService
+	tokens []*orm.Token
+}
+
+func (s *fakeMetadataDBService) UpdateTokenMetadata(token *orm.Token) error {
+	s.tokens = append(s.tokens, token)
+	return nil
+}
+
+func testTokenMetadataService(t *testing.T, gateway *fakeGateway, dbService DBService) *tokenMetadataService {
+	server := httptest.NewServer(gateway)
+	t.Cleanup(server.Close)
+	conf := &config.TokenMetadataConf{
+		Gateway:          server.URL + "/ipfs/",
+		TimeoutMs:        1000,
+		MaxMetadataBytes: 1024,
+		MaxImageBytes:    64,
+		MaxAttempts:      2,
+		BackoffSec:       1,
+		PoolSize:         1,
+		QueueSize:        2,
+	}
+	return newTokenMetadataService(context.Background(), conf, dbService)
+}
+
+func TestTokenMetadataService_Resolve(t *testing.T) {
+	gateway := &fakeGateway{contents: map[string][]byte{
+		"bafkreilogo": pngHeader,
+		"bafymeta":    []byte(`{"name":"Dog","image":"ipfs://bafkreilogo","telegram":"t.me/doggroup","twitter":"https://twitter.com/dog_bsc/"}`),
+		"bafynoimage": []byte(`{"name":"Dog","image":"bafkreitext"}`),
+		"bafkreitext": []byte("plain text"),
+		"bafkreihuge": append(pngHeader, make([]byte, 1024)...),
+	}}
+	s := testTokenMetadataService(t, gateway, nil)
+
+	// the CID is the logo
+	metadata, err := s.Resolve(&orm.Token{Address: "0x01", Cid: "bafkreilogo", Website: "Dog.io"})
+	require.NoError(t, err)
+	require.Equal(t, s.conf.Gateway+"bafkreilogo", metadata.Logo)
+	require.Equal(t, "https://dog.io", metadata.Website)
+	require.Equal(t, types.TokenMetadataFlagResolved, metadata.Flags)
+
+	// the CID is a metadata JSON, the links of the token creation take precedence
+	metadata, err = s.Resolve(&orm.Token{Address: "0x01", Cid: "bafymeta", Twitter: "@dog_official"})
+	require.NoError(t, err)
+	require.Equal(t, s.conf.Gateway+"bafkreilogo", metadata.Logo)
+	require.Equal(t, "https://t.me/doggroup", metadata.Telegram)
+	require.Equal(t, "https://x.com/dog_official", metadata.Twitter)
+	require.Equal(t, types.TokenMetadataFlagResolved, metadata.Flags)
+
+	metadata, err = s.Resolve(&orm.Token{Address: "0x01", Cid: "bafynoimage", Telegram: "https://example.com/group"})
+	require.NoError(t, err)
+	require.Empty(t, metadata.Logo)
+	require.Empty(t, metadata.Telegram)
+	require.Equal(t, types.TokenMetadataFlagResolved|types.TokenMetadataFlagImageInvalid|types.TokenMetadataFlagTelegramInvalid, metadata.Flags)
+
+	for _, cid := range []string{"bafkreitext", "bafkreihuge"} {
+		metadata, err = s.Resolve(&orm.Token{Address: "0x01", Cid: cid})
+		require.NoError(t, err)
+		require.Empty(t, metadata.Logo)
+		require.NotZero(t, metadata.Flags&types.TokenMetadataFlagInvalid, cid)
+	}
+
+	// not on the gateway yet
+	_, err = s.Resolve(&orm.Token{Address: "0x01", Cid: "bafymissing"})
+	require.Error(t, err)
+}
+
+// only the gateway is fetched, whatever host the metadata points to
+func TestTokenMetadataService_GatewayOnly(t *testing.T) {
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+		w.Write(pngHeader)
+	}))
+	t.Cleanup(internal.Close)
+
+	gateway := &fakeGateway{
+		contents: map[string][]byte{
+			"bafkreilogo":   pngHeader,
+			"bafyinternal":  []byte(`{"name":"Dog","image":"` + internal.URL + `/logo.png"}`),
+			"bafyotherhost": []byte(`{"name":"Dog","image":"https://cloudflare-ipfs.com/ipfs/bafkreilogo"}`),
+			"bafyredirect":  []byte(`{"name":"Dog","image":"ipfs://bafkreimoved"}`),
+		},
+		redirects: map[string]string{"bafkreimoved": internal.URL + "/logo.png"},
+	}
+	s := testTokenMetadataService(t, gateway, nil)
+
+	for _, cid := range []string{"bafyinternal", "bafyredirect"} {
+		metadata, err := s.Resolve(&orm.Token{Address: "0x01", Cid: cid})
+		require.NoError(t, err)
+		require.Empty(t, metadata.Logo)
+		require.Equal(t, types.TokenMetadataFlagResolved|types.TokenMetadataFlagImageInvalid, metadata.Flags, cid)
+	}
+	require.Zero(t, internalHits.Load())
+
+	// the /ipfs/ path of another gateway is fetched from the configured one
+	metadata, err := s.Resolve(&orm.Token{Address: "0x01", Cid: "bafyotherhost"})
+	require.NoError(t, err)
+	require.Equal(t, s.conf.Gateway+"bafkreilogo", metadata.Logo)
+
+	metadata, err = s.Resolve(&orm.Token{Address: "0x01", Cid: "../admin"})
+	require.NoError(t, err)
+	require.Equal(t, types.TokenMetadataFlagInvalid, metadata.Flags)
+}
+
+func TestTokenMetadataService_Retry(t *testing.T) {
+	gateway := &fakeGateway{
+		contents: map[string][]byte{"bafkreilogo": pngHeader},
+		failing:  map[string]int{"bafkreilogo": 1, "bafkreidown": 2},
+	}
+	dbService := &fakeMetadataDBService{}
+	s := testTokenMetadataService(t, gateway, dbService)
+
+	s.Enrich(&orm.Token{Address: "0x01", Cid: "bafkreilogo"})
+	s.Enrich(&orm.Token{Address: "0x02", Cid: "bafkreidown", Twitter: "dog"})
+	s.Enrich(&orm.Token{Address: "0x03"}) // nothing to enrich
+	s.Enrich(&orm.Token{Address: "0x04", Cid: "bafkreilogo"})
+	require.Len(t, s.pending, 2)
+
+	// the first attempts fail, they are retried after the backoff
+	s.process(s.pending["0x01"])
+	s.process(s.pending["0x02"])
+	require.Empty(t, dbService.tokens)
+	require.True(t, s.pending["0x01"].next.After(time.Now()))
+
+	s.process(s.pending["0x01"])
+	s.process(s.pending["0x02"])
+	require.Empty(t, s.pending)
+	require.Len(t, dbService.tokens, 2)
+	require.Equal(t, s.conf.Gateway+"bafkreilogo", dbService.tokens[0].Logo)
+	require.Equal(t, types.TokenMetadataFlagResolved, dbService.tokens[0].MetadataFlags)
+
+	// given up, the links are still normalised
+	require.Equal(t, "0x02", dbService.tokens[1].Address)
+	require.Empty(t, dbService.tokens[1].Logo)
+	require.Equal(t, "https://x.com/dog", dbService.tokens[1].Twitter)
+	require.Equal(t, types.TokenMetadataFlagFailed, dbService.tokens[1].MetadataFlags)
+}
+
+func TestNormalizeLinks(t *testing.T) {
+	for _, c := range []struct {
+		normalize func(string) (string, bool)
+		link      string
+		expected  string
+		ok        bool
+	}{
+		{normalizeTelegram, "", "", true},
+		{normalizeTelegram, "@dog_group", "https://t.me/dog_group", true},
+		{normalizeTelegram, "https://telegram.me/dog_group/", "https://t.me/dog_group", true},
+		{normalizeTelegram, "t.me/+AbCdEfGh123", "https://t.me/+AbCdEfGh123", true},
+		{normalizeTelegram, "https://t.me/dog", "", false},
+		{normalizeTelegram, "https://discord.gg/dog_group", "", false},
+		{normalizeTwitter, "dog_bsc", "https://x.com/dog_bsc", true},
+		{normalizeTwitter, "https://www.twitter.com/dog_bsc/status/123", "https://x.com/dog_bsc/status/123", true},
+		{normalizeTwitter, "x.com/a_name_longer_than_15", "", false},
+		{normalizeTwitter, "https://x.com/i/communities/1", "", false},
+		{normalizeWebsite, "dog.io/about", "https://dog.io/about", true},
+		{normalizeWebsite, "http://Dog.IO", "http://dog.io", true},
+		{normalizeWebsite, "javascript:alert(1)", "", false},
+		{normalizeWebsite, "https://user@dog.io", "", false},
+		{normalizeWebsite, "not a site", "", false},
+	} {
+		normalized, ok := c.normalize(c.link)
+		require.Equal(t, c.ok, ok, c.link)
+		require.Equal(t, c.expected, normalized, c.link)
+	}
+}
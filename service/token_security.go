@@ -0,0 +1,382 @@
+package service
+
+import (
+	"bxs/abi/registry"
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/logger"
+	"bxs/types"
+	"bytes"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/panjf2000/ants/v2"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"math/big"
+	"sync"
+	"time"
+)
+
+var (
+	// the simulated trader, and the wallet it transfers to, the overrides fund the trader
+	simTrader   = common.HexToAddress("0x5e0c0000000000000000000000000000000b7c5e")
+	simReceiver = common.HexToAddress("0x5e0c0000000000000000000000000000000b7c5f")
+
+	// selectors found in the code of the tokens able to block wallets
+	blacklistSelectors = selectors(
+		"blacklist(address)",
+		"addToBlacklist(address)",
+		"setBlacklist(address,bool)",
+		"isBlacklisted(address)",
+		"_isBlacklisted(address)",
+		"setBots(address[],bool)",
+		"addBots(address[])",
+		"isBot(address)",
+	)
+	// selectors found in the code of the tokens capping the amount of a tx or held by a wallet
+	maxTxSelectors = selectors(
+		"_maxTxAmount()",
+		"maxTxAmount()",
+		"maxTransactionAmount()",
+		"setMaxTxAmount(uint256)",
+		"setMaxTxPercent(uint256)",
+		"_maxWalletSize()",
+		"maxWallet()",
+		"maxWalletAmount()",
+	)
+)
+
+func selectors(signatures ...string) [][]byte {
+	s := make([][]byte, 0, len(signatures))
+	for _, signature := range signatures {
+		s = append(s, crypto.Keccak256([]byte(signature))[:4])
+	}
+	return s
+}
+
+// hasSelector looks for the PUSH4 of a function selector in the dispatcher of a contract code
+func hasSelector(code []byte, selectors [][]byte) bool {
+	for _, selector := range selectors {
+		if bytes.Contains(code, append([]byte{0x63}, selector...)) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenSimulator interface {
+	BlockNumber() (uint64, error)
+	CodeAt(address common.Address, blockNumber *big.Int) ([]byte, error)
+	Simulate(blockNumber *big.Int, overrides map[common.Address]*SimOverride, calls []*SimCall) ([]*SimCallResult, error)
+}
+
+type TokenSecurityService interface {
+	Start()
+	// Watch schedules the simulations of a token listed on a PancakeV2 pair at listedAt
+	Watch(token, pair common.Address, listedAt time.Time)
+}
+
+type watchedToken struct {
+	token   common.Address
+	pair    common.Address
+	until   time.Time // no refresh after it
+	next    time.Time
+	running bool
+}
+
+/*
+tokenSecurityService simulates a buy, a transfer and a sell of the tokens listed on PancakeV2, through the router,
+to measure their taxes and find the honeypots, then checks their code for blacklist and max tx functions.
+A token is simulated when listed, then every RefreshIntervalSec until WatchHours after its listing,
+a listing older than that, when catching up, is simulated once.
+The watched tokens are kept in memory, they are reloaded on start from the pairs listed in the last WatchHours.
+*/
+type tokenSecurityService struct {
+	ctx         context.Context
+	chain       *chain_params.ChainParams
+	conf        *config.TokenSecurityConf
+	simulator   tokenSimulator
+	dbService   DBService
+	probeAmount *big.Int
+	workPool    *ants.Pool
+	mu          sync.Mutex
+	watched     map[common.Address]*watchedToken
+}
+
+func NewTokenSecurityService(
+	ctx context.Context,
+	chain *chain_params.ChainParams,
+	conf *config.TokenSecurityConf,
+	contractCaller *ContractCaller,
+	dbService DBService,
+) TokenSecurityService {
+	return newTokenSecurityService(ctx, chain, conf, contractCaller, dbService)
+}
+
+func newTokenSecurityService(
+	ctx context.Context,
+	chain *chain_params.ChainParams,
+	conf *config.TokenSecurityConf,
+	simulator tokenSimulator,
+	dbService DBService,
+) *tokenSecurityService {
+	workPool, err := ants.NewPool(max(conf.PoolSize, 1))
+	if err != nil {
+		logger.G.Fatal("ants pool(TokenSecurityService) init err", zap.Error(err))
+	}
+
+	return &tokenSecurityService{
+		ctx:         ctx,
+		chain:       chain,
+		conf:        conf,
+		simulator:   simulator,
+		dbService:   dbService,
+		probeAmount: decimal.NewFromFloat(conf.ProbeAmount).Shift(int32(chain.WrappedNativeDecimals)).BigInt(),
+		workPool:    workPool,
+		watched:     make(map[common.Address]*watchedToken),
+	}
+}
+
+func (s *tokenSecurityService) Watch(token, pair common.Address, listedAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.watched[token]; ok {
+		return
+	}
+	s.watched[token] = &watchedToken{
+		token: token,
+		pair:  pair,
+		until: listedAt.Add(time.Duration(s.conf.WatchHours) * time.Hour),
+	}
+}
+
+func (s *tokenSecurityService) Start() {
+	s.reload(time.Now())
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.dispatchDue(now)
+			}
+		}
+	}()
+}
+
+// reload watches again the tokens of the pairs listed in the WatchHours before now, they were watched before the restart
+func (s *tokenSecurityService) reload(now time.Time) {
+	pairs, err := s.dbService.ListPairsSince(types.GetProtocolName(types.ProtocolIdPancakeV2), now.Add(-time.Duration(s.conf.WatchHours)*time.Hour))
+	if err != nil {
+		logger.G.Error("list listed pairs err", zap.Error(err))
+		return
+	}
+	for _, pair := range pairs {
+		s.Watch(common.HexToAddress(pair.Token0), common.HexToAddress(pair.Address), pair.BlockAt)
+	}
+	logger.G.Info("token security reloaded", zap.Int("tokens", len(pairs)))
+}
+
+// dispatchDue submits the checks due, out of the lock the checks take when they finish
+func (s *tokenSecurityService) dispatchDue(now time.Time) {
+	var due []*watchedToken
+	s.mu.Lock()
+	for _, w := range s.watched {
+		if !w.running && !now.Before(w.next) {
+			w.running = true
+			due = append(due, w)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, w := range due {
+		err := s.workPool.Submit(func() {
+			s.refresh(w)
+		})
+		if err != nil {
+			logger.G.Error("submit token security err", zap.Error(err))
+			s.mu.Lock()
+			w.running = false
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *tokenSecurityService) refresh(w *watchedToken) {
+	security, err := s.Check(w.token, w.pair)
+	if err != nil {
+		logger.G.Warn("token security check err", zap.String("token", w.token.String()), zap.Error(err))
+	} else if err = s.dbService.UpdateTokenSecurity(security.GetOrmToken()); err != nil {
+		logger.G.Error("update token security err", zap.String("token", w.token.String()), zap.Error(err))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.running = false
+	w.next = time.Now().Add(time.Duration(s.conf.RefreshIntervalSec) * time.Second)
+	if !w.next.Before(w.until) {
+		delete(s.watched, w.token)
+	}
+}
+
+// mustPack packs the calls built by the service, their methods are part of the built-in ABIs
+func mustPack(kind, method string, args ...any) hexutil.Bytes {
+	data, err := registry.G.Pack(kind, method, args...)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (s *tokenSecurityService) routerCall(value *big.Int, method string, args ...any) *SimCall {
+	return &SimCall{
+		From:  simTrader,
+		To:    &s.chain.PancakeV2RouterAddress,
+		Value: (*hexutil.Big)(value),
+		Data:  mustPack(registry.KindPancakeV2Router, method, args...),
+	}
+}
+
+func tokenCall(token common.Address, method string, args ...any) *SimCall {
+	return &SimCall{
+		From: simTrader,
+		To:   &token,
+		Data: mustPack(registry.KindBep20, method, args...),
+	}
+}
+
+// buyCalls quote the buy of the probe amount, buy and read the tokens received
+func (s *tokenSecurityService) buyCalls(token common.Address) []*SimCall {
+	path := []common.Address{s.chain.WrappedNativeAddress, token}
+	return []*SimCall{
+		s.routerCall(nil, "getAmountsOut", s.probeAmount, path),
+		s.routerCall(s.probeAmount, "swapExactETHForTokensSupportingFeeOnTransferTokens", big.NewInt(0), path, simTrader, math.MaxBig256),
+		tokenCall(token, "balanceOf", simTrader),
+	}
+}
+
+// sellCalls transfer the half of the tokens bought to another wallet, then quote and sell the other half for the wrapped native
+func (s *tokenSecurityService) sellCalls(token common.Address, transferAmount, sellAmount *big.Int) []*SimCall {
+	path := []common.Address{token, s.chain.WrappedNativeAddress}
+	return []*SimCall{
+		tokenCall(token, "transfer", simReceiver, transferAmount),
+		tokenCall(token, "balanceOf", simReceiver),
+		tokenCall(token, "approve", s.chain.PancakeV2RouterAddress, math.MaxBig256),
+		s.routerCall(nil, "getAmountsOut", sellAmount, path),
+		s.routerCall(nil, "swapExactTokensForTokensSupportingFeeOnTransferTokens", sellAmount, big.NewInt(0), path, simTrader, math.MaxBig256),
+		tokenCall(s.chain.WrappedNativeAddress, "balanceOf", simTrader),
+	}
+}
+
+/*
+Check simulates the trades of a token on top of the head block.
+The buy is simulated alone first, to know the amount bought, then again followed by the transfer and the sell.
+*/
+func (s *tokenSecurityService) Check(token, pair common.Address) (*types.TokenSecurity, error) {
+	head, err := s.simulator.BlockNumber()
+	if err != nil {
+		return nil, err
+	}
+	blockNumber := new(big.Int).SetUint64(head)
+
+	security := &types.TokenSecurity{
+		Token:     token.String(),
+		Pair:      pair.String(),
+		Block:     head,
+		CheckedAt: time.Now(),
+	}
+
+	code, err := s.simulator.CodeAt(token, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	if hasSelector(code, blacklistSelectors) {
+		security.Flags |= types.TokenSecurityFlagBlacklist
+	}
+	if hasSelector(code, maxTxSelectors) {
+		security.Flags |= types.TokenSecurityFlagMaxTx
+	}
+
+	overrides := map[common.Address]*SimOverride{
+		simTrader: {Balance: (*hexutil.Big)(new(big.Int).Mul(s.probeAmount, big.NewInt(2)))},
+	}
+
+	buyCalls := s.buyCalls(token)
+	results, err := s.simulator.Simulate(blockNumber, overrides, buyCalls)
+	if err != nil {
+		return nil, err
+	}
+	if results[1].Reverted() {
+		security.Flags |= types.TokenSecurityFlagBuyReverted
+		security.Reason = results[1].RevertReason()
+		return security, nil
+	}
+	bought := unpackBalance(results[2])
+	security.BuyTax = taxOf(bought, unpackAmountOut(results[0]))
+
+	transferAmount := new(big.Int).Div(bought, big.NewInt(2))
+	sellAmount := new(big.Int).Sub(bought, transferAmount)
+	results, err = s.simulator.Simulate(blockNumber, overrides, append(buyCalls, s.sellCalls(token, transferAmount, sellAmount)...))
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, received, approve, sellQuote, sell, sold := results[3], results[4], results[5], results[6], results[7], results[8]
+	if transfer.Reverted() {
+		security.Flags |= types.TokenSecurityFlagTransferReverted
+		security.Reason = transfer.RevertReason()
+	} else {
+		security.TransferTax = taxOf(unpackBalance(received), transferAmount)
+	}
+
+	for _, r := range []*SimCallResult{approve, sell} {
+		if r.Reverted() {
+			security.Flags |= types.TokenSecurityFlagSellReverted
+			if security.Reason == "" {
+				security.Reason = r.RevertReason()
+			}
+			return security, nil
+		}
+	}
+	security.SellTax = taxOf(unpackBalance(sold), unpackAmountOut(sellQuote))
+	return security, nil
+}
+
+// unpackBalance is the output of a balanceOf, zero when it can not be read
+func unpackBalance(result *SimCallResult) *big.Int {
+	values, err := registry.G.Unpack(registry.KindBep20, "balanceOf", result.ReturnData)
+	if err != nil || len(values) != 1 {
+		return new(big.Int)
+	}
+	if v, ok := values[0].(*big.Int); ok {
+		return v
+	}
+	return new(big.Int)
+}
+
+// unpackAmountOut is the last amount of a getAmountsOut, zero when it can not be read
+func unpackAmountOut(result *SimCallResult) *big.Int {
+	values, err := registry.G.Unpack(registry.KindPancakeV2Router, "getAmountsOut", result.ReturnData)
+	if err != nil || len(values) != 1 {
+		return new(big.Int)
+	}
+	amounts, ok := values[0].([]*big.Int)
+	if !ok || len(amounts) == 0 {
+		return new(big.Int)
+	}
+	return amounts[len(amounts)-1]
+}
+
+// taxOf is the share of the expected amount not received, between 0 and 1
+func taxOf(received, expected *big.Int) decimal.Decimal {
+	if expected.Sign() <= 0 {
+		return decimal.Zero
+	}
+	tax := decimal.NewFromInt(1).Sub(decimal.NewFromBigInt(received, 0).Div(decimal.NewFromBigInt(expected, 0))).Round(4)
+	return decimal.Min(decimal.Max(tax, decimal.Zero), decimal.NewFromInt(1))
+}
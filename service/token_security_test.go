@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bxs/abi/bep20"
+	pancakev2 "bxs/abi/pancake/v2"
+	"bxs/chain_params"
+	"bxs/config"
+	"bxs/repository/orm"
+	"bxs/types"
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type fakeSimulator struct {
+	code    []byte
+	results [][]*SimCallResult // returned by the successive simulations
+	calls   [][]*SimCall
+}
+
+func (s *fakeSimulator) BlockNumber() (uint64, error) {
+	return 100, nil
+}
+
+func (s *fakeSimulator) CodeAt(common.Address, *big.Int) ([]byte, error) {
+	return s.code, nil
+}
+
+func (s *fakeSimulator) Simulate(_ *big.Int, _ map[common.Address]*SimOverride, calls []*SimCall) ([]*SimCallResult, error) {
+	s.calls = append(s.calls, calls)
+	results := s.results[0]
+	s.results = s.results[1:]
+	return results, nil
+}
+
+type fakeDBService struct {
+	DBService
+	securities []*orm.Token
+}
+
+func (s *fakeDBService) UpdateTokenSecurity(token *orm.Token) error {
+	s.securities = append(s.securities, token)
+	return nil
+}
+
+func simOk(t *testing.T, contractAbi *abi.ABI, method string, outputs ...any) *SimCallResult {
+	data, err := contractAbi.Methods[method].Outputs.Pack(outputs...)
+	require.NoError(t, err)
+	return &SimCallResult{ReturnData: data, Status: 1}
+}
+
+func simReverted(t *testing.T, reason string) *SimCallResult {
+	stringType, err := abi.NewType("string", "", nil)
+	require.NoError(t, err)
+	data, err := abi.Arguments{{Type: stringType}}.Pack(reason)
+	require.NoError(t, err)
+	return &SimCallResult{Error: &SimCallError{Message: "execution reverted", Data: common.Bytes2Hex(append(crypto.Keccak256([]byte("Error(string)"))[:4], data...))}}
+}
+
+func testTokenSecurityService(simulator tokenSimulator, dbService DBService) *tokenSecurityService {
+	conf := &config.TokenSecurityConf{ProbeAmount: 0.1, RefreshIntervalSec: 600, WatchHours: 24, PoolSize: 1}
+	return newTokenSecurityService(context.Background(), chain_params.G, conf, simulator, dbService)
+}
+
+func simBuyResults(t *testing.T, quoted, bought int64) []*SimCallResult {
+	return []*SimCallResult{
+		simOk(t, pancakev2.RouterAbi, "getAmountsOut", []*big.Int{big.NewInt(1e17), big.NewInt(quoted)}),
+		{Status: 1},
+		simOk(t, bep20.Abi, "balanceOf", big.NewInt(bought)),
+	}
+}
+
+func TestTokenSecurityService_Check(t *testing.T) {
+	token, pair := common.HexToAddress("0x70"), common.HexToAddress("0x71")
+	sim := &fakeSimulator{code: append([]byte{0x60, 0x80, 0x63}, crypto.Keccak256([]byte("isBlacklisted(address)"))[:4]...)}
+	sim.results = [][]*SimCallResult{
+		simBuyResults(t, 1000, 900),
+		append(simBuyResults(t, 1000, 900),
+			&SimCallResult{Status: 1},
+			simOk(t, bep20.Abi, "balanceOf", big.NewInt(441)),
+			&SimCallResult{Status: 1},
+			simOk(t, pancakev2.RouterAbi, "getAmountsOut", []*big.Int{big.NewInt(450), big.NewInt(100)}),
+			&SimCallResult{Status: 1},
+			simOk(t, bep20.Abi, "balanceOf", big.NewInt(80)),
+		),
+	}
+
+	security, err := testTokenSecurityService(sim, nil).Check(token, pair)
+	require.NoError(t, err)
+	require.Equal(t, types.TokenSecurityFlagBlacklist, security.Flags)
+	require.Equal(t, uint64(100), security.Block)
+	require.True(t, decimal.RequireFromString("0.1").Equal(security.BuyTax))
+	require.True(t, decimal.RequireFromString("0.02").Equal(security.TransferTax))
+	require.True(t, decimal.RequireFromString("0.2").Equal(security.SellTax))
+	require.False(t, security.IsHoneypot())
+
+	// the second simulation replays the buy, then transfers and sells the half of the tokens bought
+	require.Len(t, sim.calls, 2)
+	require.Len(t, sim.calls[1], 9)
+	transfer, err := bep20.Abi.Pack("transfer", simReceiver, big.NewInt(450))
+	require.NoError(t, err)
+	require.Equal(t, transfer, []byte(sim.calls[1][3].Data))
+}
+
+func TestTokenSecurityService_Honeypot(t *testing.T) {
+	token, pair := common.HexToAddress("0x70"), common.HexToAddress("0x71")
+	sim := &fakeSimulator{}
+	sim.results = [][]*SimCallResult{
+		simBuyResults(t, 1000, 1000),
+		append(simBuyResults(t, 1000, 1000),
+			simReverted(t, "transfer paused"),
+			simOk(t, bep20.Abi, "balanceOf", big.NewInt(0)),
+			&SimCallResult{Status: 1},
+			simOk(t, pancakev2.RouterAbi, "getAmountsOut", []*big.Int{big.NewInt(500), big.NewInt(100)}),
+			simReverted(t, "Pancake: TRANSFER_FAILED"),
+			simOk(t, bep20.Abi, "balanceOf", big.NewInt(0)),
+		),
+	}
+
+	dbService := &fakeDBService{}
+	s := testTokenSecurityService(sim, dbService)
+	// listed long ago: checked once, then no longer watched
+	s.Watch(token, pair, time.Now().Add(-48*time.Hour))
+	s.refresh(s.watched[token])
+	require.Empty(t, s.watched)
+
+	require.Len(t, dbService.securities, 1)
+	security := dbService.securities[0]
+	require.Equal(t, token.String(), security.Address)
+	require.Equal(t, types.TokenSecurityFlagTransferReverted|types.TokenSecurityFlagSellReverted, security.SecurityFlags)
+	require.True(t, security.BuyTax.IsZero())
+
+	// the buy reverts
+	sim.results = [][]*SimCallResult{{simOk(t, pancakev2.RouterAbi, "getAmountsOut", []*big.Int{big.NewInt(1e17), big.NewInt(1000)}), simReverted(t, "trading not open"), {}}}
+	checked, err := s.Check(token, pair)
+	require.NoError(t, err)
+	require.Equal(t, types.TokenSecurityFlagBuyReverted, checked.Flags)
+	require.Equal(t, "trading not open", checked.Reason)
+}
+
+func TestTokenSecurityService_Reload(t *testing.T) {
+	now := time.Now()
+	dbService := NewMemoryDBService()
+	program := types.GetProtocolName(types.ProtocolIdPancakeV2)
+	require.NoError(t, dbService.AddPairs([]*orm.Pair{
+		{Address: "0x0000000000000000000000000000000000000071", Token0: "0x0000000000000000000000000000000000000070", Program: program, BlockAt: now.Add(-time.Hour)},
+		{Address: "0x0000000000000000000000000000000000000073", Token0: "0x0000000000000000000000000000000000000072", Program: program, BlockAt: now.Add(-48 * time.Hour)},
+		{Address: "0x0000000000000000000000000000000000000075", Token0: "0x0000000000000000000000000000000000000074", Program: "xlaunch", BlockAt: now},
+	}))
+
+	s := testTokenSecurityService(&fakeSimulator{}, dbService)
+	s.reload(now)
+	require.Len(t, s.watched, 1)
+	w := s.watched[common.HexToAddress("0x70")]
+	require.Equal(t, common.HexToAddress("0x71"), w.pair)
+	require.Equal(t, now.Add(23*time.Hour).Unix(), w.until.Unix())
+}
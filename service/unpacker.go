@@ -1,14 +1,10 @@
 package service
 
 import (
-	"bxs/abi/bep20"
-	"bxs/abi/ds_token"
-	pancakev2 "bxs/abi/pancake/v2"
-	"bxs/abi/xlaunch"
+	"bxs/abi/registry"
 	"bxs/types"
 	"bxs/util"
 	"errors"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 )
@@ -17,13 +13,14 @@ type Unpacker interface {
 	Unpack(method string, data []byte, length int) (values []interface{}, err error)
 }
 
+// unpacker tries the ABIs of its kinds in order, resolved in the ABI registry on each call
 type unpacker struct {
-	abis []*abi.ABI
+	kinds []string
 }
 
-func NewUnpacker(abis []*abi.ABI) Unpacker {
+func NewUnpacker(kinds ...string) Unpacker {
 	return &unpacker{
-		abis: abis,
+		kinds: kinds,
 	}
 }
 
@@ -37,8 +34,8 @@ var (
 )
 
 func (u *unpacker) Unpack(method string, data []byte, length int) ([]interface{}, error) {
-	for _, abi_ := range u.abis {
-		values, err := abi_.Unpack(method, data)
+	for _, kind := range u.kinds {
+		values, err := registry.G.Unpack(kind, method, data)
 		if err == nil && len(values) == length {
 			return values, nil
 		}
@@ -47,39 +44,26 @@ func (u *unpacker) Unpack(method string, data []byte, length int) ([]interface{}
 }
 
 var (
-	TokenUnpacker = NewUnpacker([]*abi.ABI{
-		bep20.Abi,
-		ds_token.Abi,
-	})
-
-	PancakeV2PairUnpacker = NewUnpacker([]*abi.ABI{
-		pancakev2.PairAbi,
-	})
-
-	PancakeV2FactoryUnpacker = NewUnpacker([]*abi.ABI{
-		pancakev2.FactoryAbi,
-	})
-
-	XLaunchUnpacker = NewUnpacker([]*abi.ABI{
-		xlaunch.PairAbi,
-	})
-
-	XLaunchFactoryUnpacker = NewUnpacker([]*abi.ABI{
-		xlaunch.FactoryAbi,
-	})
-
-	Name2Unpacker = map[string]Unpacker{
-		"name":        TokenUnpacker,
-		"symbol":      TokenUnpacker,
-		"decimals":    TokenUnpacker,
-		"totalSupply": TokenUnpacker,
-		"token0":      PancakeV2PairUnpacker,
-		"token1":      PancakeV2PairUnpacker,
-		"getReserves": PancakeV2PairUnpacker,
-		"token":       XLaunchUnpacker,
+	TokenUnpacker            = NewUnpacker(registry.KindBep20, registry.KindDsToken)
+	PancakeV2PairUnpacker    = NewUnpacker(registry.KindPancakeV2Pair)
+	PancakeV2FactoryUnpacker = NewUnpacker(registry.KindPancakeV2Factory)
+	XLaunchUnpacker          = NewUnpacker(registry.KindXLaunchPool)
+	XLaunchFactoryUnpacker   = NewUnpacker(registry.KindXLaunchFactory)
+
+	// kindUnpackers are the kinds also unpacked with another ABI, the bytes32 name and symbol of DSToken for BEP20
+	kindUnpackers = map[string]Unpacker{
+		registry.KindBep20: TokenUnpacker,
 	}
 )
 
+// unpackerOf returns the unpacker of the outputs of the methods of a contract kind
+func unpackerOf(kind string) Unpacker {
+	if upk, ok := kindUnpackers[kind]; ok {
+		return upk
+	}
+	return NewUnpacker(kind)
+}
+
 func ParseString(value interface{}) (string, error) {
 	var str string
 	var err error
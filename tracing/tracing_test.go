@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"bxs/config"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+// useRecorder installs a provider recording the ended spans, until the test ends
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func TestInit(t *testing.T) {
+	shutdown, err := Init(&config.TracingConf{Enabled: false}, "test")
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+
+	_, err = Init(&config.TracingConf{Enabled: true, Exporter: "zipkin"}, "test")
+	require.ErrorContains(t, err, "unknown tracing exporter")
+}
+
+func TestEnd(t *testing.T) {
+	recorder := useRecorder(t)
+
+	ctx, block := Start(context.Background(), "block", BlockNumber(100))
+	_, fetch := Start(ctx, "get_block")
+	End(fetch, errors.New("timeout"))
+	End(block, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, "get_block", spans[0].Name())
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, "timeout", spans[0].Status().Description)
+	require.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	require.Equal(t, codes.Unset, spans[1].Status().Code)
+	require.Contains(t, spans[1].Attributes(), BlockNumber(100))
+}
+
+func TestKafkaHeaders(t *testing.T) {
+	useRecorder(t)
+	require.Empty(t, KafkaHeaders(context.Background()))
+
+	ctx, span := Start(context.Background(), "commit")
+	defer span.End()
+
+	headers := KafkaHeaders(ctx)
+	require.Len(t, headers, 1)
+	require.Equal(t, "traceparent", string(headers[0].Key))
+	require.Contains(t, string(headers[0].Value), span.SpanContext().TraceID().String())
+}
@@ -3,6 +3,7 @@ package types
 import (
 	"bxs/logger"
 	"bxs/repository/orm"
+	"context"
 	"github.com/ethereum/go-ethereum/common"
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/shopspring/decimal"
@@ -28,12 +29,25 @@ func GetBlockHeightTime(header *ethtypes.Header) *HeightTime {
 
 type BlockContext struct {
 	HeightTime       *HeightTime
+	HeadHeight       uint64
 	TransactionsLen  uint
 	Transactions     []*ethtypes.Transaction
 	Receipts         []*ethtypes.Receipt
 	NativeTokenPrice decimal.Decimal
 	Senders          []common.Address
+	Events           [][]Event // decoded events by receipt, kept from parsing until the in order resolve
 	TxResults        []*TxResult
+	LaunchFlags      []*LaunchFlags  // tokens with a flagged buy in the block
+	FailedSwaps      []*FailedSwap   // decoded when parsed, kept when their token is resolved
+	TraceCtx         context.Context // holds the root span of the block, ended once it is committed
+}
+
+// Context returns the context of the block span, the background one when the block is not traced
+func (c *BlockContext) Context() context.Context {
+	if c.TraceCtx == nil {
+		return context.Background()
+	}
+	return c.TraceCtx
 }
 
 func (c *BlockContext) getSender(transactionIndex uint) common.Address {
@@ -88,7 +102,7 @@ func mergePoolUpdates(poolUpdates []*PoolUpdate) []*PoolUpdate {
 	return poolUpdatesMerged
 }
 
-func (c *BlockContext) GetKafkaMsg() *KafkaMsg {
+func (c *BlockContext) GetKafkaMsg(chainId int) *KafkaMsg {
 	poolUpdates := make([]*PoolUpdate, 0, 256)
 	txs := make([]*orm.Tx, 0, 256)
 	migratedPools := make([]*MigratedPool, 0, 8)
@@ -104,23 +118,29 @@ func (c *BlockContext) GetKafkaMsg() *KafkaMsg {
 		poolUpdates = append(poolUpdates, txResult.PoolUpdates...)
 		for _, event := range txResult.SwapEvents {
 			if event.CanGetTx() {
-				txs = append(txs, event.GetTx(c.NativeTokenPrice))
+				tx := event.GetTx(c.NativeTokenPrice)
+				tx.ChainId = chainId
+				txs = append(txs, tx)
 			}
 		}
 
 		for _, pair := range txResult.Pairs {
-			ormPairs = append(ormPairs, pair.GetOrmPair())
+			ormPairs = append(ormPairs, pair.GetOrmPair(chainId))
 		}
 
 		for _, token := range txResult.Tokens {
-			ormTokens = append(ormTokens, token.GetOrmToken())
+			ormTokens = append(ormTokens, token.GetOrmToken(chainId))
 		}
 
 		migratedPools = append(migratedPools, txResult.MigratedPools...)
-		actions = append(actions, txResult.Actions...)
+		for _, action := range txResult.Actions {
+			action.ChainId = chainId
+			actions = append(actions, action)
+		}
 	}
 
 	block := &KafkaMsg{
+		ChainId:          chainId,
 		Height:           c.HeightTime.Height,
 		Timestamp:        c.HeightTime.Timestamp,
 		NativeTokenPrice: c.NativeTokenPrice.String(),
@@ -130,6 +150,8 @@ func (c *BlockContext) GetKafkaMsg() *KafkaMsg {
 		NewTokens:        ormTokens,
 		NewPairs:         ormPairs,
 		PoolUpdates:      mergePoolUpdates(poolUpdates),
+		LaunchFlags:      c.LaunchFlags,
+		FailedSwaps:      c.FailedSwaps,
 	}
 
 	return block
@@ -7,9 +7,8 @@ import (
 )
 
 var (
-	ZeroAddress       = common.Address{}
-	ZeroDecimal       = decimal.NewFromInt(0)
-	ZeroBigInt        = new(big.Int)
-	Decimal18         = int8(18)
-	NativeTokenSymbol = "BNB"
+	ZeroAddress = common.Address{}
+	ZeroDecimal = decimal.NewFromInt(0)
+	ZeroBigInt  = new(big.Int)
+	Decimal18   = int8(18)
 )
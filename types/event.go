@@ -13,7 +13,12 @@ type Event interface {
 	GetPair() *Pair
 	SetPair(pair *Pair)
 	GetToken0() *Token
+	GetMaker() common.Address
 	SetMaker(maker common.Address)
+	SetSender(sender common.Address)
+	GetLogIndex() uint
+	// GetRecipient returns the account the event pays, the zero address when it pays no one
+	GetRecipient() common.Address
 	SetBlockTime(blockTime time.Time)
 
 	CanGetTx() bool
@@ -27,9 +32,11 @@ type Event interface {
 	GetAction() *orm.Action
 
 	IsPairCreated() bool
-	GetNonWBNBToken() common.Address
+	GetNonWrappedNativeToken() common.Address
 
 	IsBuyOrSell() bool
+	IsBuy() bool
+	AddFlags(flags int)
 	IsSwap() bool
 	IsTokenReverse() bool
 	IsSync() bool
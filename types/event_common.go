@@ -14,9 +14,11 @@ type EventCommon struct {
 	BlockNumber     uint64
 	BlockTime       time.Time
 	TxHash          common.Hash
-	Maker           common.Address
+	Maker           common.Address // the trader, resolved from the tx sender
+	Sender          common.Address // the tx sender
 	TxIndex         uint
 	LogIndex        uint
+	Flags           int // TradeFlag* bits
 }
 
 var _ Event = &EventCommon{}
@@ -61,10 +63,26 @@ func (e *EventCommon) IsCreated() bool {
 	return false
 }
 
+func (e *EventCommon) GetMaker() common.Address {
+	return e.Maker
+}
+
 func (e *EventCommon) SetMaker(maker common.Address) {
 	e.Maker = maker
 }
 
+func (e *EventCommon) SetSender(sender common.Address) {
+	e.Sender = sender
+}
+
+func (e *EventCommon) GetLogIndex() uint {
+	return e.LogIndex
+}
+
+func (e *EventCommon) GetRecipient() common.Address {
+	return ZeroAddress
+}
+
 func (e *EventCommon) SetBlockTime(blockTime time.Time) {
 	e.BlockTime = blockTime
 }
@@ -77,7 +95,7 @@ func (e *EventCommon) GetAction() *orm.Action {
 	return nil
 }
 
-func (e *EventCommon) GetNonWBNBToken() common.Address {
+func (e *EventCommon) GetNonWrappedNativeToken() common.Address {
 	return ZeroAddress
 }
 
@@ -89,6 +107,14 @@ func (e *EventCommon) IsBuyOrSell() bool {
 	return false
 }
 
+func (e *EventCommon) IsBuy() bool {
+	return false
+}
+
+func (e *EventCommon) AddFlags(flags int) {
+	e.Flags |= flags
+}
+
 func (e *EventCommon) IsSwap() bool {
 	return false
 }
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"bxs/repository/orm"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"math/big"
+	"time"
+)
+
+/*
+FailedSwap is a reverted tx sent to an xLaunch pool or a swap router, decoded from its calldata.
+The amounts are the limits of the calldata: the exact amount paid or sold, and the minimum amount expected,
+or the exact amount expected and the maximum amount paid.
+*/
+type FailedSwap struct {
+	TxHash       string          `json:"tx_hash"`
+	Sender       string          `json:"sender"`
+	To           string          `json:"to"` // the xLaunch pool or the router
+	Method       string          `json:"method"`
+	Event        string          `json:"event"` // the intended direction, buy or sell of the token
+	Token        string          `json:"token"`
+	TokenAmount  decimal.Decimal `json:"token_amount"`
+	NativeAmount decimal.Decimal `json:"native_amount"`
+	RevertReason string          `json:"revert_reason"` // empty when the re-simulation on the parent block does not revert
+	GasUsed      uint64          `json:"gas_used"`
+	GasFee       decimal.Decimal `json:"gas_fee"` // native token burned
+	Block        uint64          `json:"block"`
+	BlockIndex   uint            `json:"block_index"`
+
+	// decoded from the calldata, the pool, the direction of a router swap and the decimals are resolved from the cache
+	Pool            common.Address   `json:"-"` // zero for a router
+	Path            []common.Address `json:"-"` // nil for a pool
+	AmountIn        *big.Int         `json:"-"` // of a router swap, the token or native amount by its direction
+	AmountOut       *big.Int         `json:"-"`
+	TokenAddress    common.Address   `json:"-"` // of a router swap, set when resolved
+	TokenAmountWei  *big.Int         `json:"-"`
+	NativeAmountWei *big.Int         `json:"-"`
+}
+
+func (fs *FailedSwap) GetOrmFailedSwap(chainId int, blockAt time.Time) *orm.FailedSwap {
+	return &orm.FailedSwap{
+		ChainId:      chainId,
+		TxHash:       fs.TxHash,
+		Sender:       fs.Sender,
+		ToAddress:    fs.To,
+		Method:       fs.Method,
+		Event:        fs.Event,
+		Token:        fs.Token,
+		TokenAmount:  fs.TokenAmount,
+		NativeAmount: fs.NativeAmount,
+		RevertReason: fs.RevertReason,
+		GasUsed:      fs.GasUsed,
+		GasFee:       fs.GasFee,
+		Block:        fs.Block,
+		BlockIndex:   fs.BlockIndex,
+		BlockAt:      blockAt,
+	}
+}
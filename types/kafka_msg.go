@@ -2,14 +2,30 @@ package types
 
 import (
 	"bxs/repository/orm"
+	"time"
 )
 
+// ActionMigrated records a pool that finished its bonding curve, so the pending migration survives a cache loss
+const ActionMigrated = "migrated"
+
 type MigratedPool struct {
 	Pool  string `json:"pool"`
 	Token string `json:"token"`
 }
 
+func (p *MigratedPool) GetOrmAction(chainId int, block uint64, blockAt time.Time) *orm.Action {
+	return &orm.Action{
+		Token:   p.Token,
+		Pair:    p.Pool,
+		Action:  ActionMigrated,
+		Block:   block,
+		BlockAt: blockAt,
+		ChainId: chainId,
+	}
+}
+
 type KafkaMsg struct {
+	ChainId          int             `json:"chain_id"`
 	Height           uint64          `json:"height"`
 	Timestamp        uint64          `json:"timestamp"`
 	NativeTokenPrice string          `json:"native_token_price"`
@@ -19,6 +35,9 @@ type KafkaMsg struct {
 	NewTokens        []*orm.Token    `json:"new_tokens"`
 	NewPairs         []*orm.Pair     `json:"new_pairs"`
 	PoolUpdates      []*PoolUpdate   `json:"pool_updates"`
+	LaunchFlags      []*LaunchFlags  `json:"launch_flags"`
+	MevEvents        []*MevEvent     `json:"mev_events"`
+	FailedSwaps      []*FailedSwap   `json:"failed_swaps"`
 }
 
 func (bi *KafkaMsg) UsefulInfo() bool {
@@ -26,5 +45,8 @@ func (bi *KafkaMsg) UsefulInfo() bool {
 		len(bi.NewTokens) != 0 ||
 		len(bi.MigratedPools) != 0 ||
 		len(bi.Actions) != 0 ||
-		len(bi.NewPairs) != 0
+		len(bi.NewPairs) != 0 ||
+		len(bi.LaunchFlags) != 0 ||
+		len(bi.MevEvents) != 0 ||
+		len(bi.FailedSwaps) != 0
 }
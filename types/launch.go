@@ -0,0 +1,12 @@
+package types
+
+// LaunchFlags sums up the flagged buys of a token since its launch, published in the blocks with a new flagged buy
+type LaunchFlags struct {
+	Token          string `json:"token"`
+	Creator        string `json:"creator"`
+	LaunchBlock    uint64 `json:"launch_block"`
+	Block          uint64 `json:"block"`           // the last block with a flagged buy
+	Flags          int    `json:"flags"`           // the trade flags of all the flagged buys
+	FlaggedBuys    int    `json:"flagged_buys"`    // buys with at least one flag
+	FlaggedWallets int    `json:"flagged_wallets"` // distinct makers of the flagged buys
+}
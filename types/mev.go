@@ -0,0 +1,42 @@
+package types
+
+import (
+	"bxs/repository/orm"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+const (
+	MevKindSandwich  = "sandwich"
+	MevKindBackrun   = "backrun"   // an arbitrage directly following a trade of another trader on one of its pools
+	MevKindArbitrage = "arbitrage" // an arbitrage following no trade of the block on its pools
+)
+
+// MevEvent is a sandwich or an arbitrage found in the trades of a block, the trades are annotated with the TradeFlag* bits
+type MevEvent struct {
+	Kind        string          `json:"kind"`
+	Block       uint64          `json:"block"`
+	Pool        string          `json:"pool"` // the sandwiched pool, or the first pool of the arbitrage
+	Token       string          `json:"token"`
+	Attacker    string          `json:"attacker"`
+	AttackerTxs []string        `json:"attacker_txs"` // the front and back run, or the arbitrage tx
+	Victims     []string        `json:"victims"`
+	VictimTxs   []string        `json:"victim_txs"`
+	ProfitUsd   decimal.Decimal `json:"profit_usd"` // estimated on the amount both bought and sold by the attacker
+}
+
+func (e *MevEvent) GetOrmMevEvent(chainId int, blockAt time.Time) *orm.MevEvent {
+	return &orm.MevEvent{
+		ChainId:     chainId,
+		Kind:        e.Kind,
+		Block:       e.Block,
+		BlockAt:     blockAt,
+		Pool:        e.Pool,
+		Token:       e.Token,
+		Attacker:    e.Attacker,
+		AttackerTxs: e.AttackerTxs,
+		Victims:     e.Victims,
+		VictimTxs:   e.VictimTxs,
+		ProfitUsd:   e.ProfitUsd,
+	}
+}
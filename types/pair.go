@@ -1,7 +1,6 @@
 package types
 
 import (
-	"bxs/chain_params"
 	"bxs/repository/orm"
 	"bxs/util"
 	"encoding/json"
@@ -109,18 +108,19 @@ func getPairName(token0Symbol, token1Symbol string) string {
 	return token0Symbol + "/" + token1Symbol
 }
 
-func (p *Pair) GetOrmPair() *orm.Pair {
+func (p *Pair) GetOrmPair(chainId int) *orm.Pair {
 	return &orm.Pair{
-		Name:     getPairName(p.Token0.Symbol, p.Token1.Symbol),
-		Address:  p.Address.String(),
-		Token0:   p.Token0.Address.String(),
-		Token1:   p.Token1.Address.String(),
-		Reserve0: p.InitAmount0,
-		Reserve1: p.InitAmount1,
-		ChainId:  chain_params.G.ChainID,
-		Block:    p.Block,
-		BlockAt:  p.BlockAt,
-		Program:  GetProtocolName(p.ProtocolId),
+		Name:         getPairName(p.Token0.Symbol, p.Token1.Symbol),
+		Address:      p.Address.String(),
+		Token0:       p.Token0.Address.String(),
+		Token1:       p.Token1.Address.String(),
+		Reserve0:     p.InitAmount0,
+		Reserve1:     p.InitAmount1,
+		ReserveBlock: p.Block,
+		ChainId:      chainId,
+		Block:        p.Block,
+		BlockAt:      p.BlockAt,
+		Program:      GetProtocolName(p.ProtocolId),
 	}
 }
 
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"bxs/repository/orm"
 	"bxs/util"
 	"github.com/shopspring/decimal"
 )
@@ -35,3 +36,13 @@ func (u *PoolUpdate) Equal(tx *PoolUpdate) bool {
 	}
 	return true
 }
+
+// GetOrmPair returns the pair with the amounts of the update as its reserves at block
+func (u *PoolUpdate) GetOrmPair(block uint64) *orm.Pair {
+	return &orm.Pair{
+		Address:      u.Address,
+		Reserve0:     u.Amount0,
+		Reserve1:     u.Amount1,
+		ReserveBlock: block,
+	}
+}
@@ -20,3 +20,14 @@ func GetProtocolName(id int) string {
 		panic("invalid id")
 	}
 }
+
+func GetProtocolId(name string) (int, bool) {
+	switch name {
+	case ProtocolNameXLaunch:
+		return ProtocolIdXLaunch, true
+	case ProtocolNamePancakeV2:
+		return ProtocolIdPancakeV2, true
+	default:
+		return 0, false
+	}
+}
@@ -8,29 +8,24 @@ import (
 	"time"
 )
 
-var (
-	NativeTokenTinyInfo = &TokenTinyInfo{
+func NativeTokenTinyInfo(chain *chain_params.ChainParams) *TokenTinyInfo {
+	return &TokenTinyInfo{
 		Address: ZeroAddress,
-		Symbol:  NativeTokenSymbol,
+		Symbol:  chain.NativeSymbol,
 		Decimal: Decimal18,
 	}
-	WBNBSymbol  = "WBNB"
-	WBNBDecimal = Decimal18
-)
-
-func IsSameAddress(address1, address2 common.Address) bool {
-	return address1.Cmp(address2) == 0
 }
 
-func IsWBNB(address common.Address) bool {
-	return IsSameAddress(address, chain_params.G.WBNBAddress)
+func WrappedNativeTokenTinyInfo(chain *chain_params.ChainParams) *TokenTinyInfo {
+	return &TokenTinyInfo{
+		Address: chain.WrappedNativeAddress,
+		Symbol:  chain.WrappedNativeSymbol,
+		Decimal: chain.WrappedNativeDecimals,
+	}
 }
 
-func OrderAddress(a0, a1 common.Address) (common.Address, common.Address, bool) {
-	if IsSameAddress(a1, chain_params.G.WBNBAddress) {
-		return a0, a1, false
-	}
-	return a1, a0, true
+func IsSameAddress(address1, address2 common.Address) bool {
+	return address1.Cmp(address2) == 0
 }
 
 func IsNativeToken(address common.Address) bool {
@@ -114,7 +109,7 @@ func (t *Token) Equal(token *Token) bool {
 	return true
 }
 
-func (t *Token) GetOrmToken() *orm.Token {
+func (t *Token) GetOrmToken(chainId int) *orm.Token {
 	return &orm.Token{
 		Address:     t.Address.String(),
 		Creator:     t.Creator.String(),
@@ -122,7 +117,7 @@ func (t *Token) GetOrmToken() *orm.Token {
 		Symbol:      t.Symbol,
 		Decimal:     t.Decimals,
 		TotalSupply: t.TotalSupply.String(),
-		ChainId:     chain_params.G.ChainID,
+		ChainId:     chainId,
 		Block:       t.BlockNumber,
 		BlockAt:     t.BlockTime,
 		Program:     t.Program,
@@ -0,0 +1,45 @@
+package types
+
+import (
+	"bxs/repository/orm"
+	"time"
+)
+
+// TokenMetadataFlag* are the bits of TokenMetadata.Flags
+const (
+	TokenMetadataFlagResolved        = 1 << iota // the CID was fetched from the gateway
+	TokenMetadataFlagInvalid                     // the CID is neither a metadata JSON nor an image
+	TokenMetadataFlagImageInvalid                // the image of the metadata is missing, too large or not an image
+	TokenMetadataFlagTelegramInvalid             // the telegram link is not a t.me link, it is cleared
+	TokenMetadataFlagTwitterInvalid              // the twitter link is not an x.com account or post, it is cleared
+	TokenMetadataFlagWebsiteInvalid              // the website is not an http(s) URL, it is cleared
+	TokenMetadataFlagFailed                      // the gateway failed on every attempt
+)
+
+/*
+TokenMetadata is the enrichment of a created token: the logo resolved from its CID, directly an image
+or a metadata JSON pointing to one, and its social links normalised to their canonical URL.
+The links of the token creation take precedence over the ones of the metadata JSON.
+*/
+type TokenMetadata struct {
+	Token      string
+	Logo       string // gateway URL of the logo image
+	Telegram   string
+	Twitter    string
+	Website    string
+	Flags      int
+	EnrichedAt time.Time
+}
+
+// GetOrmToken returns the token with its metadata columns set
+func (m *TokenMetadata) GetOrmToken() *orm.Token {
+	return &orm.Token{
+		Address:       m.Token,
+		Telegram:      m.Telegram,
+		Twitter:       m.Twitter,
+		Website:       m.Website,
+		Logo:          m.Logo,
+		MetadataFlags: m.Flags,
+		MetadataAt:    m.EnrichedAt,
+	}
+}
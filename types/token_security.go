@@ -0,0 +1,48 @@
+package types
+
+import (
+	"bxs/repository/orm"
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// TokenSecurityFlag* are the bits of TokenSecurity.Flags
+const (
+	TokenSecurityFlagBuyReverted      = 1 << iota // the simulated buy reverts
+	TokenSecurityFlagSellReverted                 // the simulated sell reverts: a honeypot
+	TokenSecurityFlagTransferReverted             // the simulated wallet to wallet transfer reverts
+	TokenSecurityFlagBlacklist                    // the token code has blacklist functions
+	TokenSecurityFlagMaxTx                        // the token code has max tx or max wallet functions
+)
+
+/*
+TokenSecurity is the result of the simulation of a buy, a transfer and a sell of a token on its pair.
+The taxes are the share of the expected amount not received, from 0 to 1.
+*/
+type TokenSecurity struct {
+	Token       string
+	Pair        string
+	Block       uint64 // the simulation runs on top of it
+	BuyTax      decimal.Decimal
+	SellTax     decimal.Decimal
+	TransferTax decimal.Decimal
+	Flags       int
+	Reason      string // revert reason of the first reverted call
+	CheckedAt   time.Time
+}
+
+func (s *TokenSecurity) IsHoneypot() bool {
+	return s.Flags&TokenSecurityFlagSellReverted != 0
+}
+
+// GetOrmToken returns the token with its security columns set
+func (s *TokenSecurity) GetOrmToken() *orm.Token {
+	return &orm.Token{
+		Address:       s.Token,
+		BuyTax:        s.BuyTax,
+		SellTax:       s.SellTax,
+		TransferTax:   s.TransferTax,
+		SecurityFlags: s.Flags,
+		SecurityAt:    s.CheckedAt,
+	}
+}
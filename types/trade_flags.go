@@ -0,0 +1,18 @@
+package types
+
+// TradeFlag* are the bits of orm.Tx.Flags
+const (
+	// the buys of a token soon after its launch
+	TradeFlagSameTx        = 1 << iota // bought in the tx creating the token
+	TradeFlagSameBlock                 // bought in the block creating the token
+	TradeFlagCreator                   // bought by the token creator
+	TradeFlagCreatorFunded             // bought by a wallet the creator sent native token to
+	TradeFlagEarly                     // bought within the sniper blocks after the launch block
+
+	// the trades of a MevEvent
+	TradeFlagSandwichFront  // the front run of a sandwich
+	TradeFlagSandwichVictim // a trade between the front and the back run of a sandwich
+	TradeFlagSandwichBack   // the back run of a sandwich
+	TradeFlagArbitrage      // a leg of an arbitrage, buying and selling a token in one tx
+	TradeFlagBackrunVictim  // the trade an arbitrage directly follows on its pool
+)
@@ -5,8 +5,14 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// TraderResolver resolves the trader an event is credited to, among the tx sender and the accounts it acts for
+type TraderResolver interface {
+	Resolve(event Event) common.Address
+}
+
 type TxResult struct {
 	Sender            common.Address
+	Traders           TraderResolver // the events are credited to the tx sender when nil
 	SwapEvents        []Event
 	PairCreatedEvents []Event
 	PoolUpdates       []*PoolUpdate
@@ -17,7 +23,12 @@ type TxResult struct {
 }
 
 func (r *TxResult) decorateEvent(event Event) {
-	event.SetMaker(r.Sender)
+	event.SetSender(r.Sender)
+	if r.Traders == nil {
+		event.SetMaker(r.Sender)
+		return
+	}
+	event.SetMaker(r.Traders.Resolve(event))
 }
 
 func (r *TxResult) AddSwapEvent(event Event) {
@@ -1,7 +1,6 @@
 package types
 
 import (
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/shopspring/decimal"
 	"math/big"
 )
@@ -17,16 +16,14 @@ func ParseAmount(a0Wei, a1Wei *big.Int, pair *Pair) (a0, a1 decimal.Decimal) {
 	return
 }
 
+// CalcAmountAndPrice prices a trade of a pair quoted in the native or the wrapped native token, which is always token1
 func CalcAmountAndPrice(
 	nativeTokenPrice decimal.Decimal,
 	amount0, amount1 decimal.Decimal,
-	token1 common.Address,
 ) (amountUSD, priceUSD decimal.Decimal) {
-	if IsNativeToken(token1) || IsWBNB(token1) {
-		amountUSD = amount1.Mul(nativeTokenPrice)
-		if !amount0.IsZero() {
-			priceUSD = amountUSD.Div(amount0)
-		}
+	amountUSD = amount1.Mul(nativeTokenPrice)
+	if !amount0.IsZero() {
+		priceUSD = amountUSD.Div(amount0)
 	}
 	return
 }